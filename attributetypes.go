@@ -0,0 +1,126 @@
+package irma
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-errors/errors"
+)
+
+// AttributeValueType is the type of the values of an attribute, as declared in the type attribute
+// of the attribute in the credential type description.
+type AttributeValueType string
+
+const (
+	AttributeValueTypeString  = AttributeValueType("string")
+	AttributeValueTypeInteger = AttributeValueType("integer")
+	AttributeValueTypeDate    = AttributeValueType("date")    // formatted as AttributeDateFormat
+	AttributeValueTypeBoolean = AttributeValueType("boolean") // true, false, yes or no
+	AttributeValueTypeEnum    = AttributeValueType("enum")    // one of the Values of the attribute type
+)
+
+// AttributeDateFormat is the format of date attributes (i.e., YYYY-MM-DD).
+const AttributeDateFormat = "2006-01-02"
+
+var attributeBooleans = map[string]bool{"true": true, "yes": true, "false": false, "no": false}
+
+// known returns whether the type is one of the types above, or untyped.
+func (t AttributeValueType) known() bool {
+	switch t {
+	case "", AttributeValueTypeString, AttributeValueTypeInteger, AttributeValueTypeDate, AttributeValueTypeBoolean, AttributeValueTypeEnum:
+		return true
+	default:
+		return false
+	}
+}
+
+// ValidateDeclaration checks that the type and constraints of the attribute type are consistent.
+func (ad *AttributeType) ValidateDeclaration() error {
+	switch ad.Type {
+	case "", AttributeValueTypeString, AttributeValueTypeInteger, AttributeValueTypeDate, AttributeValueTypeBoolean:
+		if len(ad.Values) > 0 {
+			return errors.New("only enum attributes can have Values")
+		}
+	case AttributeValueTypeEnum:
+		if len(ad.Values) == 0 {
+			return errors.New("enum attribute has no Values")
+		}
+	default:
+		return errors.Errorf("unknown attribute type %s", ad.Type)
+	}
+	if ad.RevocationAttribute && (ad.Type != "" || ad.Pattern != "" || ad.MaxLength != 0) {
+		return errors.New("revocation attribute cannot have a type or constraints")
+	}
+	if ad.MaxLength < 0 {
+		return errors.New("maxLength cannot be negative")
+	}
+	if ad.Pattern != "" {
+		var err error
+		if ad.pattern, err = compileAttributePattern(ad.Pattern); err != nil {
+			return errors.WrapPrefix(err, "invalid pattern", 0)
+		}
+	}
+	return nil
+}
+
+// ValidateValue checks that the specified value conforms to the type and constraints of the attribute type.
+func (ad *AttributeType) ValidateValue(value string) error {
+	if ad.MaxLength != 0 && utf8.RuneCountInString(value) > ad.MaxLength {
+		return errors.Errorf("value longer than %d characters", ad.MaxLength)
+	}
+	if ad.Pattern != "" {
+		pattern := ad.pattern // compiled by ValidateDeclaration() when parsing the scheme
+		if pattern == nil {
+			var err error
+			if pattern, err = compileAttributePattern(ad.Pattern); err != nil {
+				return errors.WrapPrefix(err, "invalid pattern", 0)
+			}
+		}
+		if !pattern.MatchString(value) {
+			return errors.Errorf("value does not match pattern %s", ad.Pattern)
+		}
+	}
+	if ad.Type == AttributeValueTypeEnum {
+		for _, v := range ad.Values {
+			if v == value {
+				return nil
+			}
+		}
+		return errors.Errorf("value is not one of the allowed values %v", ad.Values)
+	}
+	_, err := ad.Type.Parse(value)
+	return err
+}
+
+// Parse parses the specified attribute value according to the type, returning a string (for string,
+// enum and untyped attributes), int64 (integer), time.Time (date) or bool (boolean).
+func (t AttributeValueType) Parse(value string) (interface{}, error) {
+	switch t {
+	case AttributeValueTypeInteger:
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, errors.Errorf("value %s is not an integer", value)
+		}
+		return i, nil
+	case AttributeValueTypeDate:
+		d, err := time.Parse(AttributeDateFormat, value)
+		if err != nil {
+			return nil, errors.Errorf("value %s is not a date of the form YYYY-MM-DD", value)
+		}
+		return d, nil
+	case AttributeValueTypeBoolean:
+		b, ok := attributeBooleans[value]
+		if !ok {
+			return nil, errors.Errorf("value %s is not a boolean", value)
+		}
+		return b, nil
+	default:
+		return value, nil
+	}
+}
+
+func compileAttributePattern(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
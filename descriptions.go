@@ -4,6 +4,7 @@ import (
 	"encoding/xml"
 	"fmt"
 	"path/filepath"
+	"regexp"
 
 	"github.com/go-errors/errors"
 	"github.com/privacybydesign/irmago/internal/common"
@@ -89,6 +90,15 @@ type AttributeType struct {
 
 	RevocationAttribute bool `xml:"revocation,attr" json:",omitempty"`
 
+	// Type and constraints of the attribute value, checked when issuing (see ValidateValue()).
+	// Untyped attributes are strings.
+	Type      AttributeValueType `xml:"type,attr" json:",omitempty"`
+	Pattern   string             `xml:"pattern,attr" json:",omitempty"`   // regular expression matching the entire value
+	MaxLength int                `xml:"maxLength,attr" json:",omitempty"` // in characters
+	Values    []string           `xml:"Values>Value" json:",omitempty"`   // allowed values of enum attributes
+
+	pattern *regexp.Regexp
+
 	// Taken from containing CredentialType
 	CredentialTypeID string `xml:"-"`
 	IssuerID         string `xml:"-"`
@@ -0,0 +1,233 @@
+package irma
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/irmago/internal/common"
+)
+
+// FileSystem is a read-only file system from which a Configuration reads its schemes and keys,
+// such as a directory on disk (see DirFileSystem()) or files embedded in a binary (see
+// NewFSFileSystem()). Like io/fs.FS, it accepts slash-separated paths relative to its root,
+// without leading or trailing slashes, in which "." denotes the root itself. Errors about
+// nonexistent files must satisfy os.IsNotExist().
+type FileSystem interface {
+	// ReadFile returns the contents of the specified file.
+	ReadFile(name string) ([]byte, error)
+	// Stat returns information about the specified file, following symlinks.
+	Stat(name string) (os.FileInfo, error)
+	// ReadDir returns information about the entries of the specified directory, sorted by name.
+	ReadDir(name string) ([]os.FileInfo, error)
+}
+
+// DirFileSystem returns a FileSystem reading from the specified directory on disk.
+func DirFileSystem(dir string) FileSystem {
+	return dirFileSystem(dir)
+}
+
+type dirFileSystem string
+
+func (dir dirFileSystem) path(name string) string {
+	return filepath.Join(string(dir), filepath.FromSlash(name))
+}
+
+func (dir dirFileSystem) ReadFile(name string) ([]byte, error) {
+	return ioutil.ReadFile(dir.path(name))
+}
+
+func (dir dirFileSystem) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(dir.path(name))
+}
+
+func (dir dirFileSystem) ReadDir(name string) ([]os.FileInfo, error) {
+	f, err := os.Open(dir.path(name))
+	if err != nil {
+		return nil, err
+	}
+	names, err := f.Readdirnames(-1)
+	_ = f.Close()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	infos := make([]os.FileInfo, 0, len(names))
+	for _, n := range names {
+		info, err := os.Stat(filepath.Join(dir.path(name), n))
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// overlayFileSystem serves each scheme from the upper file system if it contains the scheme's
+// folder, and from the lower file system otherwise. Schemes are never merged file by file, so
+// that a scheme installed or updated in the upper file system entirely replaces the lower one.
+type overlayFileSystem struct {
+	upper, lower FileSystem
+}
+
+func (o overlayFileSystem) choose(name string) FileSystem {
+	scheme := strings.SplitN(name, "/", 2)[0]
+	if _, err := o.upper.Stat(scheme); err == nil {
+		return o.upper
+	}
+	return o.lower
+}
+
+func (o overlayFileSystem) ReadFile(name string) ([]byte, error) {
+	return o.choose(name).ReadFile(name)
+}
+
+func (o overlayFileSystem) Stat(name string) (os.FileInfo, error) {
+	if name == "." {
+		return o.lower.Stat(name)
+	}
+	return o.choose(name).Stat(name)
+}
+
+func (o overlayFileSystem) ReadDir(name string) ([]os.FileInfo, error) {
+	if name != "." {
+		return o.choose(name).ReadDir(name)
+	}
+
+	entries := map[string]os.FileInfo{}
+	for _, fs := range []FileSystem{o.lower, o.upper} {
+		infos, err := fs.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			entries[info.Name()] = info
+		}
+	}
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, info := range entries {
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+// fsPath converts the specified path within the configuration path to the corresponding
+// path within the FileSystem of the Configuration.
+func (conf *Configuration) fsPath(p string) string {
+	p = filepath.Clean(p)
+	if base := filepath.Clean(conf.Path); base != "." {
+		p = strings.TrimPrefix(p, base)
+	}
+	p = path.Clean(strings.Trim(filepath.ToSlash(p), "/"))
+	if p == "" {
+		return "."
+	}
+	return p
+}
+
+func (conf *Configuration) readFile(p string) ([]byte, error) {
+	return conf.fs.ReadFile(conf.fsPath(p))
+}
+
+func (conf *Configuration) pathExists(p string) (bool, error) {
+	_, err := conf.fs.Stat(conf.fsPath(p))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// readTimestamp reads the scheme timestamp at the specified path from the FileSystem.
+func (conf *Configuration) readTimestamp(p string) (*Timestamp, bool, error) {
+	bts, err := conf.readFile(p)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, true, errors.New("Could not read scheme manager timestamp")
+	}
+	ts, err := parseTimestamp(bts)
+	return ts, true, err
+}
+
+// iterateFiles calls the handler for each entry (only the subfolders, if onlyDirs is true) of
+// the specified folder in the FileSystem, skipping .git and hidden subfolders as
+// common.IterateSubfolders() does. A nonexistent folder is treated as empty.
+func (conf *Configuration) iterateFiles(p string, onlyDirs bool, handler func(string, os.FileInfo) error) error {
+	infos, err := conf.fs.ReadDir(conf.fsPath(p))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if onlyDirs && !info.IsDir() {
+			continue
+		}
+		if info.Name() == ".git" || onlyDirs && strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		if err = handler(filepath.Join(p, info.Name()), info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (conf *Configuration) iterateSubfolders(p string, handler func(string, os.FileInfo) error) error {
+	return conf.iterateFiles(p, true, handler)
+}
+
+// walkDir recursively walks the file tree rooted at the specified folder in the FileSystem.
+func (conf *Configuration) walkDir(p string, handler func(string, os.FileInfo) error) error {
+	return conf.iterateFiles(p, false, func(file string, info os.FileInfo) error {
+		if err := handler(file, info); err != nil || !info.IsDir() {
+			return err
+		}
+		return conf.walkDir(file, handler)
+	})
+}
+
+// glob returns the files in the FileSystem matching the specified pattern, which may contain
+// wildcards only in its last element.
+func (conf *Configuration) glob(pattern string) ([]string, error) {
+	dir := filepath.Dir(pattern)
+	var files []string
+	err := conf.iterateFiles(dir, false, func(file string, _ os.FileInfo) error {
+		matches, err := filepath.Match(filepath.Base(pattern), filepath.Base(file))
+		if matches {
+			files = append(files, file)
+		}
+		return err
+	})
+	return files, err
+}
+
+// copyFromFileSystem copies the specified folder within the FileSystem to the specified folder
+// on disk.
+func (conf *Configuration) copyFromFileSystem(src, dest string) error {
+	if err := common.EnsureDirectoryExists(dest); err != nil {
+		return err
+	}
+	return conf.walkDir(src, func(file string, info os.FileInfo) error {
+		rel, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return common.EnsureDirectoryExists(filepath.Join(dest, rel))
+		}
+		bts, err := conf.readFile(file)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(dest, rel), bts, 0600)
+	})
+}
@@ -0,0 +1,42 @@
+// +build go1.16
+
+package irma
+
+import (
+	"io/fs"
+	"os"
+)
+
+// NewFSFileSystem returns a FileSystem reading from the specified io/fs.FS, e.g. an embed.FS
+// containing the schemes, for use as ConfigurationOptions.FileSystem.
+func NewFSFileSystem(fsys fs.FS) FileSystem {
+	return fsFileSystem{fsys}
+}
+
+type fsFileSystem struct {
+	fsys fs.FS
+}
+
+func (f fsFileSystem) ReadFile(name string) ([]byte, error) {
+	return fs.ReadFile(f.fsys, name)
+}
+
+func (f fsFileSystem) Stat(name string) (os.FileInfo, error) {
+	return fs.Stat(f.fsys, name)
+}
+
+func (f fsFileSystem) ReadDir(name string) ([]os.FileInfo, error) {
+	entries, err := fs.ReadDir(f.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
@@ -40,6 +40,11 @@ type AttributeTypeIdentifier struct {
 	metaObjectIdentifier
 }
 
+// RequestorSchemeIdentifier identifies a requestor scheme. Equal to its ID. For example "pbdf-requestors".
+type RequestorSchemeIdentifier struct {
+	metaObjectIdentifier
+}
+
 // CredentialIdentifier identifies a credential instance.
 type CredentialIdentifier struct {
 	Type CredentialTypeIdentifier
@@ -111,6 +116,11 @@ func NewSchemeManagerIdentifier(id string) SchemeManagerIdentifier {
 	return SchemeManagerIdentifier{metaObjectIdentifier(id)}
 }
 
+// NewRequestorSchemeIdentifier converts the specified identifier to a RequestorSchemeIdentifier.
+func NewRequestorSchemeIdentifier(id string) RequestorSchemeIdentifier {
+	return RequestorSchemeIdentifier{metaObjectIdentifier(id)}
+}
+
 // NewIssuerIdentifier converts the specified identifier to a IssuerIdentifier.
 func NewIssuerIdentifier(id string) IssuerIdentifier {
 	return IssuerIdentifier{metaObjectIdentifier(id)}
@@ -199,6 +209,17 @@ func (id *AttributeTypeIdentifier) UnmarshalText(text []byte) error {
 	return nil
 }
 
+// MarshalText implements encoding.TextMarshaler.
+func (id RequestorSchemeIdentifier) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (id *RequestorSchemeIdentifier) UnmarshalText(text []byte) error {
+	*id = NewRequestorSchemeIdentifier(string(text))
+	return nil
+}
+
 func (set *IrmaIdentifierSet) join(other *IrmaIdentifierSet) {
 	for scheme := range other.SchemeManagers {
 		set.SchemeManagers[scheme] = struct{}{}
@@ -9,6 +9,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/go-errors/errors"
 	"github.com/privacybydesign/gabi/big"
@@ -219,6 +220,9 @@ func iterateFiles(path string, onlyDirs bool, handler func(string, os.FileInfo)
 		if filepath.Base(file) == ".git" {
 			continue
 		}
+		if onlyDirs && strings.HasPrefix(filepath.Base(file), ".") {
+			continue // e.g. the staging and previous versions of schemes in irma_configuration
+		}
 		err = handler(file, stat)
 		if err != nil {
 			return err
@@ -1,6 +1,7 @@
 package sessiontest
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"math/rand"
 	"testing"
@@ -104,29 +105,29 @@ func (th TestHandler) Failure(err *irma.SessionError) {
 	}
 }
 func (th TestHandler) ClientReturnURLSet(clientReturnUrl string) {}
-func (th TestHandler) UnsatisfiableRequest(request irma.SessionRequest, serverName irma.TranslatedString, missing irmaclient.MissingAttributes) {
+func (th TestHandler) UnsatisfiableRequest(request irma.SessionRequest, requestor *irmaclient.RequestorIdentity, missing irmaclient.MissingAttributes) {
 	th.Failure(&irma.SessionError{
 		ErrorType: irma.ErrorType("UnsatisfiableRequest"),
 	})
 }
-func (th TestHandler) RequestVerificationPermission(request *irma.DisclosureRequest, candidates [][][]*irma.AttributeIdentifier, ServerName irma.TranslatedString, callback irmaclient.PermissionHandler) {
+func (th TestHandler) RequestVerificationPermission(request *irma.DisclosureRequest, candidates [][][]*irma.AttributeIdentifier, requestor *irmaclient.RequestorIdentity, callback irmaclient.PermissionHandler) {
 	var choice irma.DisclosureChoice
 	for _, cand := range candidates {
 		choice.Attributes = append(choice.Attributes, cand[0])
 	}
 	if len(th.expectedServerName) != 0 {
-		require.Equal(th.t, th.expectedServerName, ServerName)
+		require.Equal(th.t, th.expectedServerName, requestor.Name)
 	}
 	if th.wait != 0 {
 		time.Sleep(th.wait)
 	}
 	callback(true, &choice)
 }
-func (th TestHandler) RequestIssuancePermission(request *irma.IssuanceRequest, candidates [][][]*irma.AttributeIdentifier, ServerName irma.TranslatedString, callback irmaclient.PermissionHandler) {
-	th.RequestVerificationPermission(&request.DisclosureRequest, candidates, ServerName, callback)
+func (th TestHandler) RequestIssuancePermission(request *irma.IssuanceRequest, candidates [][][]*irma.AttributeIdentifier, requestor *irmaclient.RequestorIdentity, callback irmaclient.PermissionHandler) {
+	th.RequestVerificationPermission(&request.DisclosureRequest, candidates, requestor, callback)
 }
-func (th TestHandler) RequestSignaturePermission(request *irma.SignatureRequest, candidates [][][]*irma.AttributeIdentifier, ServerName irma.TranslatedString, callback irmaclient.PermissionHandler) {
-	th.RequestVerificationPermission(&request.DisclosureRequest, candidates, ServerName, callback)
+func (th TestHandler) RequestSignaturePermission(request *irma.SignatureRequest, candidates [][][]*irma.AttributeIdentifier, requestor *irmaclient.RequestorIdentity, callback irmaclient.PermissionHandler) {
+	th.RequestVerificationPermission(&request.DisclosureRequest, candidates, requestor, callback)
 }
 func (th TestHandler) RequestSchemeManagerPermission(manager *irma.SchemeManager, callback func(proceed bool)) {
 	callback(true)
@@ -139,6 +140,7 @@ type SessionResult struct {
 	Err              error
 	SignatureResult  *irma.SignedMessage
 	DisclosureResult *irma.Disclosure
+	ProximityResult  []byte
 	Missing          irmaclient.MissingAttributes
 }
 
@@ -146,7 +148,7 @@ type UnsatisfiableTestHandler struct {
 	TestHandler
 }
 
-func (th UnsatisfiableTestHandler) UnsatisfiableRequest(request irma.SessionRequest, serverName irma.TranslatedString, missing irmaclient.MissingAttributes) {
+func (th UnsatisfiableTestHandler) UnsatisfiableRequest(request irma.SessionRequest, requestor *irmaclient.RequestorIdentity, missing irmaclient.MissingAttributes) {
 	th.c <- &SessionResult{Missing: missing}
 }
 
@@ -191,10 +193,10 @@ func (th *ManualTestHandler) Success(result string) {
 
 	th.c <- retval
 }
-func (th *ManualTestHandler) RequestSignaturePermission(request *irma.SignatureRequest, candidates [][][]*irma.AttributeIdentifier, requesterName irma.TranslatedString, ph irmaclient.PermissionHandler) {
-	th.RequestVerificationPermission(&request.DisclosureRequest, candidates, requesterName, ph)
+func (th *ManualTestHandler) RequestSignaturePermission(request *irma.SignatureRequest, candidates [][][]*irma.AttributeIdentifier, requestor *irmaclient.RequestorIdentity, ph irmaclient.PermissionHandler) {
+	th.RequestVerificationPermission(&request.DisclosureRequest, candidates, requestor, ph)
 }
-func (th *ManualTestHandler) RequestIssuancePermission(request *irma.IssuanceRequest, candidates [][][]*irma.AttributeIdentifier, issuerName irma.TranslatedString, ph irmaclient.PermissionHandler) {
+func (th *ManualTestHandler) RequestIssuancePermission(request *irma.IssuanceRequest, candidates [][][]*irma.AttributeIdentifier, requestor *irmaclient.RequestorIdentity, ph irmaclient.PermissionHandler) {
 	ph(true, nil)
 }
 
@@ -202,7 +204,7 @@ func (th *ManualTestHandler) RequestIssuancePermission(request *irma.IssuanceReq
 func (th *ManualTestHandler) RequestSchemeManagerPermission(manager *irma.SchemeManager, callback func(proceed bool)) {
 	th.Failure(&irma.SessionError{Err: errors.New("Unexpected session type")})
 }
-func (th *ManualTestHandler) RequestVerificationPermission(request *irma.DisclosureRequest, candidates [][][]*irma.AttributeIdentifier, verifierName irma.TranslatedString, ph irmaclient.PermissionHandler) {
+func (th *ManualTestHandler) RequestVerificationPermission(request *irma.DisclosureRequest, candidates [][][]*irma.AttributeIdentifier, requestor *irmaclient.RequestorIdentity, ph irmaclient.PermissionHandler) {
 	var choice irma.DisclosureChoice
 	for _, cand := range candidates {
 		choice.Attributes = append(choice.Attributes, cand[0])
@@ -213,3 +215,26 @@ func (th *ManualTestHandler) RequestVerificationPermission(request *irma.Disclos
 func init() {
 	rand.Seed(time.Now().UnixNano())
 }
+
+// ProximityTestHandler embeds a ManualTestHandler, and passes on the binary encoding of the
+// disclosure of an offline proximity session, along with the identity of the verifier.
+type ProximityTestHandler struct {
+	ManualTestHandler
+	requestor *irmaclient.RequestorIdentity
+}
+
+func (th *ProximityTestHandler) Success(result string) {
+	bts, err := base64.StdEncoding.DecodeString(result)
+	if err != nil {
+		th.Failure(&irma.SessionError{
+			Err:       err,
+			ErrorType: irma.ErrorSerialization,
+		})
+		return
+	}
+	th.c <- &SessionResult{ProximityResult: bts}
+}
+func (th *ProximityTestHandler) RequestVerificationPermission(request *irma.DisclosureRequest, candidates [][][]*irma.AttributeIdentifier, requestor *irmaclient.RequestorIdentity, ph irmaclient.PermissionHandler) {
+	th.requestor = requestor
+	th.ManualTestHandler.RequestVerificationPermission(request, candidates, requestor, ph)
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/privacybydesign/gabi"
 	"github.com/privacybydesign/gabi/big"
+	"github.com/privacybydesign/gabi/signed"
 	"github.com/privacybydesign/irmago"
 	"github.com/privacybydesign/irmago/internal/test"
 	"github.com/privacybydesign/irmago/irmaclient"
@@ -161,3 +162,47 @@ func TestManualDisclosureSessionInvalidRequest(t *testing.T) {
 
 	require.Equal(t, irma.ProofStatusMissingAttributes, status)
 }
+
+func TestProximitySession(t *testing.T) {
+	client, handler := parseExistingStorage(t, test.CreateTestStorage(t))
+	defer test.ClearTestStorage(t, handler.storage)
+	result := requestorSessionHelper(t, getIssuanceRequest(true), client)
+	require.Nil(t, result.Err)
+
+	sk, err := signed.GenerateKey()
+	require.NoError(t, err)
+	request := getDisclosureRequest(irma.NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID"))
+	pr, err := irma.NewProximityRequest(client.Configuration, request, "verifier.example.com", sk)
+	require.NoError(t, err)
+	bts, err := irma.MarshalBinary(pr)
+	require.NoError(t, err)
+
+	// The disclosure returned by the client verifies against the request
+	ph := &ProximityTestHandler{ManualTestHandler: *createManualSessionHandler(t, client)}
+	client.NewProximitySession(bts, ph)
+	proximityResult := <-ph.c
+	require.NoError(t, proximityResult.Err)
+	disclosed, status, err := irma.VerifyProximityDisclosure(client.Configuration, request, proximityResult.ProximityResult)
+	require.NoError(t, err)
+	require.Equal(t, irma.ProofStatusValid, status)
+	require.Equal(t, "s1234567", *disclosed[0][0].RawValue)
+
+	// but not against another request
+	other := getDisclosureRequest(irma.NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID"))
+	_, err = irma.NewProximityRequest(client.Configuration, other, "verifier.example.com", sk)
+	require.NoError(t, err)
+	_, status, _ = irma.VerifyProximityDisclosure(client.Configuration, other, proximityResult.ProximityResult)
+	require.NotEqual(t, irma.ProofStatusValid, status)
+
+	// The verifier is not listed in a requestor scheme, so it is shown by its unverified hostname
+	require.NotNil(t, ph.requestor)
+	require.False(t, ph.requestor.Verified)
+	require.Equal(t, "verifier.example.com", ph.requestor.Name["en"])
+
+	// An invalid proximity request fails the session, before NewProximitySession() returns
+	ph = &ProximityTestHandler{ManualTestHandler: *createManualSessionHandler(t, client)}
+	ph.c = make(chan *SessionResult, 1)
+	require.Nil(t, client.NewProximitySession([]byte("invalid"), ph))
+	proximityResult = <-ph.c
+	require.Error(t, proximityResult.Err)
+}
@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -585,6 +587,74 @@ func TestRevocationAll(t *testing.T) {
 		result = revocationSession(t, client, nil, sessionOptionReuseServer, sessionOptionUnsatisfiableRequest)
 		require.NotEmpty(t, result.Missing)
 	})
+
+	t.Run("SQLReplication", func(t *testing.T) {
+		clearRevocationDB(t)
+
+		// A revocation authority serving an accumulator
+		var raHits int32
+		var updates map[uint]*revocation.Update
+		ra := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&raHits, 1)
+			bts, err := irma.MarshalBinary(updates)
+			require.NoError(t, err)
+			_, _ = w.Write(bts)
+		}))
+		defer ra.Close()
+
+		// Two replicas sharing the database
+		newConf := func() *irma.Configuration {
+			conf, err := irma.NewConfiguration(filepath.Join(testdata, "irma_configuration"), irma.ConfigurationOptions{
+				ReadOnly:              true,
+				RevocationDBType:      revocationDbType,
+				RevocationDBConnStr:   revocationDbStr,
+				RevocationReplication: &irma.RevocationReplication{Mode: irma.RevocationReplicationSQL},
+			})
+			require.NoError(t, err)
+			require.NoError(t, conf.ParseFolder())
+			conf.CredentialTypes[revocationTestCred].RevocationServers = []string{ra.URL}
+			return conf
+		}
+		conf1, conf2 := newConf(), newConf()
+		defer func() {
+			_ = conf1.Revocation.Close()
+			_ = conf2.Revocation.Close()
+		}()
+
+		sk, err := conf1.Revocation.Keys.PrivateKey(revocationTestCred.IssuerIdentifier(), revocationPkCounter)
+		require.NoError(t, err)
+		update, err := revocation.NewAccumulator(sk)
+		require.NoError(t, err)
+		updates = map[uint]*revocation.Update{revocationPkCounter: update}
+
+		// After one replica fetched the updates, the other need not fetch them itself
+		require.NoError(t, conf1.Revocation.SyncDB(revocationTestCred))
+		require.NoError(t, conf2.Revocation.SyncIfOld(revocationTestCred, 60))
+		require.Equal(t, int32(1), atomic.LoadInt32(&raHits))
+
+		// Both replicas can store the same events
+		require.NoError(t, conf2.Revocation.SyncDB(revocationTestCred))
+		pk, err := conf1.Revocation.Keys.PublicKey(revocationTestCred.IssuerIdentifier(), revocationPkCounter)
+		require.NoError(t, err)
+		acc, err := update.SignedAccumulator.UnmarshalVerify(pk)
+		require.NoError(t, err)
+		witness, err := revocation.RandomWitness(sk, acc)
+		require.NoError(t, err)
+		acc, event, err := acc.Remove(sk, witness.E, update.Events[len(update.Events)-1])
+		require.NoError(t, err)
+		update, err = revocation.NewUpdate(sk, acc, append(update.Events, event))
+		require.NoError(t, err)
+		updates = map[uint]*revocation.Update{revocationPkCounter: update}
+		require.NoError(t, conf1.Revocation.SyncDB(revocationTestCred))
+		require.NoError(t, conf2.Revocation.SyncDB(revocationTestCred))
+
+		sacc1, err := conf1.Revocation.Accumulator(revocationTestCred, revocationPkCounter)
+		require.NoError(t, err)
+		sacc2, err := conf2.Revocation.Accumulator(revocationTestCred, revocationPkCounter)
+		require.NoError(t, err)
+		require.Equal(t, sacc1, sacc2)
+		require.Equal(t, acc.Index, sacc1.Accumulator.Index)
+	})
 }
 
 // Helper functions
@@ -717,17 +787,8 @@ func startRevocationServer(t *testing.T, droptables bool) {
 
 	irma.SetLogger(logger)
 
-	// Connect to database and clear records from previous test runs
 	if droptables {
-		g, err := gorm.Open(revocationDbType, revocationDbStr)
-		require.NoError(t, err)
-		require.NoError(t, g.DropTableIfExists((*irma.EventRecord)(nil)).Error)
-		require.NoError(t, g.DropTableIfExists((*irma.AccumulatorRecord)(nil)).Error)
-		require.NoError(t, g.DropTableIfExists((*irma.IssuanceRecord)(nil)).Error)
-		require.NoError(t, g.AutoMigrate((*irma.EventRecord)(nil)).Error)
-		require.NoError(t, g.AutoMigrate((*irma.AccumulatorRecord)(nil)).Error)
-		require.NoError(t, g.AutoMigrate((*irma.IssuanceRecord)(nil)).Error)
-		require.NoError(t, g.Close())
+		clearRevocationDB(t)
 	}
 
 	// Start revocation server
@@ -744,6 +805,21 @@ func startRevocationServer(t *testing.T, droptables bool) {
 	}()
 }
 
+// clearRevocationDB connects to the database and clears records from previous test runs.
+func clearRevocationDB(t *testing.T) {
+	g, err := gorm.Open(revocationDbType, revocationDbStr)
+	require.NoError(t, err)
+	require.NoError(t, g.DropTableIfExists((*irma.EventRecord)(nil)).Error)
+	require.NoError(t, g.DropTableIfExists((*irma.AccumulatorRecord)(nil)).Error)
+	require.NoError(t, g.DropTableIfExists((*irma.IssuanceRecord)(nil)).Error)
+	require.NoError(t, g.DropTableIfExists((*irma.UpdatedRecord)(nil)).Error)
+	require.NoError(t, g.AutoMigrate((*irma.EventRecord)(nil)).Error)
+	require.NoError(t, g.AutoMigrate((*irma.AccumulatorRecord)(nil)).Error)
+	require.NoError(t, g.AutoMigrate((*irma.IssuanceRecord)(nil)).Error)
+	require.NoError(t, g.AutoMigrate((*irma.UpdatedRecord)(nil)).Error)
+	require.NoError(t, g.Close())
+}
+
 func stopRevocationServer() {
 	revocationServer.Stop()
 	_ = revocationHttpServer.Close()
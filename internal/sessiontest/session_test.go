@@ -509,3 +509,169 @@ func TestIssuedCredentialIsStored(t *testing.T) {
 	id := irma.NewAttributeTypeIdentifier("irma-demo.MijnOverheid.fullName.familyname")
 	sessionHelper(t, getDisclosureRequest(id), "verification", client)
 }
+
+func TestChainedSessions(t *testing.T) {
+	client, handler := parseStorage(t)
+	defer test.ClearTestStorage(t, handler.storage)
+	StartIrmaServer(t, false)
+	defer StopIrmaServer()
+
+	// start server that issues a credential containing the attribute disclosed in the first session
+	var disclosed string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		var result server.SessionResult
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&result))
+		require.Equal(t, irma.ProofStatusValid, result.ProofStatus)
+		require.Len(t, result.Disclosed, 1)
+		disclosed = *result.Disclosed[0][0].RawValue
+		request := getNameIssuanceRequest()
+		request.Credentials[0].Attributes["familyname"] = disclosed
+		server.WriteJson(w, request)
+	})
+	s := &http.Server{Addr: "localhost:48686", Handler: mux}
+	go func() { _ = s.ListenAndServe() }()
+	defer func() { _ = s.Shutdown(context.Background()) }()
+
+	id := irma.NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID")
+	request := &irma.ServiceProviderRequest{
+		Request:              getDisclosureRequest(id),
+		RequestorBaseRequest: irma.RequestorBaseRequest{NextSession: &irma.NextSessionData{URL: "http://localhost:48686"}},
+	}
+	results := make(chan *server.SessionResult, 2)
+	qr, token, err := irmaServer.StartSession(request, func(result *server.SessionResult) {
+		results <- result
+	})
+	require.NoError(t, err)
+
+	// The client performs both sessions after a single QR
+	c := make(chan *SessionResult)
+	bts, err := json.Marshal(qr)
+	require.NoError(t, err)
+	client.NewSession(string(bts), &TestHandler{t, c, client, nil, 0, ""})
+	for i := 0; i < 2; i++ {
+		if result := <-c; result != nil {
+			require.NoError(t, result.Err)
+		}
+	}
+
+	first := <-results
+	require.Equal(t, token, first.Token)
+	require.Equal(t, server.StatusDone, first.Status)
+	require.NotEmpty(t, first.NextSession)
+	second := <-results
+	require.Equal(t, first.NextSession, second.Token)
+	require.Equal(t, server.StatusDone, second.Status)
+	require.Equal(t, irma.ActionIssuing, second.Type)
+
+	// The issued credential contains the attribute disclosed in the first session
+	attrs := client.Attributes(irma.NewCredentialTypeIdentifier("irma-demo.MijnOverheid.fullName"), 0)
+	require.NotNil(t, attrs)
+	require.Equal(t, disclosed, *attrs.UntranslatedAttribute(irma.NewAttributeTypeIdentifier("irma-demo.MijnOverheid.fullName.familyname")))
+}
+
+func TestChainedSessionsStatusPolling(t *testing.T) {
+	client, handler := parseStorage(t)
+	defer test.ClearTestStorage(t, handler.storage)
+	StartIrmaServer(t, false)
+	defer StopIrmaServer()
+
+	// start server that polls the status of the first session before providing the follow-up session
+	var qr *irma.Qr
+	polled := make(chan int, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		res, err := (&http.Client{Timeout: 5 * time.Second}).Get(qr.URL + "/status")
+		require.NoError(t, err)
+		_ = res.Body.Close()
+		polled <- res.StatusCode
+		server.WriteJson(w, getNameIssuanceRequest())
+	})
+	s := &http.Server{Addr: "localhost:48686", Handler: mux}
+	go func() { _ = s.ListenAndServe() }()
+	defer func() { _ = s.Shutdown(context.Background()) }()
+
+	id := irma.NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID")
+	request := &irma.ServiceProviderRequest{
+		Request:              getDisclosureRequest(id),
+		RequestorBaseRequest: irma.RequestorBaseRequest{NextSession: &irma.NextSessionData{URL: "http://localhost:48686"}},
+	}
+	results := make(chan *server.SessionResult, 2)
+	var err error
+	qr, _, err = irmaServer.StartSession(request, func(result *server.SessionResult) {
+		results <- result
+	})
+	require.NoError(t, err)
+
+	c := make(chan *SessionResult)
+	bts, err := json.Marshal(qr)
+	require.NoError(t, err)
+	client.NewSession(string(bts), &TestHandler{t, c, client, nil, 0, ""})
+	for i := 0; i < 2; i++ {
+		if result := <-c; result != nil {
+			require.NoError(t, result.Err)
+		}
+	}
+	require.Equal(t, http.StatusOK, <-polled)
+
+	// The status request did not report the result before the follow-up session was started
+	first := <-results
+	require.Equal(t, server.StatusDone, first.Status)
+	require.NotEmpty(t, first.NextSession)
+	<-results
+
+	// and afterwards the session is unlocked again
+	health := make(chan *server.HealthStatus)
+	go func() { health <- irmaServer.HealthStatus() }()
+	select {
+	case status := <-health:
+		require.NotNil(t, status)
+	case <-time.After(5 * time.Second):
+		t.Fatal("session remained locked")
+	}
+}
+
+func TestChainedSessionsFailure(t *testing.T) {
+	client, handler := parseStorage(t)
+	defer test.ClearTestStorage(t, handler.storage)
+	StartIrmaServer(t, false)
+	defer StopIrmaServer()
+
+	// start server that refuses to provide a follow-up session
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	s := &http.Server{Addr: "localhost:48686", Handler: mux}
+	go func() { _ = s.ListenAndServe() }()
+	defer func() { _ = s.Shutdown(context.Background()) }()
+
+	id := irma.NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID")
+	request := &irma.ServiceProviderRequest{
+		Request:              getDisclosureRequest(id),
+		RequestorBaseRequest: irma.RequestorBaseRequest{NextSession: &irma.NextSessionData{URL: "http://localhost:48686"}},
+	}
+	results := make(chan *server.SessionResult, 1)
+	qr, token, err := irmaServer.StartSession(request, func(result *server.SessionResult) {
+		results <- result
+	})
+	require.NoError(t, err)
+
+	// The client is told that the follow-up session failed
+	c := make(chan *SessionResult)
+	bts, err := json.Marshal(qr)
+	require.NoError(t, err)
+	client.NewSession(string(bts), &TestHandler{t, c, client, nil, 0, ""})
+	result := <-c
+	require.NotNil(t, result)
+	require.Error(t, result.Err)
+
+	// but the result of the first session is unaffected
+	first := <-results
+	require.Equal(t, token, first.Token)
+	require.Equal(t, server.StatusDone, first.Status)
+	require.Equal(t, irma.ProofStatusValid, first.ProofStatus)
+	require.Len(t, first.Disclosed, 1)
+	require.Empty(t, first.NextSession)
+	require.Nil(t, first.Err)
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/privacybydesign/irmago"
+	"github.com/spf13/cobra"
+)
+
+var bundleCmd = &cobra.Command{
+	Use:   "bundle <path> <output>",
+	Short: "Pack a scheme into a single file for offline installation",
+	Long: `The bundle command packs the signed IRMA scheme at the specified path into a scheme bundle: a gzipped tar archive containing the scheme index, its signature, the scheme public key, the timestamp and all other files of the scheme.
+
+The bundle can be installed or updated elsewhere using "irma scheme update --from-bundle", which verifies it in the same way as when updating from the scheme URL. For demo schemes, the private keys in the scheme are included if --privatekeys is specified.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+		privatekeys, _ := flags.GetBool("privatekeys")
+
+		path, err := filepath.Abs(args[0])
+		if err != nil {
+			die("", err)
+		}
+		irmaconf, scheme := filepath.Dir(path), filepath.Base(path)
+
+		conf, err := irma.NewConfiguration(irmaconf, irma.ConfigurationOptions{ReadOnly: true})
+		if err != nil {
+			die("", err)
+		}
+		if err = conf.ParseSchemeManagerFolder(path, irma.NewSchemeManager(scheme)); err != nil {
+			die("Failed to parse scheme", err)
+		}
+
+		file, err := os.Create(args[1])
+		if err != nil {
+			die("Failed to create bundle", err)
+		}
+		err = conf.BundleScheme(irma.NewSchemeManagerIdentifier(scheme), file, privatekeys)
+		if e := file.Close(); err == nil {
+			err = e
+		}
+		if err != nil {
+			_ = os.Remove(args[1])
+			die("Failed to write bundle", err)
+		}
+	},
+}
+
+func init() {
+	schemeCmd.AddCommand(bundleCmd)
+
+	bundleCmd.Flags().Bool("privatekeys", false, "include private keys (demo schemes only)")
+}
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/irmago"
+	"github.com/spf13/cobra"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff <old> [<new>]",
+	Short: "Show the differences between two versions of a scheme",
+	Long: `The diff command compares two scheme folders, and reports added, removed and modified issuers,
+credential types, attributes, translations, logos, public keys and revocation settings.
+
+With --remote only one scheme folder is given, which is compared against the current online version
+of the scheme as downloaded from its URL (verified against the local public key(s)).
+
+Both scheme folders must be named after the scheme identifier and be validly signed.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		remote, _ := cmd.Flags().GetBool("remote")
+		asjson, _ := cmd.Flags().GetBool("json")
+		if remote != (len(args) == 1) {
+			die("", errors.New("Specify either two scheme folders, or one scheme folder and --remote"))
+		}
+
+		old, id, err := parseSchemeFolder(args[0])
+		if err != nil {
+			die("Failed to parse scheme", err)
+		}
+		var new *irma.Configuration
+		if remote {
+			var dir string
+			new, dir, err = downloadRemoteScheme(old.SchemeManagers[id], args[0])
+			if dir != "" {
+				defer os.RemoveAll(dir)
+			}
+		} else {
+			var newid irma.SchemeManagerIdentifier
+			new, newid, err = parseSchemeFolder(args[1])
+			if err == nil && newid != id {
+				err = errors.Errorf("Cannot compare scheme %s to scheme %s", id, newid)
+			}
+		}
+		if err != nil {
+			die("Failed to parse new scheme", err)
+		}
+
+		diff, err := irma.DiffSchemes(old, new, id)
+		if err != nil {
+			die("Failed to compare schemes", err)
+		}
+
+		if asjson {
+			bts, _ := json.MarshalIndent(diff, "", "  ")
+			fmt.Println(string(bts))
+			return
+		}
+		if diff.Empty() {
+			fmt.Println("No changes")
+		}
+		for _, change := range diff.Changes {
+			fmt.Println(change.String())
+		}
+	},
+}
+
+func parseSchemeFolder(path string) (*irma.Configuration, irma.SchemeManagerIdentifier, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, irma.SchemeManagerIdentifier{}, err
+	}
+	conf, err := irma.NewConfiguration(filepath.Dir(path), irma.ConfigurationOptions{ReadOnly: true})
+	if err != nil {
+		return nil, irma.SchemeManagerIdentifier{}, err
+	}
+	scheme := irma.NewSchemeManager(filepath.Base(path))
+	if err = conf.ParseSchemeManagerFolder(path, scheme); err != nil {
+		return nil, irma.SchemeManagerIdentifier{}, err
+	}
+	return conf, scheme.Identifier(), nil
+}
+
+// downloadRemoteScheme downloads the online version of the specified scheme into a temporary
+// folder, which is returned so that it can be removed afterwards.
+func downloadRemoteScheme(scheme *irma.SchemeManager, path string) (*irma.Configuration, string, error) {
+	pk, err := ioutil.ReadFile(filepath.Join(path, "pk.pem"))
+	if err != nil {
+		return nil, "", err
+	}
+	remote, err := irma.DownloadSchemeManager(scheme.URL)
+	if err != nil {
+		return nil, "", err
+	}
+	dir, err := ioutil.TempDir("", "irmascheme")
+	if err != nil {
+		return nil, "", err
+	}
+	conf, err := irma.NewConfiguration(dir, irma.ConfigurationOptions{})
+	if err != nil {
+		return nil, dir, err
+	}
+	if err = conf.InstallSchemeManager(remote, pk); err != nil {
+		return nil, dir, err
+	}
+	return conf, dir, nil
+}
+
+func init() {
+	schemeCmd.AddCommand(diffCmd)
+
+	diffCmd.Flags().BoolP("remote", "r", false, "compare against the online version of the scheme")
+	diffCmd.Flags().BoolP("json", "j", false, "output changes as JSON")
+}
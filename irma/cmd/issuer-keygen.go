@@ -38,35 +38,9 @@ IRMA applications.`,
 		expiryDateString, _ := flags.GetString("expirydate")
 		validFor, _ := flags.GetString("valid-for")
 
-		var expiryDate time.Time
-		var err error
-		if expiryDateString != "" {
-			expiryDate, err = time.Parse(time.RFC3339, expiryDateString)
-			if err != nil {
-				return errors.WrapPrefix(err, "Failed to parse expirydate", 0)
-			}
-		} else {
-			expiryDate = time.Now()
-			m := regexp.MustCompile(`^(\d+)([yMdhm])$`).FindStringSubmatch(validFor)
-			if m == nil {
-				return errors.New("unable to parse valid-for period")
-			}
-			num, err := strconv.Atoi(m[1])
-			if err != nil {
-				return errors.New("unable to parse valid-for period")
-			}
-			switch m[2] {
-			case "m":
-				expiryDate = expiryDate.Add(time.Minute * time.Duration(num))
-			case "h":
-				expiryDate = expiryDate.Add(time.Hour * time.Duration(num))
-			case "d":
-				expiryDate = expiryDate.AddDate(0, 0, num)
-			case "M":
-				expiryDate = expiryDate.AddDate(0, num, 0)
-			case "y":
-				expiryDate = expiryDate.AddDate(num, 0, 0)
-			}
+		expiryDate, err := parseExpiryDate(expiryDateString, validFor)
+		if err != nil {
+			return err
 		}
 
 		var path string
@@ -98,30 +72,72 @@ IRMA applications.`,
 			return err
 		}
 
-		defaultFilename := strconv.Itoa(int(counter)) + ".xml"
-		if privkeyfile == "" {
-			keypath := filepath.Join(path, "PrivateKeys")
-			if err = common.EnsureDirectoryExists(keypath); err != nil {
-				return errors.WrapPrefix(err, "Failed to create"+keypath, 0)
-			}
-			privkeyfile = filepath.Join(keypath, defaultFilename)
-		}
-		if pubkeyfile == "" {
-			keypath := filepath.Join(path, "PublicKeys")
-			if err = common.EnsureDirectoryExists(keypath); err != nil {
-				return errors.WrapPrefix(err, "Failed to create"+keypath, 0)
-			}
-			pubkeyfile = filepath.Join(keypath, defaultFilename)
+		return writeIssuerKeypair(path, privk, pubk, privkeyfile, pubkeyfile, overwrite)
+	},
+}
+
+// parseExpiryDate returns the expiry date specified either in RFC3339 format, or
+// if that is empty, as a period starting now (e.g. "1y").
+func parseExpiryDate(expiryDateString, validFor string) (time.Time, error) {
+	if expiryDateString != "" {
+		expiryDate, err := time.Parse(time.RFC3339, expiryDateString)
+		if err != nil {
+			return time.Time{}, errors.WrapPrefix(err, "Failed to parse expirydate", 0)
 		}
+		return expiryDate, nil
+	}
+
+	expiryDate := time.Now()
+	m := regexp.MustCompile(`^(\d+)([yMdhm])$`).FindStringSubmatch(validFor)
+	if m == nil {
+		return time.Time{}, errors.New("unable to parse valid-for period")
+	}
+	num, err := strconv.Atoi(m[1])
+	if err != nil {
+		return time.Time{}, errors.New("unable to parse valid-for period")
+	}
+	switch m[2] {
+	case "m":
+		expiryDate = expiryDate.Add(time.Minute * time.Duration(num))
+	case "h":
+		expiryDate = expiryDate.Add(time.Hour * time.Duration(num))
+	case "d":
+		expiryDate = expiryDate.AddDate(0, 0, num)
+	case "M":
+		expiryDate = expiryDate.AddDate(0, num, 0)
+	case "y":
+		expiryDate = expiryDate.AddDate(num, 0, 0)
+	}
+	return expiryDate, nil
+}
 
-		if _, err = privk.WriteToFile(privkeyfile, overwrite); err != nil {
-			return errors.New("private key file already exists, will not overwrite (force with -f flag)")
+// writeIssuerKeypair writes the keypair to the specified files, defaulting to
+// $path/PrivateKeys/$counter.xml and $path/PublicKeys/$counter.xml.
+func writeIssuerKeypair(path string, privk *gabi.PrivateKey, pubk *gabi.PublicKey, privkeyfile, pubkeyfile string, overwrite bool) error {
+	var err error
+	defaultFilename := strconv.Itoa(int(privk.Counter)) + ".xml"
+	if privkeyfile == "" {
+		keypath := filepath.Join(path, "PrivateKeys")
+		if err = common.EnsureDirectoryExists(keypath); err != nil {
+			return errors.WrapPrefix(err, "Failed to create"+keypath, 0)
 		}
-		if _, err = pubk.WriteToFile(pubkeyfile, overwrite); err != nil {
-			return errors.New("public key file already exists, will not overwrite (force with -f flag)")
+		privkeyfile = filepath.Join(keypath, defaultFilename)
+	}
+	if pubkeyfile == "" {
+		keypath := filepath.Join(path, "PublicKeys")
+		if err = common.EnsureDirectoryExists(keypath); err != nil {
+			return errors.WrapPrefix(err, "Failed to create"+keypath, 0)
 		}
-		return nil
-	},
+		pubkeyfile = filepath.Join(keypath, defaultFilename)
+	}
+
+	if _, err = privk.WriteToFile(privkeyfile, overwrite); err != nil {
+		return errors.New("private key file already exists, will not overwrite (force with -f flag)")
+	}
+	if _, err = pubk.WriteToFile(pubkeyfile, overwrite); err != nil {
+		return errors.New("public key file already exists, will not overwrite (force with -f flag)")
+	}
+	return nil
 }
 
 func defaultCounter(path string) (counter int) {
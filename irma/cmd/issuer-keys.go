@@ -0,0 +1,224 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/gabi"
+	"github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/internal/common"
+	"github.com/spf13/cobra"
+)
+
+// issuerKeysCmd represents the keys command
+var issuerKeysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "Manage expiry and rotation of IRMA issuer keys",
+}
+
+var issuerKeysListCmd = &cobra.Command{
+	Use:   "list [<path>]",
+	Short: "List the public keys of all issuers along with their expiry dates",
+	Long: `List the public keys of all issuers within the scheme at the specified path, or of all schemes
+within the irma_configuration folder at the specified path (the current directory if not specified),
+along with their expiry dates and whether the corresponding private key is present.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		conf, err := parseKeysConfiguration(args)
+		if err != nil {
+			die("Failed to parse configuration", err)
+		}
+
+		for _, issuerid := range sortedIssuers(conf) {
+			keys, err := conf.IssuerKeys(issuerid)
+			if err != nil {
+				die("Failed to read keys of issuer "+issuerid.String(), err)
+			}
+			fmt.Println(issuerid.String())
+			if len(keys) == 0 {
+				fmt.Println("  no public keys")
+			}
+			for _, key := range keys {
+				var notes []string
+				if key.Latest {
+					notes = append(notes, "latest")
+				}
+				if key.Expired() {
+					notes = append(notes, "expired")
+				}
+				if key.PrivateKey {
+					notes = append(notes, "private key present")
+				}
+				if key.RevocationSupported {
+					notes = append(notes, "revocation")
+				}
+				fmt.Printf("  %4d  expires %s  %v\n", key.Counter, key.ExpiryDate.Format(time.RFC3339), notes)
+			}
+		}
+	},
+}
+
+var issuerKeysCheckCmd = &cobra.Command{
+	Use:   "check [<path>]",
+	Short: "Warn about expiring issuer keys and credential types issued under old keys",
+	Long: `Check warns about issuers within the scheme or irma_configuration folder at the specified path
+(the current directory if not specified) whose latest public key has expired or expires within the
+specified amount of days, and lists the credential types that are still issued under an older
+private key than the latest public key of their issuer.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		days, _ := cmd.Flags().GetUint("days")
+		conf, err := parseKeysConfiguration(args)
+		if err != nil {
+			die("Failed to parse configuration", err)
+		}
+
+		warnings, err := keyWarnings(conf, time.Duration(days)*24*time.Hour)
+		if err != nil {
+			die("Failed to check keys", err)
+		}
+		for _, warning := range warnings {
+			fmt.Println("Warning: " + warning)
+		}
+		if len(warnings) == 0 {
+			fmt.Println("No problems found.")
+		}
+	},
+}
+
+var issuerKeysNextCmd = &cobra.Command{
+	Use:   "next [<path>]",
+	Short: "Generate the next IRMA issuer keypair",
+	Long: `Generate the next keypair of the IRMA issuer specified by the "path" parameter (the current
+directory if not specified), using the counter of its latest public key plus one, and the same key
+length and amount of attributes as that key. The keypair includes revocation key material.
+
+After adding keys, the scheme must be resigned (using "irma scheme sign") before it can be used in
+IRMA applications.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.Flags()
+		expiryDateString, _ := flags.GetString("expirydate")
+		validFor, _ := flags.GetString("valid-for")
+
+		expiryDate, err := parseExpiryDate(expiryDateString, validFor)
+		if err != nil {
+			return err
+		}
+
+		var path string
+		if len(args) != 0 {
+			path = args[0]
+		} else if path, err = os.Getwd(); err != nil {
+			return err
+		}
+		if err = common.AssertPathExists(filepath.Join(path, "PublicKeys")); err != nil {
+			return errors.WrapPrefix(err, "Issuer has no public keys, use \"irma scheme issuer keygen\"", 0)
+		}
+
+		counter := uint(defaultCounter(path))
+		if counter == 0 {
+			return errors.New("Issuer has no public keys, use \"irma scheme issuer keygen\"")
+		}
+		latest, err := gabi.NewPublicKeyFromFile(filepath.Join(path, "PublicKeys", fmt.Sprintf("%d.xml", counter-1)))
+		if err != nil {
+			return errors.WrapPrefix(err, "Failed to read latest public key", 0)
+		}
+
+		keylength := latest.N.BitLen()
+		sysParams, ok := gabi.DefaultSystemParameters[keylength]
+		if !ok {
+			return errors.Errorf("Unsupported key length %d of latest public key", keylength)
+		}
+		fmt.Printf("Generating key %d (may take several minutes)\n", counter)
+		privk, pubk, err := gabi.GenerateKeyPair(sysParams, len(latest.R), counter, expiryDate)
+		if err != nil {
+			return err
+		}
+		if !privk.RevocationSupported() {
+			if err = gabi.GenerateRevocationKeypair(privk, pubk); err != nil {
+				return errors.WrapPrefix(err, "Failed to generate revocation keys", 0)
+			}
+		}
+
+		return writeIssuerKeypair(path, privk, pubk, "", "", false)
+	},
+}
+
+func init() {
+	issuerCmd.AddCommand(issuerKeysCmd)
+	issuerKeysCmd.AddCommand(issuerKeysListCmd)
+	issuerKeysCmd.AddCommand(issuerKeysCheckCmd)
+	issuerKeysCmd.AddCommand(issuerKeysNextCmd)
+
+	issuerKeysCheckCmd.Flags().UintP("days", "d", uint(irma.DefaultKeyExpiryWarningPeriod/(24*time.Hour)), "Warn about keys expiring within this amount of days")
+	issuerKeysNextCmd.Flags().StringP("expirydate", "e", "", "Expiry date for the key pair. Specify in RFC3339 (\"2006-01-02T15:04:05+07:00\") format. Alternatively, use the --valid-for option.")
+	issuerKeysNextCmd.Flags().StringP("valid-for", "v", "1y", "The duration key pair should be valid starting from now. Specify as a number followed by either y, M, d, h, or m (for years, months, days, hours, and minutes, respectively). This flag is ignored when expirydate flag is used.")
+}
+
+// keyWarnings returns warnings about issuer keys that (almost) expired, and about credential
+// types being issued under old keys.
+func keyWarnings(conf *irma.Configuration, period time.Duration) ([]string, error) {
+	warnings, err := conf.KeyExpiryWarnings(period)
+	if err != nil {
+		return nil, err
+	}
+	outdated, err := conf.OutdatedIssuanceKeys()
+	if err != nil {
+		return nil, err
+	}
+	var credwarnings []string
+	for credid, counter := range outdated {
+		credwarnings = append(credwarnings, fmt.Sprintf(
+			"Credential type %s is issued under key %d, which is not the latest public key of its issuer",
+			credid.String(), counter))
+	}
+	sort.Strings(credwarnings)
+	return append(warnings, credwarnings...), nil
+}
+
+// parseKeysConfiguration parses the scheme or irma_configuration folder at the path in args,
+// or at the current directory if not specified.
+func parseKeysConfiguration(args []string) (*irma.Configuration, error) {
+	var path string
+	var err error
+	if len(args) > 0 {
+		path, err = filepath.Abs(args[0])
+	} else {
+		path, err = os.Getwd()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	isScheme, err := common.PathExists(filepath.Join(path, "index"))
+	if err != nil {
+		return nil, err
+	}
+	if !isScheme {
+		conf, err := irma.NewConfiguration(path, irma.ConfigurationOptions{ReadOnly: true})
+		if err != nil {
+			return nil, err
+		}
+		return conf, conf.ParseFolder()
+	}
+
+	conf, err := irma.NewConfiguration(filepath.Dir(path), irma.ConfigurationOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	return conf, conf.ParseSchemeManagerFolder(path, irma.NewSchemeManager(filepath.Base(path)))
+}
+
+func sortedIssuers(conf *irma.Configuration) []irma.IssuerIdentifier {
+	var ids []irma.IssuerIdentifier
+	for id := range conf.Issuers {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+	return ids
+}
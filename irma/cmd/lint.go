@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/irmago"
+	"github.com/spf13/cobra"
+)
+
+var lintCmd = &cobra.Command{
+	Use:   "lint [<path>]",
+	Short: "Check a scheme for problems",
+	Long: `The lint command checks the scheme at the specified path (the current directory if not specified)
+for problems, such as missing translations or logos, invalid attribute display indices and
+inconsistent revocation settings. Contrary to "irma scheme verify" it reports all problems instead of
+stopping at the first one, and it does not require the scheme to be signed.
+
+Each problem has a rule ID, which can be used to suppress it using --suppress (may be repeated, or
+read from a file with one rule per line using --suppress-file). A rule may be suppressed for specific
+files only using rule:pattern, e.g. "missing-logo:irma-demo/RU/*".
+
+Exits with a nonzero status if any problem of severity error is found.
+
+Rules:
+` + lintRulesHelp(),
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+		format, _ := flags.GetString("format")
+		suppress, _ := flags.GetStringArray("suppress")
+		suppressFile, _ := flags.GetString("suppress-file")
+
+		var path string
+		var err error
+		if len(args) > 0 {
+			path = args[0]
+		} else if path, err = os.Getwd(); err != nil {
+			die("", err)
+		}
+		if suppressFile != "" {
+			bts, err := ioutil.ReadFile(suppressFile)
+			if err != nil {
+				die("Failed to read suppress file", err)
+			}
+			for _, line := range strings.Split(string(bts), "\n") {
+				if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+					suppress = append(suppress, line)
+				}
+			}
+		}
+
+		diagnostics, err := irma.LintScheme(path, irma.LintOptions{Suppress: suppress})
+		if err != nil {
+			die("Failed to lint scheme", err)
+		}
+
+		switch format {
+		case "text":
+			for _, d := range diagnostics {
+				fmt.Printf("%s: %s: %s [%s]\n", d.File, d.Severity, d.Message, d.Rule)
+			}
+		case "json":
+			if diagnostics == nil {
+				diagnostics = []irma.LintDiagnostic{}
+			}
+			bts, _ := json.MarshalIndent(diagnostics, "", "  ")
+			fmt.Println(string(bts))
+		case "sarif":
+			bts, _ := json.MarshalIndent(sarifLog(diagnostics), "", "  ")
+			fmt.Println(string(bts))
+		default:
+			die("", errors.New("Unsupported format "+format))
+		}
+
+		for _, d := range diagnostics {
+			if d.Severity == irma.LintError {
+				os.Exit(1)
+			}
+		}
+	},
+}
+
+func lintRulesHelp() string {
+	var rules []string
+	for rule, severity := range irma.LintRules {
+		rules = append(rules, fmt.Sprintf("  %-30s %s", rule, severity))
+	}
+	sort.Strings(rules)
+	return strings.Join(rules, "\n")
+}
+
+type (
+	sarif struct {
+		Version string     `json:"version"`
+		Schema  string     `json:"$schema"`
+		Runs    []sarifRun `json:"runs"`
+	}
+	sarifRun struct {
+		Tool    sarifTool     `json:"tool"`
+		Results []sarifResult `json:"results"`
+	}
+	sarifTool struct {
+		Driver sarifDriver `json:"driver"`
+	}
+	sarifDriver struct {
+		Name    string      `json:"name"`
+		Version string      `json:"version"`
+		Rules   []sarifRule `json:"rules"`
+	}
+	sarifRule struct {
+		ID                   string             `json:"id"`
+		DefaultConfiguration sarifConfiguration `json:"defaultConfiguration"`
+	}
+	sarifConfiguration struct {
+		Level irma.LintSeverity `json:"level"`
+	}
+	sarifResult struct {
+		RuleID    string            `json:"ruleId"`
+		Level     irma.LintSeverity `json:"level"`
+		Message   sarifMessage      `json:"message"`
+		Locations []sarifLocation   `json:"locations"`
+	}
+	sarifMessage struct {
+		Text string `json:"text"`
+	}
+	sarifLocation struct {
+		PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+	}
+	sarifPhysicalLocation struct {
+		ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	}
+	sarifArtifactLocation struct {
+		URI string `json:"uri"`
+	}
+)
+
+func sarifLog(diagnostics []irma.LintDiagnostic) *sarif {
+	driver := sarifDriver{Name: "irma scheme lint", Version: irma.Version}
+	for rule, severity := range irma.LintRules {
+		driver.Rules = append(driver.Rules, sarifRule{ID: rule, DefaultConfiguration: sarifConfiguration{severity}})
+	}
+	sort.Slice(driver.Rules, func(i, j int) bool { return driver.Rules[i].ID < driver.Rules[j].ID })
+
+	results := []sarifResult{}
+	for _, d := range diagnostics {
+		results = append(results, sarifResult{
+			RuleID:  d.Rule,
+			Level:   d.Severity,
+			Message: sarifMessage{d.Message},
+			Locations: []sarifLocation{{
+				PhysicalLocation: sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: d.File}},
+			}},
+		})
+	}
+
+	return &sarif{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs:    []sarifRun{{Tool: sarifTool{Driver: driver}, Results: results}},
+	}
+}
+
+func init() {
+	schemeCmd.AddCommand(lintCmd)
+
+	lintCmd.Flags().StringP("format", "f", "text", "output format: text, json or sarif")
+	lintCmd.Flags().StringArrayP("suppress", "s", nil, "rule to suppress, optionally followed by :pattern to suppress it only for matching files")
+	lintCmd.Flags().String("suppress-file", "", "file containing rules to suppress, one per line")
+}
@@ -7,15 +7,15 @@ import (
 	"os"
 	"strconv"
 	"strings"
-	"time"
 
-	sseclient "astuart.co/go-sse"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/go-errors/errors"
 	"github.com/mdp/qrterminal"
 	"github.com/privacybydesign/irmago"
 	"github.com/privacybydesign/irmago/internal/common"
 	"github.com/privacybydesign/irmago/server"
+	"github.com/privacybydesign/irmago/server/requestorclient"
+	"github.com/privacybydesign/irmago/server/requestorserver"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 )
@@ -76,6 +76,29 @@ func configureJWTKey(authmethod, key string) (interface{}, jwt.SigningMethod, er
 	return sk, jwtalg, nil
 }
 
+// configureRequestorClient returns a client for the IRMA server at the specified URL, that
+// authenticates using the specified method (none, token, hmac or rsa) and key.
+func configureRequestorClient(url, authmethod, key, name string) (*requestorclient.Client, error) {
+	switch authmethod {
+	case "none":
+		return requestorclient.New(url, requestorserver.AuthenticationMethodNone, nil, name)
+	case "token":
+		return requestorclient.New(url, requestorserver.AuthenticationMethodToken, key, name)
+	case "hmac", "rsa":
+		sk, _, err := configureJWTKey(authmethod, key)
+		if err != nil {
+			return nil, err
+		}
+		var method requestorserver.AuthenticationMethod = requestorserver.AuthenticationMethodHmac
+		if authmethod == "rsa" {
+			method = requestorserver.AuthenticationMethodPublicKey
+		}
+		return requestorclient.New(url, method, sk, name)
+	default:
+		return nil, errors.New("Invalid authentication method (must be none, token, hmac or rsa)")
+	}
+}
+
 func signRequest(request irma.RequestorRequest, name, authmethod, key string) (string, error) {
 	sk, jwtalg, err := configureJWTKey(authmethod, key)
 	if err != nil {
@@ -112,52 +135,6 @@ func configureRequest(cmd *cobra.Command) (irma.RequestorRequest, *irma.Configur
 
 // Helper functions
 
-func wait(initialStatus server.Status, transport *irma.HTTPTransport, statuschan chan server.Status) {
-	events := make(chan *sseclient.Event)
-
-	go func() {
-		for {
-			if e := <-events; e != nil && e.Type != "open" {
-				status := server.Status(strings.Trim(string(e.Data), `"`))
-				statuschan <- status
-				if status.Finished() {
-					return
-				}
-			}
-		}
-	}()
-
-	if err := sseclient.Notify(nil, transport.Server+"statusevents", true, events); err != nil {
-		fmt.Println("SSE failed, fallback to polling", err)
-		close(events)
-		poll(initialStatus, transport, statuschan)
-		return
-	}
-}
-
-// poll recursively polls the session status until a final status is received.
-func poll(initialStatus server.Status, transport *irma.HTTPTransport, statuschan chan server.Status) {
-	// First we wait
-	<-time.NewTimer(pollInterval).C
-
-	// Get session status
-	var s string
-	if err := transport.Get("status", &s); err != nil {
-		_ = server.LogFatal(err)
-	}
-	status := server.Status(strings.Trim(s, `"`))
-
-	// report if status changed
-	if status != initialStatus {
-		statuschan <- status
-	}
-
-	if status.Finished() {
-		return
-	}
-	go poll(status, transport, statuschan)
-}
-
 func constructSessionRequest(cmd *cobra.Command, conf *irma.Configuration) (irma.RequestorRequest, error) {
 	disclose, _ := cmd.Flags().GetStringArray("disclose")
 	issue, _ := cmd.Flags().GetStringArray("issue")
@@ -1,8 +1,6 @@
 package cmd
 
 import (
-	"time"
-
 	irma "github.com/privacybydesign/irmago"
 	"github.com/privacybydesign/irmago/server"
 	"github.com/spf13/cobra"
@@ -51,33 +49,11 @@ func postRevocation(request *irma.RevocationRequest, url, schemespath, authmetho
 		die("credential type does not support revocation", nil)
 	}
 
-	transport := irma.NewHTTPTransport(url)
-
-	switch authmethod {
-	case "none":
-		err = transport.Post("revocation", nil, request)
-	case "token":
-		transport.SetHeader("Authorization", key)
-		err = transport.Post("revocation", nil, request)
-	case "hmac", "rsa":
-		sk, jwtalg, err := configureJWTKey(authmethod, key)
-		j := irma.RevocationJwt{
-			ServerJwt: irma.ServerJwt{
-				ServerName: name,
-				IssuedAt:   irma.Timestamp(time.Now()),
-			},
-			Request: request,
-		}
-		jwtstr, err := j.Sign(jwtalg, sk)
-		if err != nil {
-			die("failed to sign JWT", err)
-		}
-		err = transport.Post("revocation", nil, jwtstr)
-	default:
-		die("Invalid authentication method (must be none, token, hmac or rsa)", nil)
-	}
-
+	client, err := configureRequestorClient(url, authmethod, key, name)
 	if err != nil {
+		die("failed to configure client", err)
+	}
+	if err = client.Revoke(request); err != nil {
 		die("failed to post revocation request", err)
 	}
 }
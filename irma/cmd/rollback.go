@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"path/filepath"
+
+	"github.com/privacybydesign/irmago"
+	"github.com/spf13/cobra"
+)
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback <path>",
+	Short: "Roll back a scheme to its version before its last update",
+	Long: `The rollback command restores the version of an IRMA scheme within an irma_configuration folder from before its last update, which the update command and the IRMA server keep in the .previous folder of irma_configuration.
+
+The current version of the scheme is kept in its place, so that running rollback again undoes the rollback. Note that the scheme is updated again the next time schemes are updated if the newer version is still online.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		path, err := filepath.Abs(args[0])
+		if err != nil {
+			die("", err)
+		}
+		irmaconf, scheme := filepath.Dir(path), filepath.Base(path)
+
+		conf, err := irma.NewConfiguration(irmaconf, irma.ConfigurationOptions{})
+		if err != nil {
+			die("", err)
+		}
+		if err = conf.RollbackScheme(scheme); err != nil {
+			die("Rolling back scheme failed", err)
+		}
+	},
+}
+
+func init() {
+	schemeCmd.AddCommand(rollbackCmd)
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/server"
+	"github.com/privacybydesign/irmago/server/schememirror"
+	"github.com/spf13/cobra"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve [<path>...]",
+	Short: "Serve schemes as a scheme mirror",
+	Long:  serveHelp(),
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+		port, _ := flags.GetInt("port")
+		addr, _ := flags.GetString("listen-addr")
+		interval, _ := flags.GetInt("update")
+		verbosity, _ := flags.GetCount("verbose")
+
+		paths := args
+		if len(paths) == 0 {
+			irmaconf := irma.DefaultSchemesPath()
+			if irmaconf == "" {
+				die("Failed to find default irma_configuration path", nil)
+			}
+			files, err := ioutil.ReadDir(irmaconf)
+			if err != nil {
+				die("Failed to read default irma_configuration path", err)
+			}
+			for _, file := range files {
+				if file.IsDir() && !strings.HasPrefix(file.Name(), ".") {
+					paths = append(paths, filepath.Join(irmaconf, file.Name()))
+				}
+			}
+		}
+
+		logger = server.NewLogger(verbosity, false, false)
+		irma.SetLogger(logger)
+		mirror, err := schememirror.New(&schememirror.Configuration{
+			Schemes:        paths,
+			UpdateInterval: interval,
+			Logger:         logger,
+		})
+		if err != nil {
+			die("Failed to start scheme mirror", err)
+		}
+
+		serv := &http.Server{Addr: fmt.Sprintf("%s:%d", addr, port), Handler: mirror}
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-interrupt
+			mirror.Stop()
+			_ = serv.Shutdown(context.Background())
+		}()
+
+		logger.Info("Listening at ", serv.Addr)
+		if err = serv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			die("", errors.WrapPrefix(err, "Scheme mirror failed", 0))
+		}
+	},
+}
+
+func serveHelp() string {
+	defaultIrmaconf := irma.DefaultSchemesPath()
+	str := `The serve command serves the specified scheme folders over HTTP at /$schemeid/, so that it can be used as a scheme mirror by setting SchemeMirror in irma.ConfigurationOptions. Only files whose hash matches the signed scheme index are served, using their hash as ETag.
+
+With --update, new versions of the schemes are periodically downloaded from their own URLs into the specified folders, and served after their signatures have been verified.`
+	if defaultIrmaconf != "" {
+		str += "\n\nIf no paths are given, the default schemes at " + defaultIrmaconf + " are served."
+	}
+	return str
+}
+
+func init() {
+	schemeCmd.AddCommand(serveCmd)
+
+	flags := serveCmd.Flags()
+	flags.IntP("port", "p", 8089, "port at which to listen")
+	flags.StringP("listen-addr", "l", "", "address at which to listen (default 0.0.0.0)")
+	flags.IntP("update", "u", 0, "update schemes from upstream every x minutes (0 to disable)")
+	flags.CountP("verbose", "v", "verbose (repeatable)")
+}
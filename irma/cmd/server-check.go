@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 
 	"github.com/go-errors/errors"
+	"github.com/privacybydesign/irmago"
 	"github.com/privacybydesign/irmago/server/requestorserver"
 	"github.com/spf13/cobra"
 )
@@ -29,6 +30,14 @@ Specify -v to see the configuration.`,
 			die("", errors.WrapPrefix(err, "Invalid configuration", 0))
 		}
 
+		warnings, err := keyWarnings(conf.IrmaConfiguration, irma.DefaultKeyExpiryWarningPeriod)
+		if err != nil {
+			die("", errors.WrapPrefix(err, "Failed to check issuer keys", 0))
+		}
+		for _, warning := range warnings {
+			conf.Logger.Warn(warning)
+		}
+
 		conf.DisableSchemesUpdate = enabled // restore previous value before printing configuration
 		bts, _ := json.MarshalIndent(conf, "", "   ")
 		conf.Logger.Debug("Configuration: ", string(bts), "\n")
@@ -1,9 +1,12 @@
 package cmd
 
 import (
+	"context"
+	"encoding/json"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"syscall"
 
@@ -44,12 +47,31 @@ var serverCmd = &cobra.Command{
 			stopped <- struct{}{}
 		}()
 
+		// On SIGTERM we first wait for unfinished sessions (at most --shutdown-timeout seconds);
+		// a second signal, or an interrupt, stops the server immediately
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		shuttingDown := false
 		for {
 			select {
-			case <-interrupt:
-				conf.Logger.Debug("Caught interrupt")
-				serv.Stop() // causes serv.Start() above to return
-				conf.Logger.Debug("Sent stop signal to server")
+			case sig := <-interrupt:
+				switch {
+				case shuttingDown:
+					conf.Logger.Debug("Caught signal during shutdown, no longer waiting for sessions")
+					cancel() // causes serv.Shutdown() below to stop the server
+				case sig == syscall.SIGTERM:
+					conf.Logger.Info("Caught SIGTERM, shutting down")
+					shuttingDown = true
+					go func() {
+						if err := serv.Shutdown(ctx); err != nil {
+							conf.Logger.Warn("Not all sessions finished before shutdown: ", err)
+						}
+					}()
+				default:
+					conf.Logger.Debug("Caught interrupt")
+					serv.Stop() // causes serv.Start() above to return
+					conf.Logger.Debug("Sent stop signal to server")
+				}
 			case <-stopped:
 				conf.Logger.Info("Exiting")
 				close(stopped)
@@ -96,6 +118,7 @@ func setFlags(cmd *cobra.Command, production bool) error {
 	flags.String("revocation-db-type", "", "database type for revocation database (supported: mysql, postgres)")
 	flags.String("revocation-db-str", "", "connection string for revocation database")
 	flags.Bool("sse", false, "Enable server sent for status updates (experimental)")
+	flags.Int("shutdown-timeout", 60, "on SIGTERM, wait at most this many seconds for unfinished sessions before exiting (0 to wait until they are done)")
 
 	flags.IntP("port", "p", 8088, "port at which to listen")
 	flags.StringP("listen-addr", "l", "", "address at which to listen (default 0.0.0.0)")
@@ -114,14 +137,18 @@ func setFlags(cmd *cobra.Command, production bool) error {
 	flags.StringSlice("issue-perms", nil, issHelp)
 	flags.StringSlice("revoke-perms", nil, "list of credentials that all requestors may revoke")
 	flags.String("static-sessions", "", "preconfigured static sessions (in JSON)")
+	flags.String("session-templates", "", "session request templates with parameters (in JSON)")
 	flags.Lookup("no-auth").Header = `Requestor authentication and default requestor permissions`
 
 	flags.String("revocation-settings", "", "revocation settings (in JSON)")
+	flags.String("revocation-replication", "", "share revocation state with other replicas of this server (in JSON)")
+	flags.String("transport", "", "timeouts, retries, proxy and CA certificates of outgoing HTTP requests (in JSON; durations in seconds or as e.g. \"1.5s\")")
 
 	flags.StringP("jwt-issuer", "j", "irmaserver", "JWT issuer")
 	flags.String("jwt-privkey", "", "JWT private key")
 	flags.String("jwt-privkey-file", "", "path to JWT private key")
 	flags.Int("max-request-age", 300, "max age in seconds of a session request JWT")
+	flags.String("status-token", "", "token with which the /status endpoint can be accessed (besides the tokens of token-authenticated requestors)")
 	flags.Lookup("jwt-issuer").Header = `JWT configuration`
 
 	flags.String("tls-cert", "", "TLS certificate (chain)")
@@ -234,8 +261,10 @@ func configureServer(cmd *cobra.Command) error {
 		DisableRequestorAuthentication: viper.GetBool("no-auth"),
 		Requestors:                     make(map[string]requestorserver.Requestor),
 		MaxRequestAge:                  viper.GetInt("max-request-age"),
+		StatusToken:                    viper.GetString("status-token"),
 		StaticPath:                     viper.GetString("static-path"),
 		StaticPrefix:                   viper.GetString("static-prefix"),
+		ShutdownTimeout:                viper.GetInt("shutdown-timeout"),
 
 		TlsCertificate:           viper.GetString("tls-cert"),
 		TlsCertificateFile:       viper.GetString("tls-cert-file"),
@@ -263,6 +292,9 @@ func configureServer(cmd *cobra.Command) error {
 	if err = handleMapOrString("static-sessions", &conf.StaticSessions); err != nil {
 		return err
 	}
+	if err = handleMapOrString("session-templates", &conf.SessionTemplates); err != nil {
+		return err
+	}
 	var m map[string]*irma.RevocationSetting
 	if err = handleMapOrString("revocation-settings", &m); err != nil {
 		return err
@@ -270,6 +302,14 @@ func configureServer(cmd *cobra.Command) error {
 	for i, s := range m {
 		conf.RevocationSettings[irma.NewCredentialTypeIdentifier(i)] = s
 	}
+	conf.Transport = &irma.TransportOptions{}
+	if err = handleMapOrString("transport", conf.Transport); err != nil {
+		return err
+	}
+	conf.RevocationReplication = &irma.RevocationReplication{}
+	if err = handleMapOrString("revocation-replication", conf.RevocationReplication); err != nil {
+		return err
+	}
 
 	logger.Debug("Done configuring")
 
@@ -287,12 +327,34 @@ func handleMapOrString(key string, dest interface{}) error {
 	if len(m) == 0 {
 		return nil
 	}
-	if err := mapstructure.Decode(m, dest); err != nil {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: durationHook,
+		Result:     dest,
+	})
+	if err != nil {
+		return err
+	}
+	if err = decoder.Decode(m); err != nil {
 		return errors.WrapPrefix(err, "Failed to unmarshal "+key+" from config file", 0)
 	}
 	return nil
 }
 
+// durationHook decodes an irma.Duration from a number of seconds or a duration string, like its
+// JSON unmarshaler does.
+func durationHook(_ reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if to != reflect.TypeOf(irma.Duration(0)) {
+		return data, nil
+	}
+	bts, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var d irma.Duration
+	err = json.Unmarshal(bts, &d)
+	return d, err
+}
+
 func handlePermission(typ string) []string {
 	if !viper.IsSet(typ) {
 		if typ == "revoke-perms" || (viper.GetBool("production") && typ == "issue-perms") {
@@ -1,12 +1,9 @@
 package cmd
 
 import (
-	"fmt"
 	"net/http"
 	"regexp"
 	"strconv"
-	"sync"
-	"time"
 
 	"github.com/go-errors/errors"
 	"github.com/privacybydesign/irmago"
@@ -17,8 +14,6 @@ import (
 	prefixed "github.com/x-cray/logrus-prefixed-formatter"
 )
 
-const pollInterval = 1000 * time.Millisecond
-
 var (
 	httpServer *http.Server
 	irmaServer *irmaserver.Server
@@ -128,82 +123,37 @@ func serverRequest(
 	logger.Debug("Server URL: ", serverurl)
 
 	// Start session at server
-	qr, transport, err := postRequest(serverurl, request, name, authmethod, key)
+	client, err := configureRequestorClient(serverurl, authmethod, key, name)
+	if err != nil {
+		return nil, err
+	}
+	session, err := client.StartSession(request)
 	if err != nil {
 		return nil, err
 	}
 
 	// Print session QR
-	logger.Debug("QR: ", prettyprint(qr))
-	if err := printQr(qr, noqr); err != nil {
+	logger.Debug("QR: ", prettyprint(session.SessionPtr))
+	if err := printQr(session.SessionPtr, noqr); err != nil {
 		return nil, errors.WrapPrefix(err, "Failed to print QR", 0)
 	}
 
+	// Wait until client finishes
 	statuschan := make(chan server.Status)
-	var wg sync.WaitGroup
-
-	go wait(server.StatusInitialized, transport, statuschan)
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-
-		// Wait until client connects
-		status := <-statuschan
-		if status != server.StatusConnected {
-			err = errors.Errorf("Unexpected status: %s", status)
-			return
-		}
-
-		// Wait until client finishes
-		status = <-statuschan
-		if status != server.StatusCancelled && status != server.StatusDone {
-			err = errors.Errorf("Unexpected status: %s", status)
-			return
-		}
-	}()
-
-	wg.Wait()
-	if err != nil {
-		return nil, err
+	session.Subscribe(statuschan)
+	var status server.Status
+	for status = range statuschan {
+		logger.Debug("Session status: ", status)
 	}
-
-	// Retrieve session result
-	result := &server.SessionResult{}
-	if err := transport.Get("result", result); err != nil {
-		return nil, errors.WrapPrefix(err, "Failed to get session result", 0)
+	if err = session.Err(); err != nil {
+		return nil, errors.WrapPrefix(err, "Failed to follow session status", 0)
 	}
-	return result, nil
-}
-
-func postRequest(serverurl string, request irma.RequestorRequest, name, authmethod, key string) (*irma.Qr, *irma.HTTPTransport, error) {
-	var (
-		err       error
-		pkg       = &server.SessionPackage{}
-		transport = irma.NewHTTPTransport(serverurl)
-	)
-
-	switch authmethod {
-	case "none":
-		err = transport.Post("session", pkg, request)
-	case "token":
-		transport.SetHeader("Authorization", key)
-		err = transport.Post("session", pkg, request)
-	case "hmac", "rsa":
-		var jwtstr string
-		jwtstr, err = signRequest(request, name, authmethod, key)
-		if err != nil {
-			return nil, nil, err
-		}
-		logger.Debug("Session request JWT: ", jwtstr)
-		err = transport.Post("session", pkg, jwtstr)
-	default:
-		return nil, nil, errors.New("Invalid authentication method (must be none, token, hmac or rsa)")
+	if status != server.StatusCancelled && status != server.StatusDone {
+		return nil, errors.Errorf("Unexpected status: %s", status)
 	}
 
-	token := pkg.Token
-	transport.Server += fmt.Sprintf("session/%s/", token)
-	return pkg.SessionPtr, transport, err
+	// Retrieve session result
+	return session.Result()
 }
 
 // Configuration functions
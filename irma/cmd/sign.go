@@ -3,6 +3,8 @@ package cmd
 import (
 	"crypto/ecdsa"
 	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -24,42 +26,77 @@ var signCmd = &cobra.Command{
 	Short: "Sign a scheme directory",
 	Long: `Sign a scheme manager directory, using the specified ECDSA key. Both arguments are optional; "sk.pem" and the working directory are the defaults. Outputs an index file, signature over the index file, and the public key in the specified directory.
 
+For schemes whose private keys are kept offline, or that require multiple signers, signing can instead be done in steps:
+ 1. "irma scheme sign --prepare [<path>]" writes the index file and prints its SHA256 digest, removing any previous signatures. Use --publickey (once per signer) and --threshold to write the public keys of the signers and the amount of required signatures to pk.pem.
+ 2. Each signer signs the index file, using "irma scheme sign --detached <sigfile> [<privatekey>] [<path>]" or any other tool producing a DER-encoded ECDSA signature over the SHA256 digest of the index file (e.g. "openssl dgst -sha256 -sign sk.pem -out sigfile index").
+ 3. "irma scheme sign --attach <sigfile> [<path>]" verifies each signature against the public keys in pk.pem and adds it to index.sig.
+
 Careful: this command could fail and invalidate or destroy your scheme manager directory! Use this only if you can restore it from git or backups.`,
 	Args: cobra.MaximumNArgs(2),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		// Validate arguments
+		flags := cmd.Flags()
+		prepare, _ := flags.GetBool("prepare")
+		attach, _ := flags.GetString("attach")
+		detached, _ := flags.GetString("detached")
+		skipverification, _ := flags.GetBool("noverification")
+		if prepare && attach != "" {
+			return errors.New("--prepare and --attach cannot be combined")
+		}
+
+		// Validate arguments; when preparing or attaching, no private key is used
+		if (prepare || attach != "") && len(args) > 1 {
+			return errors.New("Too many arguments")
+		}
 		var err error
 		var sk, confpath string
-		switch len(args) {
-		case 0:
+		switch {
+		case prepare || attach != "":
+			if len(args) == 1 {
+				confpath, err = filepath.Abs(args[0])
+			} else {
+				confpath, err = os.Getwd()
+			}
+		case len(args) == 0:
 			sk = "sk.pem"
 			confpath, err = os.Getwd()
-		case 1:
+		case len(args) == 1:
 			sk = args[0]
 			confpath, err = os.Getwd()
-		case 2:
+		case len(args) == 2:
 			sk = args[0]
 			confpath, err = filepath.Abs(args[1])
 		}
 		if err != nil {
 			return errors.WrapPrefix(err, "Invalid path", 0)
 		}
-
-		privatekey, err := readPrivateKey(sk)
-		if err != nil {
-			return errors.WrapPrefix(err, "Failed to read private key:", 0)
-		}
-
 		if err = common.AssertPathExists(confpath); err != nil {
 			return err
 		}
 
-		skipverification, err := cmd.Flags().GetBool("noverification")
-		if err != nil {
-			return err
-		}
-		if err := signManager(privatekey, confpath, skipverification); err != nil {
-			die("Failed to sign scheme", err)
+		switch {
+		case prepare:
+			pkpaths, _ := flags.GetStringArray("publickey")
+			threshold, _ := flags.GetInt("threshold")
+			if err = prepareManager(confpath, pkpaths, threshold); err != nil {
+				die("Failed to prepare scheme", err)
+			}
+		case attach != "":
+			if err = attachSignature(attach, confpath, skipverification); err != nil {
+				die("Failed to attach signature", err)
+			}
+		default:
+			privatekey, err := readPrivateKey(sk)
+			if err != nil {
+				return errors.WrapPrefix(err, "Failed to read private key:", 0)
+			}
+			if detached != "" {
+				err = signIndexDetached(privatekey, confpath, detached)
+			} else {
+				err = signManager(privatekey, confpath, skipverification)
+			}
+			if err != nil {
+				die("Failed to sign scheme", err)
+			}
 		}
 		return nil
 	},
@@ -69,13 +106,179 @@ func init() {
 	schemeCmd.AddCommand(signCmd)
 
 	signCmd.Flags().BoolP("noverification", "n", false, "Skip verification of the scheme after signing it")
+	signCmd.Flags().Bool("prepare", false, "Only write the index file and print its digest, for signing elsewhere")
+	signCmd.Flags().String("attach", "", "Add the signature in the specified file to the index signatures")
+	signCmd.Flags().String("detached", "", "Write a signature over the existing index file to the specified file, without modifying the scheme")
+	signCmd.Flags().StringArray("publickey", nil, "With --prepare: public key of a signer to write to pk.pem (may be repeated)")
+	signCmd.Flags().Int("threshold", 1, "With --prepare: amount of signers that must sign the index")
 }
 
 func signManager(privatekey *ecdsa.PrivateKey, confpath string, skipverification bool) error {
+	// Keep the current public keys if they include ours, so that we act as one of multiple signers
+	pks, threshold, err := readSchemePublicKeys(confpath)
+	if err != nil || indexOfKey(pks, &privatekey.PublicKey) < 0 {
+		pks, threshold = []*ecdsa.PublicKey{&privatekey.PublicKey}, 1
+	}
+
+	bts, err := writeIndex(confpath)
+	if err != nil {
+		return err
+	}
+
+	// Create and write signature
+	sigbytes, err := signed.Sign(privatekey, bts)
+	if err != nil {
+		return errors.WrapPrefix(err, "Failed to serialize signature:", 0)
+	}
+	if err = ioutil.WriteFile(filepath.Join(confpath, "index.sig"), sigbytes, 0644); err != nil {
+		return errors.WrapPrefix(err, "Failed to write index.sig", 0)
+	}
+
+	// Write public key(s)
+	if err = writeSchemePublicKeys(confpath, pks, threshold); err != nil {
+		return err
+	}
+
+	if threshold > 1 {
+		fmt.Printf("Signed scheme index; %d more signature(s) required, add them with --attach\n", threshold-1)
+		return nil
+	}
+	if skipverification {
+		return nil
+	}
+
+	// Verify that our folder is a valid scheme
+	if err := RunVerify(confpath, false); err != nil {
+		die("Scheme was signed but verification failed", err)
+	}
+	return nil
+}
+
+func prepareManager(confpath string, pkpaths []string, threshold int) error {
+	if len(pkpaths) > 0 {
+		var pks []*ecdsa.PublicKey
+		for _, path := range pkpaths {
+			bts, err := ioutil.ReadFile(path)
+			if err != nil {
+				return errors.WrapPrefix(err, "Failed to read public key", 0)
+			}
+			keys, _, err := irma.ParseSchemePublicKeys(bts)
+			if err != nil {
+				return errors.WrapPrefix(err, "Failed to parse public key "+path, 0)
+			}
+			pks = append(pks, keys...)
+		}
+		if err := writeSchemePublicKeys(confpath, pks, threshold); err != nil {
+			return err
+		}
+	}
+
+	bts, err := writeIndex(confpath)
+	if err != nil {
+		return err
+	}
+
+	// The old signatures are invalid now that the index has changed
+	if err = os.Remove(filepath.Join(confpath, "index.sig")); err != nil && !os.IsNotExist(err) {
+		return errors.WrapPrefix(err, "Failed to remove index.sig", 0)
+	}
+
+	digest := sha256.Sum256(bts)
+	fmt.Println("Index SHA256 digest:", hex.EncodeToString(digest[:]))
+	return nil
+}
+
+func signIndexDetached(privatekey *ecdsa.PrivateKey, confpath string, sigpath string) error {
+	bts, err := ioutil.ReadFile(filepath.Join(confpath, "index"))
+	if err != nil {
+		return errors.WrapPrefix(err, "Failed to read index", 0)
+	}
+	sigbytes, err := signed.Sign(privatekey, bts)
+	if err != nil {
+		return errors.WrapPrefix(err, "Failed to serialize signature:", 0)
+	}
+	if err = ioutil.WriteFile(sigpath, sigbytes, 0644); err != nil {
+		return errors.WrapPrefix(err, "Failed to write signature", 0)
+	}
+	return nil
+}
+
+func attachSignature(sigpath string, confpath string, skipverification bool) error {
+	sigbytes, err := ioutil.ReadFile(sigpath)
+	if err != nil {
+		return errors.WrapPrefix(err, "Failed to read signature", 0)
+	}
+	newsigs, err := irma.SplitSchemeSignatures(sigbytes)
+	if err != nil {
+		return err
+	}
+	if len(newsigs) != 1 {
+		return errors.Errorf("Expected one signature, found %d", len(newsigs))
+	}
+	index, err := ioutil.ReadFile(filepath.Join(confpath, "index"))
+	if err != nil {
+		return errors.WrapPrefix(err, "Failed to read index", 0)
+	}
+	pks, threshold, err := readSchemePublicKeys(confpath)
+	if err != nil {
+		return err
+	}
+
+	// Check that the new signature is made by one of our keys that did not sign before
+	signers, _ := irma.VerifySchemeSignatures(pks, threshold, index, newsigs)
+	if len(signers) == 0 {
+		return errors.New("Signature is not valid under any of the scheme public keys")
+	}
+	sigfile := filepath.Join(confpath, "index.sig")
+	oldbytes, err := ioutil.ReadFile(sigfile)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.WrapPrefix(err, "Failed to read index.sig", 0)
+	}
+	oldsigs, err := irma.SplitSchemeSignatures(oldbytes)
+	if err != nil {
+		return err
+	}
+	oldsigners, _ := irma.VerifySchemeSignatures(pks, threshold, index, oldsigs)
+	for _, i := range oldsigners {
+		if i == signers[0] {
+			return errors.New("Index was already signed by this key")
+		}
+	}
+
+	// Drop signatures over a previous index, and append the new one
+	var sigs []byte
+	for _, sig := range oldsigs {
+		if s, _ := irma.VerifySchemeSignatures(pks, 1, index, [][]byte{sig}); len(s) > 0 {
+			sigs = append(sigs, sig...)
+		}
+	}
+	sigs = append(sigs, newsigs[0]...)
+	if err = ioutil.WriteFile(sigfile, sigs, 0644); err != nil {
+		return errors.WrapPrefix(err, "Failed to write index.sig", 0)
+	}
+
+	count := len(oldsigners) + 1
+	if count < threshold {
+		fmt.Printf("Attached signature %d of %d\n", count, threshold)
+		return nil
+	}
+	fmt.Printf("Attached signature %d; signature threshold of %d reached\n", count, threshold)
+	if skipverification {
+		return nil
+	}
+	if err := RunVerify(confpath, false); err != nil {
+		die("Signature was attached but verification failed", err)
+	}
+	return nil
+}
+
+// writeIndex writes a new timestamp, and an index containing the hashes of all scheme files,
+// returning the index bytes.
+func writeIndex(confpath string) ([]byte, error) {
 	// Write timestamp
 	bts := []byte(strconv.FormatInt(time.Now().Unix(), 10) + "\n")
 	if err := ioutil.WriteFile(filepath.Join(confpath, "timestamp"), bts, 0644); err != nil {
-		return errors.WrapPrefix(err, "Failed to write timestamp", 0)
+		return nil, errors.WrapPrefix(err, "Failed to write timestamp", 0)
 	}
 
 	// Traverse dir and add file hashes to index
@@ -84,42 +287,43 @@ func signManager(privatekey *ecdsa.PrivateKey, confpath string, skipverification
 		return calculateFileHash(path, info, confpath, index)
 	})
 	if err != nil {
-		return errors.WrapPrefix(err, "Failed to calculate file index:", 0)
+		return nil, errors.WrapPrefix(err, "Failed to calculate file index:", 0)
 	}
 
 	// Write index
 	bts = []byte(index.String())
 	if err := ioutil.WriteFile(filepath.Join(confpath, "index"), bts, 0644); err != nil {
-		return errors.WrapPrefix(err, "Failed to write index", 0)
+		return nil, errors.WrapPrefix(err, "Failed to write index", 0)
 	}
+	return bts, nil
+}
 
-	// Create and write signature
-	sigbytes, err := signed.Sign(privatekey, bts)
+func readSchemePublicKeys(confpath string) ([]*ecdsa.PublicKey, int, error) {
+	bts, err := ioutil.ReadFile(filepath.Join(confpath, "pk.pem"))
 	if err != nil {
-		return errors.WrapPrefix(err, "Failed to serialize signature:", 0)
-	}
-	if err = ioutil.WriteFile(filepath.Join(confpath, "index.sig"), sigbytes, 0644); err != nil {
-		return errors.WrapPrefix(err, "Failed to write index.sig", 0)
+		return nil, 0, errors.WrapPrefix(err, "Failed to read public key(s)", 0)
 	}
+	return irma.ParseSchemePublicKeys(bts)
+}
 
-	// Write public key
-	pemEncodedPub, err := signed.MarshalPemPublicKey(&privatekey.PublicKey)
+func writeSchemePublicKeys(confpath string, pks []*ecdsa.PublicKey, threshold int) error {
+	bts, err := irma.MarshalSchemePublicKeys(pks, threshold)
 	if err != nil {
-		return errors.WrapPrefix(err, "Failed to serialize public key", 0)
+		return err
 	}
-	if err := ioutil.WriteFile(filepath.Join(confpath, "pk.pem"), pemEncodedPub, 0644); err != nil {
+	if err := ioutil.WriteFile(filepath.Join(confpath, "pk.pem"), bts, 0644); err != nil {
 		return errors.WrapPrefix(err, "Failed to write public key", 0)
 	}
+	return nil
+}
 
-	if skipverification {
-		return nil
-	}
-
-	// Verify that our folder is a valid scheme
-	if err := RunVerify(confpath, false); err != nil {
-		die("Scheme was signed but verification failed", err)
+func indexOfKey(pks []*ecdsa.PublicKey, pk *ecdsa.PublicKey) int {
+	for i, k := range pks {
+		if k.X.Cmp(pk.X) == 0 && k.Y.Cmp(pk.Y) == 0 {
+			return i
+		}
 	}
-	return nil
+	return -1
 }
 
 func readPrivateKey(path string) (*ecdsa.PrivateKey, error) {
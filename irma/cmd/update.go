@@ -2,7 +2,9 @@ package cmd
 
 import (
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/go-errors/errors"
 	"github.com/privacybydesign/irmago"
@@ -17,6 +19,21 @@ var updateCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		var paths []string
 		irmaconf := irma.DefaultSchemesPath()
+		if bundle, _ := cmd.Flags().GetString("from-bundle"); bundle != "" {
+			if len(args) > 1 {
+				die("Specify at most one irma_configuration path when using --from-bundle", nil)
+			}
+			if len(args) == 1 {
+				irmaconf = args[0]
+			} else if irmaconf == "" {
+				die("Failed to find default irma_configuration path", nil)
+			}
+			pkpath, _ := cmd.Flags().GetString("publickey")
+			if err := updateFromBundle(irmaconf, bundle, pkpath); err != nil {
+				die("Installing scheme bundle failed", err)
+			}
+			return
+		}
 		if len(args) != 0 {
 			paths = args
 		} else {
@@ -29,7 +46,7 @@ var updateCmd = &cobra.Command{
 			}
 			paths = make([]string, 0, len(files))
 			for _, file := range files {
-				if file.IsDir() {
+				if file.IsDir() && !strings.HasPrefix(file.Name(), ".") {
 					paths = append(paths, filepath.Join(irmaconf, file.Name()))
 				}
 			}
@@ -72,16 +89,51 @@ func updateSchemeManager(paths []string) error {
 	return nil
 }
 
+func updateFromBundle(irmaconf, bundle, pkpath string) error {
+	var pk []byte
+	if pkpath != "" {
+		var err error
+		if pk, err = ioutil.ReadFile(pkpath); err != nil {
+			return err
+		}
+	}
+	if err := common.EnsureDirectoryExists(irmaconf); err != nil {
+		return err
+	}
+	conf, err := irma.NewConfiguration(irmaconf, irma.ConfigurationOptions{})
+	if err != nil {
+		return err
+	}
+	if err = conf.ParseFolder(); err != nil {
+		return err
+	}
+
+	file, err := os.Open(bundle)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return conf.InstallSchemeBundle(file, pk)
+}
+
 func updateHelp() string {
 	defaultIrmaconf := irma.DefaultSchemesPath()
 	str := "The update command updates an IRMA scheme within an irma_configuration folder by comparing its index with the online version, and downloading any new and changed files.\n\n"
 	if defaultIrmaconf != "" {
 		str += "If no paths are given, the default schemes at " + defaultIrmaconf + " are updated.\n\n"
 	}
-	str += "Careful: this command could fail and invalidate or destroy your scheme manager folder! Use this only if you can restore it from git or backups."
+	str += "The update is downloaded and verified before it replaces the scheme. The previous version of the scheme is kept, and can be restored with the rollback command.\n\n"
+	str += "With --from-bundle, the scheme in the specified scheme bundle (see the bundle command) is installed into, or updated within, the irma_configuration folder given as argument"
+	if defaultIrmaconf != "" {
+		str += " (default " + defaultIrmaconf + ")"
+	}
+	str += ", instead of downloading it. The bundle is verified in the same way as an update downloaded from the scheme URL. When the scheme is installed, its public key is taken from the file specified with --publickey, or if not specified, from the bundle; when it is updated, the public key of the installed scheme is used."
 	return str
 }
 
 func init() {
 	schemeCmd.AddCommand(updateCmd)
+
+	updateCmd.Flags().String("from-bundle", "", "install or update the scheme in the specified scheme bundle")
+	updateCmd.Flags().String("publickey", "", "with --from-bundle: public key (pk.pem) to verify the scheme with when installing it, instead of the bundled one")
 }
@@ -129,6 +129,17 @@ func New(
 	storagePath string,
 	irmaConfigurationPath string,
 	handler ClientHandler,
+) (*Client, error) {
+	return NewWithTransport(storagePath, irmaConfigurationPath, handler, nil)
+}
+
+// NewWithTransport is like New, but if transport is not nil, it configures the HTTP
+// connections of the client to IRMA servers, keyshare servers, scheme and revocation servers.
+func NewWithTransport(
+	storagePath string,
+	irmaConfigurationPath string,
+	handler ClientHandler,
+	transport *irma.TransportOptions,
 ) (*Client, error) {
 	var err error
 	if err = common.AssertPathExists(storagePath); err != nil {
@@ -148,7 +159,7 @@ func New(
 
 	client.Configuration, err = irma.NewConfiguration(
 		filepath.Join(storagePath, "irma_configuration"),
-		irma.ConfigurationOptions{Assets: irmaConfigurationPath},
+		irma.ConfigurationOptions{Assets: irmaConfigurationPath, Transport: transport},
 	)
 	if err != nil {
 		return nil, err
@@ -485,13 +496,13 @@ func (client *Client) credentialByHash(hash string) (*credential, int, error) {
 	return nil, 0, nil
 }
 
-func (client *Client) credentialByID(id irma.CredentialIdentifier) (*credential, error) {
+func (client *Client) credentialByID(conf *irma.Configuration, id irma.CredentialIdentifier) (*credential, error) {
 	if _, exists := client.attributes[id.Type]; !exists {
 		return nil, nil
 	}
 	for index, attrs := range client.attributes[id.Type] {
 		if attrs.Hash() == id.Hash {
-			return client.credential(attrs.CredentialType().Identifier(), index)
+			return client.credentialFrom(conf, attrs.CredentialType().Identifier(), index)
 		}
 	}
 	return nil, nil
@@ -499,6 +510,12 @@ func (client *Client) credentialByID(id irma.CredentialIdentifier) (*credential,
 
 // credential returns the requested credential, or nil if we do not have it.
 func (client *Client) credential(id irma.CredentialTypeIdentifier, counter int) (cred *credential, err error) {
+	return client.credentialFrom(client.Configuration.Snapshot(), id, counter)
+}
+
+// credentialFrom is like credential, constructing the credential if it is not yet in the
+// credential map using the specified snapshot of the configuration.
+func (client *Client) credentialFrom(conf *irma.Configuration, id irma.CredentialTypeIdentifier, counter int) (cred *credential, err error) {
 	// If the requested credential is not in credential map, we check if its attributes were
 	// deserialized during New(). If so, there should be a corresponding signature file,
 	// so we read that, construct the credential, and add it to the credential map
@@ -515,7 +532,7 @@ func (client *Client) credential(id irma.CredentialTypeIdentifier, counter int)
 			err = errors.New("signature file not found")
 			return nil, err
 		}
-		pk, err := attrs.PublicKey()
+		pk, err := irma.MetadataFromInt(attrs.Ints[0], conf).PublicKey()
 		if err != nil {
 			return nil, err
 		}
@@ -527,7 +544,7 @@ func (client *Client) credential(id irma.CredentialTypeIdentifier, counter int)
 			Signature:            sig,
 			NonRevocationWitness: witness,
 			Pk:                   pk,
-		}, attrs, client.Configuration)
+		}, attrs, conf)
 		if err != nil {
 			return nil, err
 		}
@@ -543,7 +560,7 @@ func (client *Client) credential(id irma.CredentialTypeIdentifier, counter int)
 // in the conjunction. (A credential instance from the client is a candidate it it contains
 // attributes required in this conjunction). If one credential type occurs multiple times in the
 // conjunction it is not added twice.
-func (client *Client) credCandidates(base *irma.BaseRequest, con irma.AttributeCon) (credCandidateSet, error) {
+func (client *Client) credCandidates(conf *irma.Configuration, base *irma.BaseRequest, con irma.AttributeCon) (credCandidateSet, error) {
 	var candidates [][]*irma.CredentialIdentifier
 	for _, credtype := range con.CredentialTypes() {
 		attrlistlist := client.attributes[credtype]
@@ -557,7 +574,7 @@ func (client *Client) credCandidates(base *irma.BaseRequest, con irma.AttributeC
 				// the requestor did not ask for a nonrevocation proof
 				continue
 			}
-			cred, err := client.credential(credtype, i)
+			cred, err := client.credentialFrom(conf, credtype, i)
 			if err != nil {
 				return nil, err
 			}
@@ -630,8 +647,14 @@ func cartesianProduct(candidates [][]*irma.CredentialIdentifier) credCandidateSe
 func (client *Client) Candidates(base *irma.BaseRequest, discon irma.AttributeDisCon) (
 	candidates [][]*irma.AttributeIdentifier, missing map[int]map[int]MissingAttribute, err error,
 ) {
-	candidates = [][]*irma.AttributeIdentifier{}
+	// Use the same schemes for all credentials, even if they are updated in the meantime
+	return client.candidates(client.Configuration.Snapshot(), base, discon)
+}
 
+func (client *Client) candidates(conf *irma.Configuration, base *irma.BaseRequest, discon irma.AttributeDisCon) (
+	candidates [][]*irma.AttributeIdentifier, missing map[int]map[int]MissingAttribute, err error,
+) {
+	candidates = [][]*irma.AttributeIdentifier{}
 	for _, con := range discon {
 		if len(con) == 0 {
 			// An empty conjunction means the containing disjunction is optional
@@ -645,7 +668,7 @@ func (client *Client) Candidates(base *irma.BaseRequest, discon irma.AttributeDi
 		// attribute types as [ a.a.a.a, a.a.a.b, a.a.b.x ], we map this to:
 		// [ [ a.a.a #1, a.a.a #2] , [ a.a.b #1 ] ]
 		// assuming the client has 2 instances of a.a.a and 1 instance of a.a.b.
-		c, err := client.credCandidates(base, con)
+		c, err := client.credCandidates(conf, base, con)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -705,6 +728,12 @@ func (client *Client) missingAttributes(discon irma.AttributeDisCon) map[int]map
 // are returned.
 func (client *Client) CheckSatisfiability(request irma.SessionRequest) (
 	candidates [][][]*irma.AttributeIdentifier, missing MissingAttributes, err error,
+) {
+	return client.checkSatisfiability(client.Configuration.Snapshot(), request)
+}
+
+func (client *Client) checkSatisfiability(conf *irma.Configuration, request irma.SessionRequest) (
+	candidates [][][]*irma.AttributeIdentifier, missing MissingAttributes, err error,
 ) {
 	condiscon := request.Disclosure().Disclose
 	base := request.Base()
@@ -714,7 +743,7 @@ func (client *Client) CheckSatisfiability(request irma.SessionRequest) (
 	client.credMutex.Lock()
 	defer client.credMutex.Unlock()
 	for i, discon := range condiscon {
-		cands, m, err := client.Candidates(base, discon)
+		cands, m, err := client.candidates(conf, base, discon)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -736,7 +765,7 @@ type attributeGroup struct {
 
 // Given the user's choice of attributes to be disclosed, group them per credential out of which they
 // are to be disclosed
-func (client *Client) groupCredentials(choice *irma.DisclosureChoice) (
+func (client *Client) groupCredentials(conf *irma.Configuration, choice *irma.DisclosureChoice) (
 	[]attributeGroup, irma.DisclosedAttributeIndices, error,
 ) {
 	if choice == nil || choice.Attributes == nil {
@@ -768,7 +797,7 @@ func (client *Client) groupCredentials(choice *irma.DisclosureChoice) (
 				continue // In this case we only disclose the metadata attribute, which is already handled above
 			}
 
-			attrIndex, err := client.Configuration.CredentialTypes[identifier.CredentialTypeIdentifier()].IndexOf(identifier)
+			attrIndex, err := conf.CredentialTypes[identifier.CredentialTypeIdentifier()].IndexOf(identifier)
 			if err != nil {
 				return nil, nil, err
 			}
@@ -785,7 +814,12 @@ func (client *Client) groupCredentials(choice *irma.DisclosureChoice) (
 // ProofBuilders constructs a list of proof builders for the specified attribute choice.
 func (client *Client) ProofBuilders(choice *irma.DisclosureChoice, request irma.SessionRequest,
 ) (gabi.ProofBuilderList, irma.DisclosedAttributeIndices, *atum.Timestamp, error) {
-	todisclose, attributeIndices, err := client.groupCredentials(choice)
+	return client.proofBuilders(client.Configuration.Snapshot(), choice, request)
+}
+
+func (client *Client) proofBuilders(conf *irma.Configuration, choice *irma.DisclosureChoice, request irma.SessionRequest,
+) (gabi.ProofBuilderList, irma.DisclosedAttributeIndices, *atum.Timestamp, error) {
+	todisclose, attributeIndices, err := client.groupCredentials(conf, choice)
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -793,7 +827,7 @@ func (client *Client) ProofBuilders(choice *irma.DisclosureChoice, request irma.
 	var builders gabi.ProofBuilderList
 	var builder gabi.ProofBuilder
 	for _, grp := range todisclose {
-		cred, err := client.credentialByID(grp.cred)
+		cred, err := client.credentialByID(conf, grp.cred)
 		if err != nil {
 			return nil, nil, nil, err
 		}
@@ -819,7 +853,7 @@ func (client *Client) ProofBuilders(choice *irma.DisclosureChoice, request irma.
 			sigs = append(sigs, s)
 			disclosed = append(disclosed, d)
 		}
-		timestamp, err = irma.GetTimestamp(r.Message, sigs, disclosed, client.Configuration)
+		timestamp, err = irma.GetTimestamp(r.Message, sigs, disclosed, conf)
 		if err != nil {
 			return nil, nil, nil, err
 		}
@@ -830,7 +864,11 @@ func (client *Client) ProofBuilders(choice *irma.DisclosureChoice, request irma.
 
 // Proofs computes disclosure proofs containing the attributes specified by choice.
 func (client *Client) Proofs(choice *irma.DisclosureChoice, request irma.SessionRequest) (*irma.Disclosure, *atum.Timestamp, error) {
-	builders, choices, timestamp, err := client.ProofBuilders(choice, request)
+	return client.proofs(client.Configuration.Snapshot(), choice, request)
+}
+
+func (client *Client) proofs(conf *irma.Configuration, choice *irma.DisclosureChoice, request irma.SessionRequest) (*irma.Disclosure, *atum.Timestamp, error) {
+	builders, choices, timestamp, err := client.proofBuilders(conf, choice, request)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -851,6 +889,11 @@ func generateIssuerProofNonce() (*big.Int, error) {
 // for the future credentials as well as possibly any disclosed attributes, and generates
 // a nonce against which the issuer's proof of knowledge must verify.
 func (client *Client) IssuanceProofBuilders(request *irma.IssuanceRequest, choice *irma.DisclosureChoice,
+) (gabi.ProofBuilderList, irma.DisclosedAttributeIndices, *big.Int, error) {
+	return client.issuanceProofBuilders(client.Configuration.Snapshot(), request, choice)
+}
+
+func (client *Client) issuanceProofBuilders(conf *irma.Configuration, request *irma.IssuanceRequest, choice *irma.DisclosureChoice,
 ) (gabi.ProofBuilderList, irma.DisclosedAttributeIndices, *big.Int, error) {
 	issuerProofNonce, err := generateIssuerProofNonce()
 	if err != nil {
@@ -859,7 +902,7 @@ func (client *Client) IssuanceProofBuilders(request *irma.IssuanceRequest, choic
 	builders := gabi.ProofBuilderList([]gabi.ProofBuilder{})
 	for _, futurecred := range request.Credentials {
 		var pk *gabi.PublicKey
-		pk, err = client.Configuration.PublicKey(futurecred.CredentialTypeID.IssuerIdentifier(), futurecred.KeyCounter)
+		pk, err = conf.PublicKey(futurecred.CredentialTypeID.IssuerIdentifier(), futurecred.KeyCounter)
 		if err != nil {
 			return nil, nil, nil, err
 		}
@@ -868,7 +911,7 @@ func (client *Client) IssuanceProofBuilders(request *irma.IssuanceRequest, choic
 		builders = append(builders, credBuilder)
 	}
 
-	disclosures, choices, _, err := client.ProofBuilders(choice, request)
+	disclosures, choices, _, err := client.proofBuilders(conf, choice, request)
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -880,7 +923,12 @@ func (client *Client) IssuanceProofBuilders(request *irma.IssuanceRequest, choic
 // and also returns the credential builders which will become the new credentials upon combination with the issuer's signature.
 func (client *Client) IssueCommitments(request *irma.IssuanceRequest, choice *irma.DisclosureChoice,
 ) (*irma.IssueCommitmentMessage, gabi.ProofBuilderList, error) {
-	builders, choices, issuerProofNonce, err := client.IssuanceProofBuilders(request, choice)
+	return client.issueCommitments(client.Configuration.Snapshot(), request, choice)
+}
+
+func (client *Client) issueCommitments(conf *irma.Configuration, request *irma.IssuanceRequest, choice *irma.DisclosureChoice,
+) (*irma.IssueCommitmentMessage, gabi.ProofBuilderList, error) {
+	builders, choices, issuerProofNonce, err := client.issuanceProofBuilders(conf, request, choice)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -896,6 +944,10 @@ func (client *Client) IssueCommitments(request *irma.IssuanceRequest, choice *ir
 // ConstructCredentials constructs and saves new credentials using the specified issuance signature messages
 // and credential builders.
 func (client *Client) ConstructCredentials(msg []*gabi.IssueSignatureMessage, request *irma.IssuanceRequest, builders gabi.ProofBuilderList) error {
+	return client.constructCredentials(client.Configuration.Snapshot(), msg, request, builders)
+}
+
+func (client *Client) constructCredentials(conf *irma.Configuration, msg []*gabi.IssueSignatureMessage, request *irma.IssuanceRequest, builders gabi.ProofBuilderList) error {
 	if len(msg) > len(builders) {
 		return errors.New("Received unexpected amount of signatures")
 	}
@@ -917,7 +969,7 @@ func (client *Client) ConstructCredentials(msg []*gabi.IssueSignatureMessage, re
 			nonrevAttr = sig.NonRevocationWitness.E
 		}
 		attrs, err := request.Credentials[i-offset].AttributeList(
-			client.Configuration,
+			conf,
 			irma.GetMetadataVersion(request.Base().ProtocolVersion),
 			nonrevAttr,
 		)
@@ -932,8 +984,8 @@ func (client *Client) ConstructCredentials(msg []*gabi.IssueSignatureMessage, re
 	}
 
 	for _, gabicred := range gabicreds {
-		attrs := irma.NewAttributeListFromInts(gabicred.Attributes[1:], client.Configuration)
-		newcred, err := newCredential(gabicred, attrs, client.Configuration)
+		attrs := irma.NewAttributeListFromInts(gabicred.Attributes[1:], conf)
+		newcred, err := newCredential(gabicred, attrs, conf)
 		if err != nil {
 			return err
 		}
@@ -987,7 +1039,7 @@ func (client *Client) keyshareEnrollWorker(managerID irma.SchemeManagerIdentifie
 		return errors.New("PIN too short, must be at least 5 characters")
 	}
 
-	transport := irma.NewHTTPTransport(manager.KeyshareServer)
+	transport := client.Configuration.NewHTTPTransport(manager.KeyshareServer, irma.TransportPurposeKeyshare)
 	kss, err := newKeyshareServer(managerID)
 	if err != nil {
 		return err
@@ -1032,7 +1084,7 @@ func (client *Client) KeyshareVerifyPin(pin string, schemeid irma.SchemeManagerI
 		}
 	}
 	kss := client.keyshareServers[schemeid]
-	return verifyPinWorker(pin, kss, irma.NewHTTPTransport(scheme.KeyshareServer))
+	return verifyPinWorker(pin, kss, client.Configuration.NewHTTPTransport(scheme.KeyshareServer, irma.TransportPurposeKeyshare))
 }
 
 func (client *Client) KeyshareChangePin(manager irma.SchemeManagerIdentifier, oldPin string, newPin string) {
@@ -1050,7 +1102,7 @@ func (client *Client) keyshareChangePinWorker(managerID irma.SchemeManagerIdenti
 		return errors.New("Unknown keyshare server")
 	}
 
-	transport := irma.NewHTTPTransport(client.Configuration.SchemeManagers[managerID].KeyshareServer)
+	transport := client.Configuration.NewHTTPTransport(client.Configuration.SchemeManagers[managerID].KeyshareServer, irma.TransportPurposeKeyshare)
 	message := keyshareChangepin{
 		Username: kss.Username,
 		OldPin:   kss.HashedPin(oldPin),
@@ -1,6 +1,8 @@
 package irmaclient
 
 import (
+	"sync"
+
 	"github.com/go-errors/errors"
 	"github.com/privacybydesign/irmago"
 )
@@ -18,7 +20,7 @@ var _ Handler = (*keyshareEnrollmentHandler)(nil)
 
 // Session handlers in the order they are called
 
-func (h *keyshareEnrollmentHandler) RequestIssuancePermission(request *irma.IssuanceRequest, candidates [][][]*irma.AttributeIdentifier, ServerName irma.TranslatedString, callback PermissionHandler) {
+func (h *keyshareEnrollmentHandler) RequestIssuancePermission(request *irma.IssuanceRequest, candidates [][][]*irma.AttributeIdentifier, requestor *RequestorIdentity, callback PermissionHandler) {
 	// Fetch the username from the credential request and save it along with the scheme manager
 	for _, attr := range request.Credentials[0].Attributes {
 		h.kss.Username = attr
@@ -56,10 +58,10 @@ func (h *keyshareEnrollmentHandler) fail(err error) {
 func (h *keyshareEnrollmentHandler) StatusUpdate(action irma.Action, status irma.Status) {}
 
 // The methods below should never be called, so we let each of them fail the session
-func (h *keyshareEnrollmentHandler) RequestVerificationPermission(request *irma.DisclosureRequest, candidates [][][]*irma.AttributeIdentifier, ServerName irma.TranslatedString, callback PermissionHandler) {
+func (h *keyshareEnrollmentHandler) RequestVerificationPermission(request *irma.DisclosureRequest, candidates [][][]*irma.AttributeIdentifier, requestor *RequestorIdentity, callback PermissionHandler) {
 	callback(false, nil)
 }
-func (h *keyshareEnrollmentHandler) RequestSignaturePermission(request *irma.SignatureRequest, candidates [][][]*irma.AttributeIdentifier, ServerName irma.TranslatedString, callback PermissionHandler) {
+func (h *keyshareEnrollmentHandler) RequestSignaturePermission(request *irma.SignatureRequest, candidates [][][]*irma.AttributeIdentifier, requestor *RequestorIdentity, callback PermissionHandler) {
 	callback(false, nil)
 }
 func (h *keyshareEnrollmentHandler) RequestSchemeManagerPermission(manager *irma.SchemeManager, callback func(proceed bool)) {
@@ -80,9 +82,109 @@ func (h *keyshareEnrollmentHandler) KeyshareEnrollmentDeleted(manager irma.Schem
 func (h *keyshareEnrollmentHandler) KeyshareEnrollmentMissing(manager irma.SchemeManagerIdentifier) {
 	h.fail(errors.New("Keyshare enrollment failed: unenrolled"))
 }
-func (h *keyshareEnrollmentHandler) UnsatisfiableRequest(request irma.SessionRequest, ServerName irma.TranslatedString, missing MissingAttributes) {
+func (h *keyshareEnrollmentHandler) UnsatisfiableRequest(request irma.SessionRequest, requestor *RequestorIdentity, missing MissingAttributes) {
 	h.fail(errors.New("Keyshare enrollment failed: unsatisfiable"))
 }
 func (h *keyshareEnrollmentHandler) ClientReturnURLSet(clientReturnURL string) {
 	h.fail(errors.New("Keyshare enrollment session unexpectedly found an external return url"))
 }
+
+// guardedHandler wraps the Handler of a session, ensuring that after Cancelled() no more
+// callbacks are made. Callbacks that are running when the session is cancelled (including one
+// from which the session is dismissed) are allowed to return first; Cancelled() is then called
+// once the last of them has returned. Callbacks that start after that are dropped.
+type guardedHandler struct {
+	handler   Handler
+	mutex     sync.Mutex
+	running   int
+	cancelled bool
+}
+
+// Force guardedHandler to implement the Handler interface
+var _ Handler = (*guardedHandler)(nil)
+
+func newGuardedHandler(handler Handler) *guardedHandler {
+	if h, ok := handler.(*guardedHandler); ok {
+		handler = h.handler
+	}
+	return &guardedHandler{handler: handler}
+}
+
+// call invokes the callback, unless the session has been cancelled.
+func (h *guardedHandler) call(callback func()) {
+	h.mutex.Lock()
+	if h.cancelled {
+		h.mutex.Unlock()
+		return
+	}
+	h.running++
+	h.mutex.Unlock()
+
+	defer func() {
+		h.mutex.Lock()
+		h.running--
+		notify := h.cancelled && h.running == 0
+		h.mutex.Unlock()
+		if notify {
+			h.handler.Cancelled()
+		}
+	}()
+	callback()
+}
+
+func (h *guardedHandler) Cancelled() {
+	h.mutex.Lock()
+	if h.cancelled {
+		h.mutex.Unlock()
+		return
+	}
+	h.cancelled = true
+	notify := h.running == 0
+	h.mutex.Unlock()
+	if notify {
+		h.handler.Cancelled()
+	}
+}
+
+func (h *guardedHandler) StatusUpdate(action irma.Action, status irma.Status) {
+	h.call(func() { h.handler.StatusUpdate(action, status) })
+}
+func (h *guardedHandler) ClientReturnURLSet(clientReturnURL string) {
+	h.call(func() { h.handler.ClientReturnURLSet(clientReturnURL) })
+}
+func (h *guardedHandler) Success(result string) {
+	h.call(func() { h.handler.Success(result) })
+}
+func (h *guardedHandler) Failure(err *irma.SessionError) {
+	h.call(func() { h.handler.Failure(err) })
+}
+func (h *guardedHandler) UnsatisfiableRequest(request irma.SessionRequest, requestor *RequestorIdentity, missing MissingAttributes) {
+	h.call(func() { h.handler.UnsatisfiableRequest(request, requestor, missing) })
+}
+func (h *guardedHandler) KeyshareBlocked(manager irma.SchemeManagerIdentifier, duration int) {
+	h.call(func() { h.handler.KeyshareBlocked(manager, duration) })
+}
+func (h *guardedHandler) KeyshareEnrollmentIncomplete(manager irma.SchemeManagerIdentifier) {
+	h.call(func() { h.handler.KeyshareEnrollmentIncomplete(manager) })
+}
+func (h *guardedHandler) KeyshareEnrollmentMissing(manager irma.SchemeManagerIdentifier) {
+	h.call(func() { h.handler.KeyshareEnrollmentMissing(manager) })
+}
+func (h *guardedHandler) KeyshareEnrollmentDeleted(manager irma.SchemeManagerIdentifier) {
+	h.call(func() { h.handler.KeyshareEnrollmentDeleted(manager) })
+}
+func (h *guardedHandler) RequestIssuancePermission(request *irma.IssuanceRequest, candidates [][][]*irma.AttributeIdentifier, requestor *RequestorIdentity, callback PermissionHandler) {
+	h.call(func() { h.handler.RequestIssuancePermission(request, candidates, requestor, callback) })
+}
+func (h *guardedHandler) RequestVerificationPermission(request *irma.DisclosureRequest, candidates [][][]*irma.AttributeIdentifier, requestor *RequestorIdentity, callback PermissionHandler) {
+	h.call(func() { h.handler.RequestVerificationPermission(request, candidates, requestor, callback) })
+}
+func (h *guardedHandler) RequestSignaturePermission(request *irma.SignatureRequest, candidates [][][]*irma.AttributeIdentifier, requestor *RequestorIdentity, callback PermissionHandler) {
+	h.call(func() { h.handler.RequestSignaturePermission(request, candidates, requestor, callback) })
+}
+func (h *guardedHandler) RequestSchemeManagerPermission(manager *irma.SchemeManager, callback func(proceed bool)) {
+	h.call(func() { h.handler.RequestSchemeManagerPermission(manager, callback) })
+}
+func (h *guardedHandler) RequestPin(remainingAttempts int, callback PinHandler) {
+	h.call(func() { h.handler.RequestPin(remainingAttempts, callback) })
+}
@@ -4,9 +4,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/privacybydesign/gabi"
 	irma "github.com/privacybydesign/irmago"
@@ -350,6 +354,54 @@ func TestRemoveStorage(t *testing.T) {
 	require.NotEqual(t, old_sk, new_sk)
 }
 
+func TestDismissSession(t *testing.T) {
+	client, handler := parseStorage(t)
+	defer test.ClearTestStorage(t, handler.storage)
+
+	// The server hangs when the session request is fetched, until the client aborts the request
+	received, aborted := make(chan struct{}), make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			return
+		}
+		close(received)
+		<-r.Context().Done()
+		close(aborted)
+	}))
+	defer server.Close()
+
+	qr, err := json.Marshal(&irma.Qr{URL: server.URL, Type: irma.ActionDisclosing})
+	require.NoError(t, err)
+	sessionHandler := &recordingHandler{}
+	dismisser := client.NewSession(string(qr), sessionHandler)
+	<-received
+	dismisser.Dismiss()
+
+	select {
+	case <-aborted:
+	case <-time.After(time.Second):
+		t.Fatal("session request not aborted after dismissing session")
+	}
+	time.Sleep(100 * time.Millisecond)
+	callbacks := sessionHandler.recorded()
+	require.Equal(t, "Cancelled", callbacks[len(callbacks)-1])
+	require.NotContains(t, callbacks[:len(callbacks)-1], "Cancelled")
+	require.NotContains(t, callbacks, "Failure")
+
+	// A session dismissed from within a callback is cancelled once the callback has returned,
+	// after which no more callbacks are made
+	sessionHandler = &recordingHandler{}
+	guarded := newGuardedHandler(sessionHandler)
+	sessionHandler.statusUpdate = func() {
+		guarded.Cancelled()
+		require.Empty(t, sessionHandler.recorded())
+	}
+	guarded.StatusUpdate(irma.ActionDisclosing, irma.StatusCommunicating)
+	guarded.Success("")
+	guarded.Cancelled()
+	require.Equal(t, []string{"Cancelled"}, sessionHandler.recorded())
+}
+
 // ------
 
 type TestClientHandler struct {
@@ -403,3 +455,33 @@ func (i *TestClientHandler) ChangePinBlocked(manager irma.SchemeManagerIdentifie
 		i.t.Fatal(err)
 	}
 }
+
+// recordingHandler records the session handler callbacks made to it, other than StatusUpdate().
+type recordingHandler struct {
+	Handler // other callbacks are not expected
+
+	mutex        sync.Mutex
+	callbacks    []string
+	statusUpdate func()
+}
+
+func (h *recordingHandler) record(callback string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.callbacks = append(h.callbacks, callback)
+}
+
+func (h *recordingHandler) recorded() []string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]string{}, h.callbacks...)
+}
+
+func (h *recordingHandler) StatusUpdate(action irma.Action, status irma.Status) {
+	if h.statusUpdate != nil {
+		h.statusUpdate()
+	}
+}
+func (h *recordingHandler) Success(result string)          { h.record("Success") }
+func (h *recordingHandler) Cancelled()                     { h.record("Cancelled") }
+func (h *recordingHandler) Failure(err *irma.SessionError) { h.record("Failure") }
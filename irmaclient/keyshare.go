@@ -1,6 +1,7 @@
 package irmaclient
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
@@ -40,6 +41,7 @@ type keyshareSessionHandler interface {
 }
 
 type keyshareSession struct {
+	ctx              context.Context
 	sessionHandler   keyshareSessionHandler
 	pinRequestor     KeysharePinRequestor
 	builders         gabi.ProofBuilderList
@@ -148,7 +150,10 @@ func (ks *keyshareServer) HashedPin(pin string) string {
 // The user's pin is retrieved using the KeysharePinRequestor, repeatedly, until either it is correct; or the
 // user cancels; or one of the keyshare servers blocks us.
 // Error, blocked or success of the keyshare session is reported back to the keyshareSessionHandler.
+// When the context is done, requests to the keyshare servers are aborted and the keyshare
+// session stops without reporting anything.
 func startKeyshareSession(
+	ctx context.Context,
 	sessionHandler keyshareSessionHandler,
 	pin KeysharePinRequestor,
 	builders gabi.ProofBuilderList,
@@ -176,6 +181,7 @@ func startKeyshareSession(
 	}
 
 	ks := &keyshareSession{
+		ctx:              ctx,
 		session:          session,
 		builders:         builders,
 		sessionHandler:   sessionHandler,
@@ -195,7 +201,7 @@ func startKeyshareSession(
 		}
 
 		ks.keyshareServer = ks.keyshareServers[managerID]
-		transport := irma.NewHTTPTransport(scheme.KeyshareServer)
+		transport := ks.conf.NewHTTPTransport(scheme.KeyshareServer, irma.TransportPurposeKeyshare).WithContext(ctx)
 		transport.SetHeader(kssUsernameHeader, ks.keyshareServer.Username)
 		transport.SetHeader(kssAuthHeader, "Bearer "+ks.keyshareServer.token)
 		transport.SetHeader(kssVersionHeader, "2")
@@ -253,6 +259,12 @@ func (ks *keyshareSession) fail(manager irma.SchemeManagerIdentifier, err error)
 	}
 }
 
+// aborted returns whether the context of the keyshare session is done, in which case the
+// keyshare session must stop without further calls to its handlers.
+func (ks *keyshareSession) aborted() bool {
+	return ks.ctx.Err() != nil
+}
+
 // Ask for a pin, repeatedly if necessary, and either continue the keyshare protocol
 // with authorization, or stop the keyshare protocol and inform of failure.
 func (ks *keyshareSession) VerifyPin(attempts int) {
@@ -262,6 +274,9 @@ func (ks *keyshareSession) VerifyPin(attempts int) {
 			return
 		}
 		success, attemptsRemaining, blocked, manager, err := ks.verifyPinAttempt(pin)
+		if ks.aborted() {
+			return
+		}
 		if err != nil {
 			ks.sessionHandler.KeyshareError(&manager, err)
 			return
@@ -367,6 +382,9 @@ func (ks *keyshareSession) GetCommitments() {
 		transport := ks.transports[managerID]
 		comms := &proofPCommitmentMap{}
 		err := transport.Post("prove/getCommitments", comms, pkids[managerID])
+		if ks.aborted() {
+			return
+		}
 		if err != nil {
 			if err.(*irma.SessionError).RemoteError != nil &&
 				err.(*irma.SessionError).RemoteError.Status == http.StatusForbidden && !ks.pinCheck {
@@ -415,6 +433,9 @@ func (ks *keyshareSession) GetProofPs() {
 		}
 		var j string
 		err := transport.Post("prove/getResponse", &j, challenge)
+		if ks.aborted() {
+			return
+		}
 		if err != nil {
 			ks.sessionHandler.KeyshareError(&managerID, err)
 			return
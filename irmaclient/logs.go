@@ -27,6 +27,9 @@ type LogEntry struct {
 	// Issuance sessions
 	IssueCommitment *irma.IssueCommitmentMessage `json:",omitempty"`
 
+	// Sessions whose request specified purposes: consent receipt signed by the IRMA server
+	ConsentReceipt string `json:",omitempty"`
+
 	// All session types
 	ServerName irma.TranslatedString `json:",omitempty"`
 	Version    *irma.ProtocolVersion `json:",omitempty"`
@@ -1,6 +1,7 @@
 package irmaclient
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/binary"
 
@@ -18,11 +19,12 @@ import (
 
 func (client *Client) initRevocation() {
 	// For every credential supporting revocation, compute nonrevocation caches in async jobs
+	conf := client.Configuration.Snapshot()
 	for id, attrsets := range client.attributes {
-		for i, attrs := range attrsets {
-			if attrs.CredentialType() == nil || !attrs.CredentialType().RevocationSupported() {
-				continue
-			}
+		if credtype := conf.CredentialTypes[id]; credtype == nil || !credtype.RevocationSupported() {
+			continue
+		}
+		for i := range attrsets {
 			id := id // make copy of same name to capture the value for closure below
 			i := i   // see https://golang.org/doc/faq#closures_and_goroutines
 			client.jobs <- func() {
@@ -41,12 +43,14 @@ func (client *Client) initRevocation() {
 	// We do this by every 10 seconds updating the credential with a low probability, which
 	// increases over time since the last update.
 	client.Configuration.Scheduler.Every(irma.RevocationParameters.ClientUpdateInterval).Seconds().Do(func() {
+		conf := client.Configuration.Snapshot()
 		for id, attrsets := range client.attributes {
+			credtype := conf.CredentialTypes[id]
+			if credtype == nil || !credtype.RevocationSupported() {
+				continue
+			}
 			for i, attrs := range attrsets {
-				if attrs.CredentialType() == nil || !attrs.CredentialType().RevocationSupported() {
-					continue
-				}
-				cred, err := client.credential(id, i)
+				cred, err := client.credentialFrom(conf, id, i)
 				if err != nil {
 					client.reportError(err)
 					continue
@@ -59,7 +63,7 @@ func (client *Client) initRevocation() {
 					client.reportError(err)
 					break
 				}
-				speed := attrs.CredentialType().RevocationUpdateSpeed * 60 * 60
+				speed := credtype.RevocationUpdateSpeed * 60 * 60
 				p := probability(cred.NonRevocationWitness.Updated, speed)
 				if r < p {
 					irma.Logger.Debugf("scheduling nonrevocation witness remote update for %s-%s", id, attrs.Hash())
@@ -79,12 +83,22 @@ func (client *Client) initRevocation() {
 // requiring a nonrevocation proof, using the updates included in the request, or the remote
 // revocation server if those do not suffice.
 func (client *Client) NonrevPrepare(request irma.SessionRequest) error {
+	return client.NonrevPrepareContext(context.Background(), request)
+}
+
+// NonrevPrepareContext is like NonrevPrepare, but aborts downloading updates from the revocation
+// server when the specified context is done, in which case the context's error is returned.
+func (client *Client) NonrevPrepareContext(ctx context.Context, request irma.SessionRequest) error {
+	return client.nonrevPrepare(ctx, client.Configuration.Snapshot(), request)
+}
+
+func (client *Client) nonrevPrepare(ctx context.Context, conf *irma.Configuration, request irma.SessionRequest) error {
 	base := request.Base()
 	var err error
 	var wg sync.WaitGroup
 	for id := range request.Disclosure().Identifiers().CredentialTypes {
-		credtype := client.Configuration.CredentialTypes[id]
-		if !credtype.RevocationSupported() {
+		credtype := conf.CredentialTypes[id]
+		if credtype == nil || !credtype.RevocationSupported() {
 			continue
 		}
 		if !base.RequestsRevocation(id) {
@@ -93,27 +107,30 @@ func (client *Client) NonrevPrepare(request irma.SessionRequest) error {
 		irma.Logger.WithField("credtype", id).Debug("updating witnesses")
 		wg.Add(1)
 		go func() {
-			if e := client.nonrevUpdate(id, base.Revocation[id].Updates); e != nil {
+			if e := client.nonrevUpdate(ctx, conf, id, base.Revocation[id].Updates); e != nil {
 				err = e // overwrites err from previously finished call, if any
 			}
 			wg.Done()
 		}()
 	}
 	wg.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 	return err
 }
 
 // nonrevUpdate updates all contained instances of the specified type, using the specified
 // updates if present and if they suffice, and contacting the issuer's server to download updates
 // otherwise.
-func (client *Client) nonrevUpdate(id irma.CredentialTypeIdentifier, updates map[uint]*revocation.Update) error {
+func (client *Client) nonrevUpdate(ctx context.Context, conf *irma.Configuration, id irma.CredentialTypeIdentifier, updates map[uint]*revocation.Update) error {
 	lowest := map[uint]uint64{}
 	attrs := client.attrs(id)
 
 	// Per credential and issuer key counter we may posess multiple credential instances.
 	// Of the nonrevocation witnesses of these, take the lowest index.
 	for i := 0; i < len(attrs); i++ {
-		cred, err := client.credential(id, i)
+		cred, err := client.credentialFrom(conf, id, i)
 		if err != nil {
 			return err
 		}
@@ -140,7 +157,7 @@ func (client *Client) nonrevUpdate(id irma.CredentialTypeIdentifier, updates map
 			u[counter] = update
 		} else {
 			var err error
-			u[counter], err = irma.RevocationClient{Conf: client.Configuration}.
+			u[counter], err = irma.RevocationClient{Conf: conf, Context: ctx}.
 				FetchUpdateFrom(id, counter, l+1)
 			if err != nil {
 				return err
@@ -149,29 +166,34 @@ func (client *Client) nonrevUpdate(id irma.CredentialTypeIdentifier, updates map
 	}
 
 	// Apply the update messages to all instances of the given type and key counter
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	for counter, update := range u {
-		if err := client.nonrevApplyUpdates(id, counter, update); err != nil {
+		if err := client.nonrevApplyUpdates(conf, id, counter, update); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (client *Client) nonrevApplyUpdates(id irma.CredentialTypeIdentifier, counter uint, update *revocation.Update) error {
+func (client *Client) nonrevApplyUpdates(
+	conf *irma.Configuration, id irma.CredentialTypeIdentifier, counter uint, update *revocation.Update,
+) error {
 	client.credMutex.Lock()
 	defer client.credMutex.Unlock()
 
 	attrs := client.attrs(id)
 	var save bool
 	for i := 0; i < len(attrs); i++ {
-		cred, err := client.credential(id, i)
+		cred, err := client.credentialFrom(conf, id, i)
 		if err != nil {
 			return err
 		}
 		if cred.NonRevocationWitness == nil || cred.Pk.Counter != counter {
 			continue
 		}
-		updated, err := cred.nonrevApplyUpdates(update, irma.RevocationKeys{Conf: client.Configuration})
+		updated, err := cred.nonrevApplyUpdates(update, irma.RevocationKeys{Conf: conf})
 		if updated {
 			save = true
 		}
@@ -209,7 +231,7 @@ func (client *Client) nonrevApplyUpdates(id irma.CredentialTypeIdentifier, count
 }
 
 func (client *Client) NonrevUpdateFromServer(id irma.CredentialTypeIdentifier) error {
-	return client.nonrevUpdate(id, nil)
+	return client.nonrevUpdate(context.Background(), client.Configuration.Snapshot(), id, nil)
 }
 
 func (client *Client) nonrevPrepareCache(id irma.CredentialTypeIdentifier, index int) error {
@@ -226,9 +248,10 @@ func (client *Client) nonrevPrepareCache(id irma.CredentialTypeIdentifier, index
 // nonrevRepopulateCaches repopulates the consumed nonrevocation caches of the credentials involved
 // in the request, in background jobs, after the request has finished.
 func (client *Client) nonrevRepopulateCaches(request irma.SessionRequest) {
+	conf := client.Configuration.Snapshot()
 	for id := range request.Disclosure().Identifiers().CredentialTypes {
-		credtype := client.Configuration.CredentialTypes[id]
-		if !credtype.RevocationSupported() {
+		credtype := conf.CredentialTypes[id]
+		if credtype == nil || !credtype.RevocationSupported() {
 			continue
 		}
 		for i := range client.attrs(id) {
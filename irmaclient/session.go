@@ -1,12 +1,15 @@
 package irmaclient
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/url"
 	"reflect"
 	"runtime/debug"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bwesterb/go-atum"
@@ -28,7 +31,11 @@ type PermissionHandler func(proceed bool, choice *irma.DisclosureChoice)
 // PinHandler is used to provide the user's PIN code.
 type PinHandler func(proceed bool, pin string)
 
-// A Handler contains callbacks for communication to the user.
+// A Handler contains callbacks for communication to the user. The purposes for which attributes
+// are requested, if specified by the requestor, should be shown to the user when asking for
+// permission (see irma.DisclosureRequest.DisconPurpose()). Likewise the identity of the requestor
+// should be shown, along with a warning if it could not be verified or if the request contains
+// attributes that the requestor is not listed for (see RequestorIdentity).
 type Handler interface {
 	StatusUpdate(action irma.Action, status irma.Status)
 	ClientReturnURLSet(clientReturnURL string)
@@ -36,7 +43,7 @@ type Handler interface {
 	Cancelled()
 	Failure(err *irma.SessionError)
 	UnsatisfiableRequest(request irma.SessionRequest,
-		ServerName irma.TranslatedString,
+		requestor *RequestorIdentity,
 		missing MissingAttributes)
 
 	KeyshareBlocked(manager irma.SchemeManagerIdentifier, duration int)
@@ -46,15 +53,15 @@ type Handler interface {
 
 	RequestIssuancePermission(request *irma.IssuanceRequest,
 		candidates [][][]*irma.AttributeIdentifier,
-		ServerName irma.TranslatedString,
+		requestor *RequestorIdentity,
 		callback PermissionHandler)
 	RequestVerificationPermission(request *irma.DisclosureRequest,
 		candidates [][][]*irma.AttributeIdentifier,
-		ServerName irma.TranslatedString,
+		requestor *RequestorIdentity,
 		callback PermissionHandler)
 	RequestSignaturePermission(request *irma.SignatureRequest,
 		candidates [][][]*irma.AttributeIdentifier,
-		ServerName irma.TranslatedString,
+		requestor *RequestorIdentity,
 		callback PermissionHandler)
 	RequestSchemeManagerPermission(manager *irma.SchemeManager,
 		callback func(proceed bool))
@@ -62,7 +69,22 @@ type Handler interface {
 	RequestPin(remainingAttempts int, callback PinHandler)
 }
 
-// SessionDismisser can dismiss the current IRMA session.
+// RequestorIdentity describes the requestor of a session as it should be presented to the user.
+type RequestorIdentity struct {
+	// Name of the requestor: its verified name if Verified, and its hostname (or for issuance
+	// sessions in which all credentials have the same issuer, the name of that issuer) otherwise
+	Name irma.TranslatedString
+	// Verified is true if the hostname of the session is listed in one of the requestor schemes
+	Verified bool
+	// Info is the entry of the requestor in its requestor scheme, if Verified
+	Info *irma.RequestorInfo
+	// Unlisted contains the attributes to be disclosed or issued in the session that the
+	// requestor scheme does not allow for this requestor. If not empty, the user should be warned.
+	Unlisted []irma.AttributeTypeIdentifier
+}
+
+// SessionDismisser can dismiss the current IRMA session. Dismissing a session aborts its
+// network activity, after which Handler.Cancelled() is the last callback of the session.
 type SessionDismisser interface {
 	Dismiss()
 }
@@ -72,14 +94,24 @@ type session struct {
 	Handler    Handler
 	Version    *irma.ProtocolVersion
 	ServerName irma.TranslatedString
+	Requestor  *RequestorIdentity
 
 	choice         *irma.DisclosureChoice
 	attrIndices    irma.DisclosedAttributeIndices
 	client         *Client
 	request        irma.SessionRequest
 	done           bool
+	mutex          sync.Mutex // protects done
 	prepRevocation chan error // used when nonrevocation preprocessing is done
 
+	// Done when the session is finished, aborting its network activity
+	ctx   context.Context
+	abort context.CancelFunc
+
+	// Snapshot of client.Configuration, taken once the schemes of the request are downloaded,
+	// so that the schemes do not change during the session
+	configuration *irma.Configuration
+
 	// State for issuance sessions
 	issuerProofNonce *big.Int
 	builders         gabi.ProofBuilderList
@@ -87,6 +119,12 @@ type session struct {
 	// State for signature sessions
 	timestamp *atum.Timestamp
 
+	// Follow-up session started by the server after this one, if any
+	next SessionDismisser
+
+	// Set in offline proximity sessions, in which the disclosure is returned in binary
+	proximity bool
+
 	// These are empty on manual sessions
 	Hostname  string
 	ServerURL string
@@ -102,6 +140,7 @@ var supportedVersions = map[int][]int{
 		4, // old protocol with legacy session requests
 		5, // introduces condiscon feature
 		6, // introduces nonrevocation proofs
+		7, // introduces chained sessions, disclosure purposes and consent receipts
 	},
 }
 var minVersion = &irma.ProtocolVersion{Major: 2, Minor: supportedVersions[2][0]}
@@ -138,18 +177,45 @@ func (client *Client) NewSession(sessionrequest string, handler Handler) Session
 	return nil
 }
 
+// NewProximitySession starts an offline proximity disclosure session, given the binary
+// irma.ProximityRequest received directly from the verifier device (e.g. by QR or NFC).
+// Instead of being sent to a server, the disclosure is passed to Handler.Success() as the
+// base64 encoding of its compact binary encoding, for transferring it back to the verifier.
+// Note that disclosing attributes from schemes using a keyshare server still requires connectivity.
+func (client *Client) NewProximitySession(request []byte, handler Handler) SessionDismisser {
+	pr, dr, err := irma.ParseProximityRequest(request)
+	if err != nil {
+		handler.Failure(&irma.SessionError{ErrorType: irma.ErrorInvalidRequest, Err: err})
+		return nil
+	}
+
+	session := client.newSession(dr, handler, irma.ActionDisclosing)
+	session.Requestor = proximityRequestor(pr, dr, client.Configuration.Snapshot())
+	session.proximity = true
+	return client.startManualSession(session)
+}
+
 // newManualSession starts a manual session, given a signature request in JSON and a handler to pass messages to
 func (client *Client) newManualSession(request irma.SessionRequest, handler Handler, action irma.Action) SessionDismisser {
-	client.PauseJobs()
+	return client.startManualSession(client.newSession(request, handler, action))
+}
 
-	session := &session{
+func (client *Client) newSession(request irma.SessionRequest, handler Handler, action irma.Action) *session {
+	ctx, abort := context.WithCancel(context.Background())
+	return &session{
 		Action:         action,
-		Handler:        handler,
+		Handler:        newGuardedHandler(handler),
 		client:         client,
 		Version:        minVersion,
 		request:        request,
-		prepRevocation: make(chan error),
+		prepRevocation: make(chan error, 1),
+		ctx:            ctx,
+		abort:          abort,
 	}
+}
+
+func (client *Client) startManualSession(session *session) SessionDismisser {
+	client.PauseJobs()
 	session.Handler.StatusUpdate(session.Action, irma.StatusManualStarted)
 
 	session.processSessionInfo()
@@ -157,12 +223,15 @@ func (client *Client) newManualSession(request irma.SessionRequest, handler Hand
 }
 
 func (client *Client) newSchemeSession(qr *irma.SchemeManagerRequest, handler Handler) SessionDismisser {
+	ctx, abort := context.WithCancel(context.Background())
 	session := &session{
 		ServerURL: qr.URL,
-		transport: irma.NewHTTPTransport(qr.URL),
+		transport: client.Configuration.NewHTTPTransport(qr.URL, irma.TransportPurposeScheme).WithContext(ctx),
 		Action:    irma.ActionSchemeManager,
-		Handler:   handler,
+		Handler:   newGuardedHandler(handler),
 		client:    client,
+		ctx:       ctx,
+		abort:     abort,
 	}
 	session.Handler.StatusUpdate(session.Action, irma.StatusCommunicating)
 
@@ -174,7 +243,7 @@ func (client *Client) newSchemeSession(qr *irma.SchemeManagerRequest, handler Ha
 func (client *Client) newQrSession(qr *irma.Qr, handler Handler) SessionDismisser {
 	if qr.Type == irma.ActionRedirect {
 		newqr := &irma.Qr{}
-		if err := irma.NewHTTPTransport("").Post(qr.URL, newqr, struct{}{}); err != nil {
+		if err := client.Configuration.NewHTTPTransport("", irma.TransportPurposeSession).Post(qr.URL, newqr, struct{}{}); err != nil {
 			handler.Failure(&irma.SessionError{ErrorType: irma.ErrorTransport, Err: errors.Wrap(err, 0)})
 			return nil
 		}
@@ -188,14 +257,17 @@ func (client *Client) newQrSession(qr *irma.Qr, handler Handler) SessionDismisse
 	client.PauseJobs()
 
 	u, _ := url.ParseRequestURI(qr.URL) // Qr validator already checked this for errors
+	ctx, abort := context.WithCancel(context.Background())
 	session := &session{
 		ServerURL:      qr.URL,
 		Hostname:       u.Hostname(),
-		transport:      irma.NewHTTPTransport(qr.URL),
+		transport:      client.Configuration.NewHTTPTransport(qr.URL, irma.TransportPurposeSession).WithContext(ctx),
 		Action:         irma.Action(qr.Type),
-		Handler:        handler,
+		Handler:        newGuardedHandler(handler),
 		client:         client,
-		prepRevocation: make(chan error),
+		prepRevocation: make(chan error, 1),
+		ctx:            ctx,
+		abort:          abort,
 	}
 
 	session.Handler.StatusUpdate(session.Action, irma.StatusCommunicating)
@@ -268,6 +340,37 @@ func serverName(hostname string, request irma.SessionRequest, conf *irma.Configu
 	return sn
 }
 
+// requestorIdentity determines the identity of the requestor of the session, using the requestor
+// schemes if they list the hostname of the session.
+func requestorIdentity(hostname string, request irma.SessionRequest, conf *irma.Configuration) *RequestorIdentity {
+	info := conf.RequestorInfo(hostname)
+	if info == nil {
+		return &RequestorIdentity{Name: serverName(hostname, request, conf)}
+	}
+	return &RequestorIdentity{
+		Name:     info.Name,
+		Verified: true,
+		Info:     info,
+		Unlisted: info.Permits(request),
+	}
+}
+
+// proximityRequestor determines the identity of the verifier of a proximity session. Only if the
+// request is signed with the proximity key listed for its hostname in a requestor scheme is the
+// verifier considered verified.
+func proximityRequestor(pr *irma.ProximityRequest, request irma.SessionRequest, conf *irma.Configuration) *RequestorIdentity {
+	requestor := requestorIdentity(pr.Hostname, request, conf)
+	if !requestor.Verified {
+		return requestor
+	}
+	pk, err := requestor.Info.ProximityPublicKey()
+	if err == nil && pk != nil && pr.Verify(pk) == nil {
+		return requestor
+	}
+	irma.Logger.Warnf("Proximity request for %s not signed by its listed key", pr.Hostname)
+	return &RequestorIdentity{Name: irma.NewTranslatedString(&pr.Hostname)}
+}
+
 // processSessionInfo continues the session after all session state has been received:
 // it checks if the session can be performed and asks the user for consent.
 func (session *session) processSessionInfo() {
@@ -287,11 +390,14 @@ func (session *session) processSessionInfo() {
 		baserequest.ProtocolVersion = session.Version
 	}
 
-	session.ServerName = serverName(session.Hostname, session.request, session.client.Configuration)
+	if session.Requestor == nil {
+		session.Requestor = requestorIdentity(session.Hostname, session.request, session.configuration)
+	}
+	session.ServerName = session.Requestor.Name
 
 	if session.Action == irma.ActionIssuing {
 		ir := session.request.(*irma.IssuanceRequest)
-		_, err := ir.GetCredentialInfoList(session.client.Configuration, session.Version)
+		_, err := ir.GetCredentialInfoList(session.configuration, session.Version)
 		if err != nil {
 			session.fail(&irma.SessionError{ErrorType: irma.ErrorUnknownIdentifier, Err: err})
 			return
@@ -318,9 +424,11 @@ func (session *session) processSessionInfo() {
 	// if it finishes in time, then credentials that have been revoked can be excluded from the
 	// candidate calculation.
 	go func() {
-		session.prepRevocation <- session.client.NonrevPrepare(session.request)
+		session.prepRevocation <- session.client.nonrevPrepare(session.ctx, session.configuration, session.request)
 	}()
 	select {
+	case <-session.ctx.Done():
+		return
 	case err := <-session.prepRevocation:
 		irma.Logger.Debug("revocation witnesses updated before candidate computation")
 		close(session.prepRevocation)
@@ -332,13 +440,13 @@ func (session *session) processSessionInfo() {
 		irma.Logger.Debug("starting candidate computation before revocation witnesses updating finished")
 	}
 
-	candidates, missing, err := session.client.CheckSatisfiability(session.request)
+	candidates, missing, err := session.client.checkSatisfiability(session.configuration, session.request)
 	if err != nil {
 		session.fail(&irma.SessionError{ErrorType: irma.ErrorCrypto, Err: err})
 		return
 	}
 	if len(missing) > 0 {
-		session.Handler.UnsatisfiableRequest(session.request, session.ServerName, missing)
+		session.Handler.UnsatisfiableRequest(session.request, session.Requestor, missing)
 		return
 	}
 
@@ -357,13 +465,13 @@ func (session *session) processSessionInfo() {
 	switch session.Action {
 	case irma.ActionDisclosing:
 		session.Handler.RequestVerificationPermission(
-			session.request.(*irma.DisclosureRequest), candidates, session.ServerName, callback)
+			session.request.(*irma.DisclosureRequest), candidates, session.Requestor, callback)
 	case irma.ActionSigning:
 		session.Handler.RequestSignaturePermission(
-			session.request.(*irma.SignatureRequest), candidates, session.ServerName, callback)
+			session.request.(*irma.SignatureRequest), candidates, session.Requestor, callback)
 	case irma.ActionIssuing:
 		session.Handler.RequestIssuancePermission(
-			session.request.(*irma.IssuanceRequest), candidates, session.ServerName, callback)
+			session.request.(*irma.IssuanceRequest), candidates, session.Requestor, callback)
 	default:
 		panic("Invalid session type") // does not happen, session.Action has been checked earlier
 	}
@@ -382,7 +490,12 @@ func (session *session) doSession(proceed bool) {
 	session.Handler.StatusUpdate(session.Action, irma.StatusCommunicating)
 
 	// wait for revocation preparation to finish
-	err := <-session.prepRevocation
+	var err error
+	select {
+	case <-session.ctx.Done():
+		return
+	case err = <-session.prepRevocation:
+	}
 	if err != nil {
 		session.fail(&irma.SessionError{ErrorType: irma.ErrorRevocation, Err: err})
 		return
@@ -402,11 +515,12 @@ func (session *session) doSession(proceed bool) {
 			session.fail(&irma.SessionError{ErrorType: irma.ErrorCrypto, Err: err})
 		}
 		startKeyshareSession(
+			session.ctx,
 			session,
 			session.Handler,
 			session.builders,
 			session.request,
-			session.client.Configuration,
+			session.configuration,
 			session.client.keyshareServers,
 			session.issuerProofNonce,
 			session.timestamp,
@@ -422,6 +536,7 @@ func (session *session) sendResponse(message interface{}) {
 	var log *LogEntry
 	var err error
 	var messageJson []byte
+	response := &irma.ServerSessionResponse{} // stays empty in noninteractive sessions
 
 	switch session.Action {
 	case irma.ActionSigning:
@@ -438,15 +553,10 @@ func (session *session) sendResponse(message interface{}) {
 		}
 
 		if session.IsInteractive() {
-			var response disclosureResponse
-			if err = session.transport.Post("proofs", &response, irmaSignature); err != nil {
+			if response, err = session.postProofs(irmaSignature); err != nil {
 				session.fail(err.(*irma.SessionError))
 				return
 			}
-			if response != "VALID" {
-				session.fail(&irma.SessionError{ErrorType: irma.ErrorRejected, Info: string(response)})
-				return
-			}
 		}
 		log, err = session.createLogEntry(message)
 		if err != nil {
@@ -454,21 +564,22 @@ func (session *session) sendResponse(message interface{}) {
 			raven.CaptureError(err, nil)
 		}
 	case irma.ActionDisclosing:
-		messageJson, err = json.Marshal(message)
+		if session.proximity {
+			var bts []byte
+			bts, err = irma.MarshalBinary(message)
+			messageJson = []byte(base64.StdEncoding.EncodeToString(bts))
+		} else {
+			messageJson, err = json.Marshal(message)
+		}
 		if err != nil {
 			session.fail(&irma.SessionError{ErrorType: irma.ErrorSerialization, Err: err})
 			return
 		}
 		if session.IsInteractive() {
-			var response disclosureResponse
-			if err = session.transport.Post("proofs", &response, message); err != nil {
+			if response, err = session.postProofs(message); err != nil {
 				session.fail(err.(*irma.SessionError))
 				return
 			}
-			if response != "VALID" {
-				session.fail(&irma.SessionError{ErrorType: irma.ErrorRejected, Info: string(response)})
-				return
-			}
 		}
 		log, err = session.createLogEntry(message)
 		if err != nil {
@@ -476,12 +587,11 @@ func (session *session) sendResponse(message interface{}) {
 			raven.CaptureError(err, nil)
 		}
 	case irma.ActionIssuing:
-		response := []*gabi.IssueSignatureMessage{}
-		if err = session.transport.Post("commitments", &response, message); err != nil {
+		if response, err = session.postCommitments(message); err != nil {
 			session.fail(err.(*irma.SessionError))
 			return
 		}
-		if err = session.client.ConstructCredentials(response, session.request.(*irma.IssuanceRequest), session.builders); err != nil {
+		if err = session.client.constructCredentials(session.configuration, response.IssueSignatures, session.request.(*irma.IssuanceRequest), session.builders); err != nil {
 			session.fail(&irma.SessionError{ErrorType: irma.ErrorCrypto, Err: err})
 			return
 		}
@@ -492,16 +602,65 @@ func (session *session) sendResponse(message interface{}) {
 		}
 	}
 
+	if log != nil {
+		log.ConsentReceipt = response.ConsentReceipt
+	}
 	if err = session.client.storage.AddLogEntry(log); err != nil {
 		irma.Logger.Warn(errors.WrapPrefix(err, "Failed to write log entry", 0).ErrorStack())
 	}
 	if session.Action == irma.ActionIssuing {
 		session.client.handler.UpdateAttributes()
 	}
+	session.mutex.Lock()
 	session.done = true
+	session.mutex.Unlock()
+	session.abort()
 	session.client.nonrevRepopulateCaches(session.request)
 	session.client.StartJobs()
 	session.Handler.Success(string(messageJson))
+
+	// Proceed directly into the follow-up session, if the server started one
+	if response.NextSession != nil {
+		session.next = session.client.newQrSession(response.NextSession, session.Handler)
+	}
+}
+
+// postProofs POSTs the disclosure or attribute-based signature to the server. Servers using
+// protocol versions below 2.7 only return the proof status, which is converted to a
+// ServerSessionResponse.
+func (session *session) postProofs(message interface{}) (*irma.ServerSessionResponse, error) {
+	response := &irma.ServerSessionResponse{}
+	if session.Version.Below(2, 7) {
+		var status disclosureResponse
+		if err := session.transport.Post("proofs", &status, message); err != nil {
+			return nil, err
+		}
+		response.ProofStatus = irma.ProofStatus(status)
+	} else if err := session.transport.Post("proofs", response, message); err != nil {
+		return nil, err
+	}
+	if response.ProofStatus != irma.ProofStatusValid {
+		return nil, &irma.SessionError{ErrorType: irma.ErrorRejected, Info: string(response.ProofStatus)}
+	}
+	return response, nil
+}
+
+// postCommitments POSTs the issuance commitments to the server. Servers using protocol
+// versions below 2.7 only return the issuer's signatures, which are converted to a
+// ServerSessionResponse.
+func (session *session) postCommitments(message interface{}) (*irma.ServerSessionResponse, error) {
+	if session.Version.Below(2, 7) {
+		sigs := []*gabi.IssueSignatureMessage{}
+		if err := session.transport.Post("commitments", &sigs, message); err != nil {
+			return nil, err
+		}
+		return &irma.ServerSessionResponse{ProofStatus: irma.ProofStatusValid, IssueSignatures: sigs}, nil
+	}
+	response := &irma.ServerSessionResponse{}
+	if err := session.transport.Post("commitments", response, message); err != nil {
+		return nil, err
+	}
+	return response, nil
 }
 
 // managerSession performs a "session" in which a new scheme manager is added (asking for permission first).
@@ -552,9 +711,9 @@ func (session *session) getBuilders() (gabi.ProofBuilderList, irma.DisclosedAttr
 
 	switch session.Action {
 	case irma.ActionSigning, irma.ActionDisclosing:
-		builders, choices, session.timestamp, err = session.client.ProofBuilders(session.choice, session.request)
+		builders, choices, session.timestamp, err = session.client.proofBuilders(session.configuration, session.choice, session.request)
 	case irma.ActionIssuing:
-		builders, choices, issuerProofNonce, err = session.client.IssuanceProofBuilders(session.request.(*irma.IssuanceRequest), session.choice)
+		builders, choices, issuerProofNonce, err = session.client.issuanceProofBuilders(session.configuration, session.request.(*irma.IssuanceRequest), session.choice)
 	}
 
 	return builders, choices, issuerProofNonce, err
@@ -568,9 +727,9 @@ func (session *session) getProof() (interface{}, error) {
 
 	switch session.Action {
 	case irma.ActionSigning, irma.ActionDisclosing:
-		message, session.timestamp, err = session.client.Proofs(session.choice, session.request)
+		message, session.timestamp, err = session.client.proofs(session.configuration, session.choice, session.request)
 	case irma.ActionIssuing:
-		message, session.builders, err = session.client.IssueCommitments(session.request.(*irma.IssuanceRequest), session.choice)
+		message, session.builders, err = session.client.issueCommitments(session.configuration, session.request.(*irma.IssuanceRequest), session.choice)
 	}
 
 	return message, err
@@ -582,7 +741,7 @@ func (session *session) getProof() (interface{}, error) {
 // and aborts the session if not
 func (session *session) checkKeyshareEnrollment() bool {
 	for id := range session.request.Identifiers().SchemeManagers {
-		distributed := session.client.Configuration.SchemeManagers[id].Distributed()
+		distributed := session.configuration.SchemeManagers[id].Distributed()
 		_, enrolled := session.client.keyshareServers[id]
 		if distributed && !enrolled {
 			session.Handler.KeyshareEnrollmentMissing(id)
@@ -606,13 +765,14 @@ func (session *session) checkAndUpdateConfiguration() error {
 		}
 		session.client.handler.UpdateConfiguration(downloaded)
 	}
+	session.configuration = session.client.Configuration.Snapshot()
 
 	// Check if we are enrolled into all involved keyshare servers
 	if !session.checkKeyshareEnrollment() {
 		return &irma.SessionError{ErrorType: irma.ErrorKeyshareUnenrolled}
 	}
 
-	if err = session.request.Disclosure().Disclose.Validate(session.client.Configuration); err != nil {
+	if err = session.request.Disclosure().Disclose.Validate(session.configuration); err != nil {
 		return &irma.SessionError{ErrorType: irma.ErrorInvalidRequest}
 	}
 
@@ -630,7 +790,7 @@ func (session *session) Distributed() bool {
 	if session.Action == irma.ActionIssuing {
 		for _, credreq := range session.request.(*irma.IssuanceRequest).Credentials {
 			smi = credreq.CredentialTypeID.IssuerIdentifier().SchemeManagerIdentifier()
-			if session.client.Configuration.SchemeManagers[smi].Distributed() {
+			if session.configuration.SchemeManagers[smi].Distributed() {
 				return true
 			}
 		}
@@ -643,7 +803,7 @@ func (session *session) Distributed() bool {
 	for _, attrlist := range session.choice.Attributes {
 		for _, ai := range attrlist {
 			smi = ai.Type.CredentialTypeIdentifier().IssuerIdentifier().SchemeManagerIdentifier()
-			if session.client.Configuration.SchemeManagers[smi].Distributed() {
+			if session.configuration.SchemeManagers[smi].Distributed() {
 				return true
 			}
 		}
@@ -677,20 +837,25 @@ func panicToError(e interface{}) *irma.SessionError {
 	return &irma.SessionError{ErrorType: irma.ErrorPanic, Info: info + "\n\n" + string(debug.Stack())}
 }
 
-// finish the session, by sending a DELETE to the server if there is one, and restarting local
-// background jobs. This function is idempotent, doing nothing when called a second time. It
-// returns whether or not it did something.
+// finish the session, by aborting its network activity, sending a DELETE to the server if there
+// is one, and restarting local background jobs. This function is idempotent, doing nothing when
+// called a second time. It returns whether or not it did something.
 func (session *session) finish() bool {
-	if !session.done {
-		if session.IsInteractive() {
-			session.transport.Delete()
-		}
-		session.client.nonrevRepopulateCaches(session.request)
-		session.client.StartJobs()
-		session.done = true
-		return true
+	session.mutex.Lock()
+	done := session.done
+	session.done = true
+	session.mutex.Unlock()
+	if done {
+		return false
 	}
-	return false
+
+	session.abort()
+	if session.IsInteractive() {
+		_ = session.transport.WithContext(context.Background()).Delete()
+	}
+	session.client.nonrevRepopulateCaches(session.request)
+	session.client.StartJobs()
+	return true
 }
 
 func (session *session) fail(err *irma.SessionError) {
@@ -708,6 +873,10 @@ func (session *session) cancel() {
 }
 
 func (session *session) Dismiss() {
+	if session.next != nil {
+		session.next.Dismiss()
+		return
+	}
 	session.cancel()
 }
 
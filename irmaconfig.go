@@ -18,6 +18,7 @@ import (
 	"fmt"
 
 	"strings"
+	"sync"
 
 	"sort"
 
@@ -33,7 +34,6 @@ import (
 	"github.com/jasonlvhit/gocron"
 	"github.com/privacybydesign/gabi"
 	"github.com/privacybydesign/gabi/big"
-	"github.com/privacybydesign/gabi/signed"
 	"github.com/privacybydesign/irmago/internal/common"
 	"github.com/sirupsen/logrus"
 )
@@ -61,8 +61,35 @@ type Configuration struct {
 	// (i.e., invalid signature, parsing error), and the problem that occurred when parsing them
 	DisabledSchemeManagers map[SchemeManagerIdentifier]*SchemeManagerError
 
+	// RequestorSchemes contains the requestor schemes, and Requestors the requestors listed in the
+	// valid ones by hostname, with which the identity of the requestor of a session can be verified
+	RequestorSchemes map[RequestorSchemeIdentifier]*RequestorScheme
+	Requestors       map[string]*RequestorInfo
+
+	// DisabledRequestorSchemes keeps track of requestor schemes that did not parse succesfully.
+	// Unlike disabled scheme managers these do not cause ParseFolder() to fail; the requestors
+	// that they list are just not verified.
+	DisabledRequestorSchemes map[RequestorSchemeIdentifier]error
+
 	Warnings []string
 
+	updateStatus     SchemeUpdateStatus
+	updateStatusLock sync.Mutex
+
+	// The state as parsed by the last call to ParseFolder(), see Snapshot()
+	snapshot     *Configuration
+	snapshotLock sync.RWMutex
+	// If this is a snapshot, the Configuration of which it is the snapshot
+	live *Configuration
+
+	// Guards the caches of keys below and PrivateKeys, which are filled as keys are requested
+	keysLock sync.Mutex
+
+	// Listeners registered with SubscribeSchemeUpdates(), by subscription number
+	updateListeners     map[int]func(*SchemeUpdateEvent)
+	updateListenerCount int
+	updateListenersLock sync.Mutex
+
 	kssPublicKeys map[SchemeManagerIdentifier]map[int]*rsa.PublicKey
 	publicKeys    map[IssuerIdentifier]map[uint]*gabi.PublicKey
 	reverseHashes map[string]CredentialTypeIdentifier
@@ -70,6 +97,16 @@ type Configuration struct {
 	assets        string
 	readOnly      bool
 
+	// If set, scheme files are not downloaded from the scheme URL but read from the extracted
+	// scheme bundle in this folder, see InstallSchemeBundle()
+	bundle string
+
+	// The file system from which schemes and keys are read
+	fs FileSystem
+
+	// Health of the revocation servers used by RevocationClient, shared with snapshots
+	revocationServers *revocationServers
+
 	options ConfigurationOptions
 }
 
@@ -125,6 +162,23 @@ type ConfigurationOptions struct {
 	RevocationDBConnStr string
 	RevocationDBType    string
 	RevocationSettings  RevocationSettings
+	// RevocationReplication configures how revocation state is shared with other replicas
+	RevocationReplication *RevocationReplication
+
+	// SchemeMirror is the URL of a scheme mirror (e.g. as served by "irma scheme serve").
+	// If set, schemes are downloaded and updated from $SchemeMirror/$schemeid instead of from
+	// the URL in their description.
+	SchemeMirror string
+
+	// FileSystem, if set, is read from instead of the path passed to NewConfiguration(), e.g. to
+	// use schemes embedded in the binary. Schemes that are installed or updated are then written
+	// to the path instead, where they take precedence over the same schemes in FileSystem.
+	// If the path is empty, the Configuration is read-only.
+	FileSystem FileSystem
+
+	// Transport configures the HTTP transports used for downloading schemes, revocation and by
+	// users of the Configuration; see NewHTTPTransport().
+	Transport *TransportOptions
 }
 
 // NewConfiguration returns a new configuration. After this
@@ -135,6 +189,9 @@ func NewConfiguration(path string, opts ConfigurationOptions) (conf *Configurati
 		assets:   opts.Assets,
 		readOnly: opts.ReadOnly,
 		options:  opts,
+		fs:       DirFileSystem(path),
+
+		revocationServers: newRevocationServers(),
 	}
 
 	if conf.assets != "" { // If an assets folder is specified, then it must exist
@@ -142,8 +199,18 @@ func NewConfiguration(path string, opts ConfigurationOptions) (conf *Configurati
 			return nil, errors.WrapPrefix(err, "Nonexistent assets folder specified", 0)
 		}
 	}
-	if err = common.EnsureDirectoryExists(conf.Path); err != nil {
+	if opts.Transport != nil {
+		if err = opts.Transport.Validate(); err != nil {
+			return nil, errors.WrapPrefix(err, "Invalid transport options", 0)
+		}
+	}
+	if opts.FileSystem != nil && path == "" {
+		conf.readOnly = true
+		conf.fs = opts.FileSystem
+	} else if err = common.EnsureDirectoryExists(conf.Path); err != nil {
 		return nil, err
+	} else if opts.FileSystem != nil {
+		conf.fs = overlayFileSystem{upper: conf.fs, lower: opts.FileSystem}
 	}
 
 	// Init all maps
@@ -158,6 +225,9 @@ func (conf *Configuration) clear() {
 	conf.CredentialTypes = make(map[CredentialTypeIdentifier]*CredentialType)
 	conf.AttributeTypes = make(map[AttributeTypeIdentifier]*AttributeType)
 	conf.DisabledSchemeManagers = make(map[SchemeManagerIdentifier]*SchemeManagerError)
+	conf.RequestorSchemes = make(map[RequestorSchemeIdentifier]*RequestorScheme)
+	conf.Requestors = make(map[string]*RequestorInfo)
+	conf.DisabledRequestorSchemes = make(map[RequestorSchemeIdentifier]error)
 	conf.kssPublicKeys = make(map[SchemeManagerIdentifier]map[int]*rsa.PublicKey)
 	conf.publicKeys = make(map[IssuerIdentifier]map[uint]*gabi.PublicKey)
 	conf.PrivateKeys = make(map[IssuerIdentifier]map[uint]*gabi.PrivateKey)
@@ -166,9 +236,10 @@ func (conf *Configuration) clear() {
 
 // ParseFolder populates the current Configuration by parsing the storage path,
 // listing the containing scheme managers, issuers and credential types.
+// The new state is parsed into a separate Configuration, which is then published at once,
+// replacing the maps of the current Configuration and becoming its Snapshot().
 func (conf *Configuration) ParseFolder() (err error) {
-	// Init all maps
-	conf.clear()
+	parsed := conf.derive()
 
 	// Copy any new or updated scheme managers out of the assets into storage
 	if conf.assets != "" {
@@ -188,11 +259,24 @@ func (conf *Configuration) ParseFolder() (err error) {
 		}
 	}
 
-	// Parse scheme managers in storage
+	// Parse scheme managers and requestor schemes in storage
 	var mgrerr *SchemeManagerError
-	err = common.IterateSubfolders(conf.Path, func(dir string, _ os.FileInfo) error {
+	err = conf.iterateSubfolders(conf.Path, func(dir string, _ os.FileInfo) error {
+		isRequestorScheme, err := conf.isRequestorSchemeFolder(dir)
+		if err != nil {
+			return err
+		}
+		if isRequestorScheme {
+			scheme := NewRequestorScheme(filepath.Base(dir))
+			if err = parsed.ParseRequestorSchemeFolder(dir, scheme); err != nil {
+				Logger.WithField("scheme", scheme.ID).Warn("Disabling invalid requestor scheme: ", err.Error())
+				parsed.DisabledRequestorSchemes[scheme.Identifier()] = err
+			}
+			return nil
+		}
+
 		manager := NewSchemeManager(filepath.Base(dir))
-		err := conf.ParseSchemeManagerFolder(dir, manager)
+		err = parsed.ParseSchemeManagerFolder(dir, manager)
 		if err == nil {
 			return nil // OK, do next scheme manager folder
 		}
@@ -200,7 +284,7 @@ func (conf *Configuration) ParseFolder() (err error) {
 		// so as to continue parsing other managers.
 		var ok bool
 		if mgrerr, ok = err.(*SchemeManagerError); ok {
-			conf.DisabledSchemeManagers[manager.Identifier()] = mgrerr
+			parsed.DisabledSchemeManagers[manager.Identifier()] = mgrerr
 			return nil
 		}
 		return err // Not a SchemeManagerError? return it & halt parsing now
@@ -209,27 +293,156 @@ func (conf *Configuration) ParseFolder() (err error) {
 		return
 	}
 
-	if conf.Revocation == nil {
+	loadRevocation := conf.Revocation == nil
+	if loadRevocation {
 		conf.Scheduler = gocron.NewScheduler()
 		conf.Scheduler.Start()
 		conf.Revocation = &RevocationStorage{conf: conf}
+	}
+	parsed.Revocation, parsed.Scheduler = conf.Revocation, conf.Scheduler
+	parsed.initialized = true
+	conf.publish(parsed)
+
+	if loadRevocation {
 		if err = conf.Revocation.Load(
 			Logger.IsLevelEnabled(logrus.DebugLevel),
 			conf.options.RevocationDBType,
 			conf.options.RevocationDBConnStr,
 			conf.options.RevocationSettings,
+			conf.options.RevocationReplication,
 		); err != nil {
 			return err
 		}
 	}
 
-	conf.initialized = true
 	if mgrerr != nil {
 		return mgrerr
 	}
 	return
 }
 
+// Snapshot returns the state of the Configuration as parsed by the last call to ParseFolder()
+// (or changed since by installing or removing schemes), as a Configuration that is not modified by
+// later calls to ParseFolder(), e.g. by scheme updates. Code that runs concurrently with these
+// should pin a snapshot to consistently look up schemes, issuers, credential types and keys.
+// If the Configuration has not been parsed yet, it is returned itself.
+func (conf *Configuration) Snapshot() *Configuration {
+	conf.snapshotLock.RLock()
+	defer conf.snapshotLock.RUnlock()
+	if conf.snapshot == nil {
+		return conf
+	}
+	return conf.snapshot
+}
+
+// derive returns an empty Configuration with the same path and options as conf,
+// into which a new state can be parsed.
+func (conf *Configuration) derive() *Configuration {
+	derived := &Configuration{
+		Path:       conf.Path,
+		Revocation: conf.Revocation,
+		Scheduler:  conf.Scheduler,
+		Warnings:   append([]string{}, conf.Warnings...),
+		assets:     conf.assets,
+		readOnly:   conf.readOnly,
+		options:    conf.options,
+		fs:         conf.fs,
+
+		revocationServers: conf.revocationServers,
+	}
+	derived.clear()
+	return derived
+}
+
+// clone returns a Configuration with a copy of the current state of conf, which can be modified
+// and then published with publish(), so that the snapshot of conf is never modified in place.
+func (conf *Configuration) clone() *Configuration {
+	cloned := conf.derive()
+	cloned.copyState(conf)
+	return cloned
+}
+
+// publish replaces the state of conf by that of the parsed Configuration, which becomes the
+// snapshot of conf. The maps of conf are copies, so that conf can still be modified without
+// affecting the snapshot. The maps of conf are replaced without synchronization, so code running
+// concurrently with this should not use them but pin a Snapshot() instead.
+func (conf *Configuration) publish(parsed *Configuration) {
+	conf.snapshotLock.Lock()
+	defer conf.snapshotLock.Unlock()
+
+	conf.copyState(parsed)
+	parsed.live = conf
+	conf.snapshot = parsed
+}
+
+// copyState replaces the state of conf by copies of the maps of src.
+func (conf *Configuration) copyState(src *Configuration) {
+	conf.SchemeManagers = make(map[SchemeManagerIdentifier]*SchemeManager, len(src.SchemeManagers))
+	for id, manager := range src.SchemeManagers {
+		conf.SchemeManagers[id] = manager
+	}
+	conf.Issuers = make(map[IssuerIdentifier]*Issuer, len(src.Issuers))
+	for id, issuer := range src.Issuers {
+		conf.Issuers[id] = issuer
+	}
+	conf.CredentialTypes = make(map[CredentialTypeIdentifier]*CredentialType, len(src.CredentialTypes))
+	for id, credtype := range src.CredentialTypes {
+		conf.CredentialTypes[id] = credtype
+	}
+	conf.AttributeTypes = make(map[AttributeTypeIdentifier]*AttributeType, len(src.AttributeTypes))
+	for id, attrtype := range src.AttributeTypes {
+		conf.AttributeTypes[id] = attrtype
+	}
+	conf.DisabledSchemeManagers = make(map[SchemeManagerIdentifier]*SchemeManagerError, len(src.DisabledSchemeManagers))
+	for id, err := range src.DisabledSchemeManagers {
+		conf.DisabledSchemeManagers[id] = err
+	}
+	conf.RequestorSchemes = make(map[RequestorSchemeIdentifier]*RequestorScheme, len(src.RequestorSchemes))
+	for id, scheme := range src.RequestorSchemes {
+		conf.RequestorSchemes[id] = scheme
+	}
+	conf.Requestors = make(map[string]*RequestorInfo, len(src.Requestors))
+	for hostname, requestor := range src.Requestors {
+		conf.Requestors[hostname] = requestor
+	}
+	conf.DisabledRequestorSchemes = make(map[RequestorSchemeIdentifier]error, len(src.DisabledRequestorSchemes))
+	for id, err := range src.DisabledRequestorSchemes {
+		conf.DisabledRequestorSchemes[id] = err
+	}
+	conf.reverseHashes = make(map[string]CredentialTypeIdentifier, len(src.reverseHashes))
+	for hash, id := range src.reverseHashes {
+		conf.reverseHashes[hash] = id
+	}
+	conf.Warnings = append([]string{}, src.Warnings...)
+
+	// The caches of public keys are filled again as keys are requested. Private keys do not depend
+	// on the schemes, so they are kept, and snapshots retrieve them from their live Configuration.
+	conf.keysLock.Lock()
+	conf.kssPublicKeys = make(map[SchemeManagerIdentifier]map[int]*rsa.PublicKey)
+	conf.publicKeys = make(map[IssuerIdentifier]map[uint]*gabi.PublicKey)
+	conf.keysLock.Unlock()
+
+	conf.initialized = src.initialized
+}
+
+// republish publishes a copy of the current state of conf as its snapshot, after conf has been
+// modified other than by ParseFolder(). It does nothing if conf has not been parsed yet.
+func (conf *Configuration) republish() {
+	conf.snapshotLock.RLock()
+	parsed := conf.snapshot != nil
+	conf.snapshotLock.RUnlock()
+	if !parsed {
+		return
+	}
+
+	copied := conf.clone()
+	copied.live = conf
+
+	conf.snapshotLock.Lock()
+	defer conf.snapshotLock.Unlock()
+	conf.snapshot = copied
+}
+
 // ParseOrRestoreFolder parses the irma_configuration folder, and when possible attempts to restore
 // any broken scheme managers from their remote.
 // Any error encountered during parsing is considered recoverable only if it is of type *SchemeManagerError;
@@ -260,6 +473,7 @@ func (conf *Configuration) ParseOrRestoreFolder() error {
 		}
 	}
 
+	conf.republish()
 	return err
 }
 
@@ -287,7 +501,7 @@ func (conf *Configuration) ParseSchemeManagerFolder(dir string, manager *SchemeM
 	if err = conf.VerifySignature(manager.Identifier()); err != nil {
 		return
 	}
-	if manager.index, err = conf.parseIndex(filepath.Base(dir), manager); err != nil {
+	if manager.index, err = conf.parseIndex(filepath.Base(dir)); err != nil {
 		manager.Status = SchemeManagerStatusInvalidIndex
 		return
 	}
@@ -312,7 +526,7 @@ func (conf *Configuration) ParseSchemeManagerFolder(dir string, manager *SchemeM
 	}
 
 	// Read timestamp indicating time of last modification
-	ts, exists, err := readTimestamp(dir + "/timestamp")
+	ts, exists, err := conf.readTimestamp(dir + "/timestamp")
 	if err != nil || !exists {
 		return errors.WrapPrefix(err, "Could not read scheme manager timestamp", 0)
 	}
@@ -331,6 +545,12 @@ func (conf *Configuration) ParseSchemeManagerFolder(dir string, manager *SchemeM
 
 // PrivateKey returns the specified private key of the specified issuer if present; an error otherwise.
 func (conf *Configuration) PrivateKey(id IssuerIdentifier, counter uint) (*gabi.PrivateKey, error) {
+	if conf.live != nil {
+		return conf.live.PrivateKey(id, counter)
+	}
+	conf.keysLock.Lock()
+	defer conf.keysLock.Unlock()
+
 	if _, haveIssuer := conf.PrivateKeys[id]; haveIssuer {
 		if sk := conf.PrivateKeys[id][counter]; sk != nil {
 			return sk, nil
@@ -339,7 +559,11 @@ func (conf *Configuration) PrivateKey(id IssuerIdentifier, counter uint) (*gabi.
 
 	path := fmt.Sprintf(privkeyPattern, conf.Path, id.SchemeManagerIdentifier().Name(), id.Name())
 	file := strings.Replace(path, "*", strconv.FormatUint(uint64(counter), 10), 1)
-	sk, err := gabi.NewPrivateKeyFromFile(file)
+	bts, err := conf.readFile(file)
+	if err != nil {
+		return nil, err
+	}
+	sk, err := gabi.NewPrivateKeyFromXML(string(bts))
 	if err != nil {
 		return nil, err
 	}
@@ -369,6 +593,9 @@ func (conf *Configuration) PrivateKeyLatest(id IssuerIdentifier) (*gabi.PrivateK
 
 // PublicKey returns the specified public key, or nil if not present in the Configuration.
 func (conf *Configuration) PublicKey(id IssuerIdentifier, counter uint) (*gabi.PublicKey, error) {
+	conf.keysLock.Lock()
+	defer conf.keysLock.Unlock()
+
 	var haveIssuer, haveKey bool
 	var err error
 	_, haveIssuer = conf.publicKeys[id]
@@ -415,11 +642,14 @@ func (conf *Configuration) KeyshareServerKeyFunc(scheme SchemeManagerIdentifier)
 
 // KeyshareServerPublicKey returns the i'th public key of the specified scheme.
 func (conf *Configuration) KeyshareServerPublicKey(scheme SchemeManagerIdentifier, i int) (*rsa.PublicKey, error) {
+	conf.keysLock.Lock()
+	defer conf.keysLock.Unlock()
+
 	if _, contains := conf.kssPublicKeys[scheme]; !contains {
 		conf.kssPublicKeys[scheme] = make(map[int]*rsa.PublicKey)
 	}
 	if _, contains := conf.kssPublicKeys[scheme][i]; !contains {
-		pkbts, err := ioutil.ReadFile(filepath.Join(conf.Path, scheme.Name(), fmt.Sprintf("kss-%d.pem", i)))
+		pkbts, err := conf.readFile(filepath.Join(conf.Path, scheme.Name(), fmt.Sprintf("kss-%d.pem", i)))
 		if err != nil {
 			return nil, err
 		}
@@ -464,7 +694,7 @@ func (conf *Configuration) Prune() {
 }
 
 func (conf *Configuration) parseIssuerFolders(manager *SchemeManager, path string) error {
-	return common.IterateSubfolders(path, func(dir string, _ os.FileInfo) error {
+	return conf.iterateSubfolders(path, func(dir string, _ os.FileInfo) error {
 		issuer := &Issuer{}
 		exists, err := conf.pathToDescription(manager, dir+"/description.xml", issuer)
 		if err != nil {
@@ -496,16 +726,19 @@ func (conf *Configuration) DeleteSchemeManager(id SchemeManagerIdentifier) error
 			delete(conf.Issuers, iss)
 		}
 	}
+	conf.keysLock.Lock()
 	for iss := range conf.publicKeys {
 		if iss.Root() == name {
 			delete(conf.publicKeys, iss)
 		}
 	}
+	conf.keysLock.Unlock()
 	for cred := range conf.CredentialTypes {
 		if cred.Root() == name {
 			delete(conf.CredentialTypes, cred)
 		}
 	}
+	conf.republish()
 	if !conf.readOnly {
 		return os.RemoveAll(filepath.Join(conf.Path, id.Name()))
 	}
@@ -517,7 +750,7 @@ func (conf *Configuration) parseKeysFolder(issuerid IssuerIdentifier) error {
 	manager := conf.SchemeManagers[issuerid.SchemeManagerIdentifier()]
 	conf.publicKeys[issuerid] = map[uint]*gabi.PublicKey{}
 	path := fmt.Sprintf(pubkeyPattern, conf.Path, issuerid.SchemeManagerIdentifier().Name(), issuerid.Name())
-	files, err := filepath.Glob(path)
+	files, err := conf.glob(path)
 	if err != nil {
 		return err
 	}
@@ -529,11 +762,7 @@ func (conf *Configuration) parseKeysFolder(issuerid IssuerIdentifier) error {
 		if err != nil {
 			return err
 		}
-		relativepath, err := filepath.Rel(conf.Path, file)
-		if err != nil {
-			return err
-		}
-		bts, found, err := conf.ReadAuthenticatedFile(manager, relativepath)
+		bts, found, err := conf.ReadAuthenticatedFile(manager, conf.fsPath(file))
 		if err != nil || !found {
 			return err
 		}
@@ -572,14 +801,19 @@ func unionset(a, b []uint) []uint {
 }
 
 func (conf *Configuration) PrivateKeyIndices(issuerid IssuerIdentifier) (i []uint, err error) {
+	if conf.live != nil {
+		return conf.live.PrivateKeyIndices(issuerid)
+	}
 	filekeys, err := conf.matchKeyPattern(issuerid, privkeyPattern)
 	if err != nil {
 		return nil, err
 	}
 	var mapkeys []uint
+	conf.keysLock.Lock()
 	for _, sk := range conf.PrivateKeys[issuerid] {
 		mapkeys = append(mapkeys, sk.Counter)
 	}
+	conf.keysLock.Unlock()
 	return unionset(filekeys, mapkeys), nil
 }
 
@@ -589,7 +823,7 @@ func (conf *Configuration) PublicKeyIndices(issuerid IssuerIdentifier) (i []uint
 
 func (conf *Configuration) matchKeyPattern(issuerid IssuerIdentifier, pattern string) (ints []uint, err error) {
 	pkpath := fmt.Sprintf(pattern, conf.Path, issuerid.SchemeManagerIdentifier().Name(), issuerid.Name())
-	files, err := filepath.Glob(pkpath)
+	files, err := conf.glob(pkpath)
 	if err != nil {
 		return
 	}
@@ -608,7 +842,7 @@ func (conf *Configuration) matchKeyPattern(issuerid IssuerIdentifier, pattern st
 // parse $schememanager/$issuer/Issues/*/description.xml
 func (conf *Configuration) parseCredentialsFolder(manager *SchemeManager, issuer *Issuer, path string) error {
 	var foundcred bool
-	err := common.IterateSubfolders(path, func(dir string, _ os.FileInfo) error {
+	err := conf.iterateSubfolders(path, func(dir string, _ os.FileInfo) error {
 		cred := &CredentialType{}
 		exists, err := conf.pathToDescription(manager, dir+"/description.xml", cred)
 		if err != nil {
@@ -652,14 +886,11 @@ func (conf *Configuration) parseCredentialsFolder(manager *SchemeManager, issuer
 }
 
 func (conf *Configuration) pathToDescription(manager *SchemeManager, path string, description interface{}) (bool, error) {
-	if _, err := os.Stat(path); err != nil {
+	if exists, err := conf.pathExists(path); err != nil || !exists {
 		return false, nil
 	}
 
-	relativepath, err := filepath.Rel(conf.Path, path)
-	if err != nil {
-		return false, err
-	}
+	relativepath := conf.fsPath(path)
 	bts, found, err := conf.ReadAuthenticatedFile(manager, relativepath)
 	if !found {
 		if manager.index.Scheme() != manager.Identifier() {
@@ -727,6 +958,10 @@ func (conf *Configuration) CopyManagerFromAssets(scheme SchemeManagerIdentifier)
 // DownloadSchemeManager downloads and returns a scheme manager description.xml file
 // from the specified URL.
 func DownloadSchemeManager(url string) (*SchemeManager, error) {
+	return downloadSchemeManager(url, nil)
+}
+
+func downloadSchemeManager(url string, opts *TransportOptions) (*SchemeManager, error) {
 	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
 		url = "https://" + url
 	}
@@ -736,7 +971,7 @@ func DownloadSchemeManager(url string) (*SchemeManager, error) {
 	if strings.HasSuffix(url, "/description.xml") {
 		url = url[:len(url)-len("/description.xml")]
 	}
-	b, err := NewHTTPTransport(url).GetBytes("description.xml")
+	b, err := NewHTTPTransportWithOptions(url, opts, TransportPurposeScheme).GetBytes("description.xml")
 	if err != nil {
 		return nil, err
 	}
@@ -763,12 +998,15 @@ func (conf *Configuration) RemoveSchemeManager(id SchemeManagerIdentifier, fromS
 			delete(conf.Issuers, issid)
 		}
 	}
+	conf.keysLock.Lock()
 	for issid := range conf.publicKeys {
 		if issid.SchemeManagerIdentifier() == id {
 			delete(conf.publicKeys, issid)
 		}
 	}
+	conf.keysLock.Unlock()
 	delete(conf.SchemeManagers, id)
+	conf.republish()
 
 	if fromStorage || !conf.readOnly {
 		return os.RemoveAll(fmt.Sprintf("%s/%s", conf.Path, id.String()))
@@ -783,7 +1021,7 @@ func (conf *Configuration) ReinstallSchemeManager(manager *SchemeManager) (err e
 
 	// Check if downloading stuff from the remote works before we uninstall the specified manager:
 	// If we can't download anything we should keep the broken version
-	manager, err = DownloadSchemeManager(manager.URL)
+	manager, err = downloadSchemeManager(conf.schemeURL(manager), conf.options.Transport)
 	if err != nil {
 		return
 	}
@@ -806,7 +1044,7 @@ func (conf *Configuration) InstallSchemeManager(manager *SchemeManager, publicke
 		return err
 	}
 
-	t := NewHTTPTransport(manager.URL)
+	t := conf.schemeTransport(conf.schemeURL(manager))
 	if err := conf.downloadFile(t, name, "description.xml"); err != nil {
 		return err
 	}
@@ -827,24 +1065,53 @@ func (conf *Configuration) InstallSchemeManager(manager *SchemeManager, publicke
 		return err
 	}
 
-	return conf.ParseSchemeManagerFolder(filepath.Join(conf.Path, name), manager)
+	err := conf.ParseSchemeManagerFolder(filepath.Join(conf.Path, name), manager)
+	conf.republish()
+	return err
+}
+
+// NewHTTPTransport returns an HTTPTransport for the specified purpose, configured by the
+// Transport option of the Configuration.
+func (conf *Configuration) NewHTTPTransport(serverURL string, purpose TransportPurpose) *HTTPTransport {
+	return NewHTTPTransportWithOptions(serverURL, conf.options.Transport, purpose)
+}
+
+// schemeURL returns the URL from which the specified scheme is to be downloaded.
+func (conf *Configuration) schemeURL(manager *SchemeManager) string {
+	return conf.mirrorURL(manager.ID, manager.URL)
+}
+
+// mirrorURL returns the scheme mirror URL of the scheme with the specified ID if a
+// scheme mirror is configured, and the specified URL otherwise.
+func (conf *Configuration) mirrorURL(id, url string) string {
+	if conf.bundle != "" {
+		return "file:///" + id
+	}
+	if conf.options.SchemeMirror == "" {
+		return url
+	}
+	return strings.TrimSuffix(conf.options.SchemeMirror, "/") + "/" + id
 }
 
 // DownloadSchemeManagerSignature downloads, stores and verifies the latest version
 // of the index file and signature of the specified manager.
-func (conf *Configuration) DownloadSchemeManagerSignature(manager *SchemeManager) (err error) {
+func (conf *Configuration) DownloadSchemeManagerSignature(manager *SchemeManager) error {
+	return conf.downloadSchemeSignature(manager.ID, conf.schemeURL(manager))
+}
+
+func (conf *Configuration) downloadSchemeSignature(name, url string) (err error) {
 	if conf.readOnly {
 		return errors.New("cannot download into a read-only configuration")
 	}
 
-	t := NewHTTPTransport(manager.URL)
-	if err = conf.downloadFile(t, manager.ID, "index"); err != nil {
+	t := conf.schemeTransport(url)
+	if err = conf.downloadFile(t, name, "index"); err != nil {
 		return
 	}
-	if err = conf.downloadFile(t, manager.ID, "index.sig"); err != nil {
+	if err = conf.downloadFile(t, name, "index.sig"); err != nil {
 		return
 	}
-	err = conf.VerifySignature(manager.Identifier())
+	err = conf.verifySignature(name)
 	return
 }
 
@@ -883,13 +1150,17 @@ func (conf *Configuration) Download(session SessionRequest) (downloaded *IrmaIde
 	allMissing.join(requiredMissing)
 
 	// Try updating them
+	old := conf.Snapshot()
 	for id := range allMissing.allSchemes() {
 		if err = conf.UpdateSchemeManager(id, downloaded); err != nil {
+			conf.notifySchemeUpdates(old, nil, map[SchemeManagerIdentifier]error{id: err})
 			return
 		}
 	}
 	if !downloaded.Empty() {
-		if err = conf.ParseFolder(); err != nil {
+		err = conf.ParseFolder()
+		conf.notifySchemeUpdates(old, downloaded.SchemeManagers, nil)
+		if err != nil {
 			return nil, err
 		}
 	}
@@ -1049,13 +1320,13 @@ func (i SchemeManagerIndex) Scheme() SchemeManagerIdentifier {
 	return NewSchemeManagerIdentifier("")
 }
 
-// parseIndex parses the index file of the specified manager.
-func (conf *Configuration) parseIndex(name string, manager *SchemeManager) (SchemeManagerIndex, error) {
+// parseIndex parses the index file of the specified scheme.
+func (conf *Configuration) parseIndex(name string) (SchemeManagerIndex, error) {
 	path := filepath.Join(conf.Path, name, "index")
-	if err := common.AssertPathExists(path); err != nil {
+	indexbts, err := conf.readFile(path)
+	if os.IsNotExist(err) {
 		return nil, fmt.Errorf("Missing scheme manager index file; tried %s", path)
 	}
-	indexbts, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
@@ -1068,11 +1339,8 @@ func (conf *Configuration) parseIndex(name string, manager *SchemeManager) (Sche
 }
 
 func (conf *Configuration) checkUnsignedFiles(name string, index SchemeManagerIndex) error {
-	return common.WalkDir(filepath.Join(conf.Path, name), func(path string, info os.FileInfo) error {
-		relpath, err := filepath.Rel(conf.Path, path)
-		if err != nil {
-			return err
-		}
+	return conf.walkDir(filepath.Join(conf.Path, name), func(path string, info os.FileInfo) error {
+		relpath := conf.fsPath(path)
 		for _, ex := range sigExceptions {
 			if ex.MatchString(filepath.ToSlash(relpath)) {
 				return nil
@@ -1122,10 +1390,13 @@ func (conf *Configuration) VerifySchemeManager(manager *SchemeManager) error {
 	if err != nil {
 		return err
 	}
+	return conf.verifyIndexedFiles(manager.index)
+}
 
-	var exists bool
-	for file := range manager.index {
-		exists, err = common.PathExists(filepath.Join(conf.Path, file))
+// verifyIndexedFiles checks the hashes of all files in the index that are present on disk.
+func (conf *Configuration) verifyIndexedFiles(index SchemeManagerIndex) error {
+	for file := range index {
+		exists, err := conf.pathExists(filepath.Join(conf.Path, file))
 		if err != nil {
 			return err
 		}
@@ -1133,7 +1404,7 @@ func (conf *Configuration) VerifySchemeManager(manager *SchemeManager) error {
 			continue
 		}
 		// Don't care about the actual bytes
-		if _, _, err = conf.ReadAuthenticatedFile(manager, file); err != nil {
+		if _, _, err = conf.readAuthenticatedFile(index, file); err != nil {
 			return err
 		}
 	}
@@ -1145,12 +1416,16 @@ func (conf *Configuration) VerifySchemeManager(manager *SchemeManager) error {
 // and verifies its authenticity by checking that the file hash
 // is present in the (signed) scheme manager index file.
 func (conf *Configuration) ReadAuthenticatedFile(manager *SchemeManager, path string) ([]byte, bool, error) {
-	signedHash, ok := manager.index[filepath.ToSlash(path)]
+	return conf.readAuthenticatedFile(manager.index, path)
+}
+
+func (conf *Configuration) readAuthenticatedFile(index SchemeManagerIndex, path string) ([]byte, bool, error) {
+	signedHash, ok := index[filepath.ToSlash(path)]
 	if !ok {
 		return nil, false, nil
 	}
 
-	bts, err := ioutil.ReadFile(filepath.Join(conf.Path, path))
+	bts, err := conf.readFile(filepath.Join(conf.Path, path))
 	if err != nil {
 		return nil, true, err
 	}
@@ -1162,10 +1437,16 @@ func (conf *Configuration) ReadAuthenticatedFile(manager *SchemeManager, path st
 	return bts, true, nil
 }
 
-// VerifySignature verifies the signature on the scheme manager index file
+// VerifySignature verifies the signatures on the scheme manager index file
 // (which contains the SHA256 hashes of all files under this scheme manager,
-// which are used for verifying file authenticity).
-func (conf *Configuration) VerifySignature(id SchemeManagerIdentifier) (err error) {
+// which are used for verifying file authenticity), requiring valid signatures of as many
+// of the scheme public keys in pk.pem as its threshold demands.
+func (conf *Configuration) VerifySignature(id SchemeManagerIdentifier) error {
+	return conf.verifySignature(id.String())
+}
+
+// verifySignature verifies the index signature of the scheme in the specified subfolder.
+func (conf *Configuration) verifySignature(name string) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			if e, ok := r.(error); ok {
@@ -1176,34 +1457,41 @@ func (conf *Configuration) VerifySignature(id SchemeManagerIdentifier) (err erro
 		}
 	}()
 
-	dir := filepath.Join(conf.Path, id.String())
-	if err := common.AssertPathExists(filepath.Join(dir, "index"), filepath.Join(dir, "index.sig"), filepath.Join(dir, "pk.pem")); err != nil {
-		return errors.New("Missing scheme manager index file, signature, or public key")
+	dir := filepath.Join(conf.Path, name)
+	for _, file := range []string{"index", "index.sig", "pk.pem"} {
+		if exists, err := conf.pathExists(filepath.Join(dir, file)); err != nil || !exists {
+			return errors.New("Missing scheme manager index file, signature, or public key")
+		}
 	}
 
 	// Read and hash index file
-	indexbts, err := ioutil.ReadFile(filepath.Join(dir, "index"))
+	indexbts, err := conf.readFile(filepath.Join(dir, "index"))
 	if err != nil {
 		return err
 	}
 
-	// Read and parse scheme manager public key
-	pkbts, err := ioutil.ReadFile(filepath.Join(dir, "pk.pem"))
+	// Read and parse scheme manager public keys
+	pkbts, err := conf.readFile(filepath.Join(dir, "pk.pem"))
 	if err != nil {
 		return err
 	}
-	pk, err := signed.UnmarshalPemPublicKey(pkbts)
+	pks, threshold, err := ParseSchemePublicKeys(pkbts)
 	if err != nil {
 		return err
 	}
 
-	// Read and parse signature
-	sig, err := ioutil.ReadFile(filepath.Join(dir, "index.sig"))
+	// Read and parse signatures
+	sigbts, err := conf.readFile(filepath.Join(dir, "index.sig"))
+	if err != nil {
+		return err
+	}
+	sigs, err := SplitSchemeSignatures(sigbts)
 	if err != nil {
 		return err
 	}
 
-	return signed.Verify(pk, indexbts, sig)
+	_, err = VerifySchemeSignatures(pks, threshold, indexbts, sigs)
+	return err
 }
 
 func (hash ConfigurationFileHash) String() string {
@@ -1219,111 +1507,188 @@ func (hash ConfigurationFileHash) Equal(other ConfigurationFileHash) bool {
 // new and modified files, according to the index files of both versions.
 // It stores the identifiers of new or updated credential types or issuers in the second parameter.
 // Note: any newly downloaded files are not yet parsed and inserted into conf.
-func (conf *Configuration) UpdateSchemeManager(id SchemeManagerIdentifier, downloaded *IrmaIdentifierSet) (err error) {
+func (conf *Configuration) UpdateSchemeManager(id SchemeManagerIdentifier, downloaded *IrmaIdentifierSet) error {
+	_, err := conf.updateScheme(id, downloaded, "")
+	return err
+}
+
+// updateScheme updates the specified scheme as UpdateSchemeManager() does, from the extracted
+// scheme bundle in the specified folder if it is not empty, and returns whether it was updated.
+func (conf *Configuration) updateScheme(id SchemeManagerIdentifier, downloaded *IrmaIdentifierSet, bundle string) (updated bool, err error) {
 	if conf.readOnly {
-		return errors.New("cannot update a read-only configuration")
+		return false, errors.New("cannot update a read-only configuration")
 	}
 	manager, contains := conf.SchemeManagers[id]
 	if !contains {
-		return errors.Errorf("Cannot update unknown scheme manager %s", id)
+		return false, errors.Errorf("Cannot update unknown scheme manager %s", id)
 	}
 
-	// Download the new index and its signature, and check that the new index
-	// is validly signed by the new signature
-	// By aborting immediately in case of error, and restoring backup versions
-	// of the index and signature, we leave our stored copy of the scheme manager
-	// intact.
-	if err = conf.DownloadSchemeManagerSignature(manager); err != nil {
-		return
+	issPattern := regexp.MustCompile("^([^/]+)/([^/]+)/description\\.xml")
+	credPattern := regexp.MustCompile("^([^/]+)/([^/]+)/Issues/([^/]+)/description\\.xml")
+
+	// The update is downloaded into and verified in a copy of the scheme, leaving our stored copy
+	// of the scheme intact until the new version is known to be valid
+	updated, err = conf.updateSchemeStaged(manager.ID, func(stage *Configuration) (bool, error) {
+		if bundle != "" {
+			stage.bundle = bundle
+		}
+		// Download the new index and its signature, and check that the new index
+		// is validly signed by the new signature
+		if err := stage.DownloadSchemeManagerSignature(manager); err != nil {
+			return false, err
+		}
+		updated, err := stage.updateSchemeFiles(manager.ID, stage.schemeURL(manager), manager.index, manager.Timestamp, func(filename string) {
+			// See if the file is a credential type or issuer, and add it to the downloaded set if so
+			if downloaded == nil {
+				return
+			}
+			var matches []string
+			matches = issPattern.FindStringSubmatch(filepath.ToSlash(filename))
+			if len(matches) == 3 {
+				issid := NewIssuerIdentifier(fmt.Sprintf("%s.%s", matches[1], matches[2]))
+				downloaded.Issuers[issid] = struct{}{}
+			}
+			matches = credPattern.FindStringSubmatch(filepath.ToSlash(filename))
+			if len(matches) == 4 {
+				credid := NewCredentialTypeIdentifier(fmt.Sprintf("%s.%s.%s", matches[1], matches[2], matches[3]))
+				downloaded.CredentialTypes[credid] = struct{}{}
+			}
+		})
+		if err != nil || !updated {
+			return false, err
+		}
+		if err = stage.downloadDemoPrivateKeys(manager); err != nil {
+			return false, err
+		}
+
+		staged := NewSchemeManager(manager.ID)
+		if err = stage.ParseSchemeManagerFolder(filepath.Join(stage.Path, manager.ID), staged); err != nil {
+			return false, err
+		}
+		return true, stage.VerifySchemeManager(staged)
+	})
+	if updated && downloaded != nil {
+		downloaded.SchemeManagers[manager.Identifier()] = struct{}{}
 	}
-	newIndex, err := conf.parseIndex(manager.ID, manager)
+	return
+}
+
+// updateSchemeFiles downloads the files of the scheme in the specified subfolder whose hashes
+// in the freshly downloaded and verified index differ from those in oldIndex, provided that the
+// remote timestamp is newer than the specified one. The onDownload function, if not nil, is
+// called with the name of each downloaded file. It returns whether or not the scheme was updated.
+func (conf *Configuration) updateSchemeFiles(
+	name, url string, oldIndex SchemeManagerIndex, oldTimestamp Timestamp, onDownload func(filename string),
+) (bool, error) {
+	newIndex, err := conf.parseIndex(name)
 	if err != nil {
-		return
+		return false, err
 	}
 
 	// Check remote timestamp, verify it against the new index, and see if we have to do anything
-	transport := NewHTTPTransport(manager.URL + "/")
-	err = conf.downloadSignedFile(transport, manager.ID, "timestamp", newIndex[manager.ID+"/timestamp"])
+	transport := conf.schemeTransport(url + "/")
+	err = conf.downloadSignedFile(transport, name, "timestamp", newIndex[name+"/timestamp"])
 	if err != nil {
-		return err
+		return false, err
 	}
-	timestampBts, err := ioutil.ReadFile(filepath.Join(conf.Path, manager.ID, "timestamp"))
+	timestampBts, err := ioutil.ReadFile(filepath.Join(conf.Path, name, "timestamp"))
 	if err != nil {
-		return err
+		return false, err
 	}
 	timestamp, err := parseTimestamp(timestampBts)
 	if err != nil {
-		return err
+		return false, err
 	}
-	if !manager.Timestamp.Before(*timestamp) {
-		return nil
+	if !oldTimestamp.Before(*timestamp) {
+		return false, nil
 	}
 
-	issPattern := regexp.MustCompile("^([^/]+)/([^/]+)/description\\.xml")
-	credPattern := regexp.MustCompile("^([^/]+)/([^/]+)/Issues/([^/]+)/description\\.xml")
-
-	// TODO: how to recover/fix local copy if err != nil below?
+	// This runs in a staging copy of the scheme, so if anything below fails the installed scheme
+	// is left untouched and the staging copy is discarded
 	for filename, newHash := range newIndex {
 		path := filepath.Join(conf.Path, filename)
-		oldHash, known := manager.index[filename]
-		var have bool
-		have, err = common.PathExists(path)
+		oldHash, known := oldIndex[filename]
+		have, err := common.PathExists(path)
 		if err != nil {
-			return err
+			return false, err
 		}
 		if known && have && oldHash.Equal(newHash) {
 			continue // nothing to do, we already have this file
 		}
 		// Ensure that the folder in which to write the file exists
 		if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
-			return err
+			return false, err
 		}
-		stripped := filename[len(manager.ID)+1:] // Scheme manager URL already ends with its name
+		stripped := filename[len(name)+1:] // Scheme URL already ends with its name
 		// Download the new file, store it in our own irma_configuration folder
-		if err = conf.downloadSignedFile(transport, manager.ID, stripped, newHash); err != nil {
-			return
-		}
-		// See if the file is a credential type or issuer, and add it to the downloaded set if so
-		if downloaded == nil {
-			continue
+		if err = conf.downloadSignedFile(transport, name, stripped, newHash); err != nil {
+			return false, err
 		}
-		var matches []string
-		matches = issPattern.FindStringSubmatch(filepath.ToSlash(filename))
-		if len(matches) == 3 {
-			issid := NewIssuerIdentifier(fmt.Sprintf("%s.%s", matches[1], matches[2]))
-			downloaded.Issuers[issid] = struct{}{}
-		}
-		matches = credPattern.FindStringSubmatch(filepath.ToSlash(filename))
-		if len(matches) == 4 {
-			credid := NewCredentialTypeIdentifier(fmt.Sprintf("%s.%s.%s", matches[1], matches[2], matches[3]))
-			downloaded.CredentialTypes[credid] = struct{}{}
+		if onDownload != nil {
+			onDownload(filename)
 		}
 	}
 
-	if err := conf.downloadDemoPrivateKeys(manager); err != nil {
-		return err
-	}
+	return true, nil
+}
 
-	return
+// SchemeUpdateStatus describes the outcome of the most recent invocations of UpdateSchemes().
+type SchemeUpdateStatus struct {
+	LastAttempt time.Time `json:"last_attempt"` // zero if the schemes have never been updated
+	LastSuccess time.Time `json:"last_success"`
+	Error       string    `json:"error,omitempty"` // error of the last attempt, if it failed
+}
+
+// SchemeUpdateStatus returns the outcome of the most recent scheme updates.
+func (conf *Configuration) SchemeUpdateStatus() SchemeUpdateStatus {
+	conf.updateStatusLock.Lock()
+	defer conf.updateStatusLock.Unlock()
+	return conf.updateStatus
 }
 
 func (conf *Configuration) UpdateSchemes() error {
-	updated := IrmaIdentifierSet{
-		SchemeManagers:  map[SchemeManagerIdentifier]struct{}{},
-		Issuers:         map[IssuerIdentifier]struct{}{},
-		CredentialTypes: map[CredentialTypeIdentifier]struct{}{},
-		AttributeTypes:  map[AttributeTypeIdentifier]struct{}{},
+	err := conf.updateSchemes()
+
+	conf.updateStatusLock.Lock()
+	defer conf.updateStatusLock.Unlock()
+	conf.updateStatus.LastAttempt = time.Now()
+	if err != nil {
+		conf.updateStatus.Error = err.Error()
+	} else {
+		conf.updateStatus.LastSuccess = conf.updateStatus.LastAttempt
+		conf.updateStatus.Error = ""
 	}
+	return err
+}
+
+func (conf *Configuration) updateSchemes() (err error) {
+	old := conf.Snapshot()
+	updated := newIrmaIdentifierSet()
+	failed := map[SchemeManagerIdentifier]error{}
 	for id := range conf.SchemeManagers {
 		Logger.WithField("scheme", id).Info("Auto-updating scheme")
-		if err := conf.UpdateSchemeManager(id, &updated); err != nil {
-			return err
+		if err = conf.UpdateSchemeManager(id, updated); err != nil {
+			failed[id] = err
+			break
+		}
+	}
+	if err == nil {
+		for id := range conf.RequestorSchemes {
+			Logger.WithField("scheme", id).Info("Auto-updating requestor scheme")
+			if err = conf.UpdateRequestorScheme(id); err != nil {
+				break
+			}
 		}
 	}
+
+	// Parse the schemes that were updated, even if updating another one failed
 	if !updated.Empty() {
-		return conf.ParseFolder()
+		if e := conf.ParseFolder(); err == nil {
+			err = e
+		}
 	}
-	return nil
+	conf.notifySchemeUpdates(old, updated.SchemeManagers, failed)
+	return err
 }
 
 func (conf *Configuration) AutoUpdateSchemes(interval uint) {
@@ -1384,7 +1749,7 @@ func (conf *Configuration) validateIssuer(manager *SchemeManager, issuer *Issuer
 	conf.validateTranslations(fmt.Sprintf("Issuer %s", issuerid.String()), issuer)
 	// Check that the issuer has public keys
 	pkpath := fmt.Sprintf(pubkeyPattern, conf.Path, issuerid.SchemeManagerIdentifier().Name(), issuerid.Name())
-	files, err := filepath.Glob(pkpath)
+	files, err := conf.glob(pkpath)
 	if err != nil {
 		return err
 	}
@@ -1401,7 +1766,7 @@ func (conf *Configuration) validateIssuer(manager *SchemeManager, issuer *Issuer
 	if err = validateDemoPrefix(issuer.Name); manager.Demo && err != nil {
 		return errors.Errorf("Name of demo issuer %s invalid: %s", issuer.ID, err.Error())
 	}
-	if err = common.AssertPathExists(filepath.Join(dir, "logo.png")); err != nil {
+	if exists, _ := conf.pathExists(filepath.Join(dir, "logo.png")); !exists {
 		conf.Warnings = append(conf.Warnings, fmt.Sprintf("Issuer %s has no logo.png", issuerid.String()))
 	}
 	return nil
@@ -1425,7 +1790,7 @@ func (conf *Configuration) validateCredentialType(manager *SchemeManager, issuer
 	if err := validateDemoPrefix(cred.Name); manager.Demo && err != nil {
 		return errors.Errorf("Name of demo credential %s invalid: %s", cred.ID, err.Error())
 	}
-	if err := common.AssertPathExists(filepath.Join(dir, "logo.png")); err != nil {
+	if exists, _ := conf.pathExists(filepath.Join(dir, "logo.png")); !exists {
 		conf.Warnings = append(conf.Warnings, fmt.Sprintf("Credential type %s has no logo.png", credid.String()))
 	}
 	return conf.validateAttributes(cred)
@@ -1440,6 +1805,16 @@ func (conf *Configuration) validateAttributes(cred *CredentialType) error {
 		return errors.Errorf("Credenial type %s has no attributes", name)
 	}
 	for i, attr := range cred.AttributeTypes {
+		if !attr.Type.known() {
+			// The type may have been introduced after this version of irmago
+			warning := fmt.Sprintf("Attribute %s of credential type %s has unknown type %s, treating it as untyped", attr.ID, name, attr.Type)
+			Logger.Warn(warning)
+			conf.Warnings = append(conf.Warnings, warning)
+			attr.Type, attr.Values = "", nil
+		}
+		if err := attr.ValidateDeclaration(); err != nil {
+			return errors.WrapPrefix(err, fmt.Sprintf("Attribute %s of credential type %s", attr.ID, name), 0)
+		}
 		if !attr.RevocationAttribute {
 			conf.validateTranslations(fmt.Sprintf("Attribute %s of credential type %s", attr.ID, cred.Identifier().String()), attr)
 		}
@@ -1478,7 +1853,7 @@ func (conf *Configuration) validateScheme(scheme *SchemeManager, dir string) err
 		return errors.Errorf("Scheme %s has wrong directory name %s", scheme.ID, filepath.Base(dir))
 	}
 	if scheme.KeyshareServer != "" {
-		if err := common.AssertPathExists(filepath.Join(dir, "kss-0.pem")); err != nil {
+		if exists, _ := conf.pathExists(filepath.Join(dir, "kss-0.pem")); !exists {
 			scheme.Status = SchemeManagerStatusParsingError
 			return errors.Errorf("Scheme %s has keyshare URL but no keyshare public key kss-0.pem", scheme.ID)
 		}
@@ -1490,6 +1865,19 @@ func (conf *Configuration) validateScheme(scheme *SchemeManager, dir string) err
 // validateTranslations checks for each member of the interface o that is of type TranslatedString
 // that it contains all necessary translations.
 func (conf *Configuration) validateTranslations(file string, o interface{}) {
+	for _, missing := range missingTranslations(o) {
+		conf.Warnings = append(conf.Warnings, fmt.Sprintf("%s misses %s translation in <%s> tag", file, missing.lang, missing.tag))
+	}
+}
+
+type missingTranslation struct {
+	tag, lang string
+}
+
+// missingTranslations returns the necessary translations that are absent in the members of
+// the interface o that are of type TranslatedString.
+func missingTranslations(o interface{}) []missingTranslation {
+	var missing []missingTranslation
 	v := reflect.ValueOf(o)
 
 	// Dereference in case of pointer or interface
@@ -1506,17 +1894,23 @@ func (conf *Configuration) validateTranslations(file string, o interface{}) {
 		val := field.Interface().(TranslatedString)
 		for _, lang := range validLangs {
 			if _, exists := val[lang]; !exists {
-				conf.Warnings = append(conf.Warnings, fmt.Sprintf("%s misses %s translation in <%s> tag", file, lang, name))
+				missing = append(missing, missingTranslation{tag: name, lang: lang})
 			}
 		}
 	}
+	return missing
 }
 
-func (conf *Configuration) ValidateKeys() error {
-	const expiryBoundary = int64(time.Hour/time.Second) * 24 * 31 // 1 month, TODO make configurable
+// DefaultKeyExpiryWarningPeriod is the period before the expiry of the latest public key of an
+// issuer within which ValidateKeys warns about the upcoming expiry.
+const DefaultKeyExpiryWarningPeriod = 31 * 24 * time.Hour
 
-	for issuerid, issuer := range conf.Issuers {
-		if err := conf.parseKeysFolder(issuerid); err != nil {
+func (conf *Configuration) ValidateKeys() error {
+	for issuerid := range conf.Issuers {
+		conf.keysLock.Lock()
+		err := conf.parseKeysFolder(issuerid)
+		conf.keysLock.Unlock()
+		if err != nil {
 			return err
 		}
 		indices, err := conf.PublicKeyIndices(issuerid)
@@ -1531,16 +1925,13 @@ func (conf *Configuration) ValidateKeys() error {
 			return err
 		}
 
-		// Check expiry date public keys only if issuer is not deprecated
-		now := time.Now()
-		if issuer.DeprecatedSince.IsZero() || issuer.DeprecatedSince.After(Timestamp(now)) {
-			if latest == nil || latest.ExpiryDate < now.Unix() {
-				conf.Warnings = append(conf.Warnings, fmt.Sprintf("Issuer %s has no nonexpired public keys", issuerid.String()))
-			}
-			if latest != nil && latest.ExpiryDate > now.Unix() && latest.ExpiryDate < now.Unix()+expiryBoundary {
-				conf.Warnings = append(conf.Warnings, fmt.Sprintf("Latest public key of issuer %s expires soon (at %s)",
-					issuerid.String(), time.Unix(latest.ExpiryDate, 0).String()))
-			}
+		// Check expiry date public keys
+		warning, err := conf.keyExpiryWarning(issuerid, DefaultKeyExpiryWarningPeriod)
+		if err != nil {
+			return err
+		}
+		if warning != "" {
+			conf.Warnings = append(conf.Warnings, warning)
 		}
 
 		// Check private keys if any
@@ -1593,6 +1984,126 @@ func (conf *Configuration) ValidateKeys() error {
 	return nil
 }
 
+// IssuerKeyInfo summarizes a public key of an issuer, for planning key rotation.
+type IssuerKeyInfo struct {
+	Issuer              IssuerIdentifier
+	Counter             uint
+	ExpiryDate          time.Time
+	Latest              bool // Whether this is the public key with the highest counter
+	PrivateKey          bool // Whether the corresponding private key is present
+	RevocationSupported bool
+}
+
+// Expired returns true if the key has expired.
+func (info IssuerKeyInfo) Expired() bool {
+	return info.ExpiryDate.Before(time.Now())
+}
+
+// IssuerKeys returns information about all public keys of the specified issuer, sorted by counter.
+func (conf *Configuration) IssuerKeys(id IssuerIdentifier) ([]IssuerKeyInfo, error) {
+	indices, err := conf.PublicKeyIndices(id)
+	if err != nil {
+		return nil, err
+	}
+	skindices, err := conf.PrivateKeyIndices(id)
+	if err != nil {
+		return nil, err
+	}
+	sks := map[uint]struct{}{}
+	for _, i := range skindices {
+		sks[i] = struct{}{}
+	}
+
+	var infos []IssuerKeyInfo
+	for j, i := range indices {
+		pk, err := conf.PublicKey(id, i)
+		if err != nil {
+			return nil, err
+		}
+		if pk == nil {
+			continue
+		}
+		_, havesk := sks[i]
+		infos = append(infos, IssuerKeyInfo{
+			Issuer:              id,
+			Counter:             i,
+			ExpiryDate:          time.Unix(pk.ExpiryDate, 0),
+			Latest:              j == len(indices)-1,
+			PrivateKey:          havesk,
+			RevocationSupported: pk.RevocationSupported(),
+		})
+	}
+	return infos, nil
+}
+
+// KeyExpiryWarnings returns a warning for each issuer that is not deprecated and whose latest
+// public key has expired or expires within the specified period.
+func (conf *Configuration) KeyExpiryWarnings(period time.Duration) ([]string, error) {
+	var warnings []string
+	for issuerid := range conf.Issuers {
+		warning, err := conf.keyExpiryWarning(issuerid, period)
+		if err != nil {
+			return nil, err
+		}
+		if warning != "" {
+			warnings = append(warnings, warning)
+		}
+	}
+	sort.Strings(warnings)
+	return warnings, nil
+}
+
+func (conf *Configuration) keyExpiryWarning(issuerid IssuerIdentifier, period time.Duration) (string, error) {
+	// Check expiry date public keys only if issuer is not deprecated
+	now := time.Now()
+	issuer := conf.Issuers[issuerid]
+	if !issuer.DeprecatedSince.IsZero() && !issuer.DeprecatedSince.After(Timestamp(now)) {
+		return "", nil
+	}
+	indices, err := conf.PublicKeyIndices(issuerid)
+	if err != nil || len(indices) == 0 {
+		return "", err
+	}
+	latest, err := conf.PublicKey(issuerid, indices[len(indices)-1])
+	if err != nil {
+		return "", err
+	}
+	if latest == nil || latest.ExpiryDate < now.Unix() {
+		return fmt.Sprintf("Issuer %s has no nonexpired public keys", issuerid.String()), nil
+	}
+	if latest.ExpiryDate < now.Add(period).Unix() {
+		return fmt.Sprintf("Latest public key of issuer %s expires soon (at %s)",
+			issuerid.String(), time.Unix(latest.ExpiryDate, 0).String()), nil
+	}
+	return "", nil
+}
+
+// OutdatedIssuanceKeys returns the credential types that are issued under an older key than the
+// latest public key of their issuer, i.e. for which the latest available private key has a lower
+// counter than the latest public key, mapped to the counter of that private key. Credential types
+// of issuers of which no private keys are present are not included.
+func (conf *Configuration) OutdatedIssuanceKeys() (map[CredentialTypeIdentifier]uint, error) {
+	outdated := map[CredentialTypeIdentifier]uint{}
+	for credid := range conf.CredentialTypes {
+		issuerid := credid.IssuerIdentifier()
+		skindices, err := conf.PrivateKeyIndices(issuerid)
+		if err != nil {
+			return nil, err
+		}
+		pkindices, err := conf.PublicKeyIndices(issuerid)
+		if err != nil {
+			return nil, err
+		}
+		if len(skindices) == 0 || len(pkindices) == 0 {
+			continue
+		}
+		if sk := skindices[len(skindices)-1]; sk < pkindices[len(pkindices)-1] {
+			outdated[credid] = sk
+		}
+	}
+	return outdated, nil
+}
+
 // DefaultDataPath returns the default storage path for IRMA, using XDG Base Directory Specification
 // https://specifications.freedesktop.org/basedir-spec/basedir-spec-latest.html:
 //  - %LOCALAPPDATA% (i.e. C:\Users\$user\AppData\Local) if on Windows,
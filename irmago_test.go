@@ -1,16 +1,37 @@
 package irma
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
 	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	sseclient "astuart.co/go-sse"
+	"github.com/alexandrevicenzi/go-sse"
+	"github.com/go-errors/errors"
 	"github.com/privacybydesign/gabi"
 	"github.com/privacybydesign/gabi/big"
 	"github.com/privacybydesign/gabi/revocation"
+	"github.com/privacybydesign/gabi/signed"
 	"github.com/privacybydesign/irmago/internal/common"
 	"github.com/privacybydesign/irmago/internal/test"
 	"github.com/stretchr/testify/require"
@@ -65,6 +86,66 @@ func TestParseInvalidIrmaConfiguration(t *testing.T) {
 	require.Equal(t, false, conf.SchemeManagers[smerr.Manager].Valid)
 }
 
+func TestSchemeMultiSignature(t *testing.T) {
+	index := []byte("0123 irma-demo/description.xml\n")
+	var pks []*ecdsa.PublicKey
+	var sigs [][]byte
+	for i := 0; i < 3; i++ {
+		sk, err := signed.GenerateKey()
+		require.NoError(t, err)
+		sig, err := signed.Sign(sk, index)
+		require.NoError(t, err)
+		pks = append(pks, &sk.PublicKey)
+		sigs = append(sigs, sig)
+	}
+
+	// Threshold survives a roundtrip through pk.pem
+	bts, err := MarshalSchemePublicKeys(pks, 2)
+	require.NoError(t, err)
+	parsed, threshold, err := ParseSchemePublicKeys(bts)
+	require.NoError(t, err)
+	require.Len(t, parsed, 3)
+	require.Equal(t, 2, threshold)
+	_, err = MarshalSchemePublicKeys(pks, 4)
+	require.Error(t, err)
+
+	// A key occurring twice in pk.pem counts once toward the threshold
+	bts, err = MarshalSchemePublicKeys([]*ecdsa.PublicKey{pks[0], pks[1], pks[0]}, 3)
+	require.NoError(t, err)
+	_, _, err = ParseSchemePublicKeys(bts)
+	require.Error(t, err)
+	bts, err = MarshalSchemePublicKeys([]*ecdsa.PublicKey{pks[0], pks[1], pks[0]}, 2)
+	require.NoError(t, err)
+	parsed, threshold, err = ParseSchemePublicKeys(bts)
+	require.NoError(t, err)
+	require.Equal(t, pks[:2], parsed)
+	require.Equal(t, 2, threshold)
+
+	// A single key without threshold is the legacy format
+	bts, err = MarshalSchemePublicKeys(pks[:1], 1)
+	require.NoError(t, err)
+	legacy, err := signed.MarshalPemPublicKey(pks[0])
+	require.NoError(t, err)
+	require.Equal(t, legacy, bts)
+
+	// Concatenated signatures are split again
+	split, err := SplitSchemeSignatures(append(append([]byte{}, sigs[0]...), sigs[2]...))
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{sigs[0], sigs[2]}, split)
+
+	signers, err := VerifySchemeSignatures(pks, 2, index, split)
+	require.NoError(t, err)
+	require.Equal(t, []int{0, 2}, signers)
+
+	// The same signature twice does not count twice
+	_, err = VerifySchemeSignatures(pks, 2, index, [][]byte{sigs[1], sigs[1]})
+	require.Error(t, err)
+
+	// Signatures over another index do not count
+	_, err = VerifySchemeSignatures(pks, 2, []byte("other"), split)
+	require.Error(t, err)
+}
+
 func TestRetryHTTPRequest(t *testing.T) {
 	test.StartBadHttpServer(2, 1*time.Second, "42")
 	defer test.StopBadHttpServer()
@@ -154,6 +235,395 @@ func TestParseIrmaConfiguration(t *testing.T) {
 	//	"irma-demo.MijnOverheid.root had improper hash")
 }
 
+func TestIssuerKeys(t *testing.T) {
+	conf := parseConfiguration(t)
+	issuerid := NewIssuerIdentifier("irma-demo.RU")
+
+	keys, err := conf.IssuerKeys(issuerid)
+	require.NoError(t, err)
+	require.NotEmpty(t, keys)
+	latest := keys[len(keys)-1]
+	require.True(t, latest.Latest)
+	require.True(t, latest.PrivateKey)
+	require.Equal(t, issuerid, latest.Issuer)
+
+	// The test keys have expired long ago
+	require.True(t, latest.Expired())
+	warnings, err := conf.KeyExpiryWarnings(DefaultKeyExpiryWarningPeriod)
+	require.NoError(t, err)
+	require.Contains(t, warnings, "Issuer irma-demo.RU has no nonexpired public keys")
+
+	outdated, err := conf.OutdatedIssuanceKeys()
+	require.NoError(t, err)
+	require.NotContains(t, outdated, NewCredentialTypeIdentifier("irma-demo.RU.studentCard"))
+}
+
+func TestDiffSchemes(t *testing.T) {
+	old := parseConfiguration(t)
+	updated, err := NewConfiguration(filepath.Join("testdata", "irma_configuration_updated"), ConfigurationOptions{ReadOnly: true})
+	require.NoError(t, err)
+	require.NoError(t, updated.ParseFolder())
+
+	diff, err := DiffSchemes(old, updated, NewSchemeManagerIdentifier("irma-demo"))
+	require.NoError(t, err)
+	require.Contains(t, diff.Changes, SchemeChange{
+		Kind: SchemeChangeAdded, Type: "attribute", ID: "irma-demo.RU.studentCard.newAttribute",
+	})
+
+	diff, err = DiffSchemes(old, old, NewSchemeManagerIdentifier("irma-demo"))
+	require.NoError(t, err)
+	require.True(t, diff.Empty())
+}
+
+func TestSchemeUpdateRollback(t *testing.T) {
+	test.StartSchemeManagerHttpServer()
+	defer test.StopSchemeManagerHttpServer()
+
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+
+	path := filepath.Join(storage, "irma_configuration")
+	require.NoError(t, common.CopyDirectory(filepath.Join("testdata", "irma_configuration"), path))
+	conf, err := NewConfiguration(path, ConfigurationOptions{})
+	require.NoError(t, err)
+	require.NoError(t, conf.ParseFolder())
+
+	schemeid := NewSchemeManagerIdentifier("irma-demo")
+	credid := NewCredentialTypeIdentifier("irma-demo.RU.studentCard")
+	attrid := NewAttributeTypeIdentifier("irma-demo.RU.studentCard.newAttribute")
+	require.False(t, conf.CredentialTypes[credid].ContainsAttribute(attrid))
+
+	// Failing updates leave the scheme untouched
+	conf.SchemeManagers[schemeid].URL = "http://localhost:48681/nonexisting/irma-demo"
+	require.Error(t, conf.UpdateSchemeManager(schemeid, nil))
+	_, err = conf.updateSchemeStaged("irma-demo", func(stage *Configuration) (bool, error) {
+		require.NoError(t, os.Remove(filepath.Join(stage.Path, "irma-demo", "description.xml")))
+		return true, stage.ParseSchemeManagerFolder(filepath.Join(stage.Path, "irma-demo"), NewSchemeManager("irma-demo"))
+	})
+	require.Error(t, err)
+	require.NoError(t, conf.ParseFolder())
+	require.Contains(t, conf.SchemeManagers, schemeid)
+	require.Empty(t, conf.DisabledSchemeManagers)
+	require.Error(t, conf.RollbackScheme("irma-demo"))
+
+	// An update of which a file does not match the index is discarded
+	corrupt := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/studentCard/description.xml") {
+			_, _ = w.Write([]byte("<IssueSpecification/>"))
+			return
+		}
+		http.FileServer(http.Dir("testdata")).ServeHTTP(w, r)
+	}))
+	defer corrupt.Close()
+	conf.SchemeManagers[schemeid].URL = corrupt.URL + "/irma_configuration_updated/irma-demo"
+	conf.SchemeManagers[schemeid].Timestamp = Timestamp(time.Unix(0, 0))
+	require.Error(t, conf.UpdateSchemeManager(schemeid, nil))
+	require.NoError(t, conf.ParseFolder())
+	require.False(t, conf.CredentialTypes[credid].ContainsAttribute(attrid))
+	require.NoError(t, common.AssertPathNotExists(filepath.Join(path, schemePreviousFolder, "irma-demo")))
+
+	// Update to the newer version of the scheme containing irma-demo.RU.studentCard.newAttribute.
+	// If the folders can be swapped atomically, the scheme is present throughout.
+	swappable, err := exchangeFolders(storage, storage)
+	require.NoError(t, err)
+	done := make(chan struct{})
+	absent := make(chan bool, 1)
+	go func() {
+		defer close(absent)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if _, err := os.Stat(filepath.Join(path, "irma-demo", "description.xml")); err != nil {
+				absent <- true
+				return
+			}
+		}
+	}()
+	conf.SchemeManagers[schemeid].URL = "http://localhost:48681/irma_configuration_updated/irma-demo"
+	conf.SchemeManagers[schemeid].Timestamp = Timestamp(time.Unix(0, 0))
+	require.NoError(t, conf.UpdateSchemeManager(schemeid, nil))
+	close(done)
+	if swappable {
+		require.False(t, <-absent)
+	}
+	require.NoError(t, conf.ParseFolder())
+	require.True(t, conf.CredentialTypes[credid].ContainsAttribute(attrid))
+	require.DirExists(t, filepath.Join(path, schemePreviousFolder, "irma-demo"))
+	staged, err := ioutil.ReadDir(filepath.Join(path, schemeStagingFolder))
+	require.NoError(t, err)
+	require.Empty(t, staged)
+
+	require.NoError(t, conf.RollbackScheme("irma-demo"))
+	require.NoError(t, conf.ParseFolder())
+	require.False(t, conf.CredentialTypes[credid].ContainsAttribute(attrid))
+
+	// Rolling back again restores the update
+	require.NoError(t, conf.RollbackScheme("irma-demo"))
+	require.NoError(t, conf.ParseFolder())
+	require.True(t, conf.CredentialTypes[credid].ContainsAttribute(attrid))
+}
+
+func TestConfigurationFileSystem(t *testing.T) {
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+
+	credid := NewCredentialTypeIdentifier("irma-demo.RU.studentCard")
+	attrid := NewAttributeTypeIdentifier("irma-demo.RU.studentCard.newAttribute")
+	issid := NewIssuerIdentifier("irma-demo.RU")
+	fs := DirFileSystem(filepath.Join("testdata", "irma_configuration"))
+
+	// Without a path, the Configuration is read-only
+	conf, err := NewConfiguration("", ConfigurationOptions{FileSystem: fs})
+	require.NoError(t, err)
+	require.NoError(t, conf.ParseFolder())
+	expected := parseConfiguration(t)
+	require.Equal(t, len(expected.CredentialTypes), len(conf.CredentialTypes))
+	require.Equal(t, len(expected.RequestorSchemes), len(conf.RequestorSchemes))
+	require.Empty(t, conf.DisabledSchemeManagers)
+	pk, err := conf.PublicKey(issid, 0)
+	require.NoError(t, err)
+	require.NotNil(t, pk)
+	_, err = conf.PrivateKey(issid, 0)
+	require.NoError(t, err)
+	require.Error(t, conf.UpdateSchemeManager(NewSchemeManagerIdentifier("irma-demo"), nil))
+
+	// With a path, updated schemes are written to it and take precedence
+	path := filepath.Join(storage, "overlay")
+	conf, err = NewConfiguration(path, ConfigurationOptions{FileSystem: fs})
+	require.NoError(t, err)
+	require.NoError(t, conf.ParseFolder())
+	require.False(t, conf.CredentialTypes[credid].ContainsAttribute(attrid))
+	updated, err := conf.updateSchemeStaged("irma-demo", func(stage *Configuration) (bool, error) {
+		staged := filepath.Join(stage.Path, "irma-demo")
+		require.NoError(t, os.RemoveAll(staged))
+		require.NoError(t, common.CopyDirectory(filepath.Join("testdata", "irma_configuration_updated", "irma-demo"), staged))
+		return true, nil
+	})
+	require.NoError(t, err)
+	require.True(t, updated)
+	require.NoError(t, conf.ParseFolder())
+	require.True(t, conf.CredentialTypes[credid].ContainsAttribute(attrid))
+	require.Equal(t, len(expected.SchemeManagers), len(conf.SchemeManagers))
+	require.NoError(t, common.AssertPathNotExists(filepath.Join(path, "test")))
+	require.False(t, parseConfiguration(t).CredentialTypes[credid].ContainsAttribute(attrid))
+}
+
+func TestSchemeBundle(t *testing.T) {
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+
+	schemeid := NewSchemeManagerIdentifier("irma-demo")
+	credid := NewCredentialTypeIdentifier("irma-demo.RU.studentCard")
+	attrid := NewAttributeTypeIdentifier("irma-demo.RU.studentCard.newAttribute")
+
+	// Bundle the newer version of the scheme containing irma-demo.RU.studentCard.newAttribute
+	source, err := NewConfiguration(filepath.Join("testdata", "irma_configuration_updated"), ConfigurationOptions{ReadOnly: true})
+	require.NoError(t, err)
+	require.NoError(t, source.ParseSchemeManagerFolder(filepath.Join(source.Path, "irma-demo"), NewSchemeManager("irma-demo")))
+	var bundle bytes.Buffer
+	require.NoError(t, source.BundleScheme(schemeid, &bundle, true))
+
+	// A bundle is not installed when it is not signed by the specified public key
+	path := filepath.Join(storage, "otherkey")
+	require.NoError(t, common.EnsureDirectoryExists(path))
+	conf, err := NewConfiguration(path, ConfigurationOptions{})
+	require.NoError(t, err)
+	otherpk, err := ioutil.ReadFile(filepath.Join("testdata", "irma_configuration", "test", "pk.pem"))
+	require.NoError(t, err)
+	require.Error(t, conf.InstallSchemeBundle(bytes.NewReader(bundle.Bytes()), otherpk))
+	require.NoError(t, common.AssertPathNotExists(filepath.Join(path, "irma-demo")))
+
+	// Bundles with too large files, or too large in total, are rejected
+	defer func(file, total int64) { schemeBundleMaxFileSize, schemeBundleMaxSize = file, total }(schemeBundleMaxFileSize, schemeBundleMaxSize)
+	schemeBundleMaxFileSize = 1024
+	require.Error(t, conf.InstallSchemeBundle(bytes.NewReader(bundle.Bytes()), nil))
+	schemeBundleMaxFileSize, schemeBundleMaxSize = 1<<20, 4096
+	require.Error(t, conf.InstallSchemeBundle(bytes.NewReader(bundle.Bytes()), nil))
+	require.NoError(t, common.AssertPathNotExists(filepath.Join(path, "irma-demo")))
+	schemeBundleMaxSize = 1 << 30
+
+	// Install the bundle into an empty configuration, verifying it with the specified public key
+	path = filepath.Join(storage, "installed")
+	require.NoError(t, common.EnsureDirectoryExists(path))
+	conf, err = NewConfiguration(path, ConfigurationOptions{})
+	require.NoError(t, err)
+	require.NoError(t, conf.ParseFolder())
+	pk, err := ioutil.ReadFile(filepath.Join(source.Path, "irma-demo", "pk.pem"))
+	require.NoError(t, err)
+	require.NoError(t, conf.InstallSchemeBundle(bytes.NewReader(bundle.Bytes()), pk))
+	require.True(t, conf.CredentialTypes[credid].ContainsAttribute(attrid))
+	require.FileExists(t, filepath.Join(path, "irma-demo", "RU", "PrivateKeys", "0.xml"))
+	require.NoError(t, conf.ParseFolder())
+	require.Empty(t, conf.DisabledSchemeManagers)
+
+	// Installing the same bundle again does nothing
+	require.NoError(t, conf.InstallSchemeBundle(bytes.NewReader(bundle.Bytes()), nil))
+
+	// The bundle is older than the scheme in testdata/irma_configuration, so it is not applied
+	path = filepath.Join(storage, "irma_configuration")
+	require.NoError(t, common.CopyDirectory(filepath.Join("testdata", "irma_configuration"), path))
+	conf, err = NewConfiguration(path, ConfigurationOptions{})
+	require.NoError(t, err)
+	require.NoError(t, conf.ParseFolder())
+	require.NoError(t, conf.InstallSchemeBundle(bytes.NewReader(bundle.Bytes()), nil))
+	require.False(t, conf.CredentialTypes[credid].ContainsAttribute(attrid))
+
+	// Modified files are rejected
+	var tampered bytes.Buffer
+	gzr, err := gzip.NewReader(&bundle)
+	require.NoError(t, err)
+	tr := tar.NewReader(gzr)
+	gzw := gzip.NewWriter(&tampered)
+	tw := tar.NewWriter(gzw)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		bts, err := ioutil.ReadAll(tr)
+		require.NoError(t, err)
+		if header.Name == "irma-demo/RU/description.xml" {
+			bts = append(bts, ' ')
+			header.Size++
+		}
+		require.NoError(t, tw.WriteHeader(header))
+		_, err = tw.Write(bts)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gzw.Close())
+
+	path = filepath.Join(storage, "tampered")
+	require.NoError(t, common.EnsureDirectoryExists(path))
+	conf, err = NewConfiguration(path, ConfigurationOptions{})
+	require.NoError(t, err)
+	require.Error(t, conf.InstallSchemeBundle(&tampered, nil))
+	require.NoError(t, common.AssertPathNotExists(filepath.Join(path, "irma-demo")))
+}
+
+func TestSchemeUpdateEvents(t *testing.T) {
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+
+	// Update from the older version of irma-demo in irma_configuration_updated to the one in
+	// irma_configuration, which lacks irma-demo.RU.studentCard.newAttribute and has an extra key
+	path := filepath.Join(storage, "irma_configuration")
+	require.NoError(t, common.CopyDirectory(filepath.Join("testdata", "irma_configuration_updated"), path))
+	conf, err := NewConfiguration(path, ConfigurationOptions{})
+	require.NoError(t, err)
+	require.NoError(t, conf.ParseFolder())
+
+	var events []*SchemeUpdateEvent
+	unsubscribe := conf.SubscribeSchemeUpdates(func(event *SchemeUpdateEvent) {
+		events = append(events, event)
+	})
+
+	schemeid := NewSchemeManagerIdentifier("irma-demo")
+	old := conf.Snapshot()
+	_, err = conf.updateSchemeStaged("irma-demo", func(stage *Configuration) (bool, error) {
+		staged := filepath.Join(stage.Path, "irma-demo")
+		require.NoError(t, os.RemoveAll(staged))
+		require.NoError(t, common.CopyDirectory(filepath.Join("testdata", "irma_configuration", "irma-demo"), staged))
+		return true, nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, conf.ParseFolder())
+	failure := errors.New("test")
+	conf.notifySchemeUpdates(old,
+		map[SchemeManagerIdentifier]struct{}{schemeid: {}},
+		map[SchemeManagerIdentifier]error{NewSchemeManagerIdentifier("test"): failure},
+	)
+
+	require.Len(t, events, 2)
+	require.Equal(t, schemeid, events[0].Scheme)
+	require.NoError(t, events[0].Err)
+	require.Contains(t, events[0].Diff.Changes, SchemeChange{
+		Kind: SchemeChangeRemoved, Type: "attribute", ID: "irma-demo.RU.studentCard.newAttribute",
+	})
+	require.Contains(t, events[0].Diff.Changes, SchemeChange{
+		Kind: SchemeChangeAdded, Type: "publickey", ID: "irma-demo.RU-3",
+	})
+	require.Equal(t, []uint{3}, events[0].NewPublicKeys[NewIssuerIdentifier("irma-demo.RU")])
+	require.Equal(t, NewSchemeManagerIdentifier("test"), events[1].Scheme)
+	require.Equal(t, failure, events[1].Err)
+	require.Nil(t, events[1].Diff)
+
+	unsubscribe()
+	conf.notifySchemeUpdates(old, map[SchemeManagerIdentifier]struct{}{schemeid: {}}, nil)
+	require.Len(t, events, 2)
+}
+
+func TestConfigurationSnapshot(t *testing.T) {
+	conf, err := NewConfiguration(filepath.Join("testdata", "irma_configuration"), ConfigurationOptions{ReadOnly: true})
+	require.NoError(t, err)
+	require.True(t, conf == conf.Snapshot())
+	require.NoError(t, conf.ParseFolder())
+
+	schemeid := NewSchemeManagerIdentifier("irma-demo")
+	credid := NewCredentialTypeIdentifier("irma-demo.RU.studentCard")
+	snapshot := conf.Snapshot()
+	require.True(t, snapshot != conf)
+	require.Contains(t, snapshot.CredentialTypes, credid)
+	pk, err := snapshot.PublicKey(NewIssuerIdentifier("irma-demo.RU"), 0)
+	require.NoError(t, err)
+	require.NotNil(t, pk)
+
+	// Reparsing while pinned snapshots are in use publishes a new snapshot
+	errs := make(chan error, 1)
+	go func() {
+		var err error
+		for i := 0; i < 5 && err == nil; i++ {
+			err = conf.ParseFolder()
+		}
+		errs <- err
+	}()
+	for i := 0; i < 100; i++ {
+		pinned := conf.Snapshot()
+		require.Contains(t, pinned.CredentialTypes, credid)
+		require.Contains(t, pinned.SchemeManagers, schemeid)
+	}
+	require.NoError(t, <-errs)
+	require.True(t, snapshot != conf.Snapshot())
+
+	// Changes to the Configuration do not affect earlier snapshots
+	snapshot = conf.Snapshot()
+	require.NoError(t, conf.RemoveSchemeManager(schemeid, false))
+	require.NotContains(t, conf.CredentialTypes, credid)
+	require.NotContains(t, conf.Snapshot().CredentialTypes, credid)
+	require.Contains(t, snapshot.CredentialTypes, credid)
+	require.Contains(t, snapshot.SchemeManagers, schemeid)
+}
+
+func TestLintScheme(t *testing.T) {
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+
+	path := filepath.Join(storage, "irma-demo")
+	require.NoError(t, common.CopyDirectory(filepath.Join("testdata", "irma_configuration", "irma-demo"), path))
+	require.NoError(t, os.Remove(filepath.Join(path, "RU", "logo.png")))
+
+	diagnostics, err := LintScheme(path, LintOptions{})
+	require.NoError(t, err)
+	require.Contains(t, diagnostics, LintDiagnostic{
+		Rule:     "missing-logo",
+		Severity: LintWarning,
+		File:     "irma-demo/RU/description.xml",
+		Message:  "Issuer irma-demo.RU has no logo.png",
+	})
+
+	for _, suppress := range []string{"missing-logo", "missing-logo:irma-demo/RU/*"} {
+		diagnostics, err = LintScheme(path, LintOptions{Suppress: []string{suppress}})
+		require.NoError(t, err)
+		for _, d := range diagnostics {
+			require.NotEqual(t, "missing-logo", d.Rule)
+		}
+	}
+}
+
 func TestMetadataAttribute(t *testing.T) {
 	metadata := NewMetadataAttribute(0x02)
 	if metadata.Version() != 0x02 {
@@ -662,3 +1132,728 @@ func revoke(t *testing.T, acc *revocation.Accumulator, parent *revocation.Event,
 	require.NoError(t, err)
 	return acc, event
 }
+
+func TestConsentReceipt(t *testing.T) {
+	purpose := &DisclosurePurpose{
+		Purpose:          NewTranslatedString(&[]string{"membership"}[0]),
+		RetentionPeriod:  365,
+		PrivacyPolicyURL: "https://example.com/privacy",
+	}
+	bsnPurpose := &DisclosurePurpose{Purpose: NewTranslatedString(&[]string{"identification"}[0])}
+
+	request := NewDisclosureRequest(
+		NewAttributeTypeIdentifier("irma-demo.MijnOverheid.root.BSN"),
+		NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID"),
+	)
+	require.False(t, request.HasPurposes())
+	require.Nil(t, NewConsentReceipt("testserver", request, nil))
+
+	request.Purpose = purpose
+	request.Purposes = map[int]*DisclosurePurpose{0: bsnPurpose}
+	require.NoError(t, request.Validate())
+	require.Equal(t, bsnPurpose, request.DisconPurpose(0))
+	require.Equal(t, purpose, request.DisconPurpose(1))
+
+	// Invalid purposes are rejected
+	request.Purposes[2] = bsnPurpose
+	require.Error(t, request.Validate())
+	delete(request.Purposes, 2)
+	request.Purpose = &DisclosurePurpose{}
+	require.Error(t, request.Validate())
+	request.Purpose = purpose
+
+	// Only disjunctions from which attributes were disclosed are included
+	request.Nonce = big.NewInt(42)
+	disclosed := [][]*DisclosedAttribute{
+		{{Identifier: NewAttributeTypeIdentifier("irma-demo.MijnOverheid.root.BSN")}},
+		{},
+	}
+	receipt := NewConsentReceipt("testserver", request, disclosed)
+	require.NotNil(t, receipt)
+	require.Len(t, receipt.Consents, 1)
+	require.Equal(t, bsnPurpose, receipt.Consents[0].Purpose)
+
+	sk, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	j, err := receipt.Sign(sk)
+	require.NoError(t, err)
+
+	parsed, err := ParseConsentReceipt(j, &sk.PublicKey)
+	require.NoError(t, err)
+	require.Equal(t, "testserver", parsed.ServerName)
+	require.Equal(t, request.Nonce, parsed.Nonce)
+	require.Equal(t, receipt.Consents, parsed.Consents)
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, err = ParseConsentReceipt(j, &other.PublicKey)
+	require.Error(t, err)
+}
+
+func TestRequestorScheme(t *testing.T) {
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+
+	path := filepath.Join(storage, "client", "irma_configuration")
+	dir := filepath.Join(path, "test-requestors")
+	files := map[string]string{
+		"description.xml": `<RequestorScheme version="1">
+	<Id>test-requestors</Id>
+	<Url>https://example.com/test-requestors</Url>
+	<Name><en>Test requestors</en><nl>Test requestors</nl></Name>
+</RequestorScheme>`,
+		"requestors.xml": `<Requestors>
+	<Requestor>
+		<Name><en>Radboud University</en><nl>Radboud Universiteit</nl></Name>
+		<Hostname>ru.nl</Hostname>
+		<Hostname>www.ru.nl</Hostname>
+		<Logo>ru.png</Logo>
+		<Attributes>
+			<Attribute>irma-demo.RU.studentCard</Attribute>
+			<Attribute>irma-demo.MijnOverheid.root.BSN</Attribute>
+		</Attributes>
+	</Requestor>
+</Requestors>`,
+		"timestamp":    "1577836800",
+		"logos/ru.png": "png",
+	}
+	index := SchemeManagerIndex{}
+	for name, contents := range files {
+		require.NoError(t, common.EnsureDirectoryExists(filepath.Dir(filepath.Join(dir, name))))
+		require.NoError(t, common.SaveFile(filepath.Join(dir, name), []byte(contents)))
+		hash := sha256.Sum256([]byte(contents))
+		index["test-requestors/"+name] = hash[:]
+	}
+	sk, err := signed.GenerateKey()
+	require.NoError(t, err)
+	sig, err := signed.Sign(sk, []byte(index.String()))
+	require.NoError(t, err)
+	pk, err := MarshalSchemePublicKeys([]*ecdsa.PublicKey{&sk.PublicKey}, 1)
+	require.NoError(t, err)
+	require.NoError(t, common.SaveFile(filepath.Join(dir, "index"), []byte(index.String())))
+	require.NoError(t, common.SaveFile(filepath.Join(dir, "index.sig"), sig))
+	require.NoError(t, common.SaveFile(filepath.Join(dir, "pk.pem"), pk))
+
+	conf, err := NewConfiguration(path, ConfigurationOptions{})
+	require.NoError(t, err)
+	require.NoError(t, conf.ParseFolder())
+	require.Empty(t, conf.DisabledRequestorSchemes)
+	require.Empty(t, conf.SchemeManagers)
+	scheme := conf.RequestorSchemes[NewRequestorSchemeIdentifier("test-requestors")]
+	require.NotNil(t, scheme)
+	require.True(t, scheme.Valid)
+
+	// Hostnames are looked up case insensitively
+	requestor := conf.RequestorInfo("WWW.ru.nl")
+	require.NotNil(t, requestor)
+	require.Equal(t, "Radboud Universiteit", requestor.Name["nl"])
+	require.Equal(t, filepath.Join(dir, "logos", "ru.png"), requestor.LogoPath(conf))
+	require.Nil(t, conf.RequestorInfo("ru.nl.example.com"))
+
+	// Listed attributes, including all attributes of listed credential types, are permitted
+	require.Empty(t, requestor.Permits(NewDisclosureRequest(
+		NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID"),
+		NewAttributeTypeIdentifier("irma-demo.MijnOverheid.root.BSN"),
+	)))
+	unlisted := NewAttributeTypeIdentifier("irma-demo.MijnOverheid.fullName.firstname")
+	require.Equal(t, []AttributeTypeIdentifier{unlisted}, requestor.Permits(NewDisclosureRequest(
+		NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID"), unlisted,
+	)))
+
+	// Removing the scheme publishes a new snapshot, leaving earlier snapshots intact
+	snapshot := conf.Snapshot()
+	require.NoError(t, conf.RemoveRequestorScheme(NewRequestorSchemeIdentifier("test-requestors"), false))
+	require.Nil(t, conf.RequestorInfo("ru.nl"))
+	require.Nil(t, conf.Snapshot().RequestorInfo("ru.nl"))
+	require.NotNil(t, snapshot.RequestorInfo("ru.nl"))
+	require.Contains(t, snapshot.RequestorSchemes, NewRequestorSchemeIdentifier("test-requestors"))
+	require.NoError(t, conf.ParseFolder())
+	require.NotNil(t, conf.Snapshot().RequestorInfo("ru.nl"))
+
+	// Tampering with the list of requestors disables the scheme
+	require.NoError(t, common.SaveFile(filepath.Join(dir, "requestors.xml"), []byte("<Requestors></Requestors>")))
+	require.NoError(t, conf.ParseFolder())
+	require.Contains(t, conf.DisabledRequestorSchemes, NewRequestorSchemeIdentifier("test-requestors"))
+	require.Nil(t, conf.RequestorInfo("ru.nl"))
+}
+
+func TestProximityDisclosure(t *testing.T) {
+	conf, _, disclosure := parseDisclosure(t)
+
+	// The binary encoding of a disclosure is lossless and smaller than JSON
+	bts, err := MarshalBinary(disclosure)
+	require.NoError(t, err)
+	decoded := &Disclosure{}
+	require.NoError(t, UnmarshalBinary(bts, decoded))
+	expected, err := json.Marshal(disclosure)
+	require.NoError(t, err)
+	actual, err := json.Marshal(decoded)
+	require.NoError(t, err)
+	require.JSONEq(t, string(expected), string(actual))
+	require.True(t, len(bts) < len(expected))
+
+	sk, err := signed.GenerateKey()
+	require.NoError(t, err)
+	request := NewDisclosureRequest(NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID"))
+	pr, err := NewProximityRequest(conf, request, "ru.nl", sk)
+	require.NoError(t, err)
+	require.NotNil(t, request.Nonce)
+
+	bts, err = MarshalBinary(pr)
+	require.NoError(t, err)
+	parsed, parsedRequest, err := ParseProximityRequest(bts)
+	require.NoError(t, err)
+	require.Equal(t, "ru.nl", parsed.Hostname)
+	require.Equal(t, request.Nonce, parsedRequest.Nonce)
+	require.Equal(t, request.Disclose, parsedRequest.Disclose)
+	require.NoError(t, parsed.Verify(&sk.PublicKey))
+
+	// The signature covers the hostname
+	parsed.Hostname = "ru.nl.example.com"
+	require.Error(t, parsed.Verify(&sk.PublicKey))
+
+	// A disclosure over another nonce does not verify
+	bts, err = MarshalBinary(disclosure)
+	require.NoError(t, err)
+	_, status, _ := VerifyProximityDisclosure(conf, request, bts)
+	require.NotEqual(t, ProofStatusValid, status)
+
+	// A disclosure over the nonce and context of the request verifies
+	credid := NewCredentialTypeIdentifier("irma-demo.RU.studentCard")
+	issuerid := credid.IssuerIdentifier()
+	ipk, err := conf.PublicKey(issuerid, 0)
+	require.NoError(t, err)
+	isk, err := conf.PrivateKey(issuerid, 0)
+	require.NoError(t, err)
+	attrs, err := (&CredentialRequest{
+		CredentialTypeID: credid,
+		Attributes:       map[string]string{"university": "Radboud", "studentCardNumber": "31415927", "studentID": "s1234567", "level": "42"},
+	}).AttributeList(conf, 0x03, nil)
+	require.NoError(t, err)
+	random := func(bits uint) *big.Int {
+		return common.RandomBigInt(new(big.Int).Lsh(big.NewInt(1), bits))
+	}
+	nonce2 := random(ipk.Params.Lstatzk)
+	builder := gabi.NewCredentialBuilder(ipk, request.GetContext(), random(ipk.Params.Lm), nonce2)
+	commitment := builder.CommitToSecretAndProve(random(ipk.Params.Lstatzk))
+	sig, err := gabi.NewIssuer(isk, ipk, request.GetContext()).IssueSignature(commitment.U, attrs.Ints, nil, nonce2)
+	require.NoError(t, err)
+	cred, err := builder.ConstructCredential(sig, attrs.Ints)
+	require.NoError(t, err)
+	index, err := conf.CredentialTypes[credid].IndexOf(NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID"))
+	require.NoError(t, err)
+	proof, err := cred.CreateDisclosureProof([]int{1, index + 2}, false, request.GetContext(), request.GetNonce(nil))
+	require.NoError(t, err)
+	bts, err = MarshalBinary(&Disclosure{
+		Proofs:  gabi.ProofList{proof},
+		Indices: DisclosedAttributeIndices{{{CredentialIndex: 0, AttributeIndex: index + 2}}},
+	})
+	require.NoError(t, err)
+	disclosed, status, err := VerifyProximityDisclosure(conf, request, bts)
+	require.NoError(t, err)
+	require.Equal(t, ProofStatusValid, status)
+	require.Equal(t, "s1234567", *disclosed[0][0].RawValue)
+}
+
+func TestAttributeTypes(t *testing.T) {
+	cred := &CredentialType{}
+	require.NoError(t, xml.Unmarshal([]byte(`<IssueSpecification version="4"><Attributes>
+		<Attribute id="level" type="integer" maxLength="2"/>
+		<Attribute id="birthdate" type="date"/>
+		<Attribute id="over18" type="boolean"/>
+		<Attribute id="kind" type="enum"><Values><Value>bachelor</Value><Value>master</Value></Values></Attribute>
+		<Attribute id="studentID" pattern="s[0-9]{7}"/>
+	</Attributes></IssueSpecification>`), cred))
+	for _, attr := range cred.AttributeTypes {
+		require.NoError(t, attr.ValidateDeclaration(), attr.ID)
+	}
+	level, birthdate, over18, kind, studentID := cred.AttributeTypes[0], cred.AttributeTypes[1],
+		cred.AttributeTypes[2], cred.AttributeTypes[3], cred.AttributeTypes[4]
+	require.Equal(t, []string{"bachelor", "master"}, kind.Values)
+
+	for attr, valid := range map[*AttributeType][]string{
+		level:     {"42", "-1"},
+		birthdate: {"2020-02-29"},
+		over18:    {"true", "no"},
+		kind:      {"master"},
+		studentID: {"s1234567"},
+	} {
+		for _, value := range valid {
+			require.NoError(t, attr.ValidateValue(value), "%s: %s", attr.ID, value)
+		}
+	}
+	for attr, invalid := range map[*AttributeType][]string{
+		level:     {"420", "4.2", "", "x"},
+		birthdate: {"2020-02-31", "31-01-2020"},
+		over18:    {"True", "1"},
+		kind:      {"phd", ""},
+		studentID: {"s123456", "xs1234567", "s12345678"},
+	} {
+		for _, value := range invalid {
+			require.Error(t, attr.ValidateValue(value), "%s: %s", attr.ID, value)
+		}
+	}
+
+	// Inconsistent declarations
+	for _, attr := range []*AttributeType{
+		{Type: "float"},
+		{Type: AttributeValueTypeEnum},
+		{Type: AttributeValueTypeInteger, Values: []string{"1"}},
+		{Pattern: "("},
+		{MaxLength: -1},
+		{RevocationAttribute: true, Type: AttributeValueTypeString},
+	} {
+		require.Error(t, attr.ValidateDeclaration())
+	}
+
+	// Attributes of unknown types in schemes are treated as untyped, with a warning
+	cred = &CredentialType{}
+	require.NoError(t, xml.Unmarshal([]byte(`<IssueSpecification version="4"><Attributes>
+		<Attribute id="height" type="float"/>
+		<Attribute id="colors" type="multienum"><Values><Value>red</Value><Value>blue</Value></Values></Attribute>
+	</Attributes></IssueSpecification>`), cred))
+	schemeconf := &Configuration{}
+	require.NoError(t, schemeconf.validateAttributes(cred))
+	require.Contains(t, schemeconf.Warnings, "Attribute height of credential type .. has unknown type float, treating it as untyped")
+	for _, attr := range cred.AttributeTypes {
+		require.Equal(t, AttributeValueType(""), attr.Type)
+		require.NoError(t, attr.ValidateValue("1.85, red"))
+	}
+
+	// Credential requests are checked against the declared types
+	conf := parseConfiguration(t)
+	credid := NewCredentialTypeIdentifier("irma-demo.RU.studentCard")
+	for _, attr := range conf.CredentialTypes[credid].AttributeTypes {
+		if attr.ID == "level" {
+			attr.Type = AttributeValueTypeInteger
+		}
+	}
+	request := &CredentialRequest{
+		CredentialTypeID: credid,
+		Attributes: map[string]string{
+			"university":        "Radboud",
+			"studentCardNumber": "31415927",
+			"studentID":         "s1234567",
+			"level":             "42",
+		},
+	}
+	require.NoError(t, request.Validate(conf))
+	request.Attributes["level"] = "high"
+	require.Error(t, request.Validate(conf))
+
+	// Disclosed attributes expose their typed value
+	value := "2020-02-29"
+	attr := &DisclosedAttribute{RawValue: &value, ValueType: AttributeValueTypeDate}
+	typed, err := attr.TypedValue()
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC), typed)
+	attr.RawValue = nil
+	typed, err = attr.TypedValue()
+	require.NoError(t, err)
+	require.Nil(t, typed)
+}
+
+func TestTransportOptions(t *testing.T) {
+	var userAgent string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.Header.Get("User-Agent")
+		if r.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	cert := server.Certificate()
+	ca := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+	pin := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	get := func(opts *TransportOptions, path string) error {
+		_, err := NewHTTPTransportWithOptions(server.URL, opts, TransportPurposeSession).GetBytes(path)
+		return err
+	}
+
+	// The server certificate is not trusted by default
+	require.Error(t, get(nil, "ok"))
+	require.NoError(t, get(&TransportOptions{CACertificates: ca, UserAgent: "test"}, "ok"))
+	require.Equal(t, "test", userAgent)
+
+	// Pinned public keys
+	opts := &TransportOptions{CACertificates: ca, PinnedPublicKeys: []string{base64.StdEncoding.EncodeToString(pin[:])}}
+	require.NoError(t, opts.Validate())
+	require.NoError(t, get(opts, "ok"))
+	other := sha256.Sum256([]byte("other"))
+	require.Error(t, get(&TransportOptions{CACertificates: ca, PinnedPublicKeys: []string{base64.StdEncoding.EncodeToString(other[:])}}, "ok"))
+
+	// Timeouts per purpose
+	opts = &TransportOptions{CACertificates: ca, RetryMax: -1, Timeouts: map[TransportPurpose]Duration{
+		TransportPurposeSession: Duration(50 * time.Millisecond),
+	}}
+	require.Error(t, get(opts, "slow"))
+	_, err := NewHTTPTransportWithOptions(server.URL, opts, TransportPurposeScheme).GetBytes("slow")
+	require.NoError(t, err)
+
+	// The CA certificates are parsed once
+	pool, err := caCertPool(ca)
+	require.NoError(t, err)
+	transport, err := (&TransportOptions{CACertificates: ca}).httpTransport()
+	require.NoError(t, err)
+	require.True(t, pool == transport.TLSClientConfig.RootCAs)
+
+	// Durations are unmarshaled from numbers of seconds or duration strings
+	opts = &TransportOptions{}
+	require.NoError(t, json.Unmarshal([]byte(`{"timeout": 1.5, "timeouts": {"scheme": "200ms"}}`), opts))
+	require.Equal(t, 1500*time.Millisecond, opts.timeout(TransportPurposeSession))
+	require.Equal(t, 200*time.Millisecond, opts.timeout(TransportPurposeScheme))
+	require.Error(t, json.Unmarshal([]byte(`{"timeout": "soon"}`), opts))
+
+	// Invalid options are rejected, and transports using them fail
+	opts = &TransportOptions{CACertificates: "not a certificate"}
+	require.Error(t, opts.Validate())
+	require.Error(t, get(opts, "ok"))
+	_, err = NewConfiguration(filepath.Join("testdata", "irma_configuration"), ConfigurationOptions{Transport: opts})
+	require.Error(t, err)
+}
+
+func TestSchemeUpdateTransport(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.Dir("testdata")))
+	mux.HandleFunc("/requestors/description.xml", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<RequestorScheme version="1"><Id>test-requestors</Id></RequestorScheme>`))
+	})
+	server := httptest.NewTLSServer(mux)
+	defer server.Close()
+	ca := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+	newConf := func(name string, opts *TransportOptions) *Configuration {
+		path := filepath.Join(storage, name)
+		require.NoError(t, common.CopyDirectory(filepath.Join("testdata", "irma_configuration"), path))
+		conf, err := NewConfiguration(path, ConfigurationOptions{Transport: opts})
+		require.NoError(t, err)
+		require.NoError(t, conf.ParseFolder())
+		// The timestamp of the updated scheme is older than that of ours, so pretend ours is older still
+		scheme := conf.SchemeManagers[NewSchemeManagerIdentifier("irma-demo")]
+		scheme.URL = server.URL + "/irma_configuration_updated/irma-demo"
+		scheme.Timestamp = Timestamp(time.Unix(0, 0))
+		return conf
+	}
+	schemeid := NewSchemeManagerIdentifier("irma-demo")
+	credid := NewCredentialTypeIdentifier("irma-demo.RU.studentCard")
+	attrid := NewAttributeTypeIdentifier("irma-demo.RU.studentCard.newAttribute")
+
+	// The server certificate is trusted only through the configured CA certificates
+	conf := newConf("untrusted", nil)
+	require.Error(t, conf.UpdateSchemeManager(schemeid, nil))
+	_, err := conf.DownloadRequestorScheme(server.URL + "/requestors")
+	require.Error(t, err)
+
+	conf = newConf("trusted", &TransportOptions{CACertificates: ca})
+	require.NoError(t, conf.UpdateSchemeManager(schemeid, nil))
+	require.NoError(t, conf.ParseFolder())
+	require.True(t, conf.CredentialTypes[credid].ContainsAttribute(attrid))
+	scheme, err := conf.DownloadRequestorScheme(server.URL + "/requestors")
+	require.NoError(t, err)
+	require.Equal(t, "test-requestors", scheme.ID)
+}
+
+func TestRevocationServerFailover(t *testing.T) {
+	// Only this configuration contains issuer keys supporting revocation
+	conf, err := NewConfiguration("testdata/irma_configuration_updated", ConfigurationOptions{ReadOnly: true})
+	require.NoError(t, err)
+	require.NoError(t, conf.ParseFolder())
+	sk, err := conf.Revocation.Keys.PrivateKey(revocationTestCred.IssuerIdentifier(), revocationPkCounter)
+	require.NoError(t, err)
+
+	// Start two working revocation servers, one that fails, one serving a different accumulator,
+	// and one that does not respond until the request is aborted
+	revocationServer := func(served *atomic.Value, hits *int32) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(hits, 1)
+			if served == nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			bts, err := MarshalBinary(served.Load())
+			require.NoError(t, err)
+			_, _ = w.Write(bts)
+		}))
+	}
+	update, err := revocation.NewAccumulator(sk)
+	require.NoError(t, err)
+	other, err := revocation.NewAccumulator(sk)
+	require.NoError(t, err)
+	var served, inconsistentServed atomic.Value
+	served.Store(update)
+	inconsistentServed.Store(other)
+	var goodHits, mirrorHits, downHits, otherHits, slowHits int32
+	good, mirror := revocationServer(&served, &goodHits), revocationServer(&served, &mirrorHits)
+	down, inconsistent := revocationServer(nil, &downHits), revocationServer(&inconsistentServed, &otherHits)
+	defer good.Close()
+	defer mirror.Close()
+	defer down.Close()
+	defer inconsistent.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&slowHits, 1)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer slow.Close()
+
+	serverStatus := func(url string) RevocationServerStatus {
+		for _, status := range conf.RevocationServerStatus() {
+			if status.URL == url {
+				return status
+			}
+		}
+		return RevocationServerStatus{URL: url, Healthy: true}
+	}
+	fetch := func(urls ...string) (*revocation.Update, error) {
+		conf.CredentialTypes[revocationTestCred].RevocationServers = urls
+		return RevocationClient{Conf: conf}.FetchUpdateLatest(revocationTestCred, revocationPkCounter, 0)
+	}
+
+	// The next server is queried as soon as the first one fails
+	fetched, err := fetch(down.URL, good.URL)
+	require.NoError(t, err)
+	require.Equal(t, update.SignedAccumulator.Data, fetched.SignedAccumulator.Data)
+	status := serverStatus(down.URL)
+	require.Equal(t, 1, status.Failures)
+	require.False(t, status.Healthy)
+	require.NotNil(t, status.RetryAfter)
+	status = serverStatus(good.URL)
+	require.True(t, status.Healthy)
+	require.NotNil(t, status.LastSuccess)
+
+	// The failing server is skipped while backing off
+	_, err = fetch(down.URL, good.URL)
+	require.NoError(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&downHits))
+	require.Equal(t, int32(2), atomic.LoadInt32(&goodHits))
+
+	// Once the first server responds, the others are not queried
+	_, err = fetch(good.URL, inconsistent.URL)
+	require.NoError(t, err)
+	require.Equal(t, int32(3), atomic.LoadInt32(&goodHits))
+	require.Zero(t, atomic.LoadInt32(&otherHits))
+
+	// Accumulators differing from those that another server served are not used: all servers
+	// are queried, and the server disagreeing with the majority is rejected
+	fetched, err = fetch(inconsistent.URL, good.URL, mirror.URL)
+	require.NoError(t, err)
+	require.Equal(t, update.SignedAccumulator.Data, fetched.SignedAccumulator.Data)
+	require.Equal(t, int32(1), atomic.LoadInt32(&otherHits))
+	require.Equal(t, int32(4), atomic.LoadInt32(&goodHits))
+	require.Equal(t, int32(1), atomic.LoadInt32(&mirrorHits))
+	status = serverStatus(inconsistent.URL)
+	require.Contains(t, status.Inconsistency, "compared to majority")
+	require.Equal(t, 1, status.Failures)
+	inconsistency := func(s RevocationServerStatus) bool { return s.Inconsistency != "" }
+	require.Len(t, filterRevocationServerStatus(conf, inconsistency), 1)
+
+	// Without a majority the accumulators are not used either
+	_, err = fetch(inconsistent.URL)
+	require.Error(t, err)
+
+	// The inconsistency is cleared once the server agrees again
+	inconsistentServed.Store(update)
+	_, err = fetch(inconsistent.URL)
+	require.NoError(t, err)
+	require.Empty(t, filterRevocationServerStatus(conf, inconsistency))
+
+	// A server that does not respond in time is raced by the next one, and aborted once that
+	// responds; it counts as failed, so that it is not queried first again
+	defer func(delay uint64) { RevocationParameters.ServerFallbackDelay = delay }(RevocationParameters.ServerFallbackDelay)
+	RevocationParameters.ServerFallbackDelay = 50
+	fetched, err = fetch(slow.URL, good.URL)
+	require.NoError(t, err)
+	require.Equal(t, update.SignedAccumulator.Data, fetched.SignedAccumulator.Data)
+	require.Equal(t, int32(1), atomic.LoadInt32(&slowHits))
+	require.Equal(t, int32(5), atomic.LoadInt32(&goodHits))
+	status = serverStatus(slow.URL)
+	require.Equal(t, 1, status.Failures)
+	require.NotNil(t, status.RetryAfter)
+	_, err = fetch(slow.URL, good.URL)
+	require.NoError(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&slowHits))
+	require.Equal(t, int32(6), atomic.LoadInt32(&goodHits))
+}
+
+func filterRevocationServerStatus(conf *Configuration, f func(RevocationServerStatus) bool) []RevocationServerStatus {
+	var statuses []RevocationServerStatus
+	for _, status := range conf.RevocationServerStatus() {
+		if f(status) {
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses
+}
+
+func TestStaticRevocationUpdates(t *testing.T) {
+	conf, err := NewConfiguration("testdata/irma_configuration_updated", ConfigurationOptions{ReadOnly: true})
+	require.NoError(t, err)
+	require.NoError(t, conf.ParseFolder())
+	sk, err := conf.Revocation.Keys.PrivateKey(revocationTestCred.IssuerIdentifier(), revocationPkCounter)
+	require.NoError(t, err)
+	pk, err := conf.Revocation.Keys.PublicKey(revocationTestCred.IssuerIdentifier(), revocationPkCounter)
+	require.NoError(t, err)
+	count := conf.CredentialTypes[revocationTestCred].RevocationUpdateCount
+
+	// Revoke enough credentials to require fetching events beyond the latest ones
+	update, err := revocation.NewAccumulator(sk)
+	require.NoError(t, err)
+	acc, event := update.SignedAccumulator.Accumulator, update.Events[0]
+	events := []*revocation.Event{event}
+	for i := 0; i < 40; i++ {
+		acc, event = revoke(t, acc, event, sk)
+		events = append(events, event)
+	}
+	update, err = revocation.NewUpdate(sk, acc, events[len(events)-int(count):])
+	require.NoError(t, err)
+	_, err = update.Verify(pk)
+	require.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "revocation")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	var requested [][2]uint64
+	eventlist := func(counter uint, from, to uint64) (*revocation.EventList, error) {
+		require.Equal(t, revocationPkCounter, counter)
+		requested = append(requested, [2]uint64{from, to})
+		return revocation.NewEventList(events[from:to]...), nil
+	}
+	updates := map[uint]*revocation.Update{revocationPkCounter: update}
+	require.NoError(t, writeStaticRevocationUpdates(dir, revocationTestCred, count, updates, eventlist))
+	require.Contains(t, requested, [2]uint64{0, 32})
+	require.NotContains(t, requested, [2]uint64{32, 48}) // not all events exist yet
+
+	// Event files are written only once
+	written := len(requested)
+	require.NoError(t, writeStaticRevocationUpdates(dir, revocationTestCred, count, updates, eventlist))
+	require.Len(t, requested, written)
+
+	server := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer server.Close()
+	conf.CredentialTypes[revocationTestCred].RevocationServers = []string{"static+" + server.URL}
+	client := RevocationClient{Conf: conf}
+
+	fetched, err := client.FetchUpdatesLatest(revocationTestCred, count)
+	require.NoError(t, err)
+	require.Contains(t, fetched, revocationPkCounter)
+	require.Equal(t, update.SignedAccumulator.Data, fetched[revocationPkCounter].SignedAccumulator.Data)
+
+	full, err := client.FetchUpdateFrom(revocationTestCred, revocationPkCounter, 0)
+	require.NoError(t, err)
+	require.Len(t, full.Events, len(events))
+	_, err = full.Verify(pk)
+	require.NoError(t, err)
+}
+
+func TestRevocationReplication(t *testing.T) {
+	// A revocation authority serving an accumulator
+	var raHits int32
+	var updates map[uint]*revocation.Update
+	ra := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&raHits, 1)
+		bts, err := MarshalBinary(updates)
+		require.NoError(t, err)
+		_, _ = w.Write(bts)
+	}))
+	defer ra.Close()
+
+	// A replica receiving the updates of its peer, as the IRMA server does
+	var replica *Configuration
+	received := make(chan struct{}, 1)
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/revocation/"+revocationTestCred.String()+"/replicate", r.URL.Path)
+		if !replica.Revocation.AuthorizeReplicationPeer(r.Header.Get("Authorization")) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		bts, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		var msg RevocationReplicationMessage
+		require.NoError(t, UnmarshalBinary(bts, &msg))
+		require.NoError(t, replica.Revocation.ReceiveReplication(revocationTestCred, &msg))
+		received <- struct{}{}
+	}))
+	defer peer.Close()
+
+	newConf := func(replication *RevocationReplication) (*Configuration, error) {
+		conf, err := NewConfiguration("testdata/irma_configuration_updated", ConfigurationOptions{
+			ReadOnly:              true,
+			RevocationReplication: replication,
+		})
+		require.NoError(t, err)
+		if err = conf.ParseFolder(); err != nil {
+			return nil, err
+		}
+		conf.CredentialTypes[revocationTestCred].RevocationServers = []string{ra.URL}
+		return conf, nil
+	}
+	_, err := newConf(&RevocationReplication{Mode: RevocationReplicationSQL})
+	require.Error(t, err) // no database configured
+	_, err = newConf(&RevocationReplication{Mode: RevocationReplicationPeers, Peers: []string{peer.URL}})
+	require.Error(t, err) // no secret configured
+
+	replication := &RevocationReplication{Mode: RevocationReplicationPeers, Peers: []string{"http://localhost:1"}, Secret: "secret"}
+	replica, err = newConf(replication)
+	require.NoError(t, err)
+	replication = &RevocationReplication{Mode: RevocationReplicationPeers, Peers: []string{peer.URL}, Secret: "secret"}
+	conf, err := newConf(replication)
+	require.NoError(t, err)
+	require.False(t, replica.Revocation.AuthorizeReplicationPeer("wrong"))
+
+	// A client of the replica, subscribed to its revocation update events
+	replica.Revocation.settings.Get(revocationTestCred).Server = true
+	replica.Revocation.ServerSentEvents = sse.NewServer(&sse.Options{
+		ChannelNameFunc: func(r *http.Request) string { return strings.TrimPrefix(r.URL.Path, "/") },
+	})
+	defer replica.Revocation.ServerSentEvents.Shutdown()
+	sseServer := httptest.NewServer(replica.Revocation.ServerSentEvents)
+	defer sseServer.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan *sseclient.Event, 1)
+	go func() {
+		_ = sseclient.Notify(ctx, sseServer.URL+"/revocation/"+revocationTestCred.String(), true, events)
+	}()
+	for i := 0; !replica.Revocation.ServerSentEvents.HasChannel("revocation/" + revocationTestCred.String()); i++ {
+		require.True(t, i < 100, "client did not subscribe to revocation update events")
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	sk, err := conf.Revocation.Keys.PrivateKey(revocationTestCred.IssuerIdentifier(), revocationPkCounter)
+	require.NoError(t, err)
+	update, err := revocation.NewAccumulator(sk)
+	require.NoError(t, err)
+	updates = map[uint]*revocation.Update{revocationPkCounter: update}
+
+	// After fetching the updates, the replica has them too, as recent as its peer
+	require.NoError(t, conf.Revocation.SyncDB(revocationTestCred))
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("replica did not receive revocation updates")
+	}
+	require.True(t, conf.Revocation.updatedTime(revocationTestCred).Equal(replica.Revocation.updatedTime(revocationTestCred)))
+	replicated, err := replica.Revocation.UpdateLatest(revocationTestCred, 0, nil)
+	require.NoError(t, err)
+	require.Equal(t, update.SignedAccumulator.Data, replicated[revocationPkCounter].SignedAccumulator.Data)
+
+	// and the replica sends them on to its own subscribers
+	select {
+	case event := <-events:
+		var posted revocation.Update
+		require.NoError(t, json.Unmarshal(event.Data, &posted))
+		require.Equal(t, update.SignedAccumulator.Data, posted.SignedAccumulator.Data)
+	case <-time.After(5 * time.Second):
+		t.Fatal("replica did not send revocation update event")
+	}
+
+	// so that it need not fetch them itself
+	require.NoError(t, replica.Revocation.SyncIfOld(revocationTestCred, 60))
+	require.Equal(t, int32(1), atomic.LoadInt32(&raHits))
+}
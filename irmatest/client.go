@@ -0,0 +1,186 @@
+package irmatest
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/internal/common"
+	"github.com/privacybydesign/irmago/irmaclient"
+	"github.com/stretchr/testify/require"
+)
+
+// Client is an irmaclient.Client with temporary storage, that performs sessions without user
+// interaction: it gives permission in all sessions, choosing candidates using Choose, and enters PIN
+// when asked for its PIN.
+type Client struct {
+	*irmaclient.Client
+
+	// PIN to enter when the keyshare server asks for it
+	PIN string
+	// Choose returns the attributes to disclose from the candidates for each disjunction of the request.
+	// If nil, the first candidate of each disjunction is chosen. Return nil to refuse the session.
+	Choose func(request irma.SessionRequest, candidates [][][]*irma.AttributeIdentifier) *irma.DisclosureChoice
+
+	dir string
+}
+
+// SessionResult is the outcome of a session at a Client.
+type SessionResult struct {
+	// Passed to irmaclient.Handler.Success(); e.g., the signature or disclosure in manual sessions
+	Result string
+	// Identity of the requestor as presented to the user
+	Requestor *irmaclient.RequestorIdentity
+	// Attributes that the client did not have, if the request was unsatisfiable
+	Missing irmaclient.MissingAttributes
+}
+
+// NewClient returns a Client without any credentials, using a temporary copy of the demo schemes.
+func NewClient(t testing.TB) *Client {
+	dir, path := copySchemes(t)
+	storage := filepath.Join(dir, "client")
+	require.NoError(t, common.EnsureDirectoryExists(storage))
+	client, err := irmaclient.New(storage, path, &clientHandler{})
+	require.NoError(t, err)
+	return &Client{Client: client, PIN: "12345", dir: dir}
+}
+
+// Close closes the client and removes its storage.
+func (c *Client) Close() {
+	_ = c.Client.Close()
+	removeDir(c.dir)
+}
+
+// Perform performs the session of the specified session pointer (e.g. from a QR), returning
+// when the session is done. It returns an error if the session did not succeed.
+func (c *Client) Perform(sessionptr *irma.Qr) (*SessionResult, error) {
+	bts, err := json.Marshal(sessionptr)
+	if err != nil {
+		return nil, err
+	}
+	return c.PerformRequest(string(bts))
+}
+
+// PerformRequest performs the session of the specified session pointer or (for manual sessions)
+// session request, like Perform.
+func (c *Client) PerformRequest(request string) (*SessionResult, error) {
+	h := &sessionHandler{client: c, done: make(chan error, 1)}
+	c.NewSession(request, h)
+	err := <-h.done
+	return &h.result, err
+}
+
+// sessionHandler is the irmaclient.Handler of a session performed by Client.
+type sessionHandler struct {
+	client *Client
+	result SessionResult
+	done   chan error
+}
+
+func (h *sessionHandler) finish(err error) {
+	select {
+	case h.done <- err:
+	default: // session already finished
+	}
+}
+
+func (h *sessionHandler) StatusUpdate(action irma.Action, status irma.Status) {}
+func (h *sessionHandler) ClientReturnURLSet(clientReturnURL string)           {}
+
+func (h *sessionHandler) Success(result string) {
+	h.result.Result = result
+	h.finish(nil)
+}
+
+func (h *sessionHandler) Cancelled() {
+	h.finish(errors.New("session cancelled"))
+}
+
+func (h *sessionHandler) Failure(err *irma.SessionError) {
+	h.finish(err)
+}
+
+func (h *sessionHandler) UnsatisfiableRequest(
+	request irma.SessionRequest, requestor *irmaclient.RequestorIdentity, missing irmaclient.MissingAttributes,
+) {
+	h.result.Requestor = requestor
+	h.result.Missing = missing
+	h.finish(errors.New("unsatisfiable session request"))
+}
+
+func (h *sessionHandler) KeyshareBlocked(manager irma.SchemeManagerIdentifier, duration int) {
+	h.finish(errors.Errorf("keyshare account at %s blocked", manager))
+}
+
+func (h *sessionHandler) KeyshareEnrollmentIncomplete(manager irma.SchemeManagerIdentifier) {
+	h.finish(errors.Errorf("keyshare enrollment at %s incomplete", manager))
+}
+
+func (h *sessionHandler) KeyshareEnrollmentMissing(manager irma.SchemeManagerIdentifier) {
+	h.finish(errors.Errorf("not enrolled at keyshare server of %s", manager))
+}
+
+func (h *sessionHandler) KeyshareEnrollmentDeleted(manager irma.SchemeManagerIdentifier) {
+	h.finish(errors.Errorf("keyshare enrollment at %s deleted", manager))
+}
+
+func (h *sessionHandler) RequestIssuancePermission(
+	request *irma.IssuanceRequest, candidates [][][]*irma.AttributeIdentifier,
+	requestor *irmaclient.RequestorIdentity, callback irmaclient.PermissionHandler,
+) {
+	h.permission(request, candidates, requestor, callback)
+}
+
+func (h *sessionHandler) RequestVerificationPermission(
+	request *irma.DisclosureRequest, candidates [][][]*irma.AttributeIdentifier,
+	requestor *irmaclient.RequestorIdentity, callback irmaclient.PermissionHandler,
+) {
+	h.permission(request, candidates, requestor, callback)
+}
+
+func (h *sessionHandler) RequestSignaturePermission(
+	request *irma.SignatureRequest, candidates [][][]*irma.AttributeIdentifier,
+	requestor *irmaclient.RequestorIdentity, callback irmaclient.PermissionHandler,
+) {
+	h.permission(request, candidates, requestor, callback)
+}
+
+func (h *sessionHandler) RequestSchemeManagerPermission(manager *irma.SchemeManager, callback func(proceed bool)) {
+	callback(true)
+}
+
+func (h *sessionHandler) RequestPin(remainingAttempts int, callback irmaclient.PinHandler) {
+	callback(true, h.client.PIN)
+}
+
+func (h *sessionHandler) permission(
+	request irma.SessionRequest, candidates [][][]*irma.AttributeIdentifier,
+	requestor *irmaclient.RequestorIdentity, callback irmaclient.PermissionHandler,
+) {
+	h.result.Requestor = requestor
+	var choice *irma.DisclosureChoice
+	if h.client.Choose != nil {
+		choice = h.client.Choose(request, candidates)
+	} else {
+		choice = &irma.DisclosureChoice{}
+		for _, candidate := range candidates {
+			choice.Attributes = append(choice.Attributes, candidate[0])
+		}
+	}
+	callback(choice != nil, choice)
+}
+
+// clientHandler is the irmaclient.ClientHandler of Client.
+type clientHandler struct{}
+
+func (h *clientHandler) UpdateConfiguration(new *irma.IrmaIdentifierSet)                       {}
+func (h *clientHandler) UpdateAttributes()                                                     {}
+func (h *clientHandler) Revoked(cred *irma.CredentialIdentifier)                               {}
+func (h *clientHandler) EnrollmentFailure(manager irma.SchemeManagerIdentifier, err error)     {}
+func (h *clientHandler) EnrollmentSuccess(manager irma.SchemeManagerIdentifier)                {}
+func (h *clientHandler) ChangePinFailure(manager irma.SchemeManagerIdentifier, err error)      {}
+func (h *clientHandler) ChangePinSuccess(manager irma.SchemeManagerIdentifier)                 {}
+func (h *clientHandler) ChangePinIncorrect(manager irma.SchemeManagerIdentifier, attempts int) {}
+func (h *clientHandler) ChangePinBlocked(manager irma.SchemeManagerIdentifier, timeout int)    {}
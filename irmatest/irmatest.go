@@ -0,0 +1,66 @@
+// Package irmatest is a harness for integration tests of applications using irmago. It starts
+// an IRMA server (Server) on a random port and creates IRMA clients (Client) with scripted
+// behaviour, both using temporary copies of the demo schemes and issuer private keys of
+// irmago's testdata, and performs IRMA sessions between them end to end. Example:
+//
+//	s := irmatest.StartServer(t, nil)
+//	defer s.Stop()
+//	c := irmatest.NewClient(t)
+//	defer c.Close()
+//
+//	s.Issue(t, c, &irma.CredentialRequest{ ... })
+//	result := irmatest.RunSession(t, s, c, irma.NewDisclosureRequest(attrid))
+package irmatest
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/internal/common"
+	"github.com/stretchr/testify/require"
+)
+
+// TestdataPath is the path to the testdata folder of irmago containing the demo schemes.
+// By default it is found next to the source of this package (e.g. in the Go module cache);
+// it must be set explicitly when tests are built with -trimpath.
+var TestdataPath = func() string {
+	_, file, _, ok := runtime.Caller(0)
+	if !ok {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(file), "..", "testdata")
+}()
+
+func init() {
+	// Sessions between the test server and clients happen over plain HTTP
+	irma.ForceHttps = false
+}
+
+// copySchemes copies the demo schemes, including the issuer private keys, to a new temporary
+// directory, returning the directory and the path to the irma_configuration folder within it.
+func copySchemes(t testing.TB) (string, string) {
+	dir, err := ioutil.TempDir("", "irmatest")
+	require.NoError(t, err)
+	path := filepath.Join(dir, "irma_configuration")
+	require.NoError(t, common.CopyDirectory(filepath.Join(TestdataPath, "irma_configuration"), path))
+	return dir, path
+}
+
+func removeDir(dir string) {
+	if err := os.RemoveAll(dir); err != nil {
+		irma.Logger.Warn("Failed to remove temporary directory: ", err)
+	}
+}
+
+// freePort returns a TCP port that is free at the time of calling.
+func freePort(t testing.TB) int {
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
@@ -0,0 +1,220 @@
+package irmatest
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/server"
+	"github.com/privacybydesign/irmago/server/requestorserver"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIssueAndDisclose(t *testing.T) {
+	s := StartServer(t, nil)
+	defer s.Stop()
+	c := NewClient(t)
+	defer c.Close()
+
+	credid := irma.NewCredentialTypeIdentifier("irma-demo.RU.studentCard")
+	attrid := irma.NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID")
+	s.Issue(t, c, &irma.CredentialRequest{
+		CredentialTypeID: credid,
+		Attributes: map[string]string{
+			"university":        "Radboud",
+			"studentCardNumber": "31415927",
+			"studentID":         "s1234567",
+			"level":             "42",
+		},
+	})
+	require.Len(t, c.CredentialInfoList(), 1)
+
+	result := RunSession(t, s, c, irma.NewDisclosureRequest(attrid))
+	require.Equal(t, server.StatusDone, result.Status)
+	require.Equal(t, irma.ProofStatusValid, result.ProofStatus)
+	require.Equal(t, "s1234567", *result.Disclosed[0][0].RawValue)
+
+	// Refusing to disclose cancels the session
+	c.Choose = func(irma.SessionRequest, [][][]*irma.AttributeIdentifier) *irma.DisclosureChoice { return nil }
+	session := s.StartSession(t, irma.NewDisclosureRequest(attrid))
+	_, err := c.Perform(session.SessionPtr)
+	require.Error(t, err)
+	result, err = session.Wait()
+	require.NoError(t, err)
+	require.Equal(t, server.StatusCancelled, result.Status)
+
+	// Requests for attributes that the client does not have are unsatisfiable
+	session = s.StartSession(t, irma.NewDisclosureRequest(irma.NewAttributeTypeIdentifier("irma-demo.MijnOverheid.root.BSN")))
+	r, err := c.Perform(session.SessionPtr)
+	require.Error(t, err)
+	require.NotEmpty(t, r.Missing)
+	session.Cancel()
+}
+
+func TestSessionTemplate(t *testing.T) {
+	s := StartServer(t, &requestorserver.Configuration{
+		Configuration: &server.Configuration{
+			DisableSchemesUpdate: true,
+			SessionTemplates: map[string]*server.SessionTemplate{
+				"student": {
+					Request: map[string]interface{}{
+						"@context": "https://irma.app/ld/request/disclosure/v2",
+						"disclose": [][][]interface{}{{{map[string]interface{}{
+							"type":  "irma-demo.RU.studentCard.studentID",
+							"value": "{{id}}",
+						}}}},
+					},
+					Parameters: map[string]*server.TemplateParameter{"id": {Pattern: "s[0-9]{7}"}},
+				},
+			},
+		},
+		DisableRequestorAuthentication: true,
+		Permissions: requestorserver.Permissions{
+			Disclosing: []string{"irma-demo.RU.studentCard.studentID"},
+			Issuing:    []string{"*"},
+		},
+	})
+	defer s.Stop()
+	c := NewClient(t)
+	defer c.Close()
+
+	s.Issue(t, c, &irma.CredentialRequest{
+		CredentialTypeID: irma.NewCredentialTypeIdentifier("irma-demo.RU.studentCard"),
+		Attributes: map[string]string{
+			"university":        "Radboud",
+			"studentCardNumber": "31415927",
+			"studentID":         "s1234567",
+			"level":             "42",
+		},
+	})
+
+	session, err := s.Client.StartTemplateSession("student", map[string]string{"id": "s1234567"})
+	require.NoError(t, err)
+	_, err = c.Perform(session.SessionPtr)
+	require.NoError(t, err)
+	result, err := session.Wait()
+	require.NoError(t, err)
+	require.Equal(t, irma.ProofStatusValid, result.ProofStatus)
+
+	// The client does not have a credential with another student ID
+	session, err = s.Client.StartTemplateSession("student", map[string]string{"id": "s7654321"})
+	require.NoError(t, err)
+	_, err = c.Perform(session.SessionPtr)
+	require.Error(t, err)
+	session.Cancel()
+
+	_, err = s.Client.StartTemplateSession("student", map[string]string{"id": "1234567"})
+	require.Error(t, err)
+	_, err = s.Client.StartTemplateSession("unknown", nil)
+	require.Error(t, err)
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	s := StartServer(t, nil)
+	defer s.Stop()
+
+	transport := irma.NewHTTPTransport(s.URL + "/")
+	var health string
+	require.NoError(t, transport.Get("health", &health))
+	require.Equal(t, "OK", health)
+	require.NoError(t, transport.Get("ready", &health))
+	require.Equal(t, "OK", health)
+
+	status := s.HealthStatus(t)
+	require.True(t, status.Ready)
+	require.Empty(t, status.DisabledSchemes)
+	require.Nil(t, status.SchemesUpdate)
+	require.Empty(t, status.RevocationDBError)
+
+	session := s.StartSession(t, irma.NewDisclosureRequest(irma.NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID")))
+	defer session.Cancel()
+	status = s.HealthStatus(t)
+	require.Equal(t, 1, status.Sessions[server.StatusInitialized])
+}
+
+func TestStatusToken(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	s := StartServer(t, &requestorserver.Configuration{
+		Requestors: map[string]requestorserver.Requestor{
+			"requestor": {
+				AuthenticationMethod: requestorserver.AuthenticationMethodHmac,
+				AuthenticationKey:    key,
+				Permissions:          requestorserver.Permissions{Disclosing: []string{"*"}},
+			},
+		},
+		StatusToken: "status",
+	})
+	defer s.Stop()
+
+	// Requestors using HMAC authentication cannot authenticate GET requests, but the status token can
+	transport := irma.NewHTTPTransport(s.URL + "/")
+	require.Error(t, transport.Get("status", &server.HealthStatus{}))
+	transport.SetHeader("Authorization", key)
+	require.Error(t, transport.Get("status", &server.HealthStatus{}))
+	require.True(t, s.HealthStatus(t).Ready)
+
+	// Readiness does not require authentication
+	var ready string
+	require.NoError(t, irma.NewHTTPTransport(s.URL+"/").Get("ready", &ready))
+	require.Equal(t, "OK", ready)
+}
+
+func TestShutdownDrainsSessions(t *testing.T) {
+	s := StartServer(t, nil)
+	require.Zero(t, s.Configuration.ShutdownTimeout) // the default when not using the CLI: no timeout
+	c := NewClient(t)
+	defer c.Close()
+
+	request := irma.NewIssuanceRequest([]*irma.CredentialRequest{{
+		CredentialTypeID: irma.NewCredentialTypeIdentifier("irma-demo.RU.studentCard"),
+		Attributes: map[string]string{
+			"university":        "Radboud",
+			"studentCardNumber": "31415927",
+			"studentID":         "s1234567",
+			"level":             "42",
+		},
+	}})
+	session := s.StartSession(t, request)
+	unstarted := s.StartSession(t, request)
+
+	// Have the client start the first session, pausing it when asked for permission
+	connected, proceed := make(chan struct{}), make(chan struct{})
+	c.Choose = func(irma.SessionRequest, [][][]*irma.AttributeIdentifier) *irma.DisclosureChoice {
+		close(connected)
+		<-proceed
+		return &irma.DisclosureChoice{}
+	}
+	performed := make(chan error, 1)
+	go func() {
+		_, err := c.Perform(session.SessionPtr)
+		performed <- err
+	}()
+	<-connected
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- s.Shutdown(context.Background())
+	}()
+	for !s.HealthStatus(t).Draining {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// New sessions are refused, and the server is no longer ready
+	_, err := s.Client.StartSession(&irma.IdentityProviderRequest{Request: request})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), string(server.ErrorShuttingDown.Type))
+	require.Error(t, irma.NewHTTPTransport(s.URL+"/").Get("ready", nil))
+
+	// The session that no client started is cancelled
+	status, err := unstarted.Status()
+	require.NoError(t, err)
+	require.Equal(t, server.StatusCancelled, status)
+
+	// The started session can still be completed, after which the server stops
+	close(proceed)
+	require.NoError(t, <-performed)
+	require.NoError(t, <-shutdown)
+	require.Len(t, c.CredentialInfoList(), 1)
+}
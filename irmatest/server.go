@@ -0,0 +1,178 @@
+package irmatest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/internal/common"
+	"github.com/privacybydesign/irmago/server"
+	"github.com/privacybydesign/irmago/server/requestorclient"
+	"github.com/privacybydesign/irmago/server/requestorserver"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+// Server is an IRMA server listening on a random port of localhost.
+type Server struct {
+	*requestorserver.Server
+	Configuration *requestorserver.Configuration
+
+	// URL of the requestor API of the server
+	URL string
+	// Client for the requestor API of the server, authenticating as one of the requestors of the
+	// configuration if requestor authentication is enabled.
+	Client *requestorclient.Client
+
+	dir  string
+	done chan struct{}
+}
+
+// StartServer starts an IRMA server using a temporary copy of the demo schemes and issuer
+// private keys, and returns when it accepts requests. The configuration may be nil; otherwise
+// its schemes path, private keys path, URL and port are overwritten. Unless configured otherwise,
+// requestor authentication is disabled, all requestors may issue and verify all attributes, and
+// schemes are not updated.
+func StartServer(t testing.TB, conf *requestorserver.Configuration) *Server {
+	if conf == nil {
+		conf = &requestorserver.Configuration{
+			DisableRequestorAuthentication: true,
+			Permissions: requestorserver.Permissions{
+				Disclosing: []string{"*"},
+				Signing:    []string{"*"},
+				Issuing:    []string{"*"},
+			},
+		}
+	}
+	if conf.Configuration == nil {
+		conf.Configuration = &server.Configuration{DisableSchemesUpdate: true}
+	}
+	if conf.Logger == nil {
+		conf.Logger = logrus.New()
+		conf.Logger.Level = logrus.FatalLevel
+	}
+
+	dir, path := copySchemes(t)
+	conf.SchemesPath = path
+	conf.IssuerPrivateKeysPath = ""
+	conf.ListenAddress = "localhost"
+	conf.Port = freePort(t)
+	url := fmt.Sprintf("http://localhost:%d", conf.Port)
+	conf.URL = url + "/irma"
+
+	s := &Server{Configuration: conf, URL: url, dir: dir, done: make(chan struct{})}
+	var err error
+	s.Server, err = requestorserver.New(conf)
+	require.NoError(t, err)
+	go func() {
+		defer close(s.done)
+		if err := s.Start(conf); err != nil {
+			t.Error("IRMA server failed: ", err)
+		}
+	}()
+	s.waitUntilListening(t)
+
+	var (
+		method requestorserver.AuthenticationMethod = requestorserver.AuthenticationMethodNone
+		key    interface{}
+		name   string
+	)
+	if !conf.DisableRequestorAuthentication {
+		name, method, key = s.requestorKey(t)
+	}
+	s.Client, err = requestorclient.New(url, method, key, name)
+	require.NoError(t, err)
+
+	return s
+}
+
+// Stop stops the server and removes its temporary copy of the schemes.
+func (s *Server) Stop() {
+	s.Server.Stop()
+	<-s.done
+	removeDir(s.dir)
+}
+
+// Shutdown gracefully stops the server (see requestorserver.Server.Shutdown()) and removes its
+// temporary copy of the schemes.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.Server.Shutdown(ctx)
+	<-s.done
+	removeDir(s.dir)
+	return err
+}
+
+// HealthStatus retrieves the status of the server from its /status endpoint.
+func (s *Server) HealthStatus(t testing.TB) *server.HealthStatus {
+	status := &server.HealthStatus{}
+	transport := irma.NewHTTPTransport(s.URL + "/")
+	if s.Configuration.StatusToken != "" {
+		transport.SetHeader("Authorization", s.Configuration.StatusToken)
+	} else if s.Client.Method == requestorserver.AuthenticationMethodToken {
+		transport.SetHeader("Authorization", s.Client.Key.(string))
+	}
+	require.NoError(t, transport.Get("status", status))
+	return status
+}
+
+// StartSession starts a session at the server using its requestor client.
+func (s *Server) StartSession(t testing.TB, request interface{}) *requestorclient.Session {
+	rrequest, err := server.ParseSessionRequest(request)
+	require.NoError(t, err)
+	session, err := s.Client.StartSession(rrequest)
+	require.NoError(t, err)
+	return session
+}
+
+// Issue issues the specified credentials to the client, failing the test if this does not succeed.
+func (s *Server) Issue(t testing.TB, client *Client, credentials ...*irma.CredentialRequest) {
+	result := RunSession(t, s, client, irma.NewIssuanceRequest(credentials))
+	require.Equal(t, server.StatusDone, result.Status)
+	require.Nil(t, result.Err)
+}
+
+// RunSession starts the specified session request (any request accepted by the IRMA server)
+// at the server, lets the client perform the session, and returns the session result.
+// The session must not fail at the client.
+func RunSession(t testing.TB, s *Server, client *Client, request interface{}) *server.SessionResult {
+	session := s.StartSession(t, request)
+	_, err := client.Perform(session.SessionPtr)
+	require.NoError(t, err)
+	result, err := session.Wait()
+	require.NoError(t, err)
+	return result
+}
+
+func (s *Server) waitUntilListening(t testing.TB) {
+	for i := 0; i < 100; i++ {
+		res, err := http.Get(s.URL + "/publickey")
+		if err == nil {
+			_ = res.Body.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.FailNow(t, "IRMA server did not start")
+}
+
+// requestorKey returns the name, authentication method and key of a requestor
+// from the configuration that authenticates with a key included in the configuration.
+func (s *Server) requestorKey(t testing.TB) (string, requestorserver.AuthenticationMethod, interface{}) {
+	for name, requestor := range s.Configuration.Requestors {
+		switch requestor.AuthenticationMethod {
+		case requestorserver.AuthenticationMethodToken:
+			return name, requestor.AuthenticationMethod, requestor.AuthenticationKey
+		case requestorserver.AuthenticationMethodHmac:
+			bts, err := common.ReadKey(requestor.AuthenticationKey, requestor.AuthenticationKeyFile)
+			require.NoError(t, err)
+			key, err := common.Base64Decode(bts)
+			require.NoError(t, err)
+			return name, requestor.AuthenticationMethod, key
+		}
+	}
+	require.FailNow(t, "no requestor with token or hmac key in IRMA server configuration")
+	return "", "", nil
+}
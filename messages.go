@@ -169,6 +169,17 @@ type Qr struct {
 
 type SchemeManagerRequest Qr
 
+// ServerSessionResponse is the response of the IRMA server to the proofs or commitments that the
+// client sends at the end of a session, as of protocol version 2.7.
+type ServerSessionResponse struct {
+	ProofStatus     ProofStatus                   `json:"proofStatus"`
+	IssueSignatures []*gabi.IssueSignatureMessage `json:"sigs,omitempty"`
+	// Pointer to the follow-up session, if the requestor requested one
+	NextSession *Qr `json:"nextSession,omitempty"`
+	// Signed ConsentReceipt, if the session request specified purposes
+	ConsentReceipt string `json:"consentReceipt,omitempty"`
+}
+
 // Statuses
 const (
 	StatusConnected     = Status("connected")
@@ -326,6 +337,13 @@ func ParseRequestorJwt(action string, requestorJwt string) (RequestorJwt, error)
 	return retval, nil
 }
 
+func (r *ServerSessionResponse) Validate() error {
+	if r.NextSession != nil {
+		return r.NextSession.Validate()
+	}
+	return nil
+}
+
 func (qr *Qr) Validate() (err error) {
 	if qr.URL == "" {
 		return errors.New("No URL specified")
@@ -0,0 +1,262 @@
+package irma
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/gabi"
+	"github.com/privacybydesign/gabi/big"
+	"github.com/privacybydesign/gabi/revocation"
+	"github.com/privacybydesign/gabi/signed"
+	"github.com/privacybydesign/irmago/internal/common"
+)
+
+// ProximityRequest is a disclosure request for offline proximity sessions, in which a verifier
+// device directly transfers its request to the IRMA app (e.g. using a QR or NFC) and receives the
+// binary encoding of the irma.Disclosure back the same way, without involving an IRMA server.
+// It is signed by the verifier using the ECDSA key listed as ProximityKey for its hostname in a
+// requestor scheme. Use MarshalBinary() and UnmarshalBinary() to (de)serialize it.
+type ProximityRequest struct {
+	_ struct{} `cbor:",toarray"`
+
+	// Hostname identifying the verifier in the requestor schemes
+	Hostname string
+	// JSON encoding of the DisclosureRequest
+	Request []byte
+	// ECDSA signature over the hostname and request
+	Signature []byte
+}
+
+// NewProximityRequest prepares the specified disclosure request for an offline proximity session:
+// it sets a fresh nonce, attaches the latest revocation updates known to the Configuration for each
+// credential type for which nonrevocation is requested, and signs the request with the verifier's
+// ECDSA key. If the revocation server cannot be reached, the cached accumulators are used.
+// The verifier should keep the request to later verify the response with VerifyProximityDisclosure().
+func NewProximityRequest(
+	conf *Configuration, request *DisclosureRequest, hostname string, sk *ecdsa.PrivateKey,
+) (*ProximityRequest, error) {
+	request.Nonce = common.RandomBigInt(new(big.Int).Lsh(big.NewInt(1), gabi.DefaultSystemParameters[2048].Lstatzk))
+	if request.Context == nil {
+		request.Context = bigOne
+	}
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+	if err := conf.Revocation.SetRevocationUpdates(request.Base()); err != nil {
+		return nil, err
+	}
+
+	bts, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	pr := &ProximityRequest{Hostname: hostname, Request: bts}
+	msg, err := pr.message()
+	if err != nil {
+		return nil, err
+	}
+	if pr.Signature, err = signed.Sign(sk, msg); err != nil {
+		return nil, err
+	}
+	return pr, nil
+}
+
+// ParseProximityRequest deserializes a proximity request and its disclosure request.
+// Note: this does not verify the signature! Use Verify() for that.
+func ParseProximityRequest(bts []byte) (*ProximityRequest, *DisclosureRequest, error) {
+	pr := &ProximityRequest{}
+	if err := UnmarshalBinary(bts, pr); err != nil {
+		return nil, nil, errors.WrapPrefix(err, "failed to parse proximity request", 0)
+	}
+	request := &DisclosureRequest{}
+	if err := UnmarshalValidate(pr.Request, request); err != nil {
+		return nil, nil, errors.WrapPrefix(err, "invalid disclosure request in proximity request", 0)
+	}
+	if request.Nonce == nil {
+		return nil, nil, errors.New("proximity request has no nonce")
+	}
+	return pr, request, nil
+}
+
+// Verify checks the signature of the proximity request against the specified verifier public key.
+func (pr *ProximityRequest) Verify(pk *ecdsa.PublicKey) error {
+	msg, err := pr.message()
+	if err != nil {
+		return err
+	}
+	return signed.Verify(pk, msg, pr.Signature)
+}
+
+func (pr *ProximityRequest) message() ([]byte, error) {
+	return MarshalBinary([]interface{}{pr.Hostname, pr.Request})
+}
+
+// VerifyProximityDisclosure verifies the binary encoding of a Disclosure received in response to
+// the specified request of an offline proximity session (see NewProximityRequest()). Nonrevocation
+// proofs are verified against the revocation updates attached to the request.
+func VerifyProximityDisclosure(
+	conf *Configuration, request *DisclosureRequest, response []byte,
+) ([][]*DisclosedAttribute, ProofStatus, error) {
+	disclosure := &Disclosure{}
+	if err := UnmarshalBinary(response, disclosure); err != nil {
+		return nil, ProofStatusInvalid, err
+	}
+	return disclosure.VerifyAgainstRequest(conf, request, request.GetContext(), request.GetNonce(nil), nil, nil, false)
+}
+
+// Compact binary encoding of disclosures, used in offline proximity sessions. All structs are
+// encoded as CBOR arrays instead of maps to save space, and big integers as byte strings.
+
+type binaryDisclosure struct {
+	_       struct{} `cbor:",toarray"`
+	Proofs  []*binaryProofD
+	Indices [][][2]int
+}
+
+type binaryProofD struct {
+	_             struct{} `cbor:",toarray"`
+	C             []byte
+	A             []byte
+	EResponse     []byte
+	VResponse     []byte
+	AResponses    map[int][]byte
+	ADisclosed    map[int][]byte
+	NonRevocation *binaryNonRevocationProof
+}
+
+type binaryNonRevocationProof struct {
+	_         struct{} `cbor:",toarray"`
+	Cr        []byte
+	Cu        []byte
+	Responses map[string][]byte
+	Acc       signed.Message
+	PKCounter uint
+}
+
+// MarshalCBOR implements cbor.Marshaler, encoding the disclosure compactly.
+// Only disclosure proofs (i.e., gabi.ProofD) are supported.
+func (d *Disclosure) MarshalCBOR() ([]byte, error) {
+	bd := binaryDisclosure{}
+	for _, proof := range d.Proofs {
+		proofd, ok := proof.(*gabi.ProofD)
+		if !ok {
+			return nil, errors.New("only disclosure proofs can be binary encoded")
+		}
+		bd.Proofs = append(bd.Proofs, encodeProofD(proofd))
+	}
+	for _, indices := range d.Indices {
+		var list [][2]int
+		for _, index := range indices {
+			list = append(list, [2]int{index.CredentialIndex, index.AttributeIndex})
+		}
+		bd.Indices = append(bd.Indices, list)
+	}
+	return MarshalBinary(bd)
+}
+
+// UnmarshalCBOR implements cbor.Unmarshaler.
+func (d *Disclosure) UnmarshalCBOR(data []byte) error {
+	var bd binaryDisclosure
+	if err := UnmarshalBinary(data, &bd); err != nil {
+		return err
+	}
+	d.Proofs = make(gabi.ProofList, 0, len(bd.Proofs))
+	for _, proof := range bd.Proofs {
+		if proof == nil {
+			return errors.New("empty proof in binary disclosure")
+		}
+		d.Proofs = append(d.Proofs, proof.decode())
+	}
+	d.Indices = make(DisclosedAttributeIndices, 0, len(bd.Indices))
+	for _, list := range bd.Indices {
+		indices := make([]*DisclosedAttributeIndex, 0, len(list))
+		for _, index := range list {
+			indices = append(indices, &DisclosedAttributeIndex{CredentialIndex: index[0], AttributeIndex: index[1]})
+		}
+		d.Indices = append(d.Indices, indices)
+	}
+	return nil
+}
+
+func encodeProofD(proof *gabi.ProofD) *binaryProofD {
+	bp := &binaryProofD{
+		C:          encodeInt(proof.C),
+		A:          encodeInt(proof.A),
+		EResponse:  encodeInt(proof.EResponse),
+		VResponse:  encodeInt(proof.VResponse),
+		AResponses: encodeIntMap(proof.AResponses),
+		ADisclosed: encodeIntMap(proof.ADisclosed),
+	}
+	if nonrev := proof.NonRevocationProof; nonrev != nil {
+		bp.NonRevocation = &binaryNonRevocationProof{
+			Cr:        encodeInt(nonrev.Cr),
+			Cu:        encodeInt(nonrev.Cu),
+			Responses: map[string][]byte{},
+		}
+		for key, response := range nonrev.Responses {
+			bp.NonRevocation.Responses[key] = encodeInt(response)
+		}
+		if nonrev.SignedAccumulator != nil {
+			bp.NonRevocation.Acc = nonrev.SignedAccumulator.Data
+			bp.NonRevocation.PKCounter = nonrev.SignedAccumulator.PKCounter
+		}
+	}
+	return bp
+}
+
+func (bp *binaryProofD) decode() *gabi.ProofD {
+	proof := &gabi.ProofD{
+		C:          decodeInt(bp.C),
+		A:          decodeInt(bp.A),
+		EResponse:  decodeInt(bp.EResponse),
+		VResponse:  decodeInt(bp.VResponse),
+		AResponses: decodeIntMap(bp.AResponses),
+		ADisclosed: decodeIntMap(bp.ADisclosed),
+	}
+	if nonrev := bp.NonRevocation; nonrev != nil {
+		proof.NonRevocationProof = &revocation.Proof{
+			Cr:        decodeInt(nonrev.Cr),
+			Cu:        decodeInt(nonrev.Cu),
+			Responses: map[string]*big.Int{},
+			SignedAccumulator: &revocation.SignedAccumulator{
+				Data:      nonrev.Acc,
+				PKCounter: nonrev.PKCounter,
+			},
+		}
+		for key, response := range nonrev.Responses {
+			proof.NonRevocationProof.Responses[key] = decodeInt(response)
+		}
+	}
+	return proof
+}
+
+func encodeInt(i *big.Int) []byte {
+	if i == nil {
+		return nil
+	}
+	return i.Bytes()
+}
+
+func decodeInt(bts []byte) *big.Int {
+	if bts == nil {
+		return nil
+	}
+	return new(big.Int).SetBytes(bts)
+}
+
+func encodeIntMap(m map[int]*big.Int) map[int][]byte {
+	encoded := make(map[int][]byte, len(m))
+	for key, i := range m {
+		encoded[key] = encodeInt(i)
+	}
+	return encoded
+}
+
+func decodeIntMap(m map[int][]byte) map[int]*big.Int {
+	decoded := make(map[int]*big.Int, len(m))
+	for key, bts := range m {
+		decoded[key] = decodeInt(bts)
+	}
+	return decoded
+}
@@ -0,0 +1,354 @@
+package irma
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/pem"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/gabi/signed"
+	"github.com/privacybydesign/irmago/internal/common"
+)
+
+// RequestorScheme describes a requestor scheme: a signed list of known requestors (i.e., verifiers
+// and issuers), allowing IRMA apps to show a verified name and logo of the requestor of a session
+// instead of just its hostname. Like scheme managers, requestor schemes reside in a subfolder of
+// irma_configuration containing description.xml, requestors.xml, timestamp, index, index.sig and pk.pem.
+type RequestorScheme struct {
+	ID          string           `xml:"Id"`
+	Name        TranslatedString `xml:"Name"`
+	URL         string           `xml:"Url"`
+	Contact     string           `xml:"contact"`
+	Demo        bool             `xml:"Demo"`
+	Description TranslatedString
+	XMLVersion  int      `xml:"version,attr"`
+	XMLName     xml.Name `xml:"RequestorScheme"`
+
+	Status SchemeManagerStatus `xml:"-"`
+	Valid  bool                `xml:"-"` // true iff Status == SchemeManagerStatusValid
+
+	Timestamp Timestamp `xml:"-"`
+
+	Requestors []*RequestorInfo `xml:"-"`
+
+	index SchemeManagerIndex
+}
+
+// RequestorInfo describes a single requestor within a requestor scheme.
+type RequestorInfo struct {
+	Scheme RequestorSchemeIdentifier `xml:"-"`
+
+	Name      TranslatedString `xml:"Name"`
+	Hostnames []string         `xml:"Hostname"`
+	// Logo is the filename of the requestor's logo within the logos folder of the requestor scheme
+	Logo string `xml:"Logo,omitempty"`
+	// Attributes that the requestor is allowed to request or issue. An entry consisting of a
+	// credential type identifier allows all attributes of that credential type.
+	Attributes []AttributeTypeIdentifier `xml:"Attributes>Attribute"`
+	// ProximityKey is the PEM-encoded ECDSA public key with which the requestor signs the requests
+	// of offline proximity sessions (see ProximityRequest), if it performs these
+	ProximityKey string `xml:"ProximityKey,omitempty"`
+}
+
+type requestorList struct {
+	XMLName    xml.Name         `xml:"Requestors"`
+	Requestors []*RequestorInfo `xml:"Requestor"`
+}
+
+// NewRequestorScheme returns a new, unprocessed requestor scheme with the specified ID.
+func NewRequestorScheme(name string) *RequestorScheme {
+	return &RequestorScheme{ID: name, Status: SchemeManagerStatusUnprocessed, Valid: false}
+}
+
+// Identifier returns the identifier of the requestor scheme.
+func (rs *RequestorScheme) Identifier() RequestorSchemeIdentifier {
+	return NewRequestorSchemeIdentifier(rs.ID)
+}
+
+// LogoPath returns the path to the logo of the requestor within the specified Configuration,
+// or the empty string if the requestor has no logo.
+func (ri *RequestorInfo) LogoPath(conf *Configuration) string {
+	if ri.Logo == "" {
+		return ""
+	}
+	return filepath.Join(conf.Path, ri.Scheme.String(), "logos", ri.Logo)
+}
+
+// ProximityPublicKey parses the ProximityKey of the requestor, returning nil if it has none.
+func (ri *RequestorInfo) ProximityPublicKey() (*ecdsa.PublicKey, error) {
+	if ri.ProximityKey == "" {
+		return nil, nil
+	}
+	block, _ := pem.Decode([]byte(strings.TrimSpace(ri.ProximityKey)))
+	if block == nil {
+		return nil, errors.New("proximity key is not PEM-encoded")
+	}
+	return signed.UnmarshalPublicKey(block.Bytes)
+}
+
+// Permits returns the attributes to be disclosed or issued in the specified session request
+// that are not listed for this requestor. If it returns an empty list, the request is permitted.
+func (ri *RequestorInfo) Permits(request SessionRequest) []AttributeTypeIdentifier {
+	allowed := map[AttributeTypeIdentifier]struct{}{}
+	for _, attr := range ri.Attributes {
+		allowed[attr] = struct{}{}
+	}
+
+	var unlisted []AttributeTypeIdentifier
+	for attr := range request.Identifiers().AttributeTypes {
+		cred := NewAttributeTypeIdentifier(attr.CredentialTypeIdentifier().String())
+		if _, ok := allowed[attr]; ok {
+			continue
+		}
+		if _, ok := allowed[cred]; ok {
+			continue
+		}
+		unlisted = append(unlisted, attr)
+	}
+	return unlisted
+}
+
+// RequestorInfo returns the verified requestor info of the specified hostname, as listed
+// by one of the valid requestor schemes, or nil if the hostname is not listed.
+func (conf *Configuration) RequestorInfo(hostname string) *RequestorInfo {
+	return conf.Requestors[strings.ToLower(hostname)]
+}
+
+// isRequestorSchemeFolder returns whether the description.xml in the specified folder
+// describes a requestor scheme, by inspecting its root element.
+func (conf *Configuration) isRequestorSchemeFolder(dir string) (bool, error) {
+	bts, err := conf.readFile(filepath.Join(dir, "description.xml"))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(bts))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return false, nil // leave reporting of malformed descriptions to the scheme parser
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local == "RequestorScheme", nil
+		}
+	}
+}
+
+// ParseRequestorSchemeFolder parses the requestor scheme in the specified folder, and if it
+// is valid, adds it and its requestors to the Configuration.
+func (conf *Configuration) ParseRequestorSchemeFolder(dir string, scheme *RequestorScheme) (err error) {
+	conf.RequestorSchemes[scheme.Identifier()] = scheme
+
+	defer func() {
+		if err != nil {
+			err = errors.WrapPrefix(err, "Error parsing requestor scheme "+scheme.ID, 0)
+		}
+	}()
+
+	name := filepath.Base(dir)
+	if err = conf.verifySignature(name); err != nil {
+		scheme.Status = SchemeManagerStatusInvalidSignature
+		return
+	}
+	if scheme.index, err = conf.parseIndex(name); err != nil {
+		scheme.Status = SchemeManagerStatusInvalidIndex
+		return
+	}
+	if err = conf.verifyIndexedFiles(scheme.index); err != nil {
+		scheme.Status = SchemeManagerStatusInvalidSignature
+		return
+	}
+
+	bts, found, err := conf.readAuthenticatedFile(scheme.index, name+"/description.xml")
+	if err == nil && !found {
+		err = errors.New("Requestor scheme description not found in index")
+	}
+	if err == nil {
+		err = xml.Unmarshal(bts, scheme)
+	}
+	if err != nil {
+		scheme.Status = SchemeManagerStatusParsingError
+		return
+	}
+	if scheme.ID != name {
+		scheme.Status = SchemeManagerStatusParsingError
+		return errors.Errorf("Folder must be called %s, not %s", scheme.ID, name)
+	}
+
+	ts, exists, err := conf.readTimestamp(filepath.Join(dir, "timestamp"))
+	if err == nil && !exists {
+		err = errors.New("Requestor scheme timestamp not found")
+	}
+	if err != nil {
+		scheme.Status = SchemeManagerStatusParsingError
+		return
+	}
+	scheme.Timestamp = *ts
+
+	var list requestorList
+	bts, found, err = conf.readAuthenticatedFile(scheme.index, name+"/requestors.xml")
+	if err == nil && found {
+		err = xml.Unmarshal(bts, &list)
+	}
+	if err != nil {
+		scheme.Status = SchemeManagerStatusContentParsingError
+		return
+	}
+	if err = conf.validateRequestors(scheme, list.Requestors); err != nil {
+		scheme.Status = SchemeManagerStatusContentParsingError
+		return
+	}
+
+	scheme.Requestors = list.Requestors
+	for _, requestor := range scheme.Requestors {
+		requestor.Scheme = scheme.Identifier()
+		for _, hostname := range requestor.Hostnames {
+			conf.Requestors[strings.ToLower(hostname)] = requestor
+		}
+	}
+	scheme.Status = SchemeManagerStatusValid
+	scheme.Valid = true
+	return
+}
+
+func (conf *Configuration) validateRequestors(scheme *RequestorScheme, requestors []*RequestorInfo) error {
+	for _, requestor := range requestors {
+		if len(requestor.Hostnames) == 0 {
+			return errors.Errorf("Requestor %s has no hostnames", requestor.Name["en"])
+		}
+		for _, hostname := range requestor.Hostnames {
+			if other, ok := conf.Requestors[strings.ToLower(hostname)]; ok && other.Scheme != scheme.Identifier() {
+				return errors.Errorf("Hostname %s already listed in requestor scheme %s", hostname, other.Scheme)
+			}
+		}
+		if _, err := requestor.ProximityPublicKey(); err != nil {
+			return errors.WrapPrefix(err, "Invalid proximity key of requestor "+requestor.Name["en"], 0)
+		}
+		if requestor.Logo != "" {
+			if _, ok := scheme.index[scheme.ID+"/logos/"+requestor.Logo]; !ok {
+				return errors.Errorf("Logo %s of requestor %s not present in index", requestor.Logo, requestor.Name["en"])
+			}
+		}
+	}
+	return nil
+}
+
+// DownloadRequestorScheme downloads and returns a requestor scheme description.xml file
+// from the specified URL, using the Transport option of this Configuration.
+func (conf *Configuration) DownloadRequestorScheme(url string) (*RequestorScheme, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		url = "https://" + url
+	}
+	url = strings.TrimSuffix(strings.TrimSuffix(url, "/"), "/description.xml")
+	b, err := conf.NewHTTPTransport(url, TransportPurposeScheme).GetBytes("description.xml")
+	if err != nil {
+		return nil, err
+	}
+	scheme := NewRequestorScheme("")
+	if err = xml.Unmarshal(b, scheme); err != nil {
+		return nil, err
+	}
+	scheme.URL = url
+	return scheme, nil
+}
+
+// InstallRequestorScheme downloads and adds the specified requestor scheme to this Configuration,
+// provided its signature is valid.
+func (conf *Configuration) InstallRequestorScheme(scheme *RequestorScheme, publickey []byte) error {
+	if conf.readOnly {
+		return errors.New("cannot install scheme into a read-only configuration")
+	}
+
+	name := scheme.ID
+	if err := common.EnsureDirectoryExists(filepath.Join(conf.Path, name)); err != nil {
+		return err
+	}
+	url := conf.mirrorURL(scheme.ID, scheme.URL)
+	t := conf.schemeTransport(url)
+	if publickey != nil {
+		if err := common.SaveFile(filepath.Join(conf.Path, name, "pk.pem"), publickey); err != nil {
+			return err
+		}
+	} else {
+		if err := conf.downloadFile(t, name, "pk.pem"); err != nil {
+			return err
+		}
+	}
+	if err := conf.downloadSchemeSignature(name, url); err != nil {
+		return err
+	}
+	if _, err := conf.updateSchemeFiles(name, url, nil, Timestamp{}, nil); err != nil {
+		return err
+	}
+	err := conf.ParseRequestorSchemeFolder(filepath.Join(conf.Path, name), NewRequestorScheme(name))
+	conf.republish()
+	return err
+}
+
+// UpdateRequestorScheme syncs the stored version of the specified requestor scheme with
+// the remote version, and re-parses it if it was updated.
+func (conf *Configuration) UpdateRequestorScheme(id RequestorSchemeIdentifier) error {
+	if conf.readOnly {
+		return errors.New("cannot update a read-only configuration")
+	}
+	scheme, contains := conf.RequestorSchemes[id]
+	if !contains {
+		return errors.Errorf("Cannot update unknown requestor scheme %s", id)
+	}
+
+	url := conf.mirrorURL(scheme.ID, scheme.URL)
+	updated, err := conf.updateSchemeStaged(scheme.ID, func(stage *Configuration) (bool, error) {
+		if err := stage.downloadSchemeSignature(scheme.ID, url); err != nil {
+			return false, err
+		}
+		updated, err := stage.updateSchemeFiles(scheme.ID, url, scheme.index, scheme.Timestamp, nil)
+		if err != nil || !updated {
+			return false, err
+		}
+		return true, stage.ParseRequestorSchemeFolder(filepath.Join(stage.Path, scheme.ID), NewRequestorScheme(scheme.ID))
+	})
+	if err != nil || !updated {
+		return err
+	}
+
+	// Replace the scheme in a copy of our state, which is published at once
+	parsed := conf.clone()
+	parsed.removeRequestorScheme(id)
+	if err = parsed.ParseRequestorSchemeFolder(filepath.Join(conf.Path, scheme.ID), NewRequestorScheme(scheme.ID)); err != nil {
+		return err
+	}
+	conf.publish(parsed)
+	return nil
+}
+
+// RemoveRequestorScheme removes the specified requestor scheme and its requestors from
+// this Configuration, and if fromStorage is true, also from storage.
+func (conf *Configuration) RemoveRequestorScheme(id RequestorSchemeIdentifier, fromStorage bool) error {
+	removed := conf.clone()
+	removed.removeRequestorScheme(id)
+	conf.publish(removed)
+	if fromStorage {
+		if conf.readOnly {
+			return errors.New("cannot remove scheme from a read-only configuration")
+		}
+		return os.RemoveAll(filepath.Join(conf.Path, id.String()))
+	}
+	return nil
+}
+
+func (conf *Configuration) removeRequestorScheme(id RequestorSchemeIdentifier) {
+	for hostname, requestor := range conf.Requestors {
+		if requestor.Scheme == id {
+			delete(conf.Requestors, hostname)
+		}
+	}
+	delete(conf.RequestorSchemes, id)
+	delete(conf.DisabledRequestorSchemes, id)
+}
@@ -1,10 +1,12 @@
 package irma
 
 import (
+	"crypto/rsa"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
 	"io/ioutil"
+	"net/url"
 	"strconv"
 	"time"
 
@@ -44,6 +46,19 @@ type BaseRequest struct {
 	Type   Action `json:"type,omitempty"` // Session type, only used in legacy code
 
 	ClientReturnURL string `json:"clientReturnUrl,omitempty"` // URL to proceed to when IRMA session is completed
+
+	// Purpose explains why the attributes are requested. Purposes of individual disjunctions,
+	// by index, may be specified in Purposes; Purpose applies to all other disjunctions.
+	Purpose  *DisclosurePurpose         `json:"purpose,omitempty"`
+	Purposes map[int]*DisclosurePurpose `json:"purposes,omitempty"`
+}
+
+// DisclosurePurpose explains to the user why attributes are requested, and what happens to them
+// after disclosure.
+type DisclosurePurpose struct {
+	Purpose          TranslatedString `json:"purpose"`
+	RetentionPeriod  int              `json:"retentionPeriod,omitempty"`  // Number of days the requestor retains the attributes
+	PrivacyPolicyURL string           `json:"privacyPolicyUrl,omitempty"` // URL of the requestor's privacy policy
 }
 
 // An AttributeCon is only satisfied if all of its containing attribute requests are satisfied.
@@ -117,9 +132,18 @@ type ServerJwt struct {
 // RequestorBaseRequest contains fields present in all RequestorRequest types
 // with which the requestor configures an IRMA session.
 type RequestorBaseRequest struct {
-	ResultJwtValidity int    `json:"validity,omitempty"`    // Validity of session result JWT in seconds
-	ClientTimeout     int    `json:"timeout,omitempty"`     // Wait this many seconds for the IRMA app to connect before the session times out
-	CallbackURL       string `json:"callbackUrl,omitempty"` // URL to post session result to
+	ResultJwtValidity int              `json:"validity,omitempty"`    // Validity of session result JWT in seconds
+	ClientTimeout     int              `json:"timeout,omitempty"`     // Wait this many seconds for the IRMA app to connect before the session times out
+	CallbackURL       string           `json:"callbackUrl,omitempty"` // URL to post session result to
+	NextSession       *NextSessionData `json:"nextSession,omitempty"` // Data about session to start after this one (if any)
+}
+
+// NextSessionData specifies how the IRMA server obtains the request of a follow-up session,
+// which the IRMA app performs directly after the current session has finished successfully.
+type NextSessionData struct {
+	// The IRMA server POSTs the session result (as a JWT if it has a JWT private key) to this URL,
+	// which must respond with the session request of the follow-up session.
+	URL string `json:"url"`
 }
 
 // RequestorRequest is the message with which requestors start an IRMA session. It contains a
@@ -171,6 +195,14 @@ type RevocationJwt struct {
 	Request *RevocationRequest `json:"revrequest"`
 }
 
+// SessionTemplateJwt is a requestor JWT that starts an instance of a session template
+// configured at the IRMA server, using the specified parameters.
+type SessionTemplateJwt struct {
+	ServerJwt
+	Template   string            `json:"template"`
+	Parameters map[string]string `json:"parameters"`
+}
+
 // A RequestorJwt contains an IRMA session object.
 type RequestorJwt interface {
 	Action() Action
@@ -536,6 +568,51 @@ func (dr *DisclosureRequest) Validate() error {
 			return err
 		}
 	}
+	return dr.validatePurposes()
+}
+
+// DisconPurpose returns the purpose of the i-th disjunction of the request, if any.
+func (dr *DisclosureRequest) DisconPurpose(i int) *DisclosurePurpose {
+	if p := dr.Purposes[i]; p != nil {
+		return p
+	}
+	return dr.Purpose
+}
+
+// HasPurposes returns whether the purpose of any of the disjunctions of the request is specified.
+func (dr *DisclosureRequest) HasPurposes() bool {
+	return dr.Purpose != nil || len(dr.Purposes) > 0
+}
+
+func (dr *DisclosureRequest) validatePurposes() error {
+	if dr.Purpose != nil {
+		if err := dr.Purpose.Validate(); err != nil {
+			return err
+		}
+	}
+	for i, p := range dr.Purposes {
+		if i < 0 || i >= len(dr.Disclose) {
+			return errors.Errorf("Purpose specified for nonexisting disjunction %d", i)
+		}
+		if err := p.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *DisclosurePurpose) Validate() error {
+	if p == nil || len(p.Purpose) == 0 {
+		return errors.New("Disclosure purpose is empty")
+	}
+	if p.RetentionPeriod < 0 {
+		return errors.New("Disclosure purpose has negative retention period")
+	}
+	if p.PrivacyPolicyURL != "" {
+		if _, err := url.ParseRequestURI(p.PrivacyPolicyURL); err != nil {
+			return errors.Errorf("Disclosure purpose has invalid privacy policy URL: %s", err.Error())
+		}
+	}
 	return nil
 }
 
@@ -579,6 +656,11 @@ func (cr *CredentialRequest) Validate(conf *Configuration) error {
 		if present && attrtype.RevocationAttribute {
 			return errors.New("revocation attribute cannot be set in credential request")
 		}
+		if present {
+			if err := attrtype.ValidateValue(cr.Attributes[attrtype.ID]); err != nil {
+				return errors.WrapPrefix(err, fmt.Sprintf("Invalid value of attribute %s in credential request", attrtype.ID), 0)
+			}
+		}
 	}
 
 	return nil
@@ -699,7 +781,7 @@ func (ir *IssuanceRequest) Validate() error {
 			return err
 		}
 	}
-	return nil
+	return ir.validatePurposes()
 }
 
 // GetNonce returns the nonce of this signature session
@@ -748,7 +830,7 @@ func (sr *SignatureRequest) Validate() error {
 			return err
 		}
 	}
-	return nil
+	return sr.validatePurposes()
 }
 
 // Check if Timestamp is before other Timestamp. Used for checking expiry of attributes
@@ -989,10 +1071,104 @@ func (claims *RevocationJwt) Valid() error {
 	return nil
 }
 
+func (claims *SessionTemplateJwt) Valid() error {
+	if claims.Type != "template_request" {
+		return errors.New("Session template jwt has invalid subject")
+	}
+	if time.Time(claims.IssuedAt).After(time.Now()) {
+		return errors.New("Session template jwt not yet valid")
+	}
+	return nil
+}
+
+// ConsentReceipt records for which purposes the user consented to disclosing attributes in a
+// session. The IRMA server signs it as a JWT which it includes in the session result and sends
+// to the client, so that both the requestor and the user keep matching records.
+type ConsentReceipt struct {
+	ServerJwt
+	Nonce    *big.Int   `json:"nonce"`
+	Consents []*Consent `json:"consents"`
+}
+
+// Consent records the purpose for which the attributes of a disjunction were disclosed.
+type Consent struct {
+	Attributes []AttributeTypeIdentifier `json:"attributes"`
+	Purpose    *DisclosurePurpose        `json:"purpose,omitempty"`
+}
+
+// NewConsentReceipt returns a consent receipt for the specified attributes disclosed in response
+// to the request, or nil if the request specifies no purposes or no attributes were disclosed.
+func NewConsentReceipt(serverName string, request SessionRequest, disclosed [][]*DisclosedAttribute) *ConsentReceipt {
+	dr := request.Disclosure()
+	if !dr.HasPurposes() {
+		return nil
+	}
+
+	var consents []*Consent
+	for i, attrs := range disclosed {
+		if len(attrs) == 0 {
+			continue
+		}
+		consent := &Consent{Purpose: dr.DisconPurpose(i)}
+		for _, attr := range attrs {
+			consent.Attributes = append(consent.Attributes, attr.Identifier)
+		}
+		consents = append(consents, consent)
+	}
+	if len(consents) == 0 {
+		return nil
+	}
+
+	return &ConsentReceipt{
+		ServerJwt: ServerJwt{
+			ServerName: serverName,
+			IssuedAt:   Timestamp(time.Now()),
+			Type:       "consent_receipt",
+		},
+		Nonce:    request.Base().Nonce,
+		Consents: consents,
+	}
+}
+
+// Sign returns the consent receipt as a JWT signed with the specified key.
+func (claims *ConsentReceipt) Sign(privatekey *rsa.PrivateKey) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(privatekey)
+}
+
+// ParseConsentReceipt verifies the consent receipt JWT against the public key of the IRMA server
+// that signed it, and parses it.
+func ParseConsentReceipt(receipt string, publickey *rsa.PublicKey) (*ConsentReceipt, error) {
+	claims := &ConsentReceipt{}
+	_, err := jwt.ParseWithClaims(receipt, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, errors.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return publickey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (claims *ConsentReceipt) Valid() error {
+	if claims.Type != "consent_receipt" {
+		return errors.New("Consent receipt jwt has invalid subject")
+	}
+	if time.Time(claims.IssuedAt).After(time.Now()) {
+		return errors.New("Consent receipt jwt not yet valid")
+	}
+	return nil
+}
+
 func (claims *RevocationJwt) Sign(method jwt.SigningMethod, key interface{}) (string, error) {
 	return jwt.NewWithClaims(method, claims).SignedString(key)
 }
 
+func (claims *SessionTemplateJwt) Sign(method jwt.SigningMethod, key interface{}) (string, error) {
+	return jwt.NewWithClaims(method, claims).SignedString(key)
+}
+
 func (claims *ServiceProviderJwt) Action() Action { return ActionDisclosing }
 
 func (claims *SignatureRequestorJwt) Action() Action { return ActionSigning }
@@ -38,6 +38,11 @@ type (
 		sqlMode  bool
 		settings RevocationSettings
 
+		replication *RevocationReplication
+		updatedLock sync.Mutex // protects the updated field of settings, and updatedRead
+		// In SQL replication mode, when the updated times were last read from the database
+		updatedRead map[CredentialTypeIdentifier]time.Time
+
 		Keys   RevocationKeys
 		client RevocationClient
 
@@ -51,7 +56,9 @@ type (
 	RevocationClient struct {
 		Conf     *Configuration
 		Settings RevocationSettings
-		http     *HTTPTransport
+		// If set, requests to revocation servers are aborted when this context is done
+		Context context.Context
+		http    *HTTPTransport
 	}
 
 	// RevocationKeys contains helper functions for retrieving revocation private and public keys
@@ -67,6 +74,9 @@ type (
 		RevocationServerURL string `json:"revocation_server_url,omitempty" mapstructure:"revocation_server_url"`
 		Tolerance           uint64 `json:"tolerance,omitempty" mapstructure:"tolerance"` // in seconds, min 30
 		SSE                 bool   `json:"sse,omitempty" mapstructure:"sse"`
+		// In authority mode, revocation updates are also periodically written into this folder, for
+		// hosting on a static web host whose URL is then prefixed by "static+" in RevocationServers
+		StaticPath string `json:"static_path,omitempty" mapstructure:"static_path"`
 
 		// set to now whenever a new update is received, or when the RA indicates
 		// there are no new updates. Thus it specifies up to what time our nonrevocation
@@ -158,6 +168,19 @@ var RevocationParameters = struct {
 	// Cache-control: max-age HTTP return header (in seconds)
 	EventsCacheMaxAge uint64
 
+	// After a failed request to a revocation server, RevocationClient does not use the server
+	// for this many milliseconds, doubling with each consecutive failure up to ServerBackoffMax
+	// (unless no other server is available).
+	ServerBackoffMin uint64
+	ServerBackoffMax uint64
+	// If a revocation server does not respond within this many milliseconds, RevocationClient
+	// also sends the request to the next (less healthy) server.
+	ServerFallbackDelay uint64
+
+	// In SQL revocation replication mode, up to when nonrevocation is guaranteed according to the
+	// other replicas is read from the database at most once every so many milliseconds.
+	ReplicationReadInterval uint64
+
 	UpdateMinCount      uint64
 	UpdateMaxCount      uint64
 	UpdateMinCountPower int
@@ -173,6 +196,10 @@ var RevocationParameters = struct {
 	UpdateMinCountPower:           4,
 	UpdateMaxCountPower:           9,
 	EventsCacheMaxAge:             60 * 60,
+	ServerBackoffMin:              1000,
+	ServerBackoffMax:              5 * 60 * 1000,
+	ServerFallbackDelay:           500,
+	ReplicationReadInterval:       1000,
 }
 
 func init() {
@@ -318,6 +345,19 @@ func (rs *RevocationStorage) AddUpdate(id CredentialTypeIdentifier, record *revo
 }
 
 func (rs *RevocationStorage) addUpdate(tx sqlRevStorage, id CredentialTypeIdentifier, update *revocation.Update, create bool) error {
+	if err := rs.storeUpdate(tx, id, update, create); err != nil {
+		return err
+	}
+
+	rs.bumpUpdated(id, time.Now())
+	// POST record to listeners, if any, asynchroniously
+	rs.PostUpdate(id, update)
+
+	return nil
+}
+
+// storeUpdate verifies the update and saves it to the database.
+func (rs *RevocationStorage) storeUpdate(tx sqlRevStorage, id CredentialTypeIdentifier, update *revocation.Update, create bool) error {
 	// Unmarshal and verify the record against the appropriate public key
 	pk, err := rs.Keys.PublicKey(id.IssuerIdentifier(), update.SignedAccumulator.PKCounter)
 	if err != nil {
@@ -337,6 +377,19 @@ func (rs *RevocationStorage) addUpdate(tx sqlRevStorage, id CredentialTypeIdenti
 			return err
 		}
 		for _, event := range update.Events {
+			// Replicas store the same events: in SQL replication mode in the shared database, and
+			// in peers replication mode both when fetching them and when receiving them from a peer
+			if rs.replication.mode() != RevocationReplicationNone {
+				exists, err := tx.Exists((*EventRecord)(nil), map[string]interface{}{
+					"cred_type": id, "pk_counter": update.SignedAccumulator.PKCounter, "eventindex": event.Index,
+				})
+				if err != nil {
+					return err
+				}
+				if exists {
+					continue
+				}
+			}
 			if err = tx.Insert(new(EventRecord).Convert(id, update.SignedAccumulator.PKCounter, event)); err != nil {
 				return err
 			}
@@ -345,11 +398,6 @@ func (rs *RevocationStorage) addUpdate(tx sqlRevStorage, id CredentialTypeIdenti
 		rs.memdb.Insert(id, update)
 	}
 
-	s := rs.settings.Get(id)
-	s.updated = time.Now()
-	// POST record to listeners, if any, asynchroniously
-	rs.PostUpdate(id, update)
-
 	return nil
 }
 
@@ -385,9 +433,16 @@ func (rs *RevocationStorage) Revoke(id CredentialTypeIdentifier, key string, iss
 	if !rs.settings.Get(id).Authority {
 		return errors.Errorf("cannot revoke %s", id)
 	}
-	return rs.sqldb.Transaction(func(tx sqlRevStorage) error {
+	if err := rs.sqldb.Transaction(func(tx sqlRevStorage) error {
 		return rs.revoke(tx, id, key, issued)
-	})
+	}); err != nil {
+		return err
+	}
+	// The credential is revoked, a failure here is fixed by the next scheduled write
+	if err := rs.writeStaticUpdates(id); err != nil {
+		Logger.Warn("failed to write static revocation updates: ", err)
+	}
+	return nil
 }
 
 func (rs *RevocationStorage) revoke(tx sqlRevStorage, id CredentialTypeIdentifier, key string, issued time.Time) error {
@@ -576,8 +631,7 @@ func (rs *RevocationStorage) updateAccumulatorTimes() error {
 				return err
 			}
 
-			s := rs.settings.Get(r.CredType)
-			s.updated = time.Now()
+			rs.bumpUpdated(r.CredType, time.Now())
 			// POST record to listeners, if any, asynchroniously
 			rs.PostUpdate(r.CredType, &revocation.Update{SignedAccumulator: sacc})
 		}
@@ -607,12 +661,14 @@ func (rs *RevocationStorage) SyncDB(id CredentialTypeIdentifier) error {
 		}
 	}
 	// bump updated even if no new records were added
-	rs.settings.Get(id).updated = time.Now()
+	now := time.Now()
+	rs.bumpUpdated(id, now)
+	rs.replicate(id, updates, now)
 	return nil
 }
 
 func (rs *RevocationStorage) SyncIfOld(id CredentialTypeIdentifier, maxage uint64) error {
-	if rs.settings.Get(id).updated.Before(time.Now().Add(time.Duration(-maxage) * time.Second)) {
+	if rs.updatedTime(id).Before(time.Now().Add(time.Duration(-maxage) * time.Second)) {
 		if err := rs.SyncDB(id); err != nil {
 			return err
 		}
@@ -641,6 +697,9 @@ func (rs *RevocationStorage) SaveIssuanceRecord(id CredentialTypeIdentifier, rec
 	if settings.RevocationServerURL == "" {
 		return errors.New("cannot send issuance record: no server_url configured")
 	}
+	if _, static := staticRevocationServer(settings.RevocationServerURL); static {
+		return errors.New("cannot send issuance record to static revocation server")
+	}
 	rsk, err := sk.RevocationKey()
 	if err != nil {
 		return err
@@ -670,6 +729,8 @@ func (rs *RevocationStorage) receiveUpdates() {
 				Logger.WithField("credtype", id).Trace("received SSE update event")
 				if err = rs.AddUpdate(id, &update); err != nil {
 					Logger.Warn("failed to add pushed update: ", err)
+				} else {
+					rs.replicate(id, map[uint]*revocation.Update{update.SignedAccumulator.PKCounter: &update}, time.Now())
 				}
 			}
 		case <-rs.close:
@@ -713,7 +774,7 @@ func updateURL(id CredentialTypeIdentifier, conf *Configuration, rs RevocationSe
 	}
 }
 
-func (rs *RevocationStorage) Load(debug bool, dbtype, connstr string, settings RevocationSettings) error {
+func (rs *RevocationStorage) Load(debug bool, dbtype, connstr string, settings RevocationSettings, replication *RevocationReplication) error {
 	var t *CredentialTypeIdentifier
 	for id, s := range settings {
 		if !s.Authority {
@@ -726,6 +787,9 @@ func (rs *RevocationStorage) Load(debug bool, dbtype, connstr string, settings R
 				return errors.Errorf("revocation authority mode for %s cannot be combined with URL", id.String())
 			}
 		}
+		if s.StaticPath != "" && !s.Authority {
+			return errors.Errorf("static_path for %s requires revocation authority mode", id.String())
+		}
 		if s.Server {
 			t = &id
 		}
@@ -739,6 +803,9 @@ func (rs *RevocationStorage) Load(debug bool, dbtype, connstr string, settings R
 				rs.events = make(chan *sseclient.Event)
 				go rs.receiveUpdates()
 			}
+			if _, static := staticRevocationServer(urls[0]); static {
+				return errors.Errorf("revocation server of %s is static and does not support SSE", id.String())
+			}
 			url := fmt.Sprintf("%s/revocation/%s/updateevents", urls[0], id.String())
 			go rs.listenUpdates(id, url)
 		}
@@ -752,6 +819,15 @@ func (rs *RevocationStorage) Load(debug bool, dbtype, connstr string, settings R
 			err = errors.WrapPrefix(err, "failed to write updated accumulator record", 0)
 			raven.CaptureError(err, nil)
 		}
+		for id, s := range rs.settings {
+			if !s.Authority || s.StaticPath == "" {
+				continue
+			}
+			if err := rs.writeStaticUpdates(id); err != nil {
+				err = errors.WrapPrefix(err, "failed to write static revocation updates of "+id.String(), 0)
+				raven.CaptureError(err, nil)
+			}
+		}
 	})
 
 	rs.conf.Scheduler.Every(RevocationParameters.DeleteIssuanceRecordsInterval).Minutes().Do(func() {
@@ -777,6 +853,10 @@ func (rs *RevocationStorage) Load(debug bool, dbtype, connstr string, settings R
 		rs.sqldb = db
 		rs.sqlMode = true
 	}
+	if err := replication.validate(rs.sqlMode); err != nil {
+		return err
+	}
+	rs.replication = replication
 	if settings != nil {
 		rs.settings = settings
 	} else {
@@ -800,6 +880,14 @@ func (rs *RevocationStorage) Close() error {
 	return rs.sqldb.Close()
 }
 
+// Ping checks that the revocation database is reachable, if one is configured.
+func (rs *RevocationStorage) Ping() error {
+	if !rs.sqlMode {
+		return nil
+	}
+	return rs.sqldb.Ping()
+}
+
 // SetRevocationUpdates retrieves the latest revocation records from the database, and attaches
 // them to the request, for each credential type for which a nonrevocation proof is requested in
 // b.Revocation.
@@ -822,7 +910,7 @@ func (rs *RevocationStorage) SetRevocationUpdates(b *BaseRequest) error {
 			tolerance = params.Tolerance
 		}
 		if err = rs.SyncIfOld(credid, tolerance/2); err != nil {
-			updated := settings.updated
+			updated := rs.updatedTime(credid)
 			if !updated.IsZero() {
 				Logger.Warnf("failed to fetch revocation updates for %s, nonrevocation is guaranteed only until %s ago:",
 					credid, time.Now().Sub(updated).String())
@@ -843,8 +931,10 @@ func (rs *RevocationStorage) SetRevocationUpdates(b *BaseRequest) error {
 	return nil
 }
 
+// PostUpdate sends the update to the SSE subscribers, if we host the revocation endpoints of the
+// credential type as its authority or as a (replicated) revocation server.
 func (rs *RevocationStorage) PostUpdate(id CredentialTypeIdentifier, update *revocation.Update) {
-	if rs.ServerSentEvents == nil || !rs.settings.Get(id).Authority {
+	if settings := rs.settings.Get(id); rs.ServerSentEvents == nil || !(settings.Authority || settings.Server) {
 		return
 	}
 	Logger.WithField("credtype", id).Tracef("sending SSE update event")
@@ -889,13 +979,18 @@ func (client RevocationClient) FetchUpdateFrom(id CredentialTypeIdentifier, pkco
 	for _, i := range indices {
 		wg.Add(1)
 		go func(i [2]uint64) {
-			events := &revocation.EventList{ComputeProduct: true}
-			if e := client.getMultiple(
+			var events *revocation.EventList
+			if result, e := client.getMultiple(
+				id,
 				client.Conf.CredentialTypes[id].RevocationServers,
 				fmt.Sprintf("/revocation/%s/events/%d/%d/%d", id, pkcounter, i[0], i[1]),
-				events,
+				"",
+				func() interface{} { return &revocation.EventList{ComputeProduct: true} },
+				nil,
 			); e != nil {
 				err = e
+			} else {
+				events = result.(*revocation.EventList)
 			}
 			eventsChan <- events
 			wg.Done()
@@ -930,12 +1025,20 @@ func (client RevocationClient) FetchUpdateLatest(id CredentialTypeIdentifier, pk
 	if err != nil {
 		return nil, err
 	}
-	update := &revocation.Update{}
-	return update, client.getMultiple(
+	update, err := client.getMultiple(
+		id,
 		urls,
 		fmt.Sprintf("/revocation/%s/update/%d/%d", id, count, pkcounter),
-		&update,
+		"",
+		func() interface{} { return &revocation.Update{} },
+		func(update interface{}) (map[uint]*revocation.Accumulator, error) {
+			return client.verifyUpdates(id, map[uint]*revocation.Update{pkcounter: update.(*revocation.Update)})
+		},
 	)
+	if err != nil {
+		return nil, err
+	}
+	return update.(*revocation.Update), nil
 }
 
 func (client RevocationClient) FetchUpdatesLatest(id CredentialTypeIdentifier, count uint64) (map[uint]*revocation.Update, error) {
@@ -943,34 +1046,156 @@ func (client RevocationClient) FetchUpdatesLatest(id CredentialTypeIdentifier, c
 	if err != nil {
 		return nil, err
 	}
-	update := map[uint]*revocation.Update{}
-	return update, client.getMultiple(
+	updates, err := client.getMultiple(
+		id,
 		urls,
 		fmt.Sprintf("/revocation/%s/update/%d", id, count),
-		&update,
+		fmt.Sprintf("/revocation/%s/update/%d/all", id, count),
+		func() interface{} { return &map[uint]*revocation.Update{} },
+		func(updates interface{}) (map[uint]*revocation.Accumulator, error) {
+			return client.verifyUpdates(id, *updates.(*map[uint]*revocation.Update))
+		},
 	)
+	if err != nil {
+		return nil, err
+	}
+	return *updates.(*map[uint]*revocation.Update), nil
 }
 
-func (client RevocationClient) getMultiple(urls []string, path string, dest interface{}) error {
-	var (
-		errs      multierror.Error
-		transport = client.transport()
-	)
-	for _, url := range urls {
-		transport.Server = url
-		err := transport.Get(path, dest)
-		if err == nil {
-			return nil
-		} else {
-			errs.Errors = append(errs.Errors, err)
+// getMultiple GETs the specified path from the specified revocation servers of the specified
+// credential type, and returns the first successful response, parsed into a new instance
+// returned by dest. Static revocation servers are sent staticPath instead, if not empty.
+// The servers that are not backing off after failed requests are tried in order of their health:
+// each next server is requested when the previous one fails, or does not respond within
+// RevocationParameters.ServerFallbackDelay. Once one server responds, the requests to the others
+// are aborted, and the servers requested before it count as failed.
+//
+// If accumulators is not nil, it must verify the response and return the accumulators that it
+// contains. If these differ from those that another server previously served, all servers are
+// requested, and the response agreeing with the majority of the servers is returned (see
+// revocationServers.majority()).
+func (client RevocationClient) getMultiple(
+	id CredentialTypeIdentifier, urls []string, path, staticPath string, dest func() interface{},
+	accumulators func(interface{}) (map[uint]*revocation.Accumulator, error),
+) (interface{}, error) {
+	type response struct {
+		index  int
+		result interface{}
+		servedAccumulators
+		err error
+	}
+
+	ctx := client.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // abort the requests that are still running once we are done
+
+	servers := client.servers()
+	available := servers.available(urls)
+	transports := make([]*HTTPTransport, len(available))
+	for i, url := range available {
+		transports[i] = client.transport().WithContext(ctx)
+		transports[i].Server, _ = staticRevocationServer(url)
+	}
+	delay := time.Duration(RevocationParameters.ServerFallbackDelay) * time.Millisecond
+	responses := make(chan response, len(available))
+	pending := map[int]struct{}{}
+	var fallback <-chan time.Time
+	next := 0
+	startNext := func() {
+		index, url, transport, path := next, available[next], transports[next], path
+		if _, static := staticRevocationServer(url); static && staticPath != "" {
+			path = staticPath
+		}
+		go func() {
+			r := response{index: index, result: dest(), servedAccumulators: servedAccumulators{url: url}}
+			r.err = transport.Get(path, r.result)
+			if r.err == nil && accumulators != nil {
+				r.accs, r.err = accumulators(r.result)
+			}
+			if ctx.Err() == nil { // don't count aborted requests as failures
+				servers.record(url, r.err)
+			}
+			responses <- r
+		}()
+		pending[index] = struct{}{}
+		next++
+		fallback = nil
+		if next < len(available) {
+			fallback = time.After(delay)
+		}
+	}
+
+	var errs multierror.Error
+	var contested []response // successful responses, once one of them contradicted another server
+	startNext()
+	for len(pending) > 0 {
+		select {
+		case r := <-responses:
+			delete(pending, r.index)
+			if r.err != nil {
+				errs.Errors = append(errs.Errors, r.err)
+				if next < len(available) {
+					startNext()
+				}
+				continue
+			}
+			if contested != nil {
+				contested = append(contested, r)
+				continue
+			}
+			if accumulators != nil && !servers.consistent(id, r.servedAccumulators) {
+				contested = []response{r}
+				for next < len(available) {
+					startNext()
+				}
+				fallback = nil
+				continue
+			}
+			cancel()
+			for index := range pending {
+				if index < r.index {
+					servers.record(available[index], errors.Errorf("no response within %s", delay))
+				}
+			}
+			if accumulators != nil {
+				servers.accept(id, r.servedAccumulators)
+			}
+			return r.result, nil
+		case <-fallback:
+			startNext()
 		}
 	}
-	return &errs
+
+	if contested == nil {
+		return nil, &errs
+	}
+	served := make([]servedAccumulators, len(contested))
+	for i, r := range contested {
+		served[i] = r.servedAccumulators
+	}
+	i, err := servers.majority(id, served)
+	if err != nil {
+		return nil, err
+	}
+	return contested[i].result, nil
+}
+
+func (client RevocationClient) servers() *revocationServers {
+	if client.Conf.revocationServers == nil {
+		return newRevocationServers() // not created by NewConfiguration(), don't keep track
+	}
+	return client.Conf.revocationServers
 }
 
 func (client RevocationClient) transport() *HTTPTransport {
 	if client.http == nil {
-		client.http = NewHTTPTransport("")
+		client.http = client.Conf.NewHTTPTransport("", TransportPurposeRevocation)
+		if client.Context != nil {
+			client.http = client.http.WithContext(client.Context)
+		}
 		client.http.Binary = true
 	}
 	return client.http
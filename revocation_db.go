@@ -54,6 +54,9 @@ func newSqlStorage(debug bool, dbtype, connstr string) (sqlRevStorage, error) {
 	if g.AutoMigrate((*IssuanceRecord)(nil)); g.Error != nil {
 		return sqlRevStorage{}, g.Error
 	}
+	if g.AutoMigrate((*UpdatedRecord)(nil)); g.Error != nil {
+		return sqlRevStorage{}, g.Error
+	}
 
 	return sqlRevStorage{gorm: g}, nil
 }
@@ -66,6 +69,13 @@ func (s sqlRevStorage) Close() error {
 	return s.gorm.Close()
 }
 
+func (s sqlRevStorage) Ping() error {
+	if s.gorm == nil {
+		return nil
+	}
+	return s.gorm.DB().Ping()
+}
+
 func (s sqlRevStorage) Transaction(f func(tx sqlRevStorage) error) (err error) {
 	tx := sqlRevStorage{gorm: s.gorm.Begin()}
 	defer func() {
@@ -0,0 +1,211 @@
+package irma
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/gabi/revocation"
+)
+
+type (
+	// RevocationReplicationMode specifies how replicas of an IRMA server share revocation state.
+	RevocationReplicationMode string
+
+	// RevocationReplication configures how replicas of an IRMA server share the revocation updates
+	// that they fetch or receive, so that they agree on up to when nonrevocation is guaranteed
+	// for each credential type, and therefore on the tolerance of sessions.
+	RevocationReplication struct {
+		Mode RevocationReplicationMode `json:"mode,omitempty" mapstructure:"mode"`
+		// In peers mode, the URLs of the other replicas at which their revocation endpoints are
+		// hosted, i.e. the URL that the IRMA app connects to
+		Peers []string `json:"peers,omitempty" mapstructure:"peers"`
+		// In peers mode, the secret with which the replicas authenticate to each other
+		Secret string `json:"secret,omitempty" mapstructure:"secret"`
+	}
+
+	// RevocationReplicationMessage is sent by a replica to its peers after fetching or receiving
+	// revocation updates.
+	RevocationReplicationMessage struct {
+		Updates map[uint]*revocation.Update `json:"updates,omitempty"`
+		// Unix time in nanoseconds up to which the updates guarantee nonrevocation
+		Updated int64 `json:"updated"`
+	}
+
+	// UpdatedRecord contains up to when nonrevocation is guaranteed for a credential type by the
+	// revocation updates in the database, in SQL replication mode.
+	UpdatedRecord struct {
+		CredType CredentialTypeIdentifier `gorm:"primary_key"`
+		Updated  int64
+	}
+)
+
+const (
+	// Replicas don't share revocation state
+	RevocationReplicationNone RevocationReplicationMode = ""
+	// Replicas share the revocation database, in which they also store when they last fetched
+	// or received revocation updates
+	RevocationReplicationSQL RevocationReplicationMode = "sql"
+	// Replicas send the revocation updates that they fetch or receive to their peers, for
+	// replicas that each have their own (memory) revocation database
+	RevocationReplicationPeers RevocationReplicationMode = "peers"
+)
+
+func (r *RevocationReplication) mode() RevocationReplicationMode {
+	if r == nil {
+		return RevocationReplicationNone
+	}
+	return r.Mode
+}
+
+func (r *RevocationReplication) validate(sqlMode bool) error {
+	switch r.mode() {
+	case RevocationReplicationNone:
+		return nil
+	case RevocationReplicationSQL:
+		if !sqlMode {
+			return errors.New("sql revocation replication requires a revocation database")
+		}
+		return nil
+	case RevocationReplicationPeers:
+		if len(r.Peers) == 0 {
+			return errors.New("peers revocation replication requires peers to be configured")
+		}
+		if r.Secret == "" {
+			return errors.New("peers revocation replication requires a secret")
+		}
+		return nil
+	default:
+		return errors.Errorf("unknown revocation replication mode %s", r.Mode)
+	}
+}
+
+// updatedTime returns up to when nonrevocation is guaranteed for the specified credential type by
+// the revocation updates that we or, in SQL replication mode, other replicas have received.
+func (rs *RevocationStorage) updatedTime(id CredentialTypeIdentifier) time.Time {
+	if rs.replication.mode() == RevocationReplicationSQL && rs.readUpdatedDue(id) {
+		var records []*UpdatedRecord
+		if err := rs.sqldb.Find(&records, map[string]interface{}{"cred_type": id}); err != nil {
+			Logger.Warn("failed to read revocation update time from database: ", err)
+		} else if len(records) > 0 {
+			rs.bumpUpdated(id, time.Unix(0, records[0].Updated))
+		}
+	}
+
+	rs.updatedLock.Lock()
+	defer rs.updatedLock.Unlock()
+	return rs.settings.Get(id).updated
+}
+
+// readUpdatedDue returns whether up to when nonrevocation is guaranteed for the specified
+// credential type should be read from the database, as it was not read recently. If so, it
+// registers that it is being read now.
+func (rs *RevocationStorage) readUpdatedDue(id CredentialTypeIdentifier) bool {
+	rs.updatedLock.Lock()
+	defer rs.updatedLock.Unlock()
+	now := time.Now()
+	interval := time.Duration(RevocationParameters.ReplicationReadInterval) * time.Millisecond
+	if now.Sub(rs.updatedRead[id]) < interval {
+		return false
+	}
+	if rs.updatedRead == nil {
+		rs.updatedRead = map[CredentialTypeIdentifier]time.Time{}
+	}
+	rs.updatedRead[id] = now
+	return true
+}
+
+// bumpUpdated sets up to when nonrevocation is guaranteed for the specified credential type,
+// unless it already was guaranteed up to a later time.
+func (rs *RevocationStorage) bumpUpdated(id CredentialTypeIdentifier, updated time.Time) {
+	rs.updatedLock.Lock()
+	defer rs.updatedLock.Unlock()
+	if s := rs.settings.Get(id); updated.After(s.updated) {
+		s.updated = updated
+	}
+}
+
+// replicate shares the specified updates, fetched or received at the specified time, with the
+// other replicas.
+func (rs *RevocationStorage) replicate(id CredentialTypeIdentifier, updates map[uint]*revocation.Update, updated time.Time) {
+	switch rs.replication.mode() {
+	case RevocationReplicationSQL:
+		// The updates themselves are already in the shared database
+		err := rs.sqldb.Transaction(func(tx sqlRevStorage) error {
+			var records []*UpdatedRecord
+			if err := tx.Find(&records, map[string]interface{}{"cred_type": id}); err != nil {
+				return err
+			}
+			if len(records) > 0 && records[0].Updated >= updated.UnixNano() {
+				return nil
+			}
+			return tx.Save(&UpdatedRecord{CredType: id, Updated: updated.UnixNano()})
+		})
+		if err != nil {
+			Logger.Warn("failed to write revocation update time to database: ", err)
+		}
+
+	case RevocationReplicationPeers:
+		msg := &RevocationReplicationMessage{Updates: updates, Updated: updated.UnixNano()}
+		transports := make([]*HTTPTransport, len(rs.replication.Peers))
+		for i, peer := range rs.replication.Peers {
+			transports[i] = rs.conf.NewHTTPTransport(peer, TransportPurposeRevocation)
+			transports[i].Binary = true
+			transports[i].SetHeader("Authorization", rs.replication.Secret)
+		}
+		for _, transport := range transports {
+			go func(transport *HTTPTransport) {
+				err := transport.Post(fmt.Sprintf("revocation/%s/replicate", id), nil, msg)
+				if err != nil {
+					Logger.Warnf("failed to send revocation updates to replica %s: %s", transport.Server, err)
+				}
+			}(transport)
+		}
+	}
+}
+
+// AuthorizeReplicationPeer returns whether the specified secret, sent by another replica along with
+// a RevocationReplicationMessage, is the one configured in peers replication mode.
+func (rs *RevocationStorage) AuthorizeReplicationPeer(secret string) bool {
+	return rs.replication.mode() == RevocationReplicationPeers &&
+		subtle.ConstantTimeCompare([]byte(secret), []byte(rs.replication.Secret)) == 1
+}
+
+// ReceiveReplication adds the revocation updates sent by another replica, after which nonrevocation
+// is guaranteed up to the time that the replica fetched or received them. The updates are not sent
+// on to other replicas, as all replicas send their updates to all of their peers.
+func (rs *RevocationStorage) ReceiveReplication(id CredentialTypeIdentifier, msg *RevocationReplicationMessage) error {
+	if rs.replication.mode() != RevocationReplicationPeers {
+		return errors.New("revocation replication not enabled")
+	}
+	if settings := rs.settings.Get(id); settings.Authority {
+		return errors.Errorf("cannot receive revocation updates for %s as revocation authority", id)
+	}
+
+	updated := time.Unix(0, msg.Updated)
+	if now := time.Now(); updated.After(now) {
+		updated = now // don't trust the clock of the replica beyond our own
+	}
+
+	// Unlike AddUpdate(), don't consider the updates to be received now, as the replica received
+	// them earlier; updated is bumped only once they have all been stored
+	for _, update := range msg.Updates {
+		var err error
+		if rs.sqlMode {
+			err = rs.sqldb.Transaction(func(tx sqlRevStorage) error {
+				return rs.storeUpdate(tx, id, update, false)
+			})
+		} else {
+			err = rs.storeUpdate(rs.sqldb, id, update, false)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	rs.bumpUpdated(id, updated)
+	for _, update := range msg.Updates {
+		rs.PostUpdate(id, update)
+	}
+	return nil
+}
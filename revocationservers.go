@@ -0,0 +1,268 @@
+package irma
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/gabi/revocation"
+)
+
+// RevocationServerStatus describes the health of a revocation server, as observed by the
+// RevocationClient when fetching revocation updates from it.
+type RevocationServerStatus struct {
+	URL string `json:"url"`
+	// Whether the last request to the server succeeded
+	Healthy bool `json:"healthy"`
+	// Number of consecutive failed requests
+	Failures int `json:"failures,omitempty"`
+	// Error of the last failed request
+	LastError string `json:"last_error,omitempty"`
+	// Time of the last successful request
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	// Until this time the server is not used, unless no other server is available
+	RetryAfter *time.Time `json:"retry_after,omitempty"`
+	// Last detected difference between the accumulators served by this server and another one
+	Inconsistency string `json:"inconsistency,omitempty"`
+}
+
+// revocationServers keeps track of the health of revocation servers, and of the accumulators
+// that they last served per credential type.
+type revocationServers struct {
+	sync.Mutex
+	servers      map[string]*RevocationServerStatus
+	accumulators map[CredentialTypeIdentifier]map[string]map[uint]*revocation.Accumulator
+}
+
+func newRevocationServers() *revocationServers {
+	return &revocationServers{
+		servers:      map[string]*RevocationServerStatus{},
+		accumulators: map[CredentialTypeIdentifier]map[string]map[uint]*revocation.Accumulator{},
+	}
+}
+
+func (rs *revocationServers) get(url string) *RevocationServerStatus {
+	status := rs.servers[url]
+	if status == nil {
+		status = &RevocationServerStatus{URL: url, Healthy: true}
+		rs.servers[url] = status
+	}
+	return status
+}
+
+// available returns the specified servers that are not backing off after failed requests, or
+// all of them if there are none, sorted by their number of consecutive failures and otherwise
+// in the specified order.
+func (rs *revocationServers) available(urls []string) []string {
+	rs.Lock()
+	defer rs.Unlock()
+
+	now := time.Now()
+	var available []string
+	for _, url := range urls {
+		status := rs.servers[url]
+		if status == nil || status.RetryAfter == nil || !now.Before(*status.RetryAfter) {
+			available = append(available, url)
+		}
+	}
+	if len(available) == 0 {
+		available = append(available, urls...)
+	}
+	failures := func(url string) int {
+		if status := rs.servers[url]; status != nil {
+			return status.Failures
+		}
+		return 0
+	}
+	sort.SliceStable(available, func(i, j int) bool { return failures(available[i]) < failures(available[j]) })
+	return available
+}
+
+// record updates the health of the server after a request to it. Only transport errors, server
+// errors and invalid responses count as failures.
+func (rs *revocationServers) record(url string, err error) {
+	rs.Lock()
+	defer rs.Unlock()
+
+	status := rs.get(url)
+	if serr, ok := err.(*SessionError); ok && serr.ErrorType == ErrorApi && serr.RemoteStatus < 500 {
+		err = nil // the server works, it just could not handle this request
+	}
+	now := time.Now()
+	if err == nil {
+		status.Healthy = true
+		status.Failures = 0
+		status.LastSuccess = &now
+		status.RetryAfter = nil
+		return
+	}
+
+	rs.recordFailure(status, err)
+}
+
+// recordFailure counts a failed request to the server, after which it backs off.
+func (rs *revocationServers) recordFailure(status *RevocationServerStatus, err error) {
+	now := time.Now()
+	status.Healthy = false
+	status.Failures++
+	status.LastError = err.Error()
+	backoff := time.Duration(RevocationParameters.ServerBackoffMin) * time.Millisecond
+	max := time.Duration(RevocationParameters.ServerBackoffMax) * time.Millisecond
+	for i := 1; i < status.Failures && backoff < max; i++ {
+		backoff *= 2
+	}
+	if backoff > max {
+		backoff = max
+	}
+	retry := now.Add(backoff)
+	status.RetryAfter = &retry
+}
+
+// servedAccumulators are the accumulators of a credential type, per public key counter, that a
+// revocation server served.
+type servedAccumulators struct {
+	url  string
+	accs map[uint]*revocation.Accumulator
+}
+
+// consistent returns whether the specified accumulators are consistent with those that the other
+// servers last served, recording an inconsistency for the server otherwise.
+func (rs *revocationServers) consistent(id CredentialTypeIdentifier, served servedAccumulators) bool {
+	rs.Lock()
+	defer rs.Unlock()
+
+	for other, accs := range rs.accumulators[id] {
+		if other == served.url {
+			continue
+		}
+		if err := consistentAccumulators(served.accs, accs); err != nil {
+			Logger.Warnf("revocation server %s inconsistent with %s: %s", served.url, other, err)
+			rs.get(served.url).Inconsistency = errors.WrapPrefix(err, "compared to "+other, 0).Error()
+			return false
+		}
+	}
+	return true
+}
+
+// accept remembers the specified accumulators for comparison with those of other servers, and
+// clears any earlier inconsistency of the server.
+func (rs *revocationServers) accept(id CredentialTypeIdentifier, served servedAccumulators) {
+	rs.Lock()
+	defer rs.Unlock()
+
+	if rs.accumulators[id] == nil {
+		rs.accumulators[id] = map[string]map[uint]*revocation.Accumulator{}
+	}
+	rs.accumulators[id][served.url] = served.accs
+	rs.get(served.url).Inconsistency = ""
+}
+
+// majority resolves an inconsistency between revocation servers: of the specified accumulators,
+// just served by the servers that responded, it returns the index of those that agree with the
+// majority of all servers, including the accumulators last served by servers that did not respond.
+// Servers disagreeing with the majority are rejected: they count as failed, and their accumulators
+// are forgotten. If there is no majority, an error is returned.
+func (rs *revocationServers) majority(id CredentialTypeIdentifier, served []servedAccumulators) (int, error) {
+	rs.Lock()
+	defer rs.Unlock()
+
+	votes := map[string]map[uint]*revocation.Accumulator{}
+	for url, accs := range rs.accumulators[id] {
+		votes[url] = accs
+	}
+	for _, s := range served {
+		votes[s.url] = s.accs
+	}
+	best, count := -1, 0
+	for i, s := range served {
+		c := 0
+		for _, accs := range votes {
+			if consistentAccumulators(s.accs, accs) == nil {
+				c++
+			}
+		}
+		if c > count {
+			best, count = i, c
+		}
+	}
+	if 2*count <= len(votes) {
+		return 0, errors.Errorf("no majority among %d revocation servers of %s agrees on its accumulators", len(votes), id)
+	}
+
+	if rs.accumulators[id] == nil {
+		rs.accumulators[id] = map[string]map[uint]*revocation.Accumulator{}
+	}
+	for url, accs := range votes {
+		err := consistentAccumulators(accs, served[best].accs)
+		if err == nil {
+			rs.accumulators[id][url] = accs
+			rs.get(url).Inconsistency = ""
+			continue
+		}
+		Logger.Warnf("revocation server %s disagrees with the majority: %s", url, err)
+		delete(rs.accumulators[id], url)
+		status := rs.get(url)
+		status.Inconsistency = errors.WrapPrefix(err, "compared to majority", 0).Error()
+		rs.recordFailure(status, err)
+	}
+	return best, nil
+}
+
+func (rs *revocationServers) status() []RevocationServerStatus {
+	rs.Lock()
+	defer rs.Unlock()
+
+	statuses := make([]RevocationServerStatus, 0, len(rs.servers))
+	for _, status := range rs.servers {
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].URL < statuses[j].URL })
+	return statuses
+}
+
+// RevocationServerStatus returns the health of the revocation servers from which revocation
+// updates have been fetched using this Configuration, sorted by URL.
+func (conf *Configuration) RevocationServerStatus() []RevocationServerStatus {
+	if conf.revocationServers == nil {
+		return nil
+	}
+	return conf.revocationServers.status()
+}
+
+// verifyUpdates verifies the signed accumulators of the specified updates, returning the
+// accumulators per public key counter.
+func (client RevocationClient) verifyUpdates(id CredentialTypeIdentifier, updates map[uint]*revocation.Update) (map[uint]*revocation.Accumulator, error) {
+	accs := make(map[uint]*revocation.Accumulator, len(updates))
+	for counter, update := range updates {
+		if update == nil || update.SignedAccumulator == nil {
+			return nil, errors.Errorf("revocation update of %s-%d contains no accumulator", id, counter)
+		}
+		pk, err := RevocationKeys{client.Conf}.PublicKey(id.IssuerIdentifier(), counter)
+		if err != nil {
+			return nil, err
+		}
+		if accs[counter], err = update.SignedAccumulator.UnmarshalVerify(pk); err != nil {
+			return nil, err
+		}
+	}
+	return accs, nil
+}
+
+// consistentAccumulators checks that accumulators from two revocation servers of the same index
+// are equal. Accumulators of different indices are not compared, as one of the servers may
+// simply not have processed the latest revocations yet, nor are their timestamps, which the
+// revocation authority updates periodically.
+func consistentAccumulators(a, b map[uint]*revocation.Accumulator) error {
+	for counter, acca := range a {
+		accb := b[counter]
+		if accb == nil || acca.Index != accb.Index {
+			continue
+		}
+		if acca.Nu.Cmp(accb.Nu) != 0 || !bytes.Equal(acca.EventHash, accb.EventHash) {
+			return errors.Errorf("different accumulators of index %d for key counter %d", acca.Index, counter)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package irma
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/gabi/revocation"
+	"github.com/privacybydesign/irmago/internal/common"
+)
+
+// A revocation authority can write its revocation updates as static files into a folder (the
+// static_path revocation setting), so that they can be served by any static web host or CDN
+// instead of by the IRMA server. Revocation server URLs starting with "static+" point to such
+// a host. The static files mirror the GET endpoints of the IRMA server, except that only the
+// update event count of the credential type is available, and all accumulators are in a file
+// named "all":
+//
+//   revocation/<id>/update/<count>/all               all latest accumulators and events
+//   revocation/<id>/update/<count>/<counter>         latest accumulator and events of a key
+//   revocation/<id>/events/<counter>/<from>/<to>     events as requested by FetchUpdateFrom()
+
+const revocationStaticPrefix = "static+"
+
+// staticRevocationServer returns the URL of the static revocation server, if the specified
+// revocation server URL points to one.
+func staticRevocationServer(url string) (string, bool) {
+	if !strings.HasPrefix(url, revocationStaticPrefix) {
+		return url, false
+	}
+	return strings.TrimPrefix(url, revocationStaticPrefix), true
+}
+
+// writeStaticUpdates writes the latest accumulators and all events of the specified credential
+// type into its static_path, if configured.
+func (rs *RevocationStorage) writeStaticUpdates(id CredentialTypeIdentifier) error {
+	dir := rs.settings.Get(id).StaticPath
+	if dir == "" {
+		return nil
+	}
+	ct := rs.conf.CredentialTypes[id]
+	if ct == nil {
+		return ErrorUnknownCredentialType
+	}
+	updates, err := rs.UpdateLatest(id, ct.RevocationUpdateCount, nil)
+	if err != nil {
+		return err
+	}
+	return writeStaticRevocationUpdates(dir, id, ct.RevocationUpdateCount, updates,
+		func(counter uint, from, to uint64) (*revocation.EventList, error) {
+			return rs.Events(id, counter, from, to)
+		},
+	)
+}
+
+// writeStaticRevocationUpdates writes the specified (verified) updates containing the latest
+// count events to dir, along with all event intervals that FetchUpdateFrom() may request,
+// retrieved using events. As events never change, existing event files are not rewritten.
+func writeStaticRevocationUpdates(
+	dir string, id CredentialTypeIdentifier, count uint64, updates map[uint]*revocation.Update,
+	events func(counter uint, from, to uint64) (*revocation.EventList, error),
+) error {
+	for counter, update := range updates {
+		acc := update.SignedAccumulator.Accumulator
+		if acc == nil {
+			return errors.Errorf("cannot write unverified revocation update of %s-%d", id, counter)
+		}
+
+		// Only intervals of which all events exist are written, as the IRMA server also refuses
+		// requests for events that do not exist yet
+		for pow := RevocationParameters.UpdateMinCountPower; pow <= RevocationParameters.UpdateMaxCountPower; pow++ {
+			size := uint64(1) << pow
+			for from := uint64(0); from+size <= acc.Index+1; from += size {
+				file := filepath.Join(dir, "revocation", id.String(), "events",
+					fmt.Sprint(counter), fmt.Sprint(from), fmt.Sprint(from+size))
+				exists, err := common.PathExists(file)
+				if err != nil {
+					return err
+				}
+				if exists {
+					continue
+				}
+				list, err := events(counter, from, from+size)
+				if err != nil {
+					return errors.WrapPrefix(err, fmt.Sprintf("failed to get events %d-%d", from, from+size), 0)
+				}
+				if err = saveStaticRevocationFile(file, list); err != nil {
+					return err
+				}
+			}
+		}
+
+		file := filepath.Join(dir, "revocation", id.String(), "update", fmt.Sprint(count), fmt.Sprint(counter))
+		if err := saveStaticRevocationFile(file, update); err != nil {
+			return err
+		}
+	}
+
+	// Written last, so that the events preceding its updates are available once it is
+	return saveStaticRevocationFile(filepath.Join(dir, "revocation", id.String(), "update", fmt.Sprint(count), "all"), updates)
+}
+
+func saveStaticRevocationFile(file string, o interface{}) error {
+	bts, err := MarshalBinary(o)
+	if err != nil {
+		return err
+	}
+	if err = common.EnsureDirectoryExists(filepath.Dir(file)); err != nil {
+		return err
+	}
+	return common.SaveFile(file, bts)
+}
@@ -0,0 +1,268 @@
+package irma
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/irmago/internal/common"
+)
+
+// A scheme bundle is a gzipped tar archive containing a signed scheme, by which schemes can be
+// installed and updated on machines that cannot reach the scheme URL. All entries are prefixed
+// by the name of the scheme, as in an irma_configuration folder.
+
+var (
+	// Maximum size in bytes of a file in a scheme bundle, and of all files in a scheme bundle
+	// together, so that extracting a bundle cannot exhaust memory or disk space
+	schemeBundleMaxFileSize int64 = 16 << 20
+	schemeBundleMaxSize     int64 = 256 << 20
+)
+
+// BundleScheme writes a scheme bundle of the specified scheme to w, containing its index, index
+// signature, public key, and all files listed in its index (including its timestamp). If
+// privateKeys is true, the scheme private key and issuer private keys present in the scheme are
+// included as well, which is only allowed for demo schemes.
+func (conf *Configuration) BundleScheme(id SchemeManagerIdentifier, w io.Writer, privateKeys bool) error {
+	scheme, ok := conf.SchemeManagers[id]
+	if !ok {
+		return errors.Errorf("Cannot bundle unknown scheme %s", id)
+	}
+	if scheme.Status != SchemeManagerStatusValid {
+		return errors.Errorf("Cannot bundle scheme %s with status %s", id, scheme.Status)
+	}
+
+	dir := filepath.Join(conf.Path, id.Name())
+	files := []string{"index", "index.sig", "pk.pem"}
+	for filename := range scheme.index {
+		files = append(files, strings.TrimPrefix(filename, id.Name()+"/"))
+	}
+	if privateKeys {
+		if !scheme.Demo {
+			return errors.Errorf("Cannot bundle private keys of non-demo scheme %s", id)
+		}
+		var keys []string
+		err := conf.iterateSubfolders(dir, func(issuerdir string, _ os.FileInfo) error {
+			files, err := conf.glob(filepath.Join(issuerdir, "PrivateKeys", "*"))
+			keys = append(keys, files...)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if exists, err := conf.pathExists(filepath.Join(dir, "sk.pem")); err != nil {
+			return err
+		} else if exists {
+			keys = append(keys, filepath.Join(dir, "sk.pem"))
+		}
+		for _, key := range keys {
+			files = append(files, strings.TrimPrefix(conf.fsPath(key), id.Name()+"/"))
+		}
+	}
+	sort.Strings(files)
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, file := range files {
+		bts, err := conf.readFile(filepath.Join(dir, filepath.FromSlash(file)))
+		if err != nil {
+			return err
+		}
+		err = tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     id.Name() + "/" + file,
+			Mode:     0600,
+			Size:     int64(len(bts)),
+		})
+		if err != nil {
+			return err
+		}
+		if _, err = tw.Write(bts); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// InstallSchemeBundle installs the scheme in the specified scheme bundle (see BundleScheme()), or
+// updates it if it is already installed, subject to the same checks as when installing or updating
+// it from its URL: the bundled index must be validly signed by the public key of the installed
+// scheme, or when installing, by the specified public key (or if nil, the bundled one); its
+// timestamp must be newer than that of the installed scheme; and all files must match the index.
+// When updating, the Configuration is reparsed and scheme update listeners are notified.
+func (conf *Configuration) InstallSchemeBundle(bundle io.Reader, publickey []byte) error {
+	if conf.readOnly {
+		return errors.New("cannot install scheme into a read-only configuration")
+	}
+
+	if err := common.EnsureDirectoryExists(filepath.Join(conf.Path, schemeStagingFolder)); err != nil {
+		return err
+	}
+	dir, err := ioutil.TempDir(filepath.Join(conf.Path, schemeStagingFolder), "bundle")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			Logger.Warn("Failed to remove extracted scheme bundle: ", err)
+		}
+	}()
+	extracted := filepath.Join(dir, "bundle")
+	name, err := extractSchemeBundle(bundle, extracted)
+	if err != nil {
+		return err
+	}
+
+	id := NewSchemeManagerIdentifier(name)
+	if _, installed := conf.SchemeManagers[id]; installed {
+		return conf.updateSchemeFromBundle(id, extracted)
+	}
+	return conf.installSchemeFromBundle(name, extracted, filepath.Join(dir, "install"), publickey)
+}
+
+func (conf *Configuration) updateSchemeFromBundle(id SchemeManagerIdentifier, extracted string) error {
+	old := conf.Snapshot()
+	downloaded := newIrmaIdentifierSet()
+	updated, err := conf.updateScheme(id, downloaded, extracted)
+	if err != nil {
+		conf.notifySchemeUpdates(old, nil, map[SchemeManagerIdentifier]error{id: err})
+		return err
+	}
+	if !updated {
+		Logger.WithField("scheme", id).Info("Scheme bundle is not newer than installed scheme")
+		return nil
+	}
+	if err = conf.ParseFolder(); err != nil {
+		return err
+	}
+	conf.notifySchemeUpdates(old, downloaded.SchemeManagers, nil)
+	return nil
+}
+
+// installSchemeFromBundle installs the extracted scheme bundle in a separate Configuration in the
+// specified staging folder, and moves the scheme into conf once it has been installed and verified.
+func (conf *Configuration) installSchemeFromBundle(name, extracted, staging string, publickey []byte) error {
+	if err := common.AssertPathNotExists(filepath.Join(conf.Path, name)); err != nil {
+		return errors.Errorf("Cannot install scheme %s: scheme folder already exists", name)
+	}
+	bts, err := ioutil.ReadFile(filepath.Join(extracted, name, "description.xml"))
+	if err != nil {
+		return errors.WrapPrefix(err, "Scheme bundle has no scheme description", 0)
+	}
+	manager := NewSchemeManager("")
+	if err = xml.Unmarshal(bts, manager); err != nil {
+		return err
+	}
+	if manager.ID != name {
+		return errors.Errorf("Scheme bundle contains scheme %s in folder %s", manager.ID, name)
+	}
+
+	if err = common.EnsureDirectoryExists(staging); err != nil {
+		return err
+	}
+	stage, err := NewConfiguration(staging, ConfigurationOptions{})
+	if err != nil {
+		return err
+	}
+	stage.bundle = extracted
+	if err = stage.InstallSchemeManager(manager, publickey); err != nil {
+		return err
+	}
+
+	path := filepath.Join(conf.Path, name)
+	if err = os.Rename(filepath.Join(staging, name), path); err != nil {
+		return err
+	}
+	err = conf.ParseSchemeManagerFolder(path, NewSchemeManager(name))
+	conf.republish()
+	return err
+}
+
+// extractSchemeBundle extracts the specified scheme bundle into the specified folder, and returns
+// the name of the scheme that it contains.
+func extractSchemeBundle(bundle io.Reader, dest string) (string, error) {
+	gz, err := gzip.NewReader(bundle)
+	if err != nil {
+		return "", errors.WrapPrefix(err, "Failed to read scheme bundle", 0)
+	}
+	defer gz.Close()
+
+	var name string
+	var size int64
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", errors.WrapPrefix(err, "Failed to read scheme bundle", 0)
+		}
+		if header.Typeflag == tar.TypeDir {
+			continue
+		}
+		if header.Typeflag != tar.TypeReg {
+			return "", errors.Errorf("Scheme bundle entry %s is not a regular file", header.Name)
+		}
+
+		// Only accept files within a single scheme folder
+		filename := path.Clean(header.Name)
+		parts := strings.Split(filename, "/")
+		if path.IsAbs(filename) || len(parts) < 2 || strings.HasPrefix(parts[0], ".") {
+			return "", errors.Errorf("Scheme bundle entry %s is not within a scheme folder", header.Name)
+		}
+		if name == "" {
+			name = parts[0]
+		} else if parts[0] != name {
+			return "", errors.New("Scheme bundle contains more than one scheme")
+		}
+
+		if header.Size > schemeBundleMaxFileSize {
+			return "", errors.Errorf("Scheme bundle entry %s exceeds maximum size of %d bytes", header.Name, schemeBundleMaxFileSize)
+		}
+		if size += header.Size; size > schemeBundleMaxSize {
+			return "", errors.Errorf("Scheme bundle exceeds maximum size of %d bytes", schemeBundleMaxSize)
+		}
+		bts, err := ioutil.ReadAll(io.LimitReader(tr, header.Size))
+		if err != nil {
+			return "", errors.WrapPrefix(err, "Failed to read scheme bundle", 0)
+		}
+		file := filepath.Join(dest, filepath.FromSlash(filename))
+		if err = common.EnsureDirectoryExists(filepath.Dir(file)); err != nil {
+			return "", err
+		}
+		if err = common.SaveFile(file, bts); err != nil {
+			return "", err
+		}
+	}
+
+	if name == "" {
+		return "", errors.New("Scheme bundle is empty")
+	}
+	return name, nil
+}
+
+// schemeTransport returns a transport for downloading scheme files from the specified URL. If a
+// scheme bundle is being installed, the transport reads files from the extracted bundle instead,
+// at the URLs returned by mirrorURL().
+func (conf *Configuration) schemeTransport(url string) *HTTPTransport {
+	transport := conf.NewHTTPTransport(url, TransportPurposeScheme)
+	if conf.bundle != "" {
+		inner := &http.Transport{}
+		inner.RegisterProtocol("file", http.NewFileTransport(http.Dir(conf.bundle)))
+		transport.client.HTTPClient.Transport = inner
+	}
+	return transport
+}
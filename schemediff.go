@@ -0,0 +1,338 @@
+package irma
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-errors/errors"
+)
+
+// SchemeChangeKind is the kind of a SchemeChange.
+type SchemeChangeKind string
+
+// SchemeChange describes a single difference between two versions of a scheme: an added or removed
+// issuer, credential type, attribute or public key, or a modified field of one of these
+// or of the scheme itself. Changes to translated fields are reported per language,
+// e.g. with Field "Name[en]".
+type SchemeChange struct {
+	Kind  SchemeChangeKind `json:"kind"`
+	Type  string           `json:"type"`
+	ID    string           `json:"id"`
+	Field string           `json:"field,omitempty"`
+	Old   string           `json:"old,omitempty"`
+	New   string           `json:"new,omitempty"`
+}
+
+// SchemeDiff contains the changes between two versions of a scheme.
+type SchemeDiff struct {
+	Scheme  SchemeManagerIdentifier `json:"scheme"`
+	Changes []SchemeChange          `json:"changes"`
+}
+
+const (
+	SchemeChangeAdded    = SchemeChangeKind("added")
+	SchemeChangeRemoved  = SchemeChangeKind("removed")
+	SchemeChangeModified = SchemeChangeKind("modified")
+)
+
+// String returns a human readable, single line description of the change.
+func (c SchemeChange) String() string {
+	switch c.Kind {
+	case SchemeChangeAdded:
+		return fmt.Sprintf("+ %s %s", c.Type, c.ID)
+	case SchemeChangeRemoved:
+		return fmt.Sprintf("- %s %s", c.Type, c.ID)
+	default:
+		return fmt.Sprintf("~ %s %s: %s %q -> %q", c.Type, c.ID, c.Field, c.Old, c.New)
+	}
+}
+
+// Empty returns true if the two scheme versions are equal.
+func (d *SchemeDiff) Empty() bool {
+	return len(d.Changes) == 0
+}
+
+// DiffSchemes compares the specified scheme as parsed in the old and new configurations,
+// reporting added, removed and modified issuers, credential types, attributes and public keys,
+// and modified scheme, issuer, credential type and attribute fields including translations,
+// logos and revocation settings.
+func DiffSchemes(old, new *Configuration, id SchemeManagerIdentifier) (*SchemeDiff, error) {
+	return diffSchemes(old, new, id, true)
+}
+
+// diffSchemes compares the specified scheme as parsed in the old and new configurations. If
+// parseKeys is false, public keys are compared by the hashes of their files in the scheme indices
+// instead of by parsing them, e.g. for two snapshots of one Configuration, which read public keys
+// from the same folder.
+func diffSchemes(old, new *Configuration, id SchemeManagerIdentifier, parseKeys bool) (*SchemeDiff, error) {
+	oldscheme, newscheme := old.SchemeManagers[id], new.SchemeManagers[id]
+	if oldscheme == nil || newscheme == nil {
+		return nil, errors.Errorf("scheme %s not present in both configurations", id)
+	}
+
+	d := &SchemeDiff{Scheme: id}
+	d.diffScheme(oldscheme, newscheme)
+
+	for _, issid := range unionIssuers(old, new, id) {
+		oldiss, newiss := old.Issuers[issid], new.Issuers[issid]
+		switch {
+		case oldiss == nil:
+			d.add(SchemeChangeAdded, "issuer", issid.String())
+		case newiss == nil:
+			d.add(SchemeChangeRemoved, "issuer", issid.String())
+		default:
+			d.diffIssuer(oldiss, newiss)
+			d.logo("issuer", issid.String(), oldscheme, newscheme,
+				path.Join(id.String(), issid.Name(), "logo.png"))
+		}
+		if !parseKeys {
+			d.diffPublicKeyFiles(oldscheme, newscheme, issid)
+		} else if err := d.diffPublicKeys(old, new, issid); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, credid := range unionCredentialTypes(old, new, id) {
+		oldcred, newcred := old.CredentialTypes[credid], new.CredentialTypes[credid]
+		switch {
+		case oldcred == nil:
+			d.add(SchemeChangeAdded, "credentialtype", credid.String())
+		case newcred == nil:
+			d.add(SchemeChangeRemoved, "credentialtype", credid.String())
+		default:
+			d.diffCredentialType(oldcred, newcred)
+			d.logo("credentialtype", credid.String(), oldscheme, newscheme,
+				path.Join(id.String(), credid.IssuerIdentifier().Name(), "Issues", credid.Name(), "logo.png"))
+		}
+	}
+
+	return d, nil
+}
+
+func (d *SchemeDiff) add(kind SchemeChangeKind, typ, id string) {
+	d.Changes = append(d.Changes, SchemeChange{Kind: kind, Type: typ, ID: id})
+}
+
+func (d *SchemeDiff) field(typ, id, field, old, new string) {
+	if old != new {
+		d.Changes = append(d.Changes, SchemeChange{
+			Kind: SchemeChangeModified, Type: typ, ID: id, Field: field, Old: old, New: new,
+		})
+	}
+}
+
+func (d *SchemeDiff) translations(typ, id, field string, old, new TranslatedString) {
+	langs := map[string]struct{}{}
+	for lang := range old {
+		langs[lang] = struct{}{}
+	}
+	for lang := range new {
+		langs[lang] = struct{}{}
+	}
+	sorted := make([]string, 0, len(langs))
+	for lang := range langs {
+		sorted = append(sorted, lang)
+	}
+	sort.Strings(sorted)
+	for _, lang := range sorted {
+		d.field(typ, id, fmt.Sprintf("%s[%s]", field, lang), old[lang], new[lang])
+	}
+}
+
+// logo compares the hashes of the logo at the specified path in the indices of both schemes.
+func (d *SchemeDiff) logo(typ, id string, old, new *SchemeManager, path string) {
+	d.field(typ, id, "Logo", old.index[path].String(), new.index[path].String())
+}
+
+func (d *SchemeDiff) diffScheme(old, new *SchemeManager) {
+	id := old.ID
+	d.translations("scheme", id, "Name", old.Name, new.Name)
+	d.translations("scheme", id, "Description", old.Description, new.Description)
+	d.field("scheme", id, "URL", old.URL, new.URL)
+	d.field("scheme", id, "Contact", old.Contact, new.Contact)
+	d.field("scheme", id, "Demo", strconv.FormatBool(old.Demo), strconv.FormatBool(new.Demo))
+	d.field("scheme", id, "MinimumAppVersion.Android",
+		strconv.Itoa(old.MinimumAppVersion.Android), strconv.Itoa(new.MinimumAppVersion.Android))
+	d.field("scheme", id, "MinimumAppVersion.iOS",
+		strconv.Itoa(old.MinimumAppVersion.IOS), strconv.Itoa(new.MinimumAppVersion.IOS))
+	d.field("scheme", id, "KeyshareServer", old.KeyshareServer, new.KeyshareServer)
+	d.field("scheme", id, "KeyshareWebsite", old.KeyshareWebsite, new.KeyshareWebsite)
+	d.field("scheme", id, "KeyshareAttribute", old.KeyshareAttribute, new.KeyshareAttribute)
+	d.field("scheme", id, "TimestampServer", old.TimestampServer, new.TimestampServer)
+}
+
+func (d *SchemeDiff) diffIssuer(old, new *Issuer) {
+	id := old.Identifier().String()
+	d.translations("issuer", id, "Name", old.Name, new.Name)
+	d.translations("issuer", id, "ShortName", old.ShortName, new.ShortName)
+	d.field("issuer", id, "ContactAddress", old.ContactAddress, new.ContactAddress)
+	d.field("issuer", id, "ContactEMail", old.ContactEMail, new.ContactEMail)
+	d.field("issuer", id, "DeprecatedSince", diffTimestamp(old.DeprecatedSince), diffTimestamp(new.DeprecatedSince))
+}
+
+func (d *SchemeDiff) diffCredentialType(old, new *CredentialType) {
+	id := old.Identifier().String()
+	d.translations("credentialtype", id, "Name", old.Name, new.Name)
+	d.translations("credentialtype", id, "ShortName", old.ShortName, new.ShortName)
+	d.translations("credentialtype", id, "Description", old.Description, new.Description)
+	d.translations("credentialtype", id, "IssueURL", old.IssueURL, new.IssueURL)
+	d.field("credentialtype", id, "ShouldBeSingleton", strconv.FormatBool(old.IsSingleton), strconv.FormatBool(new.IsSingleton))
+	d.field("credentialtype", id, "DisallowDelete", strconv.FormatBool(old.DisallowDelete), strconv.FormatBool(new.DisallowDelete))
+	d.field("credentialtype", id, "RevocationServers",
+		strings.Join(old.RevocationServers, ", "), strings.Join(new.RevocationServers, ", "))
+	d.field("credentialtype", id, "RevocationUpdateCount",
+		strconv.FormatUint(old.RevocationUpdateCount, 10), strconv.FormatUint(new.RevocationUpdateCount, 10))
+	d.field("credentialtype", id, "RevocationUpdateSpeed",
+		strconv.FormatUint(old.RevocationUpdateSpeed, 10), strconv.FormatUint(new.RevocationUpdateSpeed, 10))
+
+	oldattrs := map[string]*AttributeType{}
+	var names []string
+	for _, attr := range old.AttributeTypes {
+		oldattrs[attr.ID] = attr
+		names = append(names, attr.ID)
+	}
+	newattrs := map[string]*AttributeType{}
+	for _, attr := range new.AttributeTypes {
+		newattrs[attr.ID] = attr
+		if oldattrs[attr.ID] == nil {
+			names = append(names, attr.ID)
+		}
+	}
+	for _, name := range names {
+		oldattr, newattr := oldattrs[name], newattrs[name]
+		attrid := id + "." + name
+		switch {
+		case oldattr == nil:
+			d.add(SchemeChangeAdded, "attribute", attrid)
+		case newattr == nil:
+			d.add(SchemeChangeRemoved, "attribute", attrid)
+		default:
+			d.diffAttributeType(attrid, oldattr, newattr)
+		}
+	}
+}
+
+func (d *SchemeDiff) diffAttributeType(id string, old, new *AttributeType) {
+	d.translations("attribute", id, "Name", old.Name, new.Name)
+	d.translations("attribute", id, "Description", old.Description, new.Description)
+	d.field("attribute", id, "Index", strconv.Itoa(old.Index), strconv.Itoa(new.Index))
+	d.field("attribute", id, "Optional", strconv.FormatBool(old.IsOptional()), strconv.FormatBool(new.IsOptional()))
+	d.field("attribute", id, "DisplayIndex", diffIntPointer(old.DisplayIndex), diffIntPointer(new.DisplayIndex))
+	d.field("attribute", id, "RevocationAttribute",
+		strconv.FormatBool(old.RevocationAttribute), strconv.FormatBool(new.RevocationAttribute))
+	d.field("attribute", id, "Type", string(old.Type), string(new.Type))
+	d.field("attribute", id, "Pattern", old.Pattern, new.Pattern)
+	d.field("attribute", id, "MaxLength", strconv.Itoa(old.MaxLength), strconv.Itoa(new.MaxLength))
+	d.field("attribute", id, "Values", strings.Join(old.Values, ", "), strings.Join(new.Values, ", "))
+}
+
+func (d *SchemeDiff) diffPublicKeys(old, new *Configuration, issid IssuerIdentifier) error {
+	oldindices, err := old.PublicKeyIndices(issid)
+	if err != nil {
+		return err
+	}
+	newindices, err := new.PublicKeyIndices(issid)
+	if err != nil {
+		return err
+	}
+	for _, i := range unionset(oldindices, newindices) {
+		oldpk, err := old.PublicKey(issid, i)
+		if err != nil {
+			return err
+		}
+		newpk, err := new.PublicKey(issid, i)
+		if err != nil {
+			return err
+		}
+		id := fmt.Sprintf("%s-%d", issid, i)
+		switch {
+		case oldpk == nil && newpk == nil:
+		case oldpk == nil:
+			d.add(SchemeChangeAdded, "publickey", id)
+		case newpk == nil:
+			d.add(SchemeChangeRemoved, "publickey", id)
+		default:
+			d.field("publickey", id, "ExpiryDate",
+				time.Unix(oldpk.ExpiryDate, 0).UTC().Format(time.RFC3339),
+				time.Unix(newpk.ExpiryDate, 0).UTC().Format(time.RFC3339))
+			d.field("publickey", id, "N", oldpk.N.String(), newpk.N.String())
+			d.field("publickey", id, "RevocationSupported",
+				strconv.FormatBool(oldpk.RevocationSupported()), strconv.FormatBool(newpk.RevocationSupported()))
+		}
+	}
+	return nil
+}
+
+func (d *SchemeDiff) diffPublicKeyFiles(old, new *SchemeManager, issid IssuerIdentifier) {
+	oldkeys, newkeys := publicKeyFiles(old, issid), publicKeyFiles(new, issid)
+	var oldindices, newindices []uint
+	for i := range oldkeys {
+		oldindices = append(oldindices, i)
+	}
+	for i := range newkeys {
+		newindices = append(newindices, i)
+	}
+	for _, i := range unionset(oldindices, newindices) {
+		id := fmt.Sprintf("%s-%d", issid, i)
+		switch {
+		case oldkeys[i] == nil:
+			d.add(SchemeChangeAdded, "publickey", id)
+		case newkeys[i] == nil:
+			d.add(SchemeChangeRemoved, "publickey", id)
+		default:
+			d.field("publickey", id, "Hash", oldkeys[i].String(), newkeys[i].String())
+		}
+	}
+}
+
+func diffTimestamp(t Timestamp) string {
+	if t.IsZero() {
+		return ""
+	}
+	return time.Time(t).UTC().Format(time.RFC3339)
+}
+
+func diffIntPointer(i *int) string {
+	if i == nil {
+		return ""
+	}
+	return strconv.Itoa(*i)
+}
+
+func unionIssuers(old, new *Configuration, scheme SchemeManagerIdentifier) []IssuerIdentifier {
+	m := map[IssuerIdentifier]struct{}{}
+	for _, conf := range []*Configuration{old, new} {
+		for id := range conf.Issuers {
+			if id.SchemeManagerIdentifier() == scheme {
+				m[id] = struct{}{}
+			}
+		}
+	}
+	ids := make([]IssuerIdentifier, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+	return ids
+}
+
+func unionCredentialTypes(old, new *Configuration, scheme SchemeManagerIdentifier) []CredentialTypeIdentifier {
+	m := map[CredentialTypeIdentifier]struct{}{}
+	for _, conf := range []*Configuration{old, new} {
+		for id := range conf.CredentialTypes {
+			if id.IssuerIdentifier().SchemeManagerIdentifier() == scheme {
+				m[id] = struct{}{}
+			}
+		}
+	}
+	ids := make([]CredentialTypeIdentifier, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+	return ids
+}
@@ -0,0 +1,132 @@
+package irma
+
+import (
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SchemeUpdateEvent describes the outcome of updating a scheme, as passed to the listeners
+// registered with Configuration.SubscribeSchemeUpdates().
+type SchemeUpdateEvent struct {
+	Scheme SchemeManagerIdentifier
+
+	// Changes in the scheme made by the update: added, removed and modified issuers, credential
+	// types, attributes and public keys. Public keys are compared by the hashes of their files.
+	// Nil if the update failed.
+	Diff *SchemeDiff
+	// Counters of the public keys that the update added, per issuer
+	NewPublicKeys map[IssuerIdentifier][]uint
+
+	// Err is set if the update failed: if the new version of the scheme could not be downloaded or
+	// verified, in which case the scheme is unchanged, or if it could not be parsed, in which case
+	// Err is the *SchemeManagerError with which the scheme was disabled.
+	Err error
+}
+
+// SubscribeSchemeUpdates registers a listener that is called with an event for each scheme that is
+// changed by, or that fails to update in, UpdateSchemes() (and so by AutoUpdateSchemes()) and
+// Download(). The listener is called after the updated schemes have been parsed, from the
+// goroutine doing the update, so it should return quickly. The returned function unsubscribes
+// the listener.
+func (conf *Configuration) SubscribeSchemeUpdates(listener func(event *SchemeUpdateEvent)) (unsubscribe func()) {
+	conf.updateListenersLock.Lock()
+	defer conf.updateListenersLock.Unlock()
+
+	if conf.updateListeners == nil {
+		conf.updateListeners = map[int]func(*SchemeUpdateEvent){}
+	}
+	i := conf.updateListenerCount
+	conf.updateListenerCount++
+	conf.updateListeners[i] = listener
+
+	return func() {
+		conf.updateListenersLock.Lock()
+		defer conf.updateListenersLock.Unlock()
+		delete(conf.updateListeners, i)
+	}
+}
+
+// notifySchemeUpdates passes events for the specified updated and failed schemes to the
+// listeners, comparing the updated schemes in the old snapshot with the current one.
+func (conf *Configuration) notifySchemeUpdates(
+	old *Configuration, updated map[SchemeManagerIdentifier]struct{}, failed map[SchemeManagerIdentifier]error,
+) {
+	conf.updateListenersLock.Lock()
+	listeners := make([]func(*SchemeUpdateEvent), 0, len(conf.updateListeners))
+	for i := 0; i < conf.updateListenerCount; i++ {
+		if listener, ok := conf.updateListeners[i]; ok {
+			listeners = append(listeners, listener)
+		}
+	}
+	conf.updateListenersLock.Unlock()
+	if len(listeners) == 0 {
+		return
+	}
+
+	var events []*SchemeUpdateEvent
+	for id, err := range failed {
+		events = append(events, &SchemeUpdateEvent{Scheme: id, Err: err})
+	}
+	current := conf.Snapshot()
+	for id := range updated {
+		event := &SchemeUpdateEvent{Scheme: id}
+		if err := current.DisabledSchemeManagers[id]; err != nil {
+			event.Err = err
+		} else if oldscheme, newscheme := old.SchemeManagers[id], current.SchemeManagers[id]; oldscheme != nil && newscheme != nil {
+			event.Diff, _ = diffSchemes(old, current, id, false) // does not fail without parsing keys
+			event.NewPublicKeys = addedPublicKeys(oldscheme, newscheme)
+		}
+		events = append(events, event)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Scheme.String() < events[j].Scheme.String() })
+
+	for _, event := range events {
+		for _, listener := range listeners {
+			listener(event)
+		}
+	}
+}
+
+// publicKeyFiles returns the hashes of the public key files of the specified issuer in the index
+// of its scheme, by key counter.
+func publicKeyFiles(scheme *SchemeManager, issid IssuerIdentifier) map[uint]ConfigurationFileHash {
+	prefix := path.Join(scheme.ID, issid.Name(), "PublicKeys") + "/"
+	keys := map[uint]ConfigurationFileHash{}
+	for file, hash := range scheme.index {
+		if !strings.HasPrefix(file, prefix) || path.Ext(file) != ".xml" {
+			continue
+		}
+		counter, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(file, prefix), ".xml"), 10, 32)
+		if err != nil {
+			continue
+		}
+		keys[uint(counter)] = hash
+	}
+	return keys
+}
+
+// addedPublicKeys returns the counters of the public keys in the index of the new version of the
+// scheme that are not in the index of the old version, per issuer.
+func addedPublicKeys(old, new *SchemeManager) map[IssuerIdentifier][]uint {
+	added := map[IssuerIdentifier][]uint{}
+	issuers := map[IssuerIdentifier]struct{}{}
+	for file := range new.index {
+		if parts := strings.Split(file, "/"); len(parts) == 4 && parts[2] == "PublicKeys" {
+			issuers[NewIssuerIdentifier(parts[0]+"."+parts[1])] = struct{}{}
+		}
+	}
+	for issid := range issuers {
+		oldkeys := publicKeyFiles(old, issid)
+		for counter := range publicKeyFiles(new, issid) {
+			if _, ok := oldkeys[counter]; !ok {
+				added[issid] = append(added[issid], counter)
+			}
+		}
+		if len(added[issid]) > 0 {
+			sort.Slice(added[issid], sorter(added[issid]))
+		}
+	}
+	return added
+}
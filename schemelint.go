@@ -0,0 +1,352 @@
+package irma
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/gabi"
+	"github.com/privacybydesign/irmago/internal/common"
+)
+
+// LintSeverity is the severity of a LintDiagnostic. Its values match the levels used by SARIF.
+type LintSeverity string
+
+// LintDiagnostic is a problem found in a scheme by LintScheme.
+type LintDiagnostic struct {
+	Rule     string       `json:"rule"`
+	Severity LintSeverity `json:"severity"`
+	File     string       `json:"file"` // Path of the file, relative to the parent folder of the scheme
+	Message  string       `json:"message"`
+}
+
+// LintOptions configures LintScheme.
+type LintOptions struct {
+	// Suppress contains rules whose diagnostics are omitted, either as a rule ID (e.g.
+	// "missing-logo"), or as a rule ID and a file pattern in path.Match syntax separated by a colon
+	// (e.g. "missing-logo:irma-demo/RU/*") to suppress the rule only for matching files.
+	Suppress []string
+}
+
+const (
+	LintError   = LintSeverity("error")
+	LintWarning = LintSeverity("warning")
+	LintNote    = LintSeverity("note")
+)
+
+// LintRules contains the IDs of all rules checked by LintScheme, along with their severity.
+var LintRules = map[string]LintSeverity{
+	"unparsable-file":              LintError,
+	"wrong-directory":              LintError,
+	"wrong-reference":              LintError,
+	"no-attributes":                LintError,
+	"duplicate-attribute-id":       LintError,
+	"invalid-attribute-type":       LintError,
+	"revocation-configuration":     LintError,
+	"demo-prefix":                  LintError,
+	"invalid-display-index":        LintWarning,
+	"missing-translation":          LintWarning,
+	"missing-logo":                 LintWarning,
+	"missing-public-key":           LintWarning,
+	"unused-key":                   LintWarning,
+	"no-credential-types":          LintWarning,
+	"deprecated-issuer-referenced": LintWarning,
+	"missing-issue-url":            LintNote,
+}
+
+type schemeLinter struct {
+	dir         string // parent folder of the scheme
+	scheme      *SchemeManager
+	suppress    []string
+	diagnostics []LintDiagnostic
+}
+
+// LintScheme checks the scheme in the specified folder for problems, returning all of them
+// instead of stopping at the first one. Contrary to ParseSchemeManagerFolder, it does not
+// require the scheme to be signed, so that schemes can be checked before signing them.
+// An error is returned only if the scheme folder itself cannot be read.
+func LintScheme(dir string, opts LintOptions) ([]LintDiagnostic, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	l := &schemeLinter{dir: filepath.Dir(dir), scheme: &SchemeManager{}, suppress: opts.Suppress}
+	if err = common.AssertPathExists(filepath.Join(dir, "description.xml")); err != nil {
+		return nil, errors.WrapPrefix(err, "Not a scheme folder", 0)
+	}
+	if !l.parse(filepath.Join(dir, "description.xml"), l.scheme) {
+		return l.diagnostics, nil
+	}
+	l.lintScheme(dir)
+
+	issuers := map[string]*Issuer{}
+	err = common.IterateSubfolders(dir, func(issuerdir string, _ os.FileInfo) error {
+		if exists, _ := common.PathExists(filepath.Join(issuerdir, "description.xml")); !exists {
+			return nil
+		}
+		issuer := &Issuer{}
+		if l.parse(filepath.Join(issuerdir, "description.xml"), issuer) {
+			issuers[issuer.ID] = issuer
+			return l.lintIssuer(issuer, issuerdir)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	l.lintKeyshareAttribute(issuers)
+
+	sort.SliceStable(l.diagnostics, func(i, j int) bool {
+		return l.diagnostics[i].File < l.diagnostics[j].File
+	})
+	return l.diagnostics, nil
+}
+
+func (l *schemeLinter) report(rule, file, format string, args ...interface{}) {
+	if rel, err := filepath.Rel(l.dir, file); err == nil {
+		file = filepath.ToSlash(rel)
+	}
+	for _, s := range l.suppress {
+		parts := strings.SplitN(s, ":", 2)
+		if parts[0] != rule {
+			continue
+		}
+		if len(parts) == 1 {
+			return
+		}
+		if match, _ := path.Match(parts[1], file); match {
+			return
+		}
+	}
+	l.diagnostics = append(l.diagnostics, LintDiagnostic{
+		Rule:     rule,
+		Severity: LintRules[rule],
+		File:     file,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (l *schemeLinter) parse(file string, description interface{}) bool {
+	bts, err := ioutil.ReadFile(file)
+	if err == nil {
+		err = xml.Unmarshal(bts, description)
+	}
+	if err != nil {
+		l.report("unparsable-file", file, "Failed to parse: %s", err.Error())
+		return false
+	}
+	return true
+}
+
+func (l *schemeLinter) lintScheme(dir string) {
+	file := filepath.Join(dir, "description.xml")
+	if filepath.Base(dir) != l.scheme.ID {
+		l.report("wrong-directory", file, "Scheme %s has wrong directory name %s", l.scheme.ID, filepath.Base(dir))
+	}
+	if l.scheme.KeyshareServer != "" {
+		if exists, _ := common.PathExists(filepath.Join(dir, "kss-0.pem")); !exists {
+			l.report("missing-public-key", file, "Scheme %s has keyshare URL but no keyshare public key kss-0.pem", l.scheme.ID)
+		}
+	}
+	l.lintTranslations(file, fmt.Sprintf("Scheme %s", l.scheme.ID), l.scheme)
+}
+
+func (l *schemeLinter) lintIssuer(issuer *Issuer, dir string) error {
+	file := filepath.Join(dir, "description.xml")
+	issuerid := NewIssuerIdentifier(l.scheme.ID + "." + issuer.ID)
+	l.lintTranslations(file, fmt.Sprintf("Issuer %s", issuerid), issuer)
+	if filepath.Base(dir) != issuer.ID {
+		l.report("wrong-directory", file, "Issuer %s has wrong directory name %s", issuerid, filepath.Base(dir))
+	}
+	if issuer.SchemeManagerID != l.scheme.ID {
+		l.report("wrong-reference", file, "Issuer %s has wrong SchemeManager %s", issuerid, issuer.SchemeManagerID)
+	}
+	if err := validateDemoPrefix(issuer.Name); l.scheme.Demo && err != nil {
+		l.report("demo-prefix", file, "Name of demo issuer %s invalid: %s", issuerid, err.Error())
+	}
+	if exists, _ := common.PathExists(filepath.Join(dir, "logo.png")); !exists {
+		l.report("missing-logo", file, "Issuer %s has no logo.png", issuerid)
+	}
+
+	pks, err := l.lintKeys(issuerid, dir)
+	if err != nil {
+		return err
+	}
+
+	var creds []*CredentialType
+	err = common.IterateSubfolders(filepath.Join(dir, "Issues"), func(creddir string, _ os.FileInfo) error {
+		if exists, _ := common.PathExists(filepath.Join(creddir, "description.xml")); !exists {
+			return nil
+		}
+		cred := &CredentialType{}
+		if l.parse(filepath.Join(creddir, "description.xml"), cred) {
+			creds = append(creds, cred)
+			l.lintCredentialType(issuer, cred, creddir, pks)
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(creds) == 0 {
+		l.report("no-credential-types", file, "Issuer %s has no credential types", issuerid)
+		if len(pks) > 0 {
+			l.report("unused-key", file, "Issuer %s has public keys but no credential types", issuerid)
+		}
+	}
+	if !issuer.DeprecatedSince.IsZero() {
+		for _, cred := range creds {
+			l.report("deprecated-issuer-referenced", file, "Credential type %s belongs to deprecated issuer %s",
+				cred.ID, issuerid)
+		}
+	}
+	return nil
+}
+
+// lintKeys checks the public and private keys of the issuer, returning the public keys.
+func (l *schemeLinter) lintKeys(issuerid IssuerIdentifier, dir string) (map[uint]*gabi.PublicKey, error) {
+	pks := map[uint]*gabi.PublicKey{}
+	files, err := filepath.Glob(filepath.Join(dir, "PublicKeys", "*.xml"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		base := filepath.Base(file)
+		counter, err := strconv.ParseUint(base[:len(base)-4], 10, 32)
+		if err != nil {
+			l.report("unparsable-file", file, "Public key file name is not a number")
+			continue
+		}
+		pk, err := gabi.NewPublicKeyFromFile(file)
+		if err != nil {
+			l.report("unparsable-file", file, "Failed to parse: %s", err.Error())
+			continue
+		}
+		if pk.Counter != uint(counter) {
+			l.report("wrong-reference", file, "Public key %s of issuer %s has wrong <Counter>", base, issuerid)
+		}
+		pks[uint(counter)] = pk
+	}
+	if len(pks) == 0 {
+		l.report("missing-public-key", filepath.Join(dir, "description.xml"), "Issuer %s has no public keys", issuerid)
+	}
+
+	files, err = filepath.Glob(filepath.Join(dir, "PrivateKeys", "*.xml"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		base := filepath.Base(file)
+		counter, err := strconv.ParseUint(base[:len(base)-4], 10, 32)
+		if err == nil && pks[uint(counter)] == nil {
+			l.report("unused-key", file, "Private key %s of issuer %s has no corresponding public key", base, issuerid)
+		}
+	}
+	return pks, nil
+}
+
+func (l *schemeLinter) lintCredentialType(issuer *Issuer, cred *CredentialType, dir string, pks map[uint]*gabi.PublicKey) {
+	file := filepath.Join(dir, "description.xml")
+	credid := NewCredentialTypeIdentifier(l.scheme.ID + "." + issuer.ID + "." + cred.ID)
+	l.lintTranslations(file, fmt.Sprintf("Credential type %s", credid), cred)
+	if cred.ID != filepath.Base(dir) {
+		l.report("wrong-directory", file, "Credential type %s has wrong directory name %s", credid, filepath.Base(dir))
+	}
+	if cred.IssuerID != issuer.ID {
+		l.report("wrong-reference", file, "Credential type %s has wrong IssuerID %s", credid, cred.IssuerID)
+	}
+	if cred.SchemeManagerID != l.scheme.ID {
+		l.report("wrong-reference", file, "Credential type %s has wrong SchemeManager %s", credid, cred.SchemeManagerID)
+	}
+	if err := validateDemoPrefix(cred.Name); l.scheme.Demo && err != nil {
+		l.report("demo-prefix", file, "Name of demo credential %s invalid: %s", credid, err.Error())
+	}
+	if exists, _ := common.PathExists(filepath.Join(dir, "logo.png")); !exists {
+		l.report("missing-logo", file, "Credential type %s has no logo.png", credid)
+	}
+	if len(cred.IssueURL) == 0 {
+		l.report("missing-issue-url", file, "Credential type %s has no IssueURL", credid)
+	}
+
+	count := len(cred.AttributeTypes)
+	if count == 0 {
+		l.report("no-attributes", file, "Credential type %s has no attributes", credid)
+		return
+	}
+	ids := map[string]struct{}{}
+	indices := map[int]struct{}{}
+	revocation := false
+	for i, attr := range cred.AttributeTypes {
+		if _, seen := ids[attr.ID]; seen {
+			l.report("duplicate-attribute-id", file, "Credential type %s has multiple attributes with ID %s", credid, attr.ID)
+		}
+		ids[attr.ID] = struct{}{}
+		if err := attr.ValidateDeclaration(); err != nil {
+			l.report("invalid-attribute-type", file, "Attribute %s of credential type %s has invalid type declaration: %s", attr.ID, credid, err.Error())
+		}
+		if !attr.RevocationAttribute {
+			l.lintTranslations(file, fmt.Sprintf("Attribute %s of credential type %s", attr.ID, credid), attr)
+		} else {
+			revocation = true
+		}
+		index := i
+		if attr.DisplayIndex != nil {
+			index = *attr.DisplayIndex
+		}
+		if index < 0 || index >= count {
+			l.report("invalid-display-index", file, "Credential type %s has invalid attribute displayIndex at attribute %d", credid, i)
+		}
+		indices[index] = struct{}{}
+	}
+	if len(indices) != count {
+		l.report("invalid-display-index", file, "Credential type %s has invalid attribute ordering, check the displayIndex tags", credid)
+	}
+	if revocation && len(cred.RevocationServers) == 0 {
+		l.report("revocation-configuration", file, "Credential type %s has a revocation attribute but no RevocationServers", credid)
+	}
+	if !revocation && len(cred.RevocationServers) > 0 {
+		l.report("revocation-configuration", file, "Credential type %s has RevocationServers but no revocation attribute", credid)
+	}
+
+	// Check that the latest public key supports this credential type
+	var latest *gabi.PublicKey
+	for _, pk := range pks {
+		if latest == nil || pk.Counter > latest.Counter {
+			latest = pk
+		}
+	}
+	if latest == nil {
+		return
+	}
+	if count+2 > len(latest.R) {
+		l.report("missing-public-key", file, "Latest public key of issuer %s does not support the amount of attributes of credential type %s", issuer.ID, credid)
+	}
+	if revocation && !latest.RevocationSupported() {
+		l.report("revocation-configuration", file, "Credential type %s supports revocation but latest public key of issuer %s does not", credid, issuer.ID)
+	}
+}
+
+func (l *schemeLinter) lintKeyshareAttribute(issuers map[string]*Issuer) {
+	if l.scheme.KeyshareAttribute == "" {
+		return
+	}
+	attr := NewAttributeTypeIdentifier(l.scheme.KeyshareAttribute)
+	issuer := issuers[attr.CredentialTypeIdentifier().IssuerIdentifier().Name()]
+	if issuer != nil && !issuer.DeprecatedSince.IsZero() {
+		l.report("deprecated-issuer-referenced", filepath.Join(l.dir, l.scheme.ID, "description.xml"),
+			"Keyshare attribute %s belongs to deprecated issuer %s", attr, issuer.ID)
+	}
+}
+
+func (l *schemeLinter) lintTranslations(file, name string, o interface{}) {
+	for _, missing := range missingTranslations(o) {
+		l.report("missing-translation", file, "%s misses %s translation in <%s> tag", name, missing.lang, missing.tag)
+	}
+}
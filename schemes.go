@@ -1,20 +1,32 @@
 package irma
 
 import (
+	"crypto/ecdsa"
+	"encoding/asn1"
+	"encoding/pem"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/gabi/signed"
 	"github.com/privacybydesign/irmago/internal/common"
 )
 
 // SchemeManagerPointer points to a remote IRMA scheme, containing information to download the scheme,
-// including its (pinned) public key.
+// including its (pinned) public key(s).
 type SchemeManagerPointer struct {
 	Url       string // URL to download scheme from
-	Publickey []byte // Public key of scheme against which to verify files after they have been downloaded
+	Publickey []byte // Public key(s) of scheme against which to verify files after they have been downloaded
 }
 
+// schemeThresholdHeader is the PEM header of the first public key in a scheme's pk.pem
+// specifying how many of the public keys must have signed the scheme index.
+const schemeThresholdHeader = "Threshold"
+
 var DefaultSchemeManagers = [2]SchemeManagerPointer{
 	{
 		Url: "https://privacybydesign.foundation/schememanager/irma-demo",
@@ -36,7 +48,7 @@ func (conf *Configuration) DownloadDefaultSchemes() error {
 	Logger.Info("downloading default schemes (may take a while)")
 	for _, s := range DefaultSchemeManagers {
 		Logger.Debugf("Downloading scheme at %s", s.Url)
-		scheme, err := DownloadSchemeManager(s.Url)
+		scheme, err := downloadSchemeManager(s.Url, conf.options.Transport)
 		if err != nil {
 			return err
 		}
@@ -48,6 +60,128 @@ func (conf *Configuration) DownloadDefaultSchemes() error {
 	return nil
 }
 
+// Subfolders of the irma_configuration folder used when updating schemes: updates are downloaded
+// into and verified in a copy of the scheme in schemeStagingFolder, and the version of each scheme
+// from before its last update is kept in schemePreviousFolder.
+const (
+	schemeStagingFolder  = ".staging"
+	schemePreviousFolder = ".previous"
+)
+
+// updateSchemeStaged updates the scheme in the specified subfolder without modifying it until the
+// new version has been downloaded and verified. It copies the scheme to the staging folder, and calls
+// update with a Configuration rooted in the staging folder, which should download and verify the new
+// version there, and return whether the scheme changed. If so, the staged version replaces the scheme,
+// the current version of which is kept for RollbackScheme().
+func (conf *Configuration) updateSchemeStaged(name string, update func(stage *Configuration) (bool, error)) (bool, error) {
+	// Use a unique folder so that concurrent updates of the same scheme don't interfere
+	if err := common.EnsureDirectoryExists(filepath.Join(conf.Path, schemeStagingFolder)); err != nil {
+		return false, err
+	}
+	stagingPath, err := ioutil.TempDir(filepath.Join(conf.Path, schemeStagingFolder), name)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err := os.RemoveAll(stagingPath); err != nil {
+			Logger.Warn("Failed to remove staged scheme update: ", err)
+		}
+	}()
+	staged := filepath.Join(stagingPath, name)
+	if err = conf.copyFromFileSystem(filepath.Join(conf.Path, name), staged); err != nil {
+		return false, err
+	}
+
+	stage, err := NewConfiguration(stagingPath, ConfigurationOptions{
+		SchemeMirror: conf.options.SchemeMirror,
+		Transport:    conf.options.Transport,
+	})
+	if err != nil {
+		return false, err
+	}
+	stage.bundle = conf.bundle
+	updated, err := update(stage)
+	if err != nil || !updated {
+		return false, err
+	}
+	return true, conf.replaceScheme(name, staged)
+}
+
+// replaceScheme replaces the scheme in the specified subfolder by the one at the specified path
+// (within the irma_configuration folder), moving the current version to the previous folder.
+// Where supported (Linux, including Android), the two are swapped atomically. Otherwise they are
+// swapped by renaming one after the other, so that the scheme is briefly absent, but never
+// partially updated.
+func (conf *Configuration) replaceScheme(name, path string) error {
+	current := filepath.Join(conf.Path, name)
+	previous := filepath.Join(conf.Path, schemePreviousFolder, name)
+	if err := common.EnsureDirectoryExists(filepath.Dir(previous)); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(previous); err != nil {
+		return err
+	}
+	exists, err := common.PathExists(current)
+	if err != nil {
+		return err
+	}
+	if !exists { // the scheme is only present in the FileSystem of the Configuration
+		return os.Rename(path, current)
+	}
+	swapped, err := exchangeFolders(path, current)
+	if err != nil {
+		return err
+	}
+	if swapped {
+		// The current version is now at path
+		if err = os.Rename(path, previous); err != nil {
+			Logger.Warnf("Failed to keep previous version of scheme %s: %s", name, err)
+		}
+		return nil
+	}
+	if err = os.Rename(current, previous); err != nil {
+		return err
+	}
+	if err = os.Rename(path, current); err != nil {
+		if e := os.Rename(previous, current); e != nil {
+			Logger.Errorf("Failed to restore scheme %s after failing to replace it: %s", name, e)
+		}
+		return err
+	}
+	return nil
+}
+
+// RollbackScheme restores the version of the specified scheme (or requestor scheme) from before
+// its last update, keeping the current version as the previous one so that a second rollback
+// undoes the first. The Configuration is not reparsed; call ParseFolder() afterwards.
+// Note that UpdateSchemes() updates the scheme again if the newer version is still online.
+func (conf *Configuration) RollbackScheme(name string) error {
+	if conf.readOnly {
+		return errors.New("cannot roll back scheme in a read-only configuration")
+	}
+	previous := filepath.Join(conf.Path, schemePreviousFolder, name)
+	exists, err := common.PathExists(previous)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errors.Errorf("no previous version of scheme %s available", name)
+	}
+
+	// Move the previous version out of the way first, as replaceScheme() moves the current version there
+	staged := filepath.Join(conf.Path, schemeStagingFolder, name)
+	if err = common.EnsureDirectoryExists(filepath.Dir(staged)); err != nil {
+		return err
+	}
+	if err = os.RemoveAll(staged); err != nil {
+		return err
+	}
+	if err = os.Rename(previous, staged); err != nil {
+		return err
+	}
+	return conf.replaceScheme(name, staged)
+}
+
 // downloadDemoPrivateKeys attempts to download the scheme and issuer private keys, if the scheme is
 // a demo scheme and if they are not already present in the scheme, without failing if any of them
 // is not available.
@@ -57,7 +191,7 @@ func (conf *Configuration) downloadDemoPrivateKeys(scheme *SchemeManager) error
 	}
 
 	Logger.Debugf("Attempting downloading of private keys of scheme %s", scheme.ID)
-	transport := NewHTTPTransport(scheme.URL)
+	transport := conf.schemeTransport(conf.schemeURL(scheme))
 
 	err := conf.downloadFile(transport, scheme.ID, "sk.pem")
 	if err != nil { // If downloading of any of the private key fails just log it, and then continue
@@ -87,3 +221,110 @@ func (conf *Configuration) downloadDemoPrivateKeys(scheme *SchemeManager) error
 
 	return nil
 }
+
+// ParseSchemePublicKeys parses the contents of a pk.pem file of a scheme, which contains one or more
+// PEM-encoded ECDSA public keys, of which duplicates are ignored. It also returns the amount of
+// distinct keys that must have signed the scheme index, which is taken from the Threshold header
+// of the first key and defaults to 1.
+func ParseSchemePublicKeys(bts []byte) ([]*ecdsa.PublicKey, int, error) {
+	var (
+		pks       []*ecdsa.PublicKey
+		threshold = 1
+		block     *pem.Block
+	)
+	for {
+		block, bts = pem.Decode(bts)
+		if block == nil {
+			break
+		}
+		if block.Type != "PUBLIC KEY" {
+			continue
+		}
+		if t, ok := block.Headers[schemeThresholdHeader]; ok && len(pks) == 0 {
+			var err error
+			if threshold, err = strconv.Atoi(t); err != nil {
+				return nil, 0, errors.WrapPrefix(err, "Invalid scheme signature threshold", 0)
+			}
+		}
+		pk, err := signed.UnmarshalPublicKey(block.Bytes)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !containsPublicKey(pks, pk) {
+			pks = append(pks, pk)
+		}
+	}
+	if len(pks) == 0 {
+		return nil, 0, errors.New("No scheme public keys found")
+	}
+	if threshold < 1 || threshold > len(pks) {
+		return nil, 0, errors.Errorf("Scheme signature threshold %d out of range (have %d public keys)", threshold, len(pks))
+	}
+	return pks, threshold, nil
+}
+
+func containsPublicKey(pks []*ecdsa.PublicKey, pk *ecdsa.PublicKey) bool {
+	for _, p := range pks {
+		if p.Curve == pk.Curve && p.X.Cmp(pk.X) == 0 && p.Y.Cmp(pk.Y) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// MarshalSchemePublicKeys PEM-encodes the specified public keys and threshold for use as pk.pem
+// file of a scheme. If threshold is 1 the output is compatible with versions of irmago that only
+// support a single scheme public key.
+func MarshalSchemePublicKeys(pks []*ecdsa.PublicKey, threshold int) ([]byte, error) {
+	if threshold < 1 || threshold > len(pks) {
+		return nil, errors.Errorf("Scheme signature threshold %d out of range (have %d public keys)", threshold, len(pks))
+	}
+	var bts []byte
+	for i, pk := range pks {
+		der, err := signed.MarshalPublicKey(pk)
+		if err != nil {
+			return nil, errors.WrapPrefix(err, "Failed to serialize public key", 0)
+		}
+		block := &pem.Block{Type: "PUBLIC KEY", Bytes: der}
+		if i == 0 && threshold > 1 {
+			block.Headers = map[string]string{schemeThresholdHeader: strconv.Itoa(threshold)}
+		}
+		bts = append(bts, pem.EncodeToMemory(block)...)
+	}
+	return bts, nil
+}
+
+// SplitSchemeSignatures splits the contents of an index.sig file, consisting of one or more
+// concatenated DER-encoded ECDSA signatures, into the separate signatures.
+func SplitSchemeSignatures(bts []byte) ([][]byte, error) {
+	var sigs [][]byte
+	for len(bts) > 0 {
+		var raw asn1.RawValue
+		rest, err := asn1.Unmarshal(bts, &raw)
+		if err != nil {
+			return nil, errors.WrapPrefix(err, "Failed to parse scheme index signature", 0)
+		}
+		sigs = append(sigs, raw.FullBytes)
+		bts = rest
+	}
+	return sigs, nil
+}
+
+// VerifySchemeSignatures verifies that at least threshold of the specified public keys have
+// a valid signature over the scheme index among the specified signatures. It returns the indices
+// of the keys that signed the index.
+func VerifySchemeSignatures(pks []*ecdsa.PublicKey, threshold int, index []byte, sigs [][]byte) ([]int, error) {
+	var signers []int
+	for i, pk := range pks {
+		for _, sig := range sigs {
+			if signed.Verify(pk, index, sig) == nil {
+				signers = append(signers, i)
+				break
+			}
+		}
+	}
+	if len(signers) < threshold {
+		return signers, errors.Errorf("Scheme index has %d valid signatures, %d required", len(signers), threshold)
+	}
+	return signers, nil
+}
@@ -0,0 +1,13 @@
+package irma
+
+import "golang.org/x/sys/unix"
+
+// exchangeFolders atomically swaps the two specified folders, returning false if the kernel or
+// file system does not support this.
+func exchangeFolders(a, b string) (bool, error) {
+	err := unix.Renameat2(unix.AT_FDCWD, a, unix.AT_FDCWD, b, unix.RENAME_EXCHANGE)
+	if err == unix.ENOSYS || err == unix.EINVAL {
+		return false, nil
+	}
+	return err == nil, err
+}
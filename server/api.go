@@ -33,13 +33,15 @@ type SessionPackage struct {
 // SessionResult contains session information such as the session status, type, possible errors,
 // and disclosed attributes or attribute-based signature if appropriate to the session type.
 type SessionResult struct {
-	Token       string                       `json:"token"`
-	Status      Status                       `json:"status"`
-	Type        irma.Action                  `json:"type"'`
-	ProofStatus irma.ProofStatus             `json:"proofStatus,omitempty"`
-	Disclosed   [][]*irma.DisclosedAttribute `json:"disclosed,omitempty"`
-	Signature   *irma.SignedMessage          `json:"signature,omitempty"`
-	Err         *irma.RemoteError            `json:"error,omitempty"`
+	Token          string                       `json:"token"`
+	Status         Status                       `json:"status"`
+	Type           irma.Action                  `json:"type"'`
+	ProofStatus    irma.ProofStatus             `json:"proofStatus,omitempty"`
+	Disclosed      [][]*irma.DisclosedAttribute `json:"disclosed,omitempty"`
+	Signature      *irma.SignedMessage          `json:"signature,omitempty"`
+	Err            *irma.RemoteError            `json:"error,omitempty"`
+	NextSession    string                       `json:"nextSession,omitempty"`    // token of the follow-up session, if any
+	ConsentReceipt string                       `json:"consentReceipt,omitempty"` // signed irma.ConsentReceipt, if the request specified purposes
 
 	LegacySession bool `json:"-"` // true if request was started with legacy (i.e. pre-condiscon) session request
 }
@@ -310,6 +312,12 @@ func ResultJwt(sessionresult *SessionResult, issuer string, validity int, privat
 }
 
 func DoResultCallback(callbackUrl string, result *SessionResult, issuer string, validity int, privatekey *rsa.PrivateKey) {
+	DoResultCallbackWithTransport(callbackUrl, result, issuer, validity, privatekey, nil)
+}
+
+// DoResultCallbackWithTransport is like DoResultCallback, using the specified transport options
+// for POSTing the result (nil for the defaults).
+func DoResultCallbackWithTransport(callbackUrl string, result *SessionResult, issuer string, validity int, privatekey *rsa.PrivateKey, transport *irma.TransportOptions) {
 	logger := Logger.WithFields(logrus.Fields{"session": result.Token, "callbackUrl": callbackUrl})
 	if !strings.HasPrefix(callbackUrl, "https") {
 		logger.Warn("POSTing session result to callback URL without TLS: attributes are unencrypted in traffic")
@@ -317,30 +325,58 @@ func DoResultCallback(callbackUrl string, result *SessionResult, issuer string,
 		logger.Debug("POSTing session result")
 	}
 
-	var res string
-	if privatekey != nil {
-		var err error
-		res, err = ResultJwt(result, issuer, validity, privatekey)
-		if err != nil {
-			_ = LogError(errors.WrapPrefix(err, "Failed to create JWT for result callback", 0))
-			return
-		}
-	} else {
-		bts, err := json.Marshal(result)
-		if err != nil {
-			_ = LogError(errors.WrapPrefix(err, "Failed to marshal session result for result callback", 0))
-			return
-		}
-		res = string(bts)
+	res, err := resultMessage(result, issuer, validity, privatekey)
+	if err != nil {
+		_ = LogError(errors.WrapPrefix(err, "Failed to create session result for result callback", 0))
+		return
 	}
 
 	var x string // dummy for the server's return value that we don't care about
-	if err := irma.NewHTTPTransport(callbackUrl).Post("", &x, res); err != nil {
+	if err := irma.NewHTTPTransportWithOptions(callbackUrl, transport, irma.TransportPurposeSession).Post("", &x, res); err != nil {
 		// not our problem, log it and go on
 		logger.Warn(errors.WrapPrefix(err, "Failed to POST session result to callback URL", 0))
 	}
 }
 
+// RequestNextSession POSTs the session result to the next session URL of a session (see
+// irma.NextSessionData), and parses the response as the session request of the follow-up session.
+func RequestNextSession(nextSessionUrl string, result *SessionResult, issuer string, validity int, privatekey *rsa.PrivateKey) (irma.RequestorRequest, error) {
+	return RequestNextSessionWithTransport(nextSessionUrl, result, issuer, validity, privatekey, nil)
+}
+
+// RequestNextSessionWithTransport is like RequestNextSession, using the specified transport
+// options for POSTing the result (nil for the defaults).
+func RequestNextSessionWithTransport(nextSessionUrl string, result *SessionResult, issuer string, validity int, privatekey *rsa.PrivateKey, transport *irma.TransportOptions) (irma.RequestorRequest, error) {
+	logger := Logger.WithFields(logrus.Fields{"session": result.Token, "nextSessionUrl": nextSessionUrl})
+	if !strings.HasPrefix(nextSessionUrl, "https") {
+		logger.Warn("POSTing session result to next session URL without TLS: attributes are unencrypted in traffic")
+	} else {
+		logger.Debug("POSTing session result to next session URL")
+	}
+
+	res, err := resultMessage(result, issuer, validity, privatekey)
+	if err != nil {
+		return nil, err
+	}
+	var request string
+	if err = irma.NewHTTPTransportWithOptions(nextSessionUrl, transport, irma.TransportPurposeSession).Post("", &request, res); err != nil {
+		return nil, errors.WrapPrefix(err, "Failed to POST session result to next session URL", 0)
+	}
+	return ParseSessionRequest(request)
+}
+
+// resultMessage returns the session result as a JWT if a private key is given, and as JSON otherwise.
+func resultMessage(result *SessionResult, issuer string, validity int, privatekey *rsa.PrivateKey) (string, error) {
+	if privatekey != nil {
+		return ResultJwt(result, issuer, validity, privatekey)
+	}
+	bts, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(bts), nil
+}
+
 func log(level logrus.Level, err error) error {
 	writer := Logger.WithFields(logrus.Fields{"err": TypeString(err)}).WriterLevel(level)
 	if e, ok := err.(*errors.Error); ok && Logger.IsLevelEnabled(logrus.DebugLevel) {
@@ -9,6 +9,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/go-errors/errors"
@@ -49,11 +50,19 @@ type Configuration struct {
 	Email string `json:"email" mapstructure:"email"`
 	// Enable server sent events for status updates (experimental; tends to hang when a reverse proxy is used)
 	EnableSSE bool `json:"enable_sse" mapstructure:"enable_sse"`
+	// Configures outgoing HTTP requests: scheme downloads, revocation, and result callbacks and
+	// next session requests to requestors (only used for schemes and revocation if IrmaConfiguration == nil)
+	Transport *irma.TransportOptions `json:"transport,omitempty" mapstructure:"transport"`
 
 	// Static session requests that can be created by POST /session/{name}
 	StaticSessions map[string]interface{} `json:"static_sessions"`
-	// Static session requests after parsing
+	// Static session requests after parsing, which are reparsed after scheme updates; use
+	// StaticSessionRequest() to access them while the server is running
 	StaticSessionRequests map[string]irma.RequestorRequest `json:"-"`
+	staticSessionsLock    sync.RWMutex                     // protects StaticSessionRequests
+	// Session request templates, of which instances can be created by POST /session/template/{name}
+	// at the requestor server, or (for static templates) by POST /irma/session/{name} by IRMA apps
+	SessionTemplates map[string]*SessionTemplate `json:"session_templates" mapstructure:"session_templates"`
 
 	// Used in the "iss" field of result JWTs from /result-jwt and /getproof
 	JwtIssuer string `json:"jwt_issuer" mapstructure:"jwt_issuer"`
@@ -78,6 +87,8 @@ type Configuration struct {
 	RevocationDBType string `json:"revocation_db_type" mapstructure:"revocation_db_type"`
 	// Credentials types for which revocation database should be hosted
 	RevocationSettings irma.RevocationSettings `json:"revocation_settings" mapstructure:"revocation_settings"`
+	// Share revocation state with other replicas of this server (only used if IrmaConfiguration == nil)
+	RevocationReplication *irma.RevocationReplication `json:"revocation_replication,omitempty" mapstructure:"revocation_replication"`
 
 	// Production mode: enables safer and stricter defaults and config checking
 	Production bool `json:"production" mapstructure:"production"`
@@ -99,6 +110,7 @@ func (conf *Configuration) Check() error {
 		conf.verifyEmail,
 		conf.verifyRevocation,
 		conf.verifyStaticSessions,
+		conf.verifySessionTemplates,
 		conf.verifyJwtPrivateKey,
 	} {
 		if err := f(); err != nil {
@@ -128,7 +140,8 @@ func (conf *Configuration) HavePrivateKeys() bool {
 // helpers
 
 func (conf *Configuration) verifyStaticSessions() error {
-	conf.StaticSessionRequests = make(map[string]irma.RequestorRequest)
+	requests := make(map[string]irma.RequestorRequest)
+	irmaconf := conf.IrmaConfiguration.Snapshot() // check all requests against the same schemes
 	for name, r := range conf.StaticSessions {
 		if !regexp.MustCompile("^[a-zA-Z0-9_]+$").MatchString(name) {
 			return errors.Errorf("static session name %s not allowed, must be alphanumeric", name)
@@ -148,11 +161,57 @@ func (conf *Configuration) verifyStaticSessions() error {
 		if rrequest.Base().CallbackURL == "" {
 			return errors.Errorf("static session %s has no callback URL", name)
 		}
-		conf.StaticSessionRequests[name] = rrequest
+		// Attributes unknown to our schemes might be downloaded when the session is started
+		if err = checkSchemeIdentifiers(irmaconf, rrequest.SessionRequest()); err != nil {
+			conf.Logger.WithField("session", name).Warn("Static session request does not match schemes: ", err.Error())
+		}
+		requests[name] = rrequest
 	}
+	conf.staticSessionsLock.Lock()
+	defer conf.staticSessionsLock.Unlock()
+	conf.StaticSessionRequests = requests
 	return nil
 }
 
+// StaticSessionRequest returns the parsed static session request with the specified name, or nil
+// if there is none.
+func (conf *Configuration) StaticSessionRequest(name string) irma.RequestorRequest {
+	conf.staticSessionsLock.RLock()
+	defer conf.staticSessionsLock.RUnlock()
+	return conf.StaticSessionRequests[name]
+}
+
+// schemeUpdated checks the static sessions and issuer private keys against an updated scheme.
+// Problems are logged, as the server keeps running with the updated schemes.
+func (conf *Configuration) schemeUpdated(event *irma.SchemeUpdateEvent) {
+	if event.Err != nil || (event.Diff != nil && event.Diff.Empty()) {
+		return
+	}
+	conf.Logger.WithField("scheme", event.Scheme).Info("Scheme updated, checking static sessions and private keys")
+	if err := conf.verifyStaticSessions(); err != nil {
+		_ = LogError(err)
+	}
+	if err := conf.checkPrivateKeys(); err != nil {
+		_ = LogError(err)
+	}
+}
+
+// checkSchemeIdentifiers checks that the attributes in the request exist in the specified schemes.
+func checkSchemeIdentifiers(irmaconf *irma.Configuration, request irma.SessionRequest) error {
+	ids := request.Identifiers()
+	for credid := range ids.CredentialTypes {
+		if _, ok := irmaconf.CredentialTypes[credid]; !ok {
+			return errors.Errorf("unknown credential type %s", credid)
+		}
+	}
+	for attrid := range ids.AttributeTypes {
+		if _, ok := irmaconf.AttributeTypes[attrid]; !ok && !attrid.IsCredential() {
+			return errors.Errorf("unknown attribute %s", attrid)
+		}
+	}
+	return request.Disclosure().Disclose.Validate(irmaconf)
+}
+
 func (conf *Configuration) verifyIrmaConf() error {
 	if conf.IrmaConfiguration == nil {
 		var (
@@ -170,10 +229,12 @@ func (conf *Configuration) verifyIrmaConf() error {
 		}
 		conf.Logger.WithField("schemes_path", conf.SchemesPath).Info("Determined schemes path")
 		conf.IrmaConfiguration, err = irma.NewConfiguration(conf.SchemesPath, irma.ConfigurationOptions{
-			Assets:              conf.SchemesAssetsPath,
-			RevocationDBType:    conf.RevocationDBType,
-			RevocationDBConnStr: conf.RevocationDBConnStr,
-			RevocationSettings:  conf.RevocationSettings,
+			Assets:                conf.SchemesAssetsPath,
+			RevocationDBType:      conf.RevocationDBType,
+			RevocationDBConnStr:   conf.RevocationDBConnStr,
+			RevocationSettings:    conf.RevocationSettings,
+			Transport:             conf.Transport,
+			RevocationReplication: conf.RevocationReplication,
 		})
 		if err != nil {
 			return err
@@ -198,6 +259,7 @@ func (conf *Configuration) verifyIrmaConf() error {
 		conf.SchemesUpdateInterval = 60
 	}
 	if !conf.DisableSchemesUpdate {
+		conf.IrmaConfiguration.SubscribeSchemeUpdates(conf.schemeUpdated)
 		conf.IrmaConfiguration.AutoUpdateSchemes(uint(conf.SchemesUpdateInterval))
 	}
 
@@ -249,6 +311,11 @@ func (conf *Configuration) verifyPrivateKeys() error {
 			conf.IssuerPrivateKeys[issid][sk.Counter] = sk
 		}
 	}
+	return conf.checkPrivateKeys()
+}
+
+// checkPrivateKeys checks that the issuer private keys match the public keys in the schemes.
+func (conf *Configuration) checkPrivateKeys() error {
 	for issid := range conf.IssuerPrivateKeys {
 		for _, sk := range conf.IssuerPrivateKeys[issid] {
 			pk, err := conf.IrmaConfiguration.PublicKey(issid, sk.Counter)
@@ -389,7 +456,7 @@ func (conf *Configuration) verifyEmail() error {
 		if !strings.Contains(conf.Email, "@") || strings.Contains(conf.Email, "\n") {
 			return errors.New("Invalid email address specified")
 		}
-		t := irma.NewHTTPTransport("https://metrics.privacybydesign.foundation/history")
+		t := irma.NewHTTPTransportWithOptions("https://metrics.privacybydesign.foundation/history", conf.Transport, irma.TransportPurposeSession)
 		t.SetHeader("User-Agent", "irmaserver")
 		var x string
 		_ = t.Post("email", &x, conf.Email)
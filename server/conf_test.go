@@ -0,0 +1,85 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/internal/common"
+	"github.com/privacybydesign/irmago/internal/test"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStaticSessionsCheckedAfterSchemeUpdate(t *testing.T) {
+	// The version of irma-demo in irma_configuration_updated adds an attribute
+	dir, err := ioutil.TempDir("", "schemes")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, common.CopyDirectory(filepath.Join("..", "testdata", "irma_configuration"), dir))
+
+	logger, hook := logtest.NewNullLogger()
+	logger.SetLevel(logrus.InfoLevel)
+	conf := &Configuration{
+		SchemesPath:          dir,
+		DisableSchemesUpdate: true,
+		Logger:               logger,
+		StaticSessions: map[string]interface{}{
+			"new": map[string]interface{}{
+				"callbackUrl": "https://example.com",
+				"request": map[string]interface{}{
+					"@context": "https://irma.app/ld/request/disclosure/v2",
+					"disclose": [][][]string{{{"irma-demo.RU.studentCard.newAttribute"}}},
+				},
+			},
+		},
+	}
+	require.NoError(t, conf.Check())
+	defer conf.IrmaConfiguration.Revocation.Close()
+	conf.IrmaConfiguration.SubscribeSchemeUpdates(conf.schemeUpdated)
+	require.NotNil(t, conf.StaticSessionRequest("new"))
+	logged := func(prefix string) int {
+		count := 0
+		for _, entry := range hook.AllEntries() {
+			if strings.HasPrefix(entry.Message, prefix) {
+				count++
+			}
+		}
+		return count
+	}
+	const mismatch = "Static session request does not match schemes"
+	require.Equal(t, 1, logged(mismatch))
+	hook.Reset()
+
+	// Static sessions are read by request handlers during the update
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Millisecond):
+				_ = conf.StaticSessionRequest("new")
+			}
+		}
+	}()
+
+	test.StartSchemeManagerHttpServer()
+	defer test.StopSchemeManagerHttpServer()
+	// The timestamp of that version is older than that of ours, so pretend ours is older still
+	scheme := conf.IrmaConfiguration.SchemeManagers[irma.NewSchemeManagerIdentifier("irma-demo")]
+	scheme.URL = "http://localhost:48681/irma_configuration_updated/irma-demo"
+	scheme.Timestamp = irma.Timestamp(time.Unix(0, 0))
+	require.NoError(t, conf.IrmaConfiguration.UpdateSchemes())
+	close(done)
+
+	// The static session was checked again, and now matches the schemes
+	require.Equal(t, 1, logged("Scheme updated, checking static sessions"))
+	require.Zero(t, logged(mismatch))
+	require.NotNil(t, conf.StaticSessionRequest("new"))
+}
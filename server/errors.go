@@ -35,4 +35,7 @@ var (
 	ErrorUnsupported     Error = Error{Type: "UNSUPPORTED", Status: 501, Description: "Unsupported by this server"}
 	ErrorInvalidRequest  Error = Error{Type: "INVALID_REQUEST", Status: 400, Description: "Invalid HTTP request"}
 	ErrorProtocolVersion Error = Error{Type: "PROTOCOL_VERSION", Status: 400, Description: "Protocol version negotiation failed"}
+	ErrorNextSession     Error = Error{Type: "NEXT_SESSION", Status: 500, Description: "Error starting next session"}
+	ErrorNotReady        Error = Error{Type: "NOT_READY", Status: 503, Description: "Server is not ready to handle sessions"}
+	ErrorShuttingDown    Error = Error{Type: "SHUTTING_DOWN", Status: 503, Description: "Server is shutting down and does not accept new sessions"}
 )
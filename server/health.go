@@ -0,0 +1,64 @@
+package server
+
+import (
+	irma "github.com/privacybydesign/irmago"
+)
+
+// HealthStatus reports on the health of an IRMA server, as returned by the status endpoint of the
+// requestor server.
+type HealthStatus struct {
+	// Whether the server can handle sessions: all schemes parsed successfully and the revocation
+	// database (if any) is reachable
+	Ready bool `json:"ready"`
+
+	// Schemes that could not be parsed, with the error that occurred
+	DisabledSchemes map[string]string `json:"disabled_schemes,omitempty"`
+	// Outcome of the most recent scheme updates; absent if schemes are not updated
+	SchemesUpdate *irma.SchemeUpdateStatus `json:"schemes_update,omitempty"`
+	// Error that occurred when connecting to the revocation database, if any
+	RevocationDBError string `json:"revocation_db_error,omitempty"`
+	// Health of the revocation servers from which revocation updates have been fetched
+	RevocationServers []irma.RevocationServerStatus `json:"revocation_servers,omitempty"`
+	// Warnings about issuer public keys that are expiring or have expired
+	KeyWarnings []string `json:"key_warnings,omitempty"`
+	// Whether the server is shutting down, waiting for unfinished sessions to finish
+	Draining bool `json:"draining,omitempty"`
+	// Number of sessions known to the server per session status
+	Sessions map[Status]int `json:"sessions"`
+}
+
+// Readiness checks only what determines whether the server can handle sessions: the schemes and
+// the revocation database. Of the returned HealthStatus, only Ready, DisabledSchemes and
+// RevocationDBError are set.
+func (conf *Configuration) Readiness() *HealthStatus {
+	status := &HealthStatus{}
+	for id, err := range conf.IrmaConfiguration.Snapshot().DisabledSchemeManagers {
+		if status.DisabledSchemes == nil {
+			status.DisabledSchemes = map[string]string{}
+		}
+		status.DisabledSchemes[id.String()] = err.Error()
+	}
+	if err := conf.IrmaConfiguration.Revocation.Ping(); err != nil {
+		status.RevocationDBError = err.Error()
+	}
+	status.Ready = len(status.DisabledSchemes) == 0 && status.RevocationDBError == ""
+	return status
+}
+
+// Health checks the schemes, revocation database and issuer keys of the configuration. The
+// Sessions of the returned HealthStatus are left empty.
+func (conf *Configuration) Health() *HealthStatus {
+	status := conf.Readiness()
+	status.Sessions = map[Status]int{}
+	if !conf.DisableSchemesUpdate {
+		update := conf.IrmaConfiguration.SchemeUpdateStatus()
+		status.SchemesUpdate = &update
+	}
+	status.RevocationServers = conf.IrmaConfiguration.RevocationServerStatus()
+	warnings, err := conf.IrmaConfiguration.KeyExpiryWarnings(irma.DefaultKeyExpiryWarningPeriod)
+	if err != nil {
+		warnings = append(warnings, "Failed to check issuer keys: "+err.Error())
+	}
+	status.KeyWarnings = warnings
+	return status
+}
@@ -5,7 +5,10 @@
 package irmaserver
 
 import (
+	"context"
 	"net/http"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/alexandrevicenzi/go-sse"
@@ -25,8 +28,15 @@ type Server struct {
 	stopScheduler    chan bool
 	handlers         map[string]server.SessionHandler
 	serverSentEvents *sse.Server
+
+	draining        int32 // set to 1 by Drain(), after which no new sessions are started
+	pendingHandlers int32 // number of session result handlers that are running
 }
 
+// NextSessionAuthorizer checks whether the session request of a follow-up session, as returned
+// by the NextSession URL of a finished session (see irma.NextSessionData), may be started.
+type NextSessionAuthorizer func(next irma.RequestorRequest) error
+
 // Default server instance
 var s *Server
 
@@ -128,6 +138,7 @@ func (s *Server) HandlerFunc() http.HandlerFunc {
 		r.Get("/update/{count:\\d+}", s.handleRevocationGetUpdateLatest)
 		r.Get("/update/{count:\\d+}/{counter:\\d+}", s.handleRevocationGetUpdateLatest)
 		r.Post("/issuancerecord/{counter:\\d+}", s.handleRevocationPostIssuanceRecord)
+		r.Post("/replicate", s.handleRevocationPostReplication)
 	})
 
 	return s.router.ServeHTTP
@@ -143,6 +154,59 @@ func (s *Server) Stop() {
 	}
 	s.stopScheduler <- true
 	s.sessions.stop()
+	if s.serverSentEvents != nil {
+		// Disconnect other servers listening for our revocation updates, so that they reconnect
+		// to another server instead of waiting for updates from this one
+		for _, channel := range s.serverSentEvents.Channels() {
+			if strings.HasPrefix(channel, server.ComponentRevocation+"/") {
+				s.serverSentEvents.CloseChannel(channel)
+			}
+		}
+	}
+}
+
+// Drain stops the server from accepting new sessions, after which StartSession() and
+// StartAuthorizedSession() return an *irma.RemoteError of type server.ErrorShuttingDown.
+// Sessions that no client has started yet are cancelled. It then waits until all other
+// unfinished sessions have finished (including follow-up sessions, which are still started)
+// and all session result handlers have returned, or until ctx is done, in which case ctx.Err()
+// is returned. Stop() should be called afterwards.
+func Drain(ctx context.Context) error {
+	return s.Drain(ctx)
+}
+func (s *Server) Drain(ctx context.Context) error {
+	atomic.StoreInt32(&s.draining, 1)
+	s.conf.Logger.Info("Draining sessions")
+	if cancelled := s.sessions.cancelInitialized(); cancelled > 0 {
+		s.conf.Logger.WithField("sessions", cancelled).Info("Cancelled sessions not yet started by a client")
+	}
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		unfinished := 0
+		for status, count := range s.sessions.count() {
+			if !status.Finished() {
+				unfinished += count
+			}
+		}
+		handlers := atomic.LoadInt32(&s.pendingHandlers)
+		if unfinished == 0 && handlers == 0 {
+			s.conf.Logger.Info("All sessions finished")
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			s.conf.Logger.WithFields(logrus.Fields{"sessions": unfinished, "handlers": handlers}).
+				Warn("Stopped waiting for unfinished sessions and result handlers")
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) isDraining() bool {
+	return atomic.LoadInt32(&s.draining) == 1
 }
 
 // StartSession starts an IRMA session, running the handler on completion, if specified.
@@ -154,6 +218,26 @@ func StartSession(request interface{}, handler server.SessionHandler) (*irma.Qr,
 	return s.StartSession(request, handler)
 }
 func (s *Server) StartSession(req interface{}, handler server.SessionHandler) (*irma.Qr, string, error) {
+	if s.isDraining() {
+		return nil, "", server.RemoteError(server.ErrorShuttingDown, "")
+	}
+	return s.startSession(req, handler, nil)
+}
+
+// StartAuthorizedSession is like StartSession, but follow-up sessions (see irma.NextSessionData)
+// are only started if the specified authorizer accepts their session requests. Follow-up sessions
+// use the same handler and authorizer.
+func StartAuthorizedSession(request interface{}, handler server.SessionHandler, authorizer NextSessionAuthorizer) (*irma.Qr, string, error) {
+	return s.StartAuthorizedSession(request, handler, authorizer)
+}
+func (s *Server) StartAuthorizedSession(req interface{}, handler server.SessionHandler, authorizer NextSessionAuthorizer) (*irma.Qr, string, error) {
+	if s.isDraining() {
+		return nil, "", server.RemoteError(server.ErrorShuttingDown, "")
+	}
+	return s.startSession(req, handler, authorizer)
+}
+
+func (s *Server) startSession(req interface{}, handler server.SessionHandler, authorizer NextSessionAuthorizer) (*irma.Qr, string, error) {
 	rrequest, err := server.ParseSessionRequest(req)
 	if err != nil {
 		return nil, "", err
@@ -172,7 +256,7 @@ func (s *Server) StartSession(req interface{}, handler server.SessionHandler) (*
 		}
 	}
 
-	session := s.newSession(action, rrequest)
+	session := s.newSession(action, rrequest, authorizer)
 	s.conf.Logger.WithFields(logrus.Fields{"action": action, "session": session.token}).Infof("Session started")
 	if s.conf.Logger.IsLevelEnabled(logrus.DebugLevel) {
 		s.conf.Logger.WithFields(logrus.Fields{"session": session.token, "clienttoken": session.clientToken}).Info("Session request: ", server.ToJson(rrequest))
@@ -188,6 +272,30 @@ func (s *Server) StartSession(req interface{}, handler server.SessionHandler) (*
 	}, session.token, nil
 }
 
+// HealthStatus reports on the health of the server, including the number of sessions per status.
+func HealthStatus() *server.HealthStatus {
+	return s.HealthStatus()
+}
+func (s *Server) HealthStatus() *server.HealthStatus {
+	status := s.conf.Health()
+	status.Sessions = s.sessions.count()
+	status.Draining = s.isDraining()
+	status.Ready = status.Ready && !status.Draining
+	return status
+}
+
+// Readiness reports whether the server can handle new sessions, checking only its schemes,
+// revocation database and whether it is shutting down (see server.Configuration.Readiness()).
+func Readiness() *server.HealthStatus {
+	return s.Readiness()
+}
+func (s *Server) Readiness() *server.HealthStatus {
+	status := s.conf.Readiness()
+	status.Draining = s.isDraining()
+	status.Ready = status.Ready && !status.Draining
+	return status
+}
+
 // GetSessionResult retrieves the result of the specified IRMA session.
 func GetSessionResult(token string) *server.SessionResult {
 	return s.GetSessionResult(token)
@@ -43,7 +43,7 @@ func (session *session) handleGetRequest(min, max *irma.ProtocolVersion) (irma.S
 	// we include the latest revocation updates for the client here, as opposed to when the session
 	// was started, so that the client always gets the very latest revocation records
 	var err error
-	if err = session.conf.IrmaConfiguration.Revocation.SetRevocationUpdates(session.request.Base()); err != nil {
+	if err = session.irmaConfiguration.Revocation.SetRevocationUpdates(session.request.Base()); err != nil {
 		return nil, session.fail(server.ErrorRevocation, err.Error())
 	}
 
@@ -98,7 +98,10 @@ func (session *session) handlePostSignature(signature *irma.SignedMessage) (*irm
 	var rerr *irma.RemoteError
 	session.result.Signature = signature
 	session.result.Disclosed, session.result.ProofStatus, err = signature.Verify(
-		session.conf.IrmaConfiguration, session.request.(*irma.SignatureRequest))
+		session.irmaConfiguration, session.request.(*irma.SignatureRequest))
+	if err == nil {
+		err = session.createConsentReceipt()
+	}
 	if err == nil {
 		session.setStatus(server.StatusDone)
 	} else {
@@ -120,7 +123,10 @@ func (session *session) handlePostDisclosure(disclosure *irma.Disclosure) (*irma
 	var err error
 	var rerr *irma.RemoteError
 	session.result.Disclosed, session.result.ProofStatus, err = disclosure.Verify(
-		session.conf.IrmaConfiguration, session.request.(*irma.DisclosureRequest))
+		session.irmaConfiguration, session.request.(*irma.DisclosureRequest))
+	if err == nil {
+		err = session.createConsentReceipt()
+	}
 	if err == nil {
 		session.setStatus(server.StatusDone)
 	} else {
@@ -148,13 +154,13 @@ func (session *session) handlePostCommitments(commitments *irma.IssueCommitmentM
 
 	// Compute list of public keys against which to verify the received proofs
 	disclosureproofs := irma.ProofList(commitments.Proofs[:discloseCount])
-	pubkeys, err := disclosureproofs.ExtractPublicKeys(session.conf.IrmaConfiguration)
+	pubkeys, err := disclosureproofs.ExtractPublicKeys(session.irmaConfiguration)
 	if err != nil {
 		return nil, session.fail(server.ErrorMalformedInput, err.Error())
 	}
 	for _, cred := range request.Credentials {
 		iss := cred.CredentialTypeID.IssuerIdentifier()
-		pubkey, _ := session.conf.IrmaConfiguration.PublicKey(iss, cred.KeyCounter) // No error, already checked earlier
+		pubkey, _ := session.irmaConfiguration.PublicKey(iss, cred.KeyCounter) // No error, already checked earlier
 		pubkeys = append(pubkeys, pubkey)
 	}
 
@@ -162,7 +168,7 @@ func (session *session) handlePostCommitments(commitments *irma.IssueCommitmentM
 	for i, proof := range commitments.Proofs {
 		pubkey := pubkeys[i]
 		schemeid := irma.NewIssuerIdentifier(pubkey.Issuer).SchemeManagerIdentifier()
-		if session.conf.IrmaConfiguration.SchemeManagers[schemeid].Distributed() {
+		if session.irmaConfiguration.SchemeManagers[schemeid].Distributed() {
 			proofP, err := session.getProofP(commitments, schemeid)
 			if err != nil {
 				return nil, session.fail(server.ErrorKeyshareProofMissing, err.Error())
@@ -174,7 +180,7 @@ func (session *session) handlePostCommitments(commitments *irma.IssueCommitmentM
 	// Verify all proofs and check disclosed attributes, if any, against request
 	now := time.Now()
 	session.result.Disclosed, session.result.ProofStatus, err = commitments.Disclosure().VerifyAgainstRequest(
-		session.conf.IrmaConfiguration, request, request.GetContext(), request.GetNonce(nil), pubkeys, &now, false,
+		session.irmaConfiguration, request, request.GetContext(), request.GetNonce(nil), pubkeys, &now, false,
 	)
 	if err != nil {
 		if err == irma.ErrMissingPublicKey {
@@ -194,8 +200,8 @@ func (session *session) handlePostCommitments(commitments *irma.IssueCommitmentM
 	var sigs []*gabi.IssueSignatureMessage
 	for i, cred := range request.Credentials {
 		id := cred.CredentialTypeID.IssuerIdentifier()
-		pk, _ := session.conf.IrmaConfiguration.PublicKey(id, cred.KeyCounter)
-		sk, _ := session.conf.IrmaConfiguration.PrivateKeyLatest(id)
+		pk, _ := session.irmaConfiguration.PublicKey(id, cred.KeyCounter)
+		sk, _ := session.irmaConfiguration.PrivateKeyLatest(id)
 		issuer := gabi.NewIssuer(sk, pk, one)
 		proof, ok := commitments.Proofs[i+discloseCount].(*gabi.ProofU)
 		if !ok {
@@ -212,6 +218,9 @@ func (session *session) handlePostCommitments(commitments *irma.IssueCommitmentM
 		sigs = append(sigs, sig)
 	}
 
+	if err = session.createConsentReceipt(); err != nil {
+		return nil, session.fail(server.ErrorUnknown, err.Error())
+	}
 	session.setStatus(server.StatusDone)
 	return sigs, nil
 }
@@ -227,8 +236,19 @@ func (s *Server) handleSessionCommitments(w http.ResponseWriter, r *http.Request
 		server.WriteError(w, server.ErrorMalformedInput, err.Error())
 		return
 	}
-	res, rerr := r.Context().Value("session").(*session).handlePostCommitments(commitments)
-	server.WriteResponse(w, res, rerr)
+	session := r.Context().Value("session").(*session)
+	sigs, rerr := session.handlePostCommitments(commitments)
+	if rerr != nil || session.version.Below(2, 7) {
+		server.WriteResponse(w, sigs, rerr)
+		return
+	}
+	next, rerr := s.startNextSession(session)
+	server.WriteResponse(w, &irma.ServerSessionResponse{
+		ProofStatus:     irma.ProofStatusValid,
+		IssueSignatures: sigs,
+		NextSession:     next,
+		ConsentReceipt:  session.result.ConsentReceipt,
+	}, rerr)
 }
 
 func (s *Server) handleSessionProofs(w http.ResponseWriter, r *http.Request) {
@@ -238,7 +258,7 @@ func (s *Server) handleSessionProofs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	session := r.Context().Value("session").(*session)
-	var res interface{}
+	var res *irma.ProofStatus
 	var rerr *irma.RemoteError
 	switch session.action {
 	case irma.ActionDisclosing:
@@ -258,7 +278,16 @@ func (s *Server) handleSessionProofs(w http.ResponseWriter, r *http.Request) {
 	default:
 		rerr = server.RemoteError(server.ErrorInvalidRequest, "")
 	}
-	server.WriteResponse(w, res, rerr)
+	if rerr != nil || session.version.Below(2, 7) {
+		server.WriteResponse(w, res, rerr)
+		return
+	}
+	next, rerr := s.startNextSession(session)
+	server.WriteResponse(w, &irma.ServerSessionResponse{
+		ProofStatus:    *res,
+		NextSession:    next,
+		ConsentReceipt: session.result.ConsentReceipt,
+	}, rerr)
 }
 
 func (s *Server) handleSessionStatus(w http.ResponseWriter, r *http.Request) {
@@ -301,12 +330,30 @@ func (s *Server) handleSessionGet(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleStaticMessage(w http.ResponseWriter, r *http.Request) {
-	rrequest := s.conf.StaticSessionRequests[chi.URLParam(r, "name")]
+	name := chi.URLParam(r, "name")
+	rrequest := s.conf.StaticSessionRequest(name)
 	if rrequest == nil {
-		server.WriteResponse(w, nil, server.RemoteError(server.ErrorInvalidRequest, "unknown static session"))
-		return
+		template := s.conf.SessionTemplates[name]
+		if template == nil || !template.Static {
+			server.WriteResponse(w, nil, server.RemoteError(server.ErrorInvalidRequest, "unknown static session"))
+			return
+		}
+		// Parameters of static templates are taken from the query string of the static QR URL
+		params := map[string]string{}
+		for key, values := range r.URL.Query() {
+			params[key] = values[0]
+		}
+		var err error
+		if rrequest, err = template.Instantiate(params); err != nil {
+			server.WriteResponse(w, nil, server.RemoteError(server.ErrorInvalidRequest, err.Error()))
+			return
+		}
 	}
 	qr, _, err := s.StartSession(rrequest, s.doResultCallback)
+	if rerr, ok := err.(*irma.RemoteError); ok {
+		server.WriteResponse(w, nil, rerr)
+		return
+	}
 	if err != nil {
 		server.WriteResponse(w, nil, server.RemoteError(server.ErrorMalformedInput, err.Error()))
 		return
@@ -423,3 +470,30 @@ func (s *Server) handleRevocationPostIssuanceRecord(w http.ResponseWriter, r *ht
 	w.WriteHeader(200)
 	return
 }
+
+// POST revocation/{id}/replicate
+func (s *Server) handleRevocationPostReplication(w http.ResponseWriter, r *http.Request) {
+	cred := irma.NewCredentialTypeIdentifier(chi.URLParam(r, "id"))
+	rev := s.conf.IrmaConfiguration.Revocation
+
+	if !rev.AuthorizeReplicationPeer(r.Header.Get("Authorization")) {
+		server.WriteBinaryResponse(w, nil, server.RemoteError(server.ErrorUnauthorized, "not a replica of this server"))
+		return
+	}
+	bts, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		server.WriteBinaryResponse(w, nil, server.RemoteError(server.ErrorInvalidRequest, err.Error()))
+		return
+	}
+	var msg irma.RevocationReplicationMessage
+	if err = irma.UnmarshalBinary(bts, &msg); err != nil {
+		server.WriteBinaryResponse(w, nil, server.RemoteError(server.ErrorMalformedInput, err.Error()))
+		return
+	}
+
+	if err = rev.ReceiveReplication(cred, &msg); err != nil {
+		server.WriteBinaryResponse(w, nil, server.RemoteError(server.ErrorRevocation, err.Error()))
+		return
+	}
+	w.WriteHeader(200)
+}
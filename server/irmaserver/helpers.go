@@ -10,6 +10,7 @@ import (
 	"log"
 	"net/http"
 	"reflect"
+	"sync/atomic"
 	"time"
 
 	"github.com/alexandrevicenzi/go-sse"
@@ -70,6 +71,10 @@ func (session *session) chooseProtocolVersion(minClient, maxClient *irma.Protoco
 	if len(session.request.Base().Revocation) > 0 {
 		minServer = &irma.ProtocolVersion{2, 6}
 	}
+	// Set minimum to 2.7 if a follow-up session is to be started, or if the client must show purposes
+	if session.rrequest.Base().NextSession != nil || session.request.Disclosure().HasPurposes() {
+		minServer = &irma.ProtocolVersion{Major: 2, Minor: 7}
+	}
 
 	if minClient.AboveVersion(maxProtocolVersion) || maxClient.BelowVersion(minServer) || maxClient.BelowVersion(minClient) {
 		err := errors.Errorf("Protocol version negotiation failed, min=%s max=%s minServer=%s maxServer=%s", minClient.String(), maxClient.String(), minServer.String(), maxProtocolVersion.String())
@@ -101,17 +106,37 @@ func (session *session) checkCache(message []byte) (int, []byte) {
 	return session.responseCache.status, session.responseCache.response
 }
 
+// createConsentReceipt signs a consent receipt into the session result, if the session request
+// specified purposes and the disclosed attributes are valid.
+func (session *session) createConsentReceipt() error {
+	if session.result.ProofStatus != irma.ProofStatusValid {
+		return nil
+	}
+	receipt := irma.NewConsentReceipt(session.conf.JwtIssuer, session.request, session.result.Disclosed)
+	if receipt == nil {
+		return nil
+	}
+	if session.conf.JwtRSAPrivateKey == nil {
+		session.conf.Logger.WithFields(logrus.Fields{"session": session.token}).
+			Warn("Session request specified purposes but no JWT private key is installed to sign consent receipt")
+		return nil
+	}
+	var err error
+	session.result.ConsentReceipt, err = receipt.Sign(session.conf.JwtRSAPrivateKey)
+	return err
+}
+
 // Issuance helpers
 
 func (session *session) computeWitness(sk *gabi.PrivateKey, cred *irma.CredentialRequest) (*revocation.Witness, error) {
 	id := cred.CredentialTypeID
-	credtyp := session.conf.IrmaConfiguration.CredentialTypes[id]
+	credtyp := session.irmaConfiguration.CredentialTypes[id]
 	if !credtyp.RevocationSupported() || !session.request.Base().RevocationSupported() {
 		return nil, nil
 	}
 
 	// ensure the client always gets an up to date nonrevocation witness
-	rs := session.conf.IrmaConfiguration.Revocation
+	rs := session.irmaConfiguration.Revocation
 	if err := rs.SyncDB(id); err != nil {
 		return nil, err
 	}
@@ -158,7 +183,7 @@ func (session *session) computeAttributes(
 		nonrevAttr = witness.E
 	}
 
-	attributes, err := cred.AttributeList(session.conf.IrmaConfiguration, 0x03, nonrevAttr)
+	attributes, err := cred.AttributeList(session.irmaConfiguration, 0x03, nonrevAttr)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -172,7 +197,7 @@ func (session *session) computeAttributes(
 		ValidUntil: attributes.Expiry().UnixNano(),
 	}
 	if witness != nil {
-		err = session.conf.IrmaConfiguration.Revocation.SaveIssuanceRecord(id, issrecord, sk)
+		err = session.irmaConfiguration.Revocation.SaveIssuanceRecord(id, issrecord, sk)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -182,17 +207,18 @@ func (session *session) computeAttributes(
 }
 
 func (s *Server) validateIssuanceRequest(request *irma.IssuanceRequest) error {
+	conf := s.conf.IrmaConfiguration.Snapshot()
 	for _, cred := range request.Credentials {
 		// Check that we have the appropriate private key
 		iss := cred.CredentialTypeID.IssuerIdentifier()
-		privatekey, err := s.conf.IrmaConfiguration.PrivateKeyLatest(iss)
+		privatekey, err := conf.PrivateKeyLatest(iss)
 		if err != nil {
 			return err
 		}
 		if privatekey == nil {
 			return errors.Errorf("missing private key of issuer %s", iss.String())
 		}
-		pubkey, err := s.conf.IrmaConfiguration.PublicKey(iss, privatekey.Counter)
+		pubkey, err := conf.PublicKey(iss, privatekey.Counter)
 		if err != nil {
 			return err
 		}
@@ -201,7 +227,7 @@ func (s *Server) validateIssuanceRequest(request *irma.IssuanceRequest) error {
 		}
 		cred.KeyCounter = privatekey.Counter
 
-		if s.conf.IrmaConfiguration.CredentialTypes[cred.CredentialTypeID].RevocationSupported() {
+		if conf.CredentialTypes[cred.CredentialTypeID].RevocationSupported() {
 			settings := s.conf.RevocationSettings[cred.CredentialTypeID]
 			if settings == nil || (settings.RevocationServerURL == "" && !settings.Server) {
 				return errors.Errorf("revocation enabled for %s but no revocation server configured", cred.CredentialTypeID)
@@ -212,7 +238,7 @@ func (s *Server) validateIssuanceRequest(request *irma.IssuanceRequest) error {
 		}
 
 		// Check that the credential is consistent with irma_configuration
-		if err := cred.Validate(s.conf.IrmaConfiguration); err != nil {
+		if err := cred.Validate(conf); err != nil {
 			return err
 		}
 
@@ -244,7 +270,7 @@ func (session *session) getProofP(commitments *irma.IssueCommitmentMessage, sche
 			jwt.StandardClaims
 			ProofP *gabi.ProofP
 		}{}
-		token, err := jwt.ParseWithClaims(str, claims, session.conf.IrmaConfiguration.KeyshareServerKeyFunc(scheme))
+		token, err := jwt.ParseWithClaims(str, claims, session.irmaConfiguration.KeyshareServerKeyFunc(scheme))
 		if err != nil {
 			return nil, err
 		}
@@ -264,22 +290,72 @@ func (s *Server) doResultCallback(result *server.SessionResult) {
 	if url == "" {
 		return
 	}
-	server.DoResultCallback(url,
+	server.DoResultCallbackWithTransport(url,
 		result,
 		s.conf.JwtIssuer,
 		s.GetRequest(result.Token).Base().ResultJwtValidity,
 		s.conf.JwtRSAPrivateKey,
+		s.conf.Transport,
 	)
 }
 
+// startNextSession starts the follow-up session of the specified successfully finished session,
+// if the requestor specified one, returning the session pointer of the new session that is to be
+// sent to the client. As this involves a request to the requestor, the session is unlocked in the
+// meantime, during which other requests to the session do not process its result (see
+// sessionMiddleware). Failure to start the follow-up session is reported to the client, but does
+// not affect the result of the finished session.
+func (s *Server) startNextSession(session *session) (*irma.Qr, *irma.RemoteError) {
+	base := session.rrequest.Base()
+	if base.NextSession == nil || session.result.ProofStatus != irma.ProofStatusValid {
+		return nil, nil
+	}
+
+	result := *session.result
+	handler, authorizer := s.handlers[session.token], session.nextAuthorizer
+	session.nextSessionPending = true
+	session.locked = false
+	session.Unlock()
+	qr, token, err := s.requestNextSession(base, &result, handler, authorizer)
+	session.Lock()
+	session.locked = true
+	session.nextSessionPending = false
+	if err != nil {
+		return nil, server.RemoteError(server.ErrorNextSession, err.Error())
+	}
+
+	session.conf.Logger.WithFields(logrus.Fields{"session": session.token, "next": token}).Info("Next session started")
+	session.result.NextSession = token
+	return qr, nil
+}
+
+// requestNextSession retrieves the follow-up session request from the NextSession URL of the
+// specified request, and starts it.
+func (s *Server) requestNextSession(
+	base irma.RequestorBaseRequest, result *server.SessionResult, handler server.SessionHandler, authorizer NextSessionAuthorizer,
+) (*irma.Qr, string, error) {
+	next, err := server.RequestNextSessionWithTransport(base.NextSession.URL, result,
+		s.conf.JwtIssuer, base.ResultJwtValidity, s.conf.JwtRSAPrivateKey, s.conf.Transport)
+	if err != nil {
+		return nil, "", err
+	}
+	if authorizer != nil {
+		if err = authorizer(next); err != nil {
+			return nil, "", err
+		}
+	}
+	return s.startSession(next, handler, authorizer)
+}
+
 func (s *Server) validateRequest(request irma.SessionRequest) error {
 	if _, err := s.conf.IrmaConfiguration.Download(request); err != nil {
 		return err
 	}
-	if err := request.Base().Validate(s.conf.IrmaConfiguration); err != nil {
+	conf := s.conf.IrmaConfiguration.Snapshot()
+	if err := request.Base().Validate(conf); err != nil {
 		return err
 	}
-	return request.Disclosure().Disclose.Validate(s.conf.IrmaConfiguration)
+	return request.Disclosure().Disclose.Validate(conf)
 }
 
 func copyObject(i interface{}) (interface{}, error) {
@@ -405,7 +481,8 @@ func (s *Server) sessionMiddleware(next http.Handler) http.Handler {
 		session.Lock()
 		session.locked = true
 		defer func() {
-			if session.prevStatus != session.status {
+			// While the follow-up session is being started, the request doing so processes the result
+			if !session.nextSessionPending && session.prevStatus != session.status {
 				session.prevStatus = session.status
 				result := session.result
 				r := ctx.Value("sessionresult")
@@ -414,7 +491,11 @@ func (s *Server) sessionMiddleware(next http.Handler) http.Handler {
 				}
 				if session.status.Finished() {
 					if handler := s.handlers[result.Token]; handler != nil {
-						go handler(result)
+						atomic.AddInt32(&s.pendingHandlers, 1)
+						go func() {
+							defer atomic.AddInt32(&s.pendingHandlers, -1)
+							handler(result)
+						}()
 						delete(s.handlers, token)
 					}
 				}
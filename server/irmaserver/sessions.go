@@ -37,8 +37,16 @@ type session struct {
 
 	kssProofs map[irma.SchemeManagerIdentifier]*gabi.ProofP
 
+	// checks the request of the follow-up session, if any; inherited by follow-up sessions
+	nextAuthorizer NextSessionAuthorizer
+	// whether the follow-up session is being started, during which the session is unlocked
+	nextSessionPending bool
+
 	conf     *server.Configuration
 	sessions sessionStore
+
+	// snapshot of conf.IrmaConfiguration, so that the schemes do not change during the session
+	irmaConfiguration *irma.Configuration
 }
 
 type responseCache struct {
@@ -54,6 +62,8 @@ type sessionStore interface {
 	add(session *session)
 	update(session *session)
 	deleteExpired()
+	count() map[server.Status]int
+	cancelInitialized() int
 	stop()
 }
 
@@ -67,12 +77,13 @@ type memorySessionStore struct {
 
 const (
 	maxSessionLifetime = 5 * time.Minute // After this a session is cancelled
+	drainPollInterval  = 100 * time.Millisecond
 	sessionChars       = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 )
 
 var (
 	minProtocolVersion = irma.NewVersion(2, 4)
-	maxProtocolVersion = irma.NewVersion(2, 6)
+	maxProtocolVersion = irma.NewVersion(2, 7)
 )
 
 func (s *memorySessionStore) get(t string) *session {
@@ -98,6 +109,35 @@ func (s *memorySessionStore) update(session *session) {
 	session.onUpdate()
 }
 
+func (s *memorySessionStore) count() map[server.Status]int {
+	s.RLock()
+	defer s.RUnlock()
+	counts := map[server.Status]int{}
+	for _, session := range s.requestor {
+		session.Lock()
+		counts[session.status]++
+		session.Unlock()
+	}
+	return counts
+}
+
+// cancelInitialized cancels the sessions that have not yet been started by a client,
+// returning how many there were.
+func (s *memorySessionStore) cancelInitialized() int {
+	s.RLock()
+	defer s.RUnlock()
+	cancelled := 0
+	for _, session := range s.requestor {
+		session.Lock()
+		if session.status == server.StatusInitialized {
+			session.handleDelete()
+			cancelled++
+		}
+		session.Unlock()
+	}
+	return cancelled
+}
+
 func (s *memorySessionStore) stop() {
 	s.Lock()
 	defer s.Unlock()
@@ -152,22 +192,24 @@ func (s *memorySessionStore) deleteExpired() {
 
 var one *big.Int = big.NewInt(1)
 
-func (s *Server) newSession(action irma.Action, request irma.RequestorRequest) *session {
+func (s *Server) newSession(action irma.Action, request irma.RequestorRequest, authorizer NextSessionAuthorizer) *session {
 	token := newSessionToken()
 	clientToken := newSessionToken()
 
 	ses := &session{
-		action:      action,
-		rrequest:    request,
-		request:     request.SessionRequest(),
-		lastActive:  time.Now(),
-		token:       token,
-		clientToken: clientToken,
-		status:      server.StatusInitialized,
-		prevStatus:  server.StatusInitialized,
-		conf:        s.conf,
-		sessions:    s.sessions,
-		sse:         s.serverSentEvents,
+		action:            action,
+		rrequest:          request,
+		request:           request.SessionRequest(),
+		lastActive:        time.Now(),
+		token:             token,
+		clientToken:       clientToken,
+		status:            server.StatusInitialized,
+		prevStatus:        server.StatusInitialized,
+		conf:              s.conf,
+		irmaConfiguration: s.conf.IrmaConfiguration.Snapshot(),
+		sessions:          s.sessions,
+		sse:               s.serverSentEvents,
+		nextAuthorizer:    authorizer,
 		result: &server.SessionResult{
 			LegacySession: request.SessionRequest().Base().Legacy(),
 			Token:         token,
@@ -0,0 +1,377 @@
+// Package requestorclient is a client for the requestor HTTP API of the IRMA server
+// (see the requestorserver package and "irma server"): it starts IRMA sessions, follows their
+// status, retrieves session results (optionally as JWTs verified against the server's public
+// key), cancels sessions, and revokes credentials.
+package requestorclient
+
+import (
+	"context"
+	"crypto/rsa"
+	"strings"
+	"sync"
+	"time"
+
+	sseclient "astuart.co/go-sse"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/server"
+	"github.com/privacybydesign/irmago/server/requestorserver"
+)
+
+// PollInterval is the interval at which the session status is polled if the server
+// does not support server-sent events.
+var PollInterval = 1000 * time.Millisecond
+
+// PollMaxFailures is the number of consecutive failures to poll the session status after which
+// Subscribe() gives up following the session status (see Session.Err()).
+var PollMaxFailures = 10
+
+// Client is a client for the requestor HTTP API of an IRMA server.
+type Client struct {
+	// Authentication method and key with which session and revocation requests are authenticated
+	// to the server. The type of the key depends on the method: none (nil), token (string),
+	// hmac ([]byte) or publickey (*rsa.PrivateKey).
+	Method requestorserver.AuthenticationMethod
+	Key    interface{}
+	// Name of the requestor, used as the issuer of JWTs
+	Name string
+
+	url string
+
+	serverKeyMutex sync.Mutex // protects serverKey
+	serverKey      *rsa.PublicKey
+}
+
+// Session is an IRMA session started at the IRMA server.
+type Session struct {
+	Token string
+	// Session pointer to be passed to the IRMA app (e.g. in a QR), if the session was started by
+	// this client
+	SessionPtr *irma.Qr
+
+	client    *Client
+	transport *irma.HTTPTransport
+
+	mutex sync.Mutex // protects err
+	err   error
+}
+
+// New returns a client for the IRMA server at the specified URL, checking that the key
+// is suitable for the specified authentication method.
+func New(url string, method requestorserver.AuthenticationMethod, key interface{}, name string) (*Client, error) {
+	var ok bool
+	switch method {
+	case requestorserver.AuthenticationMethodNone:
+		ok = key == nil
+	case requestorserver.AuthenticationMethodToken:
+		_, ok = key.(string)
+	case requestorserver.AuthenticationMethodHmac:
+		_, ok = key.([]byte)
+	case requestorserver.AuthenticationMethodPublicKey:
+		_, ok = key.(*rsa.PrivateKey)
+	default:
+		return nil, errors.Errorf("Invalid authentication method %s (must be none, token, hmac or publickey)", method)
+	}
+	if !ok {
+		return nil, errors.Errorf("Invalid key for authentication method %s", method)
+	}
+	if !strings.HasSuffix(url, "/") {
+		url += "/"
+	}
+	return &Client{Method: method, Key: key, Name: name, url: url}, nil
+}
+
+// StartSession starts an IRMA session at the server.
+func (c *Client) StartSession(request irma.RequestorRequest) (*Session, error) {
+	var body interface{} = request
+	if c.signs() {
+		j, err := c.SignRequest(request)
+		if err != nil {
+			return nil, err
+		}
+		body = j
+	}
+
+	pkg := &server.SessionPackage{}
+	if err := c.transport().Post("session", pkg, body); err != nil {
+		return nil, errors.WrapPrefix(err, "Failed to start session", 0)
+	}
+	session := c.Session(pkg.Token)
+	session.SessionPtr = pkg.SessionPtr
+	return session, nil
+}
+
+// StartTemplateSession starts an instance of the session template with the specified name that is
+// configured at the server, filling in its placeholders with the specified parameters.
+func (c *Client) StartTemplateSession(name string, params map[string]string) (*Session, error) {
+	var body interface{} = params
+	if c.signs() {
+		alg, err := c.signingMethod()
+		if err != nil {
+			return nil, err
+		}
+		j := irma.SessionTemplateJwt{
+			ServerJwt: irma.ServerJwt{
+				Type:       "template_request",
+				ServerName: c.Name,
+				IssuedAt:   irma.Timestamp(time.Now()),
+			},
+			Template:   name,
+			Parameters: params,
+		}
+		if body, err = j.Sign(alg, c.Key); err != nil {
+			return nil, err
+		}
+	}
+
+	pkg := &server.SessionPackage{}
+	if err := c.transport().Post("session/template/"+name, pkg, body); err != nil {
+		return nil, errors.WrapPrefix(err, "Failed to start session from template "+name, 0)
+	}
+	session := c.Session(pkg.Token)
+	session.SessionPtr = pkg.SessionPtr
+	return session, nil
+}
+
+// SignRequest signs the specified request into a JWT using the hmac or publickey key of the
+// client, as done by StartSession.
+func (c *Client) SignRequest(request irma.RequestorRequest) (string, error) {
+	alg, err := c.signingMethod()
+	if err != nil {
+		return "", err
+	}
+	return irma.SignRequestorRequest(request, alg, c.Key, c.Name)
+}
+
+// Session returns the session with the specified token, e.g. of a session started earlier.
+func (c *Client) Session(token string) *Session {
+	return &Session{
+		Token:     token,
+		client:    c,
+		transport: irma.NewHTTPTransport(c.url + "session/" + token + "/"),
+	}
+}
+
+// ServerPublicKey retrieves the public key with which the server signs session result JWTs.
+func (c *Client) ServerPublicKey() (*rsa.PublicKey, error) {
+	c.serverKeyMutex.Lock()
+	defer c.serverKeyMutex.Unlock()
+	if c.serverKey != nil {
+		return c.serverKey, nil
+	}
+	bts, err := c.transport().GetBytes("publickey")
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "Failed to retrieve server public key", 0)
+	}
+	pk, err := jwt.ParseRSAPublicKeyFromPEM(bts)
+	if err != nil {
+		return nil, err
+	}
+	c.serverKey = pk
+	return pk, nil
+}
+
+// Revoke revokes the credential(s) specified by the revocation request. The server must be the
+// revocation server of the credential type.
+func (c *Client) Revoke(request *irma.RevocationRequest) error {
+	if request.LDContext == "" {
+		request.LDContext = irma.LDContextRevocationRequest
+	}
+	var body interface{} = request
+	if c.signs() {
+		alg, err := c.signingMethod()
+		if err != nil {
+			return err
+		}
+		j := irma.RevocationJwt{
+			ServerJwt: irma.ServerJwt{
+				ServerName: c.Name,
+				IssuedAt:   irma.Timestamp(time.Now()),
+			},
+			Request: request,
+		}
+		if body, err = j.Sign(alg, c.Key); err != nil {
+			return err
+		}
+	}
+	if err := c.transport().Post("revocation", nil, body); err != nil {
+		return errors.WrapPrefix(err, "Failed to revoke", 0)
+	}
+	return nil
+}
+
+func (c *Client) transport() *irma.HTTPTransport {
+	transport := irma.NewHTTPTransport(c.url)
+	if c.Method == requestorserver.AuthenticationMethodToken {
+		transport.SetHeader("Authorization", c.Key.(string))
+	}
+	return transport
+}
+
+func (c *Client) signs() bool {
+	return c.Method == requestorserver.AuthenticationMethodHmac || c.Method == requestorserver.AuthenticationMethodPublicKey
+}
+
+func (c *Client) signingMethod() (jwt.SigningMethod, error) {
+	switch c.Method {
+	case requestorserver.AuthenticationMethodHmac:
+		return jwt.SigningMethodHS256, nil
+	case requestorserver.AuthenticationMethodPublicKey:
+		return jwt.SigningMethodRS256, nil
+	default:
+		return nil, errors.Errorf("Authentication method %s does not sign requests", c.Method)
+	}
+}
+
+// Status retrieves the current status of the session.
+func (s *Session) Status() (server.Status, error) {
+	var status server.Status
+	if err := s.transport.Get("status", &status); err != nil {
+		return "", errors.WrapPrefix(err, "Failed to get session status", 0)
+	}
+	return status, nil
+}
+
+// Subscribe sends the status of the session to the specified channel each time it changes, using
+// server-sent events or, if the server does not support these, polling. The channel is closed after
+// the session has finished, or after polling failed too often (see Err()). Subscribe returns
+// immediately.
+func (s *Session) Subscribe(statuschan chan<- server.Status) {
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan *sseclient.Event)
+	errchan := make(chan error, 1)
+	go func() {
+		errchan <- sseclient.Notify(ctx, s.transport.Server+"statusevents", true, events)
+	}()
+
+	go func() {
+		defer close(statuschan)
+		defer cancel()
+		for {
+			select {
+			case e := <-events:
+				if e == nil || e.Type == "open" {
+					continue
+				}
+				status := server.Status(strings.Trim(string(e.Data), `"`))
+				statuschan <- status
+				if status.Finished() {
+					// Keep receiving events until Notify notices the cancellation of its context
+					go func() {
+						for {
+							select {
+							case <-events:
+							case <-errchan:
+								return
+							}
+						}
+					}()
+					return
+				}
+			case err := <-errchan:
+				if err != nil {
+					irma.Logger.Info("Server-sent events failed, falling back to polling: ", err.Error())
+				}
+				s.poll(statuschan)
+				return
+			}
+		}
+	}()
+}
+
+// poll polls the session status until it has finished, or until polling failed PollMaxFailures
+// times in a row.
+func (s *Session) poll(statuschan chan<- server.Status) {
+	var previous server.Status
+	failures := 0
+	for {
+		status, err := s.Status()
+		if err != nil {
+			irma.Logger.Warn("Failed to poll session status: ", err.Error())
+			if failures++; failures >= PollMaxFailures {
+				s.mutex.Lock()
+				s.err = errors.WrapPrefix(err, "Stopped following session status", 0)
+				s.mutex.Unlock()
+				return
+			}
+		} else {
+			failures = 0
+			if status != previous {
+				statuschan <- status
+				previous = status
+			}
+		}
+		if status.Finished() {
+			return
+		}
+		<-time.NewTimer(PollInterval).C
+	}
+}
+
+// Err returns the error because of which Subscribe() stopped following the session status before
+// the session finished, if any.
+func (s *Session) Err() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.err
+}
+
+// Wait waits until the session has finished, and returns its result.
+func (s *Session) Wait() (*server.SessionResult, error) {
+	statuschan := make(chan server.Status)
+	s.Subscribe(statuschan)
+	for range statuschan {
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return s.Result()
+}
+
+// Result retrieves the result of the session.
+func (s *Session) Result() (*server.SessionResult, error) {
+	result := &server.SessionResult{}
+	if err := s.transport.Get("result", result); err != nil {
+		return nil, errors.WrapPrefix(err, "Failed to get session result", 0)
+	}
+	return result, nil
+}
+
+// ResultJwt retrieves the result of the session as a JWT signed by the server, and verifies it
+// against the public key of the server. It returns the parsed result along with the JWT.
+func (s *Session) ResultJwt() (*server.SessionResult, string, error) {
+	var j string
+	if err := s.transport.Get("result-jwt", &j); err != nil {
+		return nil, "", errors.WrapPrefix(err, "Failed to get session result JWT", 0)
+	}
+	pk, err := s.client.ServerPublicKey()
+	if err != nil {
+		return nil, "", err
+	}
+	claims := &struct {
+		jwt.StandardClaims
+		*server.SessionResult
+	}{}
+	_, err = jwt.ParseWithClaims(j, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, errors.Errorf("Unexpected signing method %v", token.Header["alg"])
+		}
+		return pk, nil
+	})
+	if err != nil {
+		return nil, "", errors.WrapPrefix(err, "Invalid session result JWT", 0)
+	}
+	if claims.SessionResult == nil || claims.SessionResult.Token != s.Token {
+		return nil, "", errors.New("Session result JWT does not belong to this session")
+	}
+	return claims.SessionResult, j, nil
+}
+
+// Cancel cancels the session.
+func (s *Session) Cancel() error {
+	if err := s.transport.Delete(); err != nil {
+		return errors.WrapPrefix(err, "Failed to cancel session", 0)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package requestorclient
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/server"
+	"github.com/privacybydesign/irmago/server/requestorserver"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeServer mimics the requestor endpoints of the IRMA server for a single session,
+// without server-sent events so that the client falls back to polling.
+type fakeServer struct {
+	t      *testing.T
+	sk     *rsa.PrivateKey
+	hmac   []byte
+	mutex  sync.Mutex
+	status server.Status
+	revoke *irma.RevocationRequest
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	switch r.Method + " " + r.URL.Path {
+	case "POST /session":
+		require.Equal(f.t, "mytoken", r.Header.Get("Authorization"))
+		request, err := server.ParseSessionRequest(readBody(f.t, r))
+		require.NoError(f.t, err)
+		require.Equal(f.t, irma.ActionDisclosing, request.SessionRequest().Action())
+		f.status = server.StatusInitialized
+		writeJSON(f.t, w, &server.SessionPackage{Token: "token", SessionPtr: &irma.Qr{URL: "url", Type: irma.ActionDisclosing}})
+	case "GET /session/token/status":
+		writeJSON(f.t, w, f.status)
+		// Progress the session each time its status is polled
+		switch f.status {
+		case server.StatusInitialized:
+			f.status = server.StatusConnected
+		case server.StatusConnected:
+			f.status = server.StatusDone
+		}
+	case "GET /session/token/result":
+		writeJSON(f.t, w, f.result())
+	case "GET /session/token/result-jwt":
+		j, err := server.ResultJwt(f.result(), "irmaserver", 60, f.sk)
+		require.NoError(f.t, err)
+		_, _ = w.Write([]byte(j))
+	case "GET /publickey":
+		bts, err := x509.MarshalPKIXPublicKey(&f.sk.PublicKey)
+		require.NoError(f.t, err)
+		_, _ = w.Write(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: bts}))
+	case "DELETE /session/token/":
+		f.status = server.StatusCancelled
+	case "POST /revocation":
+		claims := &irma.RevocationJwt{}
+		_, err := jwt.ParseWithClaims(string(readBody(f.t, r)), claims, func(*jwt.Token) (interface{}, error) {
+			return f.hmac, nil
+		})
+		require.NoError(f.t, err)
+		require.Equal(f.t, "requestor", claims.ServerName)
+		f.revoke = claims.Request
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeServer) result() *server.SessionResult {
+	return &server.SessionResult{Token: "token", Status: f.status, Type: irma.ActionDisclosing, ProofStatus: irma.ProofStatusValid}
+}
+
+func readBody(t *testing.T, r *http.Request) []byte {
+	bts, err := ioutil.ReadAll(r.Body)
+	require.NoError(t, err)
+	return bts
+}
+
+func writeJSON(t *testing.T, w http.ResponseWriter, o interface{}) {
+	bts, err := json.Marshal(o)
+	require.NoError(t, err)
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(bts)
+}
+
+func TestClient(t *testing.T) {
+	PollInterval = 10 * time.Millisecond
+	sk, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	f := &fakeServer{t: t, sk: sk, hmac: []byte("secret")}
+	s := httptest.NewServer(f)
+	defer s.Close()
+
+	_, err = New(s.URL, requestorserver.AuthenticationMethodHmac, "not a []byte", "requestor")
+	require.Error(t, err)
+
+	client, err := New(s.URL, requestorserver.AuthenticationMethodToken, "mytoken", "requestor")
+	require.NoError(t, err)
+	session, err := client.StartSession(&irma.ServiceProviderRequest{Request: irma.NewDisclosureRequest(
+		irma.NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID"),
+	)})
+	require.NoError(t, err)
+	require.Equal(t, "token", session.Token)
+	require.Equal(t, "url", session.SessionPtr.URL)
+
+	result, err := session.Wait()
+	require.NoError(t, err)
+	require.Equal(t, server.StatusDone, result.Status)
+	require.Equal(t, irma.ProofStatusValid, result.ProofStatus)
+
+	result, j, err := session.ResultJwt()
+	require.NoError(t, err)
+	require.NotEmpty(t, j)
+	require.Equal(t, "token", result.Token)
+	require.Equal(t, server.StatusDone, result.Status)
+
+	// The server public key may be retrieved concurrently
+	keyclient, err := New(s.URL, requestorserver.AuthenticationMethodNone, nil, "requestor")
+	require.NoError(t, err)
+	keys := make(chan *rsa.PublicKey, 4)
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		go func() {
+			pk, err := keyclient.ServerPublicKey()
+			keys <- pk
+			errs <- err
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		require.NoError(t, <-errs)
+		require.Equal(t, &f.sk.PublicKey, <-keys)
+	}
+
+	// A result JWT signed by another key is rejected
+	client.serverKey, err = jwtPublicKey()
+	require.NoError(t, err)
+	_, _, err = session.ResultJwt()
+	require.Error(t, err)
+
+	require.NoError(t, session.Cancel())
+	status, err := client.Session("token").Status()
+	require.NoError(t, err)
+	require.Equal(t, server.StatusCancelled, status)
+	require.Error(t, client.Session("unknown").Cancel())
+
+	client, err = New(s.URL, requestorserver.AuthenticationMethodHmac, f.hmac, "requestor")
+	require.NoError(t, err)
+	require.NoError(t, client.Revoke(&irma.RevocationRequest{
+		CredentialType: irma.NewCredentialTypeIdentifier("irma-demo.MijnOverheid.root"),
+		Key:            "12345",
+	}))
+	require.NotNil(t, f.revoke)
+	require.Equal(t, "12345", f.revoke.Key)
+}
+
+func jwtPublicKey() (*rsa.PublicKey, error) {
+	sk, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return &sk.PublicKey, nil
+}
+
+func TestPollFailures(t *testing.T) {
+	PollInterval = 10 * time.Millisecond
+	var polls int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/session/token/status" {
+			atomic.AddInt32(&polls, 1)
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer s.Close()
+
+	client, err := New(s.URL, requestorserver.AuthenticationMethodToken, "mytoken", "requestor")
+	require.NoError(t, err)
+	session := client.Session("token")
+	_, err = session.Wait()
+	require.Error(t, err)
+	require.Equal(t, session.Err(), err)
+	require.Equal(t, int32(PollMaxFailures), atomic.LoadInt32(&polls))
+}
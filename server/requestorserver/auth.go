@@ -1,6 +1,7 @@
 package requestorserver
 
 import (
+	"encoding/json"
 	"net/http"
 	"strings"
 	"time"
@@ -32,6 +33,12 @@ type Authenticator interface {
 	AuthenticateRevocation(
 		headers http.Header, body []byte,
 	) (applies bool, request *irma.RevocationRequest, requestor string, err *irma.RemoteError)
+
+	// AuthenticateTemplate is like AuthenticateSession for requests starting an instance of the
+	// session template with the specified name, returning the parameters of the instance.
+	AuthenticateTemplate(
+		headers http.Header, body []byte, name string,
+	) (applies bool, params map[string]string, requestor string, err *irma.RemoteError)
 }
 
 type AuthenticationMethod string
@@ -83,6 +90,14 @@ func (NilAuthenticator) AuthenticateRevocation(headers http.Header, body []byte)
 	return true, r, "", nil
 }
 
+func (NilAuthenticator) AuthenticateTemplate(headers http.Header, body []byte, name string) (bool, map[string]string, string, *irma.RemoteError) {
+	if headers.Get("Authorization") != "" || !strings.HasPrefix(headers.Get("Content-Type"), "application/json") {
+		return false, nil, "", nil
+	}
+	params, rerr := parseTemplateParameters(body)
+	return true, params, "", rerr
+}
+
 func (NilAuthenticator) Initialize(name string, requestor Requestor) error {
 	return nil
 }
@@ -97,6 +112,10 @@ func (hauth *HmacAuthenticator) AuthenticateRevocation(headers http.Header, body
 	return jwtAutheticateRevocation(headers, body, jwt.SigningMethodHS256.Name, hauth.hmackeys, hauth.maxRequestAge)
 }
 
+func (hauth *HmacAuthenticator) AuthenticateTemplate(headers http.Header, body []byte, name string) (bool, map[string]string, string, *irma.RemoteError) {
+	return jwtAuthenticateTemplate(headers, body, name, jwt.SigningMethodHS256.Name, hauth.hmackeys, hauth.maxRequestAge)
+}
+
 func (hauth *HmacAuthenticator) Initialize(name string, requestor Requestor) error {
 	bts, err := common.ReadKey(requestor.AuthenticationKey, requestor.AuthenticationKeyFile)
 	if err != nil {
@@ -124,6 +143,10 @@ func (pkauth *PublicKeyAuthenticator) AuthenticateRevocation(headers http.Header
 	return jwtAutheticateRevocation(headers, body, jwt.SigningMethodRS256.Name, pkauth.publickeys, pkauth.maxRequestAge)
 }
 
+func (pkauth *PublicKeyAuthenticator) AuthenticateTemplate(headers http.Header, body []byte, name string) (bool, map[string]string, string, *irma.RemoteError) {
+	return jwtAuthenticateTemplate(headers, body, name, jwt.SigningMethodRS256.Name, pkauth.publickeys, pkauth.maxRequestAge)
+}
+
 func (pkauth *PublicKeyAuthenticator) Initialize(name string, requestor Requestor) error {
 	bts, err := common.ReadKey(requestor.AuthenticationKey, requestor.AuthenticationKeyFile)
 	if err != nil {
@@ -173,6 +196,19 @@ func (pskauth *PresharedKeyAuthenticator) AuthenticateRevocation(headers http.He
 	return true, r, requestor, nil
 }
 
+func (pskauth *PresharedKeyAuthenticator) AuthenticateTemplate(headers http.Header, body []byte, name string) (bool, map[string]string, string, *irma.RemoteError) {
+	auth := headers.Get("Authorization")
+	if auth == "" || !strings.HasPrefix(headers.Get("Content-Type"), "application/json") {
+		return false, nil, "", nil
+	}
+	requestor, ok := pskauth.presharedkeys[auth]
+	if !ok {
+		return true, nil, "", server.RemoteError(server.ErrorUnauthorized, "")
+	}
+	params, rerr := parseTemplateParameters(body)
+	return true, params, requestor, rerr
+}
+
 func (pskauth *PresharedKeyAuthenticator) Initialize(name string, requestor Requestor) error {
 	bts, err := common.ReadKey(requestor.AuthenticationKey, requestor.AuthenticationKeyFile)
 	if err != nil {
@@ -253,6 +289,44 @@ func jwtAutheticateRevocation(
 	return true, s.Request, s.ServerName, nil
 }
 
+func jwtAuthenticateTemplate(
+	headers http.Header, body []byte, name string, signatureAlg string, keys map[string]interface{}, maxRequestAge int,
+) (bool, map[string]string, string, *irma.RemoteError) {
+	if !jwtApplies(headers, body, signatureAlg) {
+		return false, nil, "", nil
+	}
+
+	// As in jwtAuthenticate, verify the signature and age of the JWT first using the standard claims
+	claims := &jwt.StandardClaims{}
+	if _, err := jwt.ParseWithClaims(string(body), claims, jwtKeyExtractor(keys)); err != nil {
+		return true, nil, "", server.RemoteError(server.ErrorInvalidRequest, err.Error())
+	}
+	if time.Unix(claims.IssuedAt, 0).Add(time.Duration(maxRequestAge) * time.Second).Before(time.Now()) {
+		return true, nil, "", server.RemoteError(server.ErrorUnauthorized, "jwt too old")
+	}
+
+	parsed := &irma.SessionTemplateJwt{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(string(body), parsed); err != nil {
+		return true, nil, "", server.RemoteError(server.ErrorInvalidRequest, err.Error())
+	}
+	if err := parsed.Valid(); err != nil {
+		return true, nil, "", server.RemoteError(server.ErrorInvalidRequest, err.Error())
+	}
+	if parsed.Template != name {
+		return true, nil, "", server.RemoteError(server.ErrorInvalidRequest, "jwt is for a different session template")
+	}
+	return true, parsed.Parameters, claims.Issuer, nil
+}
+
+// parseTemplateParameters parses a JSON object mapping template parameters to their values.
+func parseTemplateParameters(body []byte) (map[string]string, *irma.RemoteError) {
+	var params map[string]string
+	if err := json.Unmarshal(body, &params); err != nil {
+		return nil, server.RemoteError(server.ErrorInvalidRequest, err.Error())
+	}
+	return params, nil
+}
+
 func jwtApplies(headers http.Header, body []byte, signatureAlg string) bool {
 	// Read JWT and check its type
 	if headers.Get("Authorization") != "" || !strings.HasPrefix(headers.Get("Content-Type"), "text/plain") {
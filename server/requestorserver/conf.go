@@ -50,10 +50,18 @@ type Configuration struct {
 	// Max age in seconds of a session request JWT (using iat field)
 	MaxRequestAge int `json:"max_request_age" mapstructure:"max_request_age"`
 
+	// Token with which the status endpoint can be accessed, in addition to the tokens of
+	// requestors using token authentication
+	StatusToken string `json:"status_token" mapstructure:"status_token"`
+
 	// Host files under this path as static files (leave empty to disable)
 	StaticPath string `json:"static_path" mapstructure:"static_path"`
 	// Host static files under this URL prefix
 	StaticPrefix string `json:"static_prefix" mapstructure:"static_prefix"`
+
+	// Max time in seconds that Shutdown() waits for unfinished sessions and their result callbacks;
+	// if 0, Shutdown() waits until they are done or its context is done
+	ShutdownTimeout int `json:"shutdown_timeout" mapstructure:"shutdown_timeout"`
 }
 
 // Permissions specify which attributes or credential a requestor may verify or issue.
@@ -255,7 +263,7 @@ func (conf *Configuration) initialize() error {
 		}
 	}
 
-	if len(conf.StaticSessions) != 0 && conf.JwtRSAPrivateKey == nil {
+	if (len(conf.StaticSessions) != 0 || len(conf.StaticTemplates()) != 0) && conf.JwtRSAPrivateKey == nil {
 		conf.Logger.Warn("Static sessions enabled and no JWT private key installed. Ensure that POSTs to the callback URLs of static sessions are trustworthy by keeping the callback URLs secret and by using HTTPS.")
 	}
 
@@ -6,6 +6,7 @@ package requestorserver
 
 import (
 	"context"
+	"crypto/subtle"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
@@ -13,6 +14,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -136,6 +139,21 @@ func (s *Server) Stop() {
 	}
 }
 
+// Shutdown gracefully stops the server. New sessions are refused with server.ErrorShuttingDown and
+// /ready reports that the server is not ready, while unfinished sessions are allowed to finish and
+// their result callbacks are done (see irmaserver.Server.Drain()), during at most ShutdownTimeout
+// seconds (if not 0) or until ctx is done. Then the server is stopped as with Stop().
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.conf.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(s.conf.ShutdownTimeout)*time.Second)
+		defer cancel()
+	}
+	err := s.irmaserv.Drain(ctx)
+	s.Stop()
+	return err
+}
+
 func New(config *Configuration) (*Server, error) {
 	irmaserv, err := irmaserver.New(config.Configuration)
 	if err != nil {
@@ -197,6 +215,7 @@ func (s *Server) Handler() http.Handler {
 		// Server routes
 		r.Route("/session", func(r chi.Router) {
 			r.Post("/", s.handleCreateSession)
+			r.Post("/template/{name}", s.handleTemplateSession)
 			r.Route("/{token}", func(r chi.Router) {
 				r.Delete("/", s.handleDelete)
 				r.Get("/status", s.handleStatus)
@@ -219,6 +238,11 @@ func (s *Server) Handler() http.Handler {
 		r.Post("/revocation", s.handleRevocation)
 	})
 
+	// Health endpoints are polled frequently by orchestrators and load balancers, so we don't log them
+	router.Get("/health", s.handleHealth)
+	router.Get("/ready", s.handleReady)
+	router.Get("/status", s.handleHealthStatus)
+
 	return router
 }
 
@@ -447,30 +471,80 @@ func (s *Server) handlePublicKey(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(pubBytes)
 }
 
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	server.WriteString(w, "OK")
+}
+
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	status := s.irmaserv.Readiness()
+	if !status.Ready {
+		var problems []string
+		for scheme, err := range status.DisabledSchemes {
+			problems = append(problems, fmt.Sprintf("scheme %s: %s", scheme, err))
+		}
+		if status.RevocationDBError != "" {
+			problems = append(problems, "revocation database: "+status.RevocationDBError)
+		}
+		if status.Draining {
+			problems = append(problems, "shutting down")
+		}
+		sort.Strings(problems)
+		server.WriteError(w, server.ErrorNotReady, strings.Join(problems, "; "))
+		return
+	}
+	server.WriteString(w, "OK")
+}
+
+func (s *Server) handleHealthStatus(w http.ResponseWriter, r *http.Request) {
+	if !s.conf.DisableRequestorAuthentication && !s.statusAuthorized(r.Header.Get("Authorization")) {
+		server.WriteError(w, server.ErrorUnauthorized, "")
+		return
+	}
+	server.WriteJson(w, s.irmaserv.HealthStatus())
+}
+
+// statusAuthorized checks that the Authorization header contains the configured status token, or
+// the token of a requestor using token authentication; other authentication methods cannot be
+// used for GET requests.
+func (s *Server) statusAuthorized(auth string) bool {
+	if auth == "" {
+		return false
+	}
+	if s.conf.StatusToken != "" && subtle.ConstantTimeCompare([]byte(auth), []byte(s.conf.StatusToken)) == 1 {
+		return true
+	}
+	pskauth, ok := authenticators[AuthenticationMethodToken].(*PresharedKeyAuthenticator)
+	if !ok {
+		return false
+	}
+	_, ok = pskauth.presharedkeys[auth]
+	return ok
+}
+
 func (s *Server) doResultCallback(result *server.SessionResult) {
 	url := s.irmaserv.GetRequest(result.Token).Base().CallbackURL
 	if url == "" {
 		return
 	}
-	server.DoResultCallback(url,
+	server.DoResultCallbackWithTransport(url,
 		result,
 		s.conf.JwtIssuer,
 		s.irmaserv.GetRequest(result.Token).Base().ResultJwtValidity,
 		s.conf.JwtRSAPrivateKey,
+		s.conf.Transport,
 	)
 }
 
-func (s *Server) createSession(w http.ResponseWriter, requestor string, rrequest irma.RequestorRequest) {
-	// Authorize request: check if the requestor is allowed to verify or issue
-	// the requested attributes or credentials
+// authorizeSession checks if the requestor is allowed to verify or issue the requested
+// attributes or credentials.
+func (s *Server) authorizeSession(requestor string, rrequest irma.RequestorRequest) *irma.RemoteError {
 	request := rrequest.SessionRequest()
 	if request.Action() == irma.ActionIssuing {
 		allowed, reason := s.conf.CanIssue(requestor, request.(*irma.IssuanceRequest).Credentials)
 		if !allowed {
 			s.conf.Logger.WithFields(logrus.Fields{"requestor": requestor, "id": reason}).
 				Warn("Requestor not authorized to issue credential; full request: ", server.ToJson(request))
-			server.WriteError(w, server.ErrorUnauthorized, reason)
-			return
+			return server.RemoteError(server.ErrorUnauthorized, reason)
 		}
 	}
 	condiscon := request.Disclosure().Disclose
@@ -479,18 +553,90 @@ func (s *Server) createSession(w http.ResponseWriter, requestor string, rrequest
 		if !allowed {
 			s.conf.Logger.WithFields(logrus.Fields{"requestor": requestor, "id": reason}).
 				Warn("Requestor not authorized to verify attribute; full request: ", server.ToJson(request))
-			server.WriteError(w, server.ErrorUnauthorized, reason)
-			return
+			return server.RemoteError(server.ErrorUnauthorized, reason)
 		}
 	}
 	if rrequest.Base().CallbackURL != "" && s.conf.JwtRSAPrivateKey == nil {
 		s.conf.Logger.WithFields(logrus.Fields{"requestor": requestor}).Warn("Requestor provided callbackUrl but no JWT private key is installed")
-		server.WriteError(w, server.ErrorUnsupported, "")
+		return server.RemoteError(server.ErrorUnsupported, "")
+	}
+	// Without a JWT private key, the next session URL could not verify the session result from which it creates the next session
+	if rrequest.Base().NextSession != nil && s.conf.JwtRSAPrivateKey == nil {
+		s.conf.Logger.WithFields(logrus.Fields{"requestor": requestor}).Warn("Requestor provided nextSession but no JWT private key is installed")
+		return server.RemoteError(server.ErrorUnsupported, "")
+	}
+	return nil
+}
+
+func (s *Server) handleTemplateSession(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	template := s.conf.SessionTemplates[name]
+	if template == nil {
+		server.WriteError(w, server.ErrorInvalidRequest, "unknown session template")
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		s.conf.Logger.Error("Could not read session template HTTP POST body")
+		_ = server.LogError(err)
+		server.WriteError(w, server.ErrorInvalidRequest, err.Error())
 		return
 	}
 
+	var (
+		params    map[string]string
+		requestor string
+		rerr      *irma.RemoteError
+		applies   bool
+	)
+	for _, authenticator := range authenticators {
+		applies, params, requestor, rerr = authenticator.AuthenticateTemplate(r.Header, body, name)
+		if applies || rerr != nil {
+			break
+		}
+	}
+	if ok := s.checkAuth(w, r, rerr, applies, body); !ok {
+		return
+	}
+
+	// All instances of the template involve the same attribute and credential types,
+	// so the requestor's permissions are checked against the template itself
+	if rerr = s.authorizeSession(requestor, template.Template()); rerr != nil {
+		server.WriteResponse(w, nil, rerr)
+		return
+	}
+	rrequest, err := template.Instantiate(params)
+	if err != nil {
+		server.WriteError(w, server.ErrorInvalidRequest, err.Error())
+		return
+	}
+	s.startSession(w, requestor, rrequest)
+}
+
+func (s *Server) createSession(w http.ResponseWriter, requestor string, rrequest irma.RequestorRequest) {
+	if rerr := s.authorizeSession(requestor, rrequest); rerr != nil {
+		server.WriteResponse(w, nil, rerr)
+		return
+	}
+	s.startSession(w, requestor, rrequest)
+}
+
+func (s *Server) startSession(w http.ResponseWriter, requestor string, rrequest irma.RequestorRequest) {
+
 	// Everything is authenticated and parsed, we're good to go!
-	qr, token, err := s.irmaserv.StartSession(rrequest, s.doResultCallback)
+	// Follow-up sessions are subject to the same permissions of the same requestor.
+	qr, token, err := s.irmaserv.StartAuthorizedSession(rrequest, s.doResultCallback,
+		func(next irma.RequestorRequest) error {
+			if rerr := s.authorizeSession(requestor, next); rerr != nil {
+				return rerr
+			}
+			return nil
+		},
+	)
+	if rerr, ok := err.(*irma.RemoteError); ok {
+		server.WriteResponse(w, nil, rerr)
+		return
+	}
 	if err != nil {
 		server.WriteError(w, server.ErrorInvalidRequest, err.Error())
 		return
@@ -0,0 +1,252 @@
+// Package schememirror serves local copies of IRMA schemes over HTTP, so that they can be
+// downloaded by irma.Configuration instances (see irma.ConfigurationOptions.SchemeMirror)
+// from the mirror instead of from the scheme's own URL. Only files whose hash matches the
+// signed scheme index are served, and the hashes from the index are used as ETags.
+// Optionally the mirror periodically pulls new versions of the schemes from upstream,
+// verifying their signatures before serving them.
+package schememirror
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/jasonlvhit/gocron"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/server"
+	"github.com/sirupsen/logrus"
+)
+
+// Configuration contains configuration for a Mirror.
+type Configuration struct {
+	// Paths to the scheme folders to serve
+	Schemes []string `json:"schemes" mapstructure:"schemes"`
+	// Pull new versions of the schemes from upstream every x minutes (0 disables pulling)
+	UpdateInterval int `json:"update" mapstructure:"update"`
+	// Custom logger instance. If not specified, the irmago logger is used.
+	Logger *logrus.Logger `json:"-"`
+}
+
+// Mirror is an http.Handler serving the configured schemes at /$schemeid/$path.
+type Mirror struct {
+	conf      *Configuration
+	schemes   map[string]*scheme
+	mutex     sync.RWMutex
+	scheduler *gocron.Scheduler
+	stop      chan bool
+}
+
+// scheme is an immutable snapshot of a scheme folder of which the signature and file hashes
+// have been verified.
+type scheme struct {
+	id        irma.SchemeManagerIdentifier
+	path      string
+	timestamp time.Time
+	files     map[string]file // by path relative to the scheme folder
+}
+
+type file struct {
+	content []byte
+	etag    string
+}
+
+// These files are served without being contained in the scheme index
+var unindexedFiles = []string{"index", "index.sig", "pk.pem"}
+
+// New returns a new Mirror serving the schemes specified in the configuration, after
+// verifying them. If configured, it starts pulling updates of the schemes in the background.
+func New(conf *Configuration) (*Mirror, error) {
+	if len(conf.Schemes) == 0 {
+		return nil, errors.New("no schemes specified")
+	}
+	if conf.Logger == nil {
+		conf.Logger = irma.Logger
+	}
+	m := &Mirror{conf: conf, schemes: map[string]*scheme{}}
+	for _, p := range conf.Schemes {
+		s, err := loadScheme(p)
+		if err != nil {
+			return nil, errors.WrapPrefix(err, "failed to load scheme at "+p, 0)
+		}
+		if _, exists := m.schemes[s.id.Name()]; exists {
+			return nil, errors.Errorf("scheme %s specified more than once", s.id)
+		}
+		m.schemes[s.id.Name()] = s
+		conf.Logger.WithField("scheme", s.id).Info("Mirroring scheme")
+	}
+
+	if conf.UpdateInterval > 0 {
+		m.scheduler = gocron.NewScheduler()
+		m.scheduler.Every(uint64(conf.UpdateInterval)).Minutes().Do(m.Update)
+		m.stop = m.scheduler.Start()
+	}
+	return m, nil
+}
+
+// Stop stops pulling updates from upstream.
+func (m *Mirror) Stop() {
+	if m.stop != nil {
+		m.stop <- true
+	}
+}
+
+// Update pulls new versions of all schemes from their upstream URLs, verifying their
+// signatures against the pinned public keys of the local copies. If pulling or verifying
+// a scheme fails, the previous version of the scheme continues to be served.
+func (m *Mirror) Update() {
+	m.mutex.RLock()
+	schemes := make([]*scheme, 0, len(m.schemes))
+	for _, s := range m.schemes {
+		schemes = append(schemes, s)
+	}
+	m.mutex.RUnlock()
+
+	for _, s := range schemes {
+		updated, err := s.update()
+		if err != nil {
+			m.conf.Logger.WithField("scheme", s.id).Error("Failed to update scheme")
+			_ = server.LogError(err)
+			continue
+		}
+		if !updated.timestamp.After(s.timestamp) {
+			continue
+		}
+		m.mutex.Lock()
+		m.schemes[s.id.Name()] = updated
+		m.mutex.Unlock()
+		m.conf.Logger.WithField("scheme", s.id).Info("Updated scheme")
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (m *Mirror) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(path.Clean(r.URL.Path), "/"), "/", 2)
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+
+	m.mutex.RLock()
+	s := m.schemes[parts[0]]
+	m.mutex.RUnlock()
+	if s == nil {
+		http.NotFound(w, r)
+		return
+	}
+	f, ok := s.files[parts[1]]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Clients must always revalidate, as the contents of a path change with new scheme versions
+	w.Header().Set("ETag", f.etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, parts[1], s.timestamp, bytes.NewReader(f.content))
+}
+
+// loadScheme reads the scheme at the specified path, verifying its signature and the hashes of
+// all files in its index.
+func loadScheme(p string) (*scheme, error) {
+	p, err := filepath.Abs(p)
+	if err != nil {
+		return nil, err
+	}
+	conf, err := irma.NewConfiguration(filepath.Dir(p), irma.ConfigurationOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	manager := irma.NewSchemeManager(filepath.Base(p))
+	if err = conf.ParseSchemeManagerFolder(p, manager); err != nil {
+		return nil, err
+	}
+
+	s := &scheme{
+		id:        manager.Identifier(),
+		path:      p,
+		timestamp: time.Time(manager.Timestamp),
+		files:     map[string]file{},
+	}
+
+	indexbts, err := ioutil.ReadFile(filepath.Join(p, "index"))
+	if err != nil {
+		return nil, err
+	}
+	index := irma.SchemeManagerIndex{}
+	if err = index.FromString(string(indexbts)); err != nil {
+		return nil, err
+	}
+	for filename, hash := range index {
+		bts, found, err := conf.ReadAuthenticatedFile(manager, filename)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil || !found {
+			return nil, errors.Errorf("failed to read %s: %v", filename, err)
+		}
+		s.files[strings.TrimPrefix(filename, manager.ID+"/")] = file{content: bts, etag: etag(hash)}
+	}
+
+	extra := append([]string{}, unindexedFiles...)
+	if manager.Demo {
+		// Demo schemes also distribute their private keys
+		extra = append(extra, "sk.pem")
+		matches, err := filepath.Glob(filepath.Join(p, "*", "PrivateKeys", "*.xml"))
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range matches {
+			rel, err := filepath.Rel(p, match)
+			if err != nil {
+				return nil, err
+			}
+			extra = append(extra, filepath.ToSlash(rel))
+		}
+	}
+	for _, filename := range extra {
+		bts, err := ioutil.ReadFile(filepath.Join(p, filepath.FromSlash(filename)))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		hash := sha256.Sum256(bts)
+		s.files[filename] = file{content: bts, etag: etag(hash[:])}
+	}
+
+	return s, nil
+}
+
+// update pulls the latest version of the scheme from upstream into its folder, and returns
+// the new version.
+func (s *scheme) update() (*scheme, error) {
+	conf, err := irma.NewConfiguration(filepath.Dir(s.path), irma.ConfigurationOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if err = conf.ParseSchemeManagerFolder(s.path, irma.NewSchemeManager(s.id.Name())); err != nil {
+		return nil, err
+	}
+	if err = conf.UpdateSchemeManager(s.id, nil); err != nil {
+		return nil, err
+	}
+	return loadScheme(s.path)
+}
+
+func etag(hash []byte) string {
+	return `"` + hex.EncodeToString(hash) + `"`
+}
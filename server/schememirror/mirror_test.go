@@ -0,0 +1,57 @@
+package schememirror
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/privacybydesign/irmago/internal/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMirror(t *testing.T) {
+	path := filepath.Join(test.FindTestdataFolder(t), "irma_configuration", "irma-demo")
+	mirror, err := New(&Configuration{Schemes: []string{path}})
+	require.NoError(t, err)
+	defer mirror.Stop()
+	s := httptest.NewServer(mirror)
+	defer s.Close()
+
+	expected, err := ioutil.ReadFile(filepath.Join(path, "description.xml"))
+	require.NoError(t, err)
+	res, err := http.Get(s.URL + "/irma-demo/description.xml")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	bts, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	require.Equal(t, expected, bts)
+	etag := res.Header.Get("ETag")
+	require.NotEmpty(t, etag)
+
+	// Unchanged files are not sent again
+	req, err := http.NewRequest(http.MethodGet, s.URL+"/irma-demo/description.xml", nil)
+	require.NoError(t, err)
+	req.Header.Set("If-None-Match", etag)
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	require.Equal(t, http.StatusNotModified, res.StatusCode)
+
+	for _, p := range []string{"/irma-demo/index", "/irma-demo/index.sig", "/irma-demo/pk.pem", "/irma-demo/timestamp"} {
+		res, err = http.Get(s.URL + p)
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		require.Equal(t, http.StatusOK, res.StatusCode, p)
+	}
+
+	// Only files from the scheme index are served
+	for _, p := range []string{"/irma-demo/nonexisting.xml", "/irma-demo/../irma-demo/../test/description.xml", "/other/description.xml"} {
+		res, err = http.Get(s.URL + p)
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		require.Equal(t, http.StatusNotFound, res.StatusCode, p)
+	}
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-errors/errors"
+	irma "github.com/privacybydesign/irmago"
+)
+
+// SessionTemplate is a session request in which strings may contain placeholders of the form
+// {{name}}, that are replaced by the values of the corresponding parameters when an instance of the
+// template is started. Placeholders can be used in any string of the session request except for
+// attribute and credential type identifiers, e.g. in attribute values, labels, the callback URL,
+// and the attributes of issued credentials. In static templates, which anyone can start with any
+// parameters, placeholders cannot be used in the callback URL and the URL of the next session, as
+// these receive the session result.
+type SessionTemplate struct {
+	// Session request, in any of the formats accepted by ParseSessionRequest
+	Request interface{} `json:"request" mapstructure:"request"`
+	// Parameters that may be used in placeholders
+	Parameters map[string]*TemplateParameter `json:"parameters" mapstructure:"parameters"`
+	// Whether IRMA apps may start instances of the template using a static QR, specifying the
+	// parameters in the query string of its URL
+	Static bool `json:"static" mapstructure:"static"`
+
+	template irma.RequestorRequest // request with placeholders, determining the permissions required
+	request  interface{}           // JSON-decoded request in which placeholders are replaced
+}
+
+// TemplateParameter specifies the allowed values of a parameter of a SessionTemplate, using the
+// same types and constraints as attributes in credential types.
+type TemplateParameter struct {
+	Type      irma.AttributeValueType `json:"type" mapstructure:"type"`
+	Pattern   string                  `json:"pattern" mapstructure:"pattern"`
+	MaxLength int                     `json:"max_length" mapstructure:"max_length"`
+	Values    []string                `json:"values" mapstructure:"values"` // allowed values of enum parameters
+	// If the parameter is not specified, Default is used if set, and otherwise the empty string
+	// if Optional is true.
+	Optional bool   `json:"optional" mapstructure:"optional"`
+	Default  string `json:"default" mapstructure:"default"`
+
+	constraints *irma.AttributeType
+}
+
+var (
+	templatePlaceholder = regexp.MustCompile(`{{([a-zA-Z0-9_]*)}}`)
+	templateName        = regexp.MustCompile("^[a-zA-Z0-9_]+$")
+)
+
+// parse checks the template and its parameters, and prepares the template for instantiation.
+func (t *SessionTemplate) parse() error {
+	bts, err := json.Marshal(t.Request)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(bts, &t.request); err != nil {
+		return err
+	}
+	if t.template, err = ParseSessionRequest(bts); err != nil {
+		return err
+	}
+	if strings.Contains(t.template.SessionRequest().Identifiers().String(), "{{") {
+		return errors.New("placeholders cannot be used in identifiers")
+	}
+
+	used := map[string]struct{}{}
+	for _, match := range templatePlaceholder.FindAllStringSubmatch(string(bts), -1) {
+		if t.Parameters[match[1]] == nil {
+			return errors.Errorf("placeholder {{%s}} refers to undeclared parameter", match[1])
+		}
+		used[match[1]] = struct{}{}
+	}
+	for name, param := range t.Parameters {
+		if _, ok := used[name]; !ok {
+			return errors.Errorf("parameter %s is not used", name)
+		}
+		if param == nil {
+			return errors.Errorf("parameter %s has no declaration", name)
+		}
+		param.constraints = &irma.AttributeType{
+			Type:      param.Type,
+			Pattern:   param.Pattern,
+			MaxLength: param.MaxLength,
+			Values:    param.Values,
+		}
+		if err = param.constraints.ValidateDeclaration(); err != nil {
+			return errors.WrapPrefix(err, "parameter "+name, 0)
+		}
+		if param.Default != "" {
+			if err = param.constraints.ValidateValue(param.Default); err != nil {
+				return errors.WrapPrefix(err, "default value of parameter "+name, 0)
+			}
+		}
+	}
+
+	if t.Static {
+		action := t.template.SessionRequest().Action()
+		if action != irma.ActionDisclosing && action != irma.ActionSigning {
+			return errors.New("static templates must be either disclosing or signing sessions")
+		}
+		base := t.template.Base()
+		if base.CallbackURL == "" {
+			return errors.New("static templates must have a callback URL")
+		}
+		if strings.Contains(base.CallbackURL, "{{") {
+			return errors.New("placeholders cannot be used in the callback URL of static templates")
+		}
+		if base.NextSession != nil && strings.Contains(base.NextSession.URL, "{{") {
+			return errors.New("placeholders cannot be used in the next session URL of static templates")
+		}
+	}
+	return nil
+}
+
+// Template returns the session request of the template with its placeholders unreplaced.
+// Instances of the template involve the same attributes and credential types, so that the
+// permissions required to start them can be checked against the template.
+func (t *SessionTemplate) Template() irma.RequestorRequest {
+	return t.template
+}
+
+// Instantiate returns the session request of the template with its placeholders replaced by the
+// specified parameters, after checking them against their declarations.
+func (t *SessionTemplate) Instantiate(params map[string]string) (irma.RequestorRequest, error) {
+	values := make(map[string]string, len(t.Parameters))
+	for name := range params {
+		if t.Parameters[name] == nil {
+			return nil, errors.Errorf("unknown parameter %s", name)
+		}
+	}
+	for name, param := range t.Parameters {
+		value, ok := params[name]
+		if !ok {
+			if param.Default == "" && !param.Optional {
+				return nil, errors.Errorf("missing parameter %s", name)
+			}
+			values[name] = param.Default
+			continue
+		}
+		if err := param.constraints.ValidateValue(value); err != nil {
+			return nil, errors.WrapPrefix(err, "invalid value of parameter "+name, 0)
+		}
+		values[name] = value
+	}
+
+	bts, err := json.Marshal(replacePlaceholders(t.request, values))
+	if err != nil {
+		return nil, err
+	}
+	request, err := ParseSessionRequest(bts)
+	if err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// replacePlaceholders returns a copy of the JSON-decoded value in which the placeholders within
+// strings are replaced. Map keys are left untouched.
+func replacePlaceholders(v interface{}, values map[string]string) interface{} {
+	switch v := v.(type) {
+	case string:
+		return templatePlaceholder.ReplaceAllStringFunc(v, func(placeholder string) string {
+			return values[placeholder[2:len(placeholder)-2]]
+		})
+	case []interface{}:
+		replaced := make([]interface{}, len(v))
+		for i, e := range v {
+			replaced[i] = replacePlaceholders(e, values)
+		}
+		return replaced
+	case map[string]interface{}:
+		replaced := make(map[string]interface{}, len(v))
+		for key, e := range v {
+			replaced[key] = replacePlaceholders(e, values)
+		}
+		return replaced
+	default:
+		return v
+	}
+}
+
+// StaticTemplates returns the names of the templates that can be started using a static QR.
+func (conf *Configuration) StaticTemplates() []string {
+	var names []string
+	for name, t := range conf.SessionTemplates {
+		if t.Static {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (conf *Configuration) verifySessionTemplates() error {
+	for name, t := range conf.SessionTemplates {
+		if !templateName.MatchString(name) {
+			return errors.Errorf("session template name %s not allowed, must be alphanumeric", name)
+		}
+		if t == nil {
+			return errors.Errorf("session template %s is empty", name)
+		}
+		if t.Static && conf.StaticSessions[name] != nil {
+			return errors.Errorf("static session template %s has the same name as a static session", name)
+		}
+		if err := t.parse(); err != nil {
+			return errors.WrapPrefix(err, "invalid session template "+name, 0)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/privacybydesign/irmago"
+	"github.com/stretchr/testify/require"
+)
+
+func parseTemplate(t *testing.T, template string) (*SessionTemplate, error) {
+	st := &SessionTemplate{}
+	require.NoError(t, json.Unmarshal([]byte(template), st))
+	return st, st.parse()
+}
+
+func TestSessionTemplate(t *testing.T) {
+	st, err := parseTemplate(t, `{
+		"request": {
+			"@context": "https://irma.app/ld/request/disclosure/v2",
+			"disclose": [[[{"type": "irma-demo.RU.studentCard.studentID", "value": "{{student}}"}]]],
+			"labels": {"0": {"en": "Student {{student}}", "nl": "Student {{student}}"}}
+		},
+		"parameters": {
+			"student": {"type": "string", "pattern": "s[0-9]{7}"}
+		}
+	}`)
+	require.NoError(t, err)
+	require.Equal(t, "{{student}}", *st.Template().SessionRequest().Disclosure().Disclose[0][0][0].Value)
+
+	request, err := st.Instantiate(map[string]string{"student": "s1234567"})
+	require.NoError(t, err)
+	disclosure := request.SessionRequest().Disclosure()
+	require.Equal(t, "s1234567", *disclosure.Disclose[0][0][0].Value)
+	require.Equal(t, "Student s1234567", disclosure.Labels[0]["en"])
+	require.Equal(t, "{{student}}", *st.Template().SessionRequest().Disclosure().Disclose[0][0][0].Value)
+
+	_, err = st.Instantiate(map[string]string{"student": "s123"})
+	require.Error(t, err)
+	_, err = st.Instantiate(map[string]string{})
+	require.Error(t, err)
+	_, err = st.Instantiate(map[string]string{"student": "s1234567", "other": "x"})
+	require.Error(t, err)
+
+	t.Run("issuance", func(t *testing.T) {
+		st, err := parseTemplate(t, `{
+			"request": {
+				"request": {
+					"@context": "https://irma.app/ld/request/issuance/v2",
+					"credentials": [{
+						"credential": "irma-demo.RU.studentCard",
+						"attributes": {"university": "Radboud", "studentCardNumber": "{{number}}", "studentID": "s{{number}}", "level": "{{level}}"}
+					}]
+				},
+				"callbackUrl": "https://example.com/{{level}}"
+			},
+			"parameters": {
+				"number": {"type": "integer", "max_length": 7},
+				"level": {"type": "enum", "values": ["1", "2", "3"], "default": "1"}
+			}
+		}`)
+		require.NoError(t, err)
+		request, err := st.Instantiate(map[string]string{"number": "1234567"})
+		require.NoError(t, err)
+		cred := request.SessionRequest().(*irma.IssuanceRequest).Credentials[0]
+		require.Equal(t, "s1234567", cred.Attributes["studentID"])
+		require.Equal(t, "1", cred.Attributes["level"])
+		require.Equal(t, "https://example.com/1", request.Base().CallbackURL)
+
+		_, err = st.Instantiate(map[string]string{"number": "12345678"})
+		require.Error(t, err)
+		_, err = st.Instantiate(map[string]string{"number": "1", "level": "4"})
+		require.Error(t, err)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		// undeclared parameter
+		_, err := parseTemplate(t, `{"request": {"@context": "https://irma.app/ld/request/disclosure/v2",
+			"disclose": [[[{"type": "irma-demo.RU.studentCard.studentID", "value": "{{student}}"}]]]}}`)
+		require.Error(t, err)
+		// unused parameter
+		_, err = parseTemplate(t, `{"request": {"@context": "https://irma.app/ld/request/disclosure/v2",
+			"disclose": [[["irma-demo.RU.studentCard.studentID"]]]}, "parameters": {"student": {}}}`)
+		require.Error(t, err)
+		// placeholder in identifier
+		_, err = parseTemplate(t, `{"request": {"@context": "https://irma.app/ld/request/disclosure/v2",
+			"disclose": [[["irma-demo.RU.studentCard.{{attr}}"]]]}, "parameters": {"attr": {}}}`)
+		require.Error(t, err)
+		// invalid default
+		_, err = parseTemplate(t, `{"request": {"@context": "https://irma.app/ld/request/disclosure/v2",
+			"disclose": [[[{"type": "irma-demo.RU.studentCard.level", "value": "{{level}}"}]]]},
+			"parameters": {"level": {"type": "integer", "default": "high"}}}`)
+		require.Error(t, err)
+		// static template without callback URL
+		_, err = parseTemplate(t, `{"request": {"@context": "https://irma.app/ld/request/disclosure/v2",
+			"disclose": [[[{"type": "irma-demo.RU.studentCard.studentID", "value": "{{student}}"}]]]},
+			"parameters": {"student": {}}, "static": true}`)
+		require.Error(t, err)
+		// static template sending its result to a URL chosen by whoever starts it
+		_, err = parseTemplate(t, `{"request": {"request": {"@context": "https://irma.app/ld/request/disclosure/v2",
+			"disclose": [[[{"type": "irma-demo.RU.studentCard.studentID", "value": "{{student}}"}]]]},
+			"callbackUrl": "https://{{student}}"}, "parameters": {"student": {}}, "static": true}`)
+		require.Error(t, err)
+		_, err = parseTemplate(t, `{"request": {"request": {"@context": "https://irma.app/ld/request/disclosure/v2",
+			"disclose": [[["irma-demo.RU.studentCard.studentID"]]]},
+			"callbackUrl": "https://example.com", "nextSession": {"url": "https://{{host}}"}},
+			"parameters": {"host": {}}, "static": true}`)
+		require.Error(t, err)
+		_, err = parseTemplate(t, `{"request": {"request": {"@context": "https://irma.app/ld/request/disclosure/v2",
+			"disclose": [[[{"type": "irma-demo.RU.studentCard.studentID", "value": "{{student}}"}]]]},
+			"callbackUrl": "https://example.com"}, "parameters": {"student": {}}, "static": true}`)
+		require.NoError(t, err)
+	})
+}
@@ -3,6 +3,10 @@ package irma
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"io"
@@ -10,9 +14,12 @@ import (
 	"log"
 	"net"
 	"net/http"
+	neturl "net/url"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/go-errors/errors"
 	"github.com/hashicorp/go-retryablehttp"
 	"github.com/privacybydesign/gabi"
 	"github.com/privacybydesign/gabi/revocation"
@@ -29,6 +36,7 @@ type HTTPTransport struct {
 	Binary  bool
 	client  *retryablehttp.Client
 	headers map[string]string
+	ctx     context.Context
 }
 
 // Logger is used for logging. If not set, init() will initialize it to logrus.StandardLogger().
@@ -53,8 +61,82 @@ func SetLogger(logger *logrus.Logger) {
 	revocation.Logger = Logger
 }
 
-// NewHTTPTransport returns a new HTTPTransport.
+// TransportPurpose identifies what an HTTPTransport is used for, so that its timeout can be
+// configured per purpose in TransportOptions.
+type TransportPurpose string
+
+const (
+	TransportPurposeSession    = TransportPurpose("session")
+	TransportPurposeScheme     = TransportPurpose("scheme")
+	TransportPurposeRevocation = TransportPurpose("revocation")
+	TransportPurposeKeyshare   = TransportPurpose("keyshare")
+)
+
+// TransportOptions configures HTTPTransports. Fields that are left empty take their default value.
+type TransportOptions struct {
+	// Timeout of requests (default 3 seconds), and overrides of it per purpose
+	Timeout  Duration                      `json:"timeout,omitempty" mapstructure:"timeout"`
+	Timeouts map[TransportPurpose]Duration `json:"timeouts,omitempty" mapstructure:"timeouts"`
+
+	// Number of times that failed requests are retried (default 2, negative to disable retrying),
+	// and the minimum and maximum time to wait before retrying (default 100 and 200 milliseconds)
+	RetryMax     int      `json:"retry_max,omitempty" mapstructure:"retry_max"`
+	RetryWaitMin Duration `json:"retry_wait_min,omitempty" mapstructure:"retry_wait_min"`
+	RetryWaitMax Duration `json:"retry_wait_max,omitempty" mapstructure:"retry_wait_max"`
+
+	// URL of the proxy through which to send requests. If empty, no proxy is used, unless
+	// ProxyFromEnvironment is set, in which case the proxy is taken from the HTTP_PROXY,
+	// HTTPS_PROXY and NO_PROXY environment variables.
+	Proxy                string `json:"proxy,omitempty" mapstructure:"proxy"`
+	ProxyFromEnvironment bool   `json:"proxy_from_environment,omitempty" mapstructure:"proxy_from_environment"`
+
+	// PEM-encoded CA certificates to trust in addition to the system root CAs
+	CACertificates string `json:"ca_certificates,omitempty" mapstructure:"ca_certificates"`
+	// Base64-encoded SHA256 hashes of the SubjectPublicKeyInfo of pinned certificates. If not
+	// empty, TLS connections are only accepted if the server certificate chain contains one of them.
+	PinnedPublicKeys []string `json:"pinned_public_keys,omitempty" mapstructure:"pinned_public_keys"`
+
+	// Value of the User-Agent header of requests (default "irmago")
+	UserAgent string `json:"user_agent,omitempty" mapstructure:"user_agent"`
+}
+
+// Duration is a time.Duration that is unmarshaled from JSON either from a number of seconds, or
+// from a string as accepted by time.ParseDuration() such as "1.5s" or "200ms".
+type Duration time.Duration
+
+// MarshalJSON marshals a duration as a string such as "1.5s".
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON unmarshals a duration from a number of seconds or a duration string.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var str string
+	if err := json.Unmarshal(b, &str); err == nil {
+		duration, err := time.ParseDuration(str)
+		if err != nil {
+			return err
+		}
+		*d = Duration(duration)
+		return nil
+	}
+	var seconds float64
+	if err := json.Unmarshal(b, &seconds); err != nil {
+		return errors.Errorf("invalid duration %s: must be a number of seconds or a string such as \"1.5s\"", string(b))
+	}
+	*d = Duration(seconds * float64(time.Second))
+	return nil
+}
+
+// NewHTTPTransport returns a new HTTPTransport with the default TransportOptions.
 func NewHTTPTransport(serverURL string) *HTTPTransport {
+	return NewHTTPTransportWithOptions(serverURL, nil, TransportPurposeSession)
+}
+
+// NewHTTPTransportWithOptions returns a new HTTPTransport for the specified purpose, configured
+// by the specified options (if nil, the defaults are used). If the options are invalid (see
+// TransportOptions.Validate()), all requests of the HTTPTransport fail.
+func NewHTTPTransportWithOptions(serverURL string, opts *TransportOptions, purpose TransportPurpose) *HTTPTransport {
 	if Logger.IsLevelEnabled(logrus.TraceLevel) {
 		transportlogger = log.New(Logger.WriterLevel(logrus.TraceLevel), "transport: ", 0)
 	} else {
@@ -66,43 +148,173 @@ func NewHTTPTransport(serverURL string) *HTTPTransport {
 		url += "/"
 	}
 
-	// Create a transport that dials with a SIGPIPE handler (which is only active on iOS)
-	var innerTransport http.Transport
-
-	innerTransport.Dial = func(network, addr string) (c net.Conn, err error) {
-		c, err = net.Dial(network, addr)
-		if err != nil {
-			return c, err
-		}
-		if err = disable_sigpipe.DisableSigPipe(c); err != nil {
-			return c, err
-		}
-		return c, nil
+	if opts == nil {
+		opts = &TransportOptions{}
+	}
+	var roundTripper http.RoundTripper
+	innerTransport, err := opts.httpTransport()
+	if err != nil {
+		roundTripper = failingRoundTripper{err}
+	} else {
+		roundTripper = innerTransport
 	}
 
 	client := &retryablehttp.Client{
 		Logger:       transportlogger,
-		RetryWaitMin: 100 * time.Millisecond,
-		RetryWaitMax: 200 * time.Millisecond,
-		RetryMax:     2,
+		RetryWaitMin: durationOrDefault(opts.RetryWaitMin, 100*time.Millisecond),
+		RetryWaitMax: durationOrDefault(opts.RetryWaitMax, 200*time.Millisecond),
+		RetryMax:     opts.retryMax(),
 		Backoff:      retryablehttp.DefaultBackoff,
 		CheckRetry: func(ctx context.Context, resp *http.Response, err error) (bool, error) {
-			// Don't retry on 5xx (which retryablehttp does by default)
+			// Don't retry on 5xx (which retryablehttp does by default), nor after being aborted
+			if ctx.Err() != nil {
+				return false, ctx.Err()
+			}
 			return err != nil || resp.StatusCode == 0, err
 		},
 		HTTPClient: &http.Client{
-			Timeout:   time.Second * 3,
-			Transport: &innerTransport,
+			Timeout:   opts.timeout(purpose),
+			Transport: roundTripper,
 		},
 	}
 
+	headers := map[string]string{}
+	if opts.UserAgent != "" {
+		headers["User-Agent"] = opts.UserAgent
+	}
 	return &HTTPTransport{
 		Server:  url,
-		headers: map[string]string{},
+		headers: headers,
 		client:  client,
 	}
 }
 
+// Validate checks the proxy URL, CA certificates and pinned public keys of the options.
+func (opts *TransportOptions) Validate() error {
+	_, err := opts.httpTransport()
+	return err
+}
+
+func (opts *TransportOptions) timeout(purpose TransportPurpose) time.Duration {
+	if timeout := opts.Timeouts[purpose]; timeout != 0 {
+		return time.Duration(timeout)
+	}
+	return durationOrDefault(opts.Timeout, 3*time.Second)
+}
+
+func (opts *TransportOptions) retryMax() int {
+	if opts.RetryMax < 0 {
+		return 0
+	}
+	if opts.RetryMax == 0 {
+		return 2
+	}
+	return opts.RetryMax
+}
+
+func durationOrDefault(d Duration, def time.Duration) time.Duration {
+	if d == 0 {
+		return def
+	}
+	return time.Duration(d)
+}
+
+// httpTransport returns the http.Transport through which requests are sent.
+func (opts *TransportOptions) httpTransport() (*http.Transport, error) {
+	// Create a transport that dials with a SIGPIPE handler (which is only active on iOS)
+	transport := &http.Transport{}
+	transport.Dial = func(network, addr string) (c net.Conn, err error) {
+		c, err = net.Dial(network, addr)
+		if err != nil {
+			return c, err
+		}
+		if err = disable_sigpipe.DisableSigPipe(c); err != nil {
+			return c, err
+		}
+		return c, nil
+	}
+
+	if opts.Proxy != "" {
+		proxy, err := neturl.Parse(opts.Proxy)
+		if err != nil {
+			return nil, errors.WrapPrefix(err, "Invalid proxy URL", 0)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	} else if opts.ProxyFromEnvironment {
+		transport.Proxy = http.ProxyFromEnvironment
+	}
+
+	if opts.CACertificates == "" && len(opts.PinnedPublicKeys) == 0 {
+		return transport, nil
+	}
+	transport.TLSClientConfig = &tls.Config{}
+	if opts.CACertificates != "" {
+		pool, err := caCertPool(opts.CACertificates)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+	if len(opts.PinnedPublicKeys) > 0 {
+		pins := map[string]struct{}{}
+		for _, pin := range opts.PinnedPublicKeys {
+			hash, err := base64.StdEncoding.DecodeString(pin)
+			if err != nil || len(hash) != sha256.Size {
+				return nil, errors.Errorf("Invalid pinned public key hash %s", pin)
+			}
+			pins[string(hash)] = struct{}{}
+		}
+		transport.TLSClientConfig.VerifyPeerCertificate = func(_ [][]byte, chains [][]*x509.Certificate) error {
+			for _, chain := range chains {
+				for _, cert := range chain {
+					hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+					if _, ok := pins[string(hash[:])]; ok {
+						return nil
+					}
+				}
+			}
+			return errors.New("Server certificate chain contains no pinned public key")
+		}
+	}
+	return transport, nil
+}
+
+// caCertPools caches the pools returned by caCertPool() by their PEM-encoded CA certificates,
+// as parsing these and the system root CAs is relatively expensive.
+var caCertPools = struct {
+	sync.Mutex
+	pools map[string]*x509.CertPool
+}{pools: map[string]*x509.CertPool{}}
+
+// caCertPool returns a pool containing the system root CAs and the specified PEM-encoded CA
+// certificates.
+func caCertPool(certs string) (*x509.CertPool, error) {
+	caCertPools.Lock()
+	defer caCertPools.Unlock()
+	if pool, ok := caCertPools.pools[certs]; ok {
+		return pool, nil
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM([]byte(certs)) {
+		return nil, errors.New("No valid CA certificates found")
+	}
+	caCertPools.pools[certs] = pool
+	return pool, nil
+}
+
+// failingRoundTripper fails all requests with the specified error.
+type failingRoundTripper struct {
+	err error
+}
+
+func (f failingRoundTripper) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, f.err
+}
+
 func (transport *HTTPTransport) marshal(o interface{}) ([]byte, error) {
 	if transport.Binary {
 		return MarshalBinary(o)
@@ -151,11 +363,28 @@ func (transport *HTTPTransport) SetHeader(name, val string) {
 	transport.headers[name] = val
 }
 
+// WithContext returns a copy of the transport whose requests are aborted when the specified
+// context is done, including while waiting to retry.
+func (transport *HTTPTransport) WithContext(ctx context.Context) *HTTPTransport {
+	headers := make(map[string]string, len(transport.headers))
+	for name, val := range transport.headers {
+		headers[name] = val
+	}
+	t := *transport
+	t.headers = headers
+	t.ctx = ctx
+	return &t
+}
+
 func (transport *HTTPTransport) request(
 	url string, method string, reader io.Reader, contenttype string,
 ) (response *http.Response, err error) {
+	ctx := transport.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
 	var req retryablehttp.Request
-	req.Request, err = http.NewRequest(method, transport.Server+url, reader)
+	req.Request, err = http.NewRequestWithContext(ctx, method, transport.Server+url, reader)
 	if err != nil {
 		return nil, &SessionError{ErrorType: ErrorTransport, Err: err}
 	}
@@ -214,10 +443,6 @@ func (transport *HTTPTransport) jsonRequest(url string, method string, result in
 	if err != nil {
 		return err
 	}
-	if method == http.MethodDelete {
-		return nil
-	}
-
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		return &SessionError{ErrorType: ErrorServerResponse, Err: err, RemoteStatus: res.StatusCode}
@@ -275,6 +500,6 @@ func (transport *HTTPTransport) Get(url string, result interface{}) error {
 }
 
 // Delete performs a DELETE.
-func (transport *HTTPTransport) Delete() {
-	_ = transport.jsonRequest("", http.MethodDelete, nil, nil)
+func (transport *HTTPTransport) Delete() error {
+	return transport.jsonRequest("", http.MethodDelete, nil, nil)
 }
@@ -39,6 +39,16 @@ type DisclosedAttribute struct {
 	IssuanceTime     Timestamp               `json:"issuancetime"`
 	NotRevoked       bool                    `json:"notrevoked,omitempty"`
 	NotRevokedBefore *Timestamp              `json:"notrevokedbefore,omitempty"`
+	ValueType        AttributeValueType      `json:"valuetype,omitempty"` // Type of the attribute, if declared in its scheme
+}
+
+// TypedValue returns the value of the attribute parsed according to its type (see
+// AttributeValueType.Parse()), or nil if the attribute is null.
+func (da *DisclosedAttribute) TypedValue() (interface{}, error) {
+	if da.RawValue == nil {
+		return nil, nil
+	}
+	return da.ValueType.Parse(*da.RawValue)
 }
 
 // ProofList is a gabi.ProofList with some extra methods.
@@ -309,6 +319,7 @@ func (d *Disclosure) DisclosedAttributes(configuration *Configuration, condiscon
 func parseAttribute(index int, metadata *MetadataAttribute, attr *big.Int) (*DisclosedAttribute, *string, error) {
 	var attrid AttributeTypeIdentifier
 	var attrval *string
+	var valtype AttributeValueType
 	credtype := metadata.CredentialType()
 	if credtype == nil {
 		return nil, nil, errors.New("ProofList contained a disclosure proof of an unkown credential type")
@@ -320,6 +331,7 @@ func parseAttribute(index int, metadata *MetadataAttribute, attr *big.Int) (*Dis
 	} else {
 		attrid = credtype.AttributeTypes[index-2].GetAttributeTypeIdentifier()
 		attrval = decodeAttribute(attr, metadata.Version())
+		valtype = credtype.AttributeTypes[index-2].Type
 	}
 	status := AttributeProofStatusPresent
 	if attrval == nil {
@@ -331,6 +343,7 @@ func parseAttribute(index int, metadata *MetadataAttribute, attr *big.Int) (*Dis
 		Value:        NewTranslatedString(attrval),
 		Status:       status,
 		IssuanceTime: Timestamp(metadata.SigningDate()),
+		ValueType:    valtype,
 	}, attrval, nil
 }
 